@@ -12,4 +12,6 @@ type IService interface {
 	DeleteBaseDocument(ctx context.Context, id string) error
 	GetBaseDocumentByID(ctx context.Context, id string) (*persistence.BaseModel, error)
 	GetAllCreatedSince(ctx context.Context, date time.Time) ([]persistence.BaseModel, error)
+	GetBaseDocumentHistory(ctx context.Context, id string) ([]persistence.Revision, error)
+	GetBaseDocumentAsOf(ctx context.Context, id string, asOf time.Time) (*persistence.BaseModel, error)
 }
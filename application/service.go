@@ -2,34 +2,169 @@ package application
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"time"
 
+	"github.com/Martin-Jast/go-microservice/audit"
+	"github.com/Martin-Jast/go-microservice/auth"
+	"github.com/Martin-Jast/go-microservice/logging"
 	"github.com/Martin-Jast/go-microservice/persistence"
+	"github.com/Martin-Jast/go-microservice/tracing"
 )
 
+// Service holds the business logic, the tenant of the request travels in ctx down to the PersistenceAdapter which scopes every operation to it
 type Service struct {
 	PersistenceAdapter persistence.PersistenceAdapter
+	// Authorizer is checked again by every method when set, as defense in depth in case a route is registered without its check
+	Authorizer auth.Authorizer
+	// Audit records every mutating operation when set
+	Audit *audit.Log
 }
 
 func NewService(adpt persistence.PersistenceAdapter) Service {
-	return Service{adpt}
+	return Service{PersistenceAdapter: adpt}
 }
 
+func (s Service) authorize(ctx context.Context, operation string) error {
+	if s.Authorizer == nil {
+		return nil
+	}
+	return s.Authorizer.Authorize(ctx, operation)
+}
+
+// record appends rec to the audit log when set. The change is already made, a failure is returned so the caller
+// knows it may be missing from the audit log instead of getting a success
+func (s Service) record(ctx context.Context, rec audit.Record) error {
+	if s.Audit == nil {
+		return nil
+	}
+	if err := s.Audit.Record(ctx, rec); err != nil {
+		logging.For(ctx, "application").ErrorContext(ctx, "could not audit change", "action", rec.Action, "id", rec.DocumentID, "error", err)
+		return fmt.Errorf("%s of %s not audited: %w", rec.Action, rec.DocumentID, err)
+	}
+	return nil
+}
+
+// endSpan closes the span of a method, a missing document is an expected answer and not marked as an error
+func endSpan(span *tracing.Span, err error) {
+	if !errors.Is(err, persistence.ErrNotFound) {
+		span.SetError(err)
+	}
+	span.End()
+}
+
+// CreateBaseDocument creates a document recording the authenticated caller ( if any ) as its creator
 func (s Service) CreateBaseDocument(ctx context.Context, data string) (id string, err error){
-	return s.PersistenceAdapter.Create(ctx, persistence.BaseModel{
+	ctx, span := tracing.Start(ctx, "Service.CreateBaseDocument")
+	defer func() { endSpan(span, err) }()
+	if err := s.authorize(ctx, auth.OpBaseCreate); err != nil {
+		return "", err
+	}
+	createdAt := time.Now()
+	doc := persistence.BaseModel{
 		Data: data,
-	})
+		CreatedAt: &createdAt,
+	}
+	if caller := auth.FromContext(ctx); caller != nil {
+		doc.CreatedBy = caller.Subject
+	}
+	id, err = s.PersistenceAdapter.Create(ctx, doc)
+	if err != nil {
+		return "", err
+	}
+	doc.ID = &id
+	logging.For(ctx, "application").DebugContext(ctx, "document created", "id", id)
+	if err := s.record(ctx, audit.Record{Action: audit.ActionCreate, DocumentID: id, After: doc}); err != nil {
+		return id, err
+	}
+	return id, nil
+}
+
+// ImportBaseDocument creates a document keeping its id, creation date and creator, as exported from another deployment
+func (s Service) ImportBaseDocument(ctx context.Context, doc persistence.BaseModel) (id string, err error) {
+	ctx, span := tracing.Start(ctx, "Service.ImportBaseDocument")
+	defer func() { endSpan(span, err) }()
+	if err := s.authorize(ctx, auth.OpBaseCreate); err != nil {
+		return "", err
+	}
+	id, err = s.PersistenceAdapter.Create(ctx, doc)
+	if err != nil {
+		return "", err
+	}
+	doc.ID = &id
+	logging.For(ctx, "application").DebugContext(ctx, "document imported", "id", id)
+	if err := s.record(ctx, audit.Record{Action: audit.ActionCreate, DocumentID: id, After: doc}); err != nil {
+		return id, err
+	}
+	return id, nil
 }
 
-func (s Service) DeleteBaseDocument(ctx context.Context, id string) error {
-	return s.PersistenceAdapter.Delete(ctx, id)
+// DeleteBaseDocument deletes a document, the document as it was before is kept in the audit entry
+func (s Service) DeleteBaseDocument(ctx context.Context, id string) (err error) {
+	ctx, span := tracing.Start(ctx, "Service.DeleteBaseDocument")
+	defer func() { endSpan(span, err) }()
+	if err := s.authorize(ctx, auth.OpBaseDelete); err != nil {
+		return err
+	}
+	// The adapter reads the document in the same transaction as the delete, so the audit entry holds what was deleted
+	before, err := s.PersistenceAdapter.Delete(ctx, id)
+	if err != nil {
+		return err
+	}
+	// Deleting a missing document changes nothing, so nothing is audited
+	if before == nil {
+		return nil
+	}
+	logging.For(ctx, "application").DebugContext(ctx, "document deleted", "id", id)
+	return s.record(ctx, audit.Record{Action: audit.ActionDelete, DocumentID: id, Before: before})
 }
 
 
-func (s Service) GetBaseDocumentByID(ctx context.Context, id string) (*persistence.BaseModel, error) {
+func (s Service) GetBaseDocumentByID(ctx context.Context, id string) (doc *persistence.BaseModel, err error) {
+	ctx, span := tracing.Start(ctx, "Service.GetBaseDocumentByID")
+	defer func() { endSpan(span, err) }()
+	if err := s.authorize(ctx, auth.OpBaseRead); err != nil {
+		return nil, err
+	}
 	return s.PersistenceAdapter.GetByID(ctx, id)
 }
 
-func (s Service) GetAllCreatedSince(ctx context.Context, date time.Time) ([]persistence.BaseModel, error) {
+func (s Service) GetAllCreatedSince(ctx context.Context, date time.Time) (docs []persistence.BaseModel, err error) {
+	ctx, span := tracing.Start(ctx, "Service.GetAllCreatedSince")
+	defer func() { endSpan(span, err) }()
+	if err := s.authorize(ctx, auth.OpBaseRead); err != nil {
+		return nil, err
+	}
 	return s.PersistenceAdapter.GetAllCreatedSince(ctx, date)
-}
\ No newline at end of file
+}
+
+// ListBaseDocuments lists up to limit documents ordered by id, after the id after
+func (s Service) ListBaseDocuments(ctx context.Context, after string, limit int) (docs []persistence.BaseModel, err error) {
+	ctx, span := tracing.Start(ctx, "Service.ListBaseDocuments")
+	defer func() { endSpan(span, err) }()
+	if err := s.authorize(ctx, auth.OpBaseRead); err != nil {
+		return nil, err
+	}
+	return s.PersistenceAdapter.GetPage(ctx, after, limit)
+}
+
+// GetBaseDocumentHistory lists every revision of a document
+func (s Service) GetBaseDocumentHistory(ctx context.Context, id string) (revisions []persistence.Revision, err error) {
+	ctx, span := tracing.Start(ctx, "Service.GetBaseDocumentHistory")
+	defer func() { endSpan(span, err) }()
+	if err := s.authorize(ctx, auth.OpBaseRead); err != nil {
+		return nil, err
+	}
+	return s.PersistenceAdapter.GetHistory(ctx, id)
+}
+
+// GetBaseDocumentAsOf returns a document as it was at asOf
+func (s Service) GetBaseDocumentAsOf(ctx context.Context, id string, asOf time.Time) (doc *persistence.BaseModel, err error) {
+	ctx, span := tracing.Start(ctx, "Service.GetBaseDocumentAsOf")
+	defer func() { endSpan(span, err) }()
+	if err := s.authorize(ctx, auth.OpBaseRead); err != nil {
+		return nil, err
+	}
+	return s.PersistenceAdapter.GetByIDAsOf(ctx, id, asOf)
+}
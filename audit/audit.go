@@ -0,0 +1,166 @@
+package audit
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/Martin-Jast/go-microservice/auth"
+	"github.com/Martin-Jast/go-microservice/persistence"
+	"github.com/Martin-Jast/go-microservice/requestid"
+	"github.com/Martin-Jast/go-microservice/tenancy"
+)
+
+// Actions recorded by the service, denials are recorded as ActionDenied with the denied operation in After
+const (
+	ActionCreate = "base:create"
+	ActionDelete = "base:delete"
+	ActionDenied = "denied"
+)
+
+// ErrChainBroken is returned by Verify when an entry does not match the chain, as opposed to an error reading it
+var ErrChainBroken = errors.New("audit chain broken")
+
+// maxAppendAttempts bounds the retries when several writers race for the same place in the chain
+const maxAppendAttempts = 5
+
+// Record is what the callers provide, the chain fields are filled by the Log
+type Record struct {
+	Action     string
+	DocumentID string
+	// Before and After are marshaled to JSON, nil when the document did not exist
+	Before interface{}
+	After  interface{}
+}
+
+// Log appends hash chained entries to a persistence.AuditStore
+type Log struct {
+	Store persistence.AuditStore
+	// Now is used to timestamp the entries, time.Now if nil
+	Now func() time.Time
+}
+
+// NewLog creates a Log on top of store
+func NewLog(store persistence.AuditStore) *Log {
+	return &Log{Store: store}
+}
+
+func (l *Log) now() time.Time {
+	if l.Now != nil {
+		return l.Now()
+	}
+	return time.Now()
+}
+
+// Record appends an entry to the chain of the tenant in ctx, the actor and request id are taken from ctx
+func (l *Log) Record(ctx context.Context, rec Record) error {
+	entry := persistence.AuditEntry{
+		// Stored with millisecond precision, truncating here keeps the hash valid after a round trip
+		TenantID:   tenancy.TenantOrDefault(ctx),
+		Time:       l.now().UTC().Truncate(time.Millisecond),
+		Actor:      actor(ctx),
+		Action:     rec.Action,
+		DocumentID: rec.DocumentID,
+		RequestID:  requestid.FromContext(ctx),
+	}
+	var err error
+	if entry.Before, err = snapshot(rec.Before); err != nil {
+		return err
+	}
+	if entry.After, err = snapshot(rec.After); err != nil {
+		return err
+	}
+
+	for attempt := 0; attempt < maxAppendAttempts; attempt++ {
+		last, err := l.Store.LastAuditEntry(ctx)
+		if err != nil {
+			return fmt.Errorf("could not read audit chain: %v", err)
+		}
+		entry.Seq, entry.PrevHash = 1, ""
+		if last != nil {
+			entry.Seq, entry.PrevHash = last.Seq+1, last.Hash
+		}
+		entry.Hash = Hash(entry)
+		err = l.Store.AppendAuditEntry(ctx, entry)
+		if errors.Is(err, persistence.ErrAuditConflict) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("could not append audit entry: %v", err)
+		}
+		return nil
+	}
+	return fmt.Errorf("could not append audit entry: %w", persistence.ErrAuditConflict)
+}
+
+// Query lists the entries of the tenant in ctx matching filter
+func (l *Log) Query(ctx context.Context, filter persistence.AuditFilter) ([]persistence.AuditEntry, error) {
+	return l.Store.ListAuditEntries(ctx, filter)
+}
+
+// Verify walks the whole chain of the tenant in ctx and returns an ErrChainBroken pointing to the first entry that was tampered with,
+// other errors come from the store
+func (l *Log) Verify(ctx context.Context) (entries int, err error) {
+	list, err := l.Store.ListAuditEntries(ctx, persistence.AuditFilter{})
+	if err != nil {
+		return 0, err
+	}
+	prevHash := ""
+	for i, entry := range list {
+		if entry.Seq != int64(i+1) {
+			return i, fmt.Errorf("%w: expected entry %d, found %d", ErrChainBroken, i+1, entry.Seq)
+		}
+		if entry.PrevHash != prevHash {
+			return i, fmt.Errorf("%w at entry %d: previous hash does not match", ErrChainBroken, entry.Seq)
+		}
+		if Hash(entry) != entry.Hash {
+			return i, fmt.Errorf("%w at entry %d: hash does not match its content", ErrChainBroken, entry.Seq)
+		}
+		prevHash = entry.Hash
+	}
+	return len(list), nil
+}
+
+// Hash computes the hash of entry from every field but Hash
+func Hash(entry persistence.AuditEntry) string {
+	h := sha256.New()
+	for _, field := range []string{
+		strconv.FormatInt(entry.Seq, 10),
+		entry.TenantID,
+		entry.Time.UTC().Format(time.RFC3339Nano),
+		entry.Actor,
+		entry.Action,
+		entry.DocumentID,
+		entry.RequestID,
+		entry.Before,
+		entry.After,
+		entry.PrevHash,
+	} {
+		// Length prefixes keep different splits of the same bytes from colliding
+		fmt.Fprintf(h, "%d:%s;", len(field), field)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func actor(ctx context.Context) string {
+	if id := auth.FromContext(ctx); id != nil {
+		return id.Subject
+	}
+	return "anonymous"
+}
+
+func snapshot(v interface{}) (string, error) {
+	if v == nil {
+		return "", nil
+	}
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return "", fmt.Errorf("could not snapshot document: %v", err)
+	}
+	return string(raw), nil
+}
@@ -0,0 +1,143 @@
+package audit
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/Martin-Jast/go-microservice/auth"
+	"github.com/Martin-Jast/go-microservice/persistence"
+	"github.com/Martin-Jast/go-microservice/requestid"
+	"github.com/Martin-Jast/go-microservice/tenancy"
+	"github.com/stretchr/testify/assert"
+)
+
+// memoryStore keeps the entries of every tenant in a slice, conflicts can be injected to simulate concurrent writers
+type memoryStore struct {
+	entries   []persistence.AuditEntry
+	conflicts int
+}
+
+func (m *memoryStore) tenantEntries(ctx context.Context) []persistence.AuditEntry {
+	list := []persistence.AuditEntry{}
+	for _, e := range m.entries {
+		if e.TenantID == tenancy.TenantOrDefault(ctx) {
+			list = append(list, e)
+		}
+	}
+	return list
+}
+
+func (m *memoryStore) AppendAuditEntry(ctx context.Context, entry persistence.AuditEntry) error {
+	if m.conflicts > 0 {
+		m.conflicts--
+		return persistence.ErrAuditConflict
+	}
+	entry.TenantID = tenancy.TenantOrDefault(ctx)
+	m.entries = append(m.entries, entry)
+	return nil
+}
+
+func (m *memoryStore) LastAuditEntry(ctx context.Context) (*persistence.AuditEntry, error) {
+	list := m.tenantEntries(ctx)
+	if len(list) == 0 {
+		return nil, nil
+	}
+	return &list[len(list)-1], nil
+}
+
+func (m *memoryStore) ListAuditEntries(ctx context.Context, filter persistence.AuditFilter) ([]persistence.AuditEntry, error) {
+	list := []persistence.AuditEntry{}
+	for _, e := range m.tenantEntries(ctx) {
+		if filter.Actor == "" || filter.Actor == e.Actor {
+			list = append(list, e)
+		}
+	}
+	return list, nil
+}
+
+func TestLog(t *testing.T) {
+	store := &memoryStore{}
+	log := NewLog(store)
+	log.Now = func() time.Time { return time.Date(2023, 7, 1, 10, 0, 0, 123456789, time.UTC) }
+
+	ctxA := auth.WithIdentity(tenancy.WithTenant(requestid.WithRequestID(context.Background(), "req-1"), "team-a"), &auth.Identity{Subject: "alice"})
+	ctxB := tenancy.WithTenant(context.Background(), "team-b")
+
+	assert.NoError(t, log.Record(ctxA, Record{Action: ActionCreate, DocumentID: "1", After: map[string]string{"Data": "a"}}))
+	assert.NoError(t, log.Record(ctxB, Record{Action: ActionCreate, DocumentID: "2"}))
+	store.conflicts = 2
+	assert.NoError(t, log.Record(ctxA, Record{Action: ActionDelete, DocumentID: "1", Before: map[string]string{"Data": "a"}}))
+
+	entries, err := log.Query(ctxA, persistence.AuditFilter{})
+	assert.NoError(t, err)
+	assert.Len(t, entries, 2)
+	assert.Equal(t, "alice", entries[0].Actor)
+	assert.Equal(t, "req-1", entries[0].RequestID)
+	assert.Equal(t, `{"Data":"a"}`, entries[0].After)
+	assert.Equal(t, entries[0].Hash, entries[1].PrevHash)
+	assert.Equal(t, int64(2), entries[1].Seq)
+
+	entries, err = log.Query(ctxB, persistence.AuditFilter{})
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1)
+	assert.Equal(t, "anonymous", entries[0].Actor)
+	assert.Equal(t, "", entries[0].PrevHash, "each tenant has its own chain")
+
+	count, err := log.Verify(ctxA)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, count)
+
+	t.Run("fail - tampered entry", func(t *testing.T) {
+		for i := range store.entries {
+			if store.entries[i].TenantID == "team-a" && store.entries[i].Seq == 1 {
+				store.entries[i].Actor = "mallory"
+			}
+		}
+		count, err := log.Verify(ctxA)
+		assert.ErrorIs(t, err, ErrChainBroken)
+		assert.Equal(t, 0, count)
+	})
+
+	t.Run("fail - too many conflicts", func(t *testing.T) {
+		store.conflicts = maxAppendAttempts
+		assert.ErrorIs(t, log.Record(ctxB, Record{Action: ActionCreate}), persistence.ErrAuditConflict)
+	})
+}
+
+func TestDenials(t *testing.T) {
+	store := &memoryStore{}
+	log := NewLog(store)
+	now := time.Date(2023, 7, 1, 10, 0, 0, 0, time.UTC)
+	log.Now = func() time.Time { return now }
+	denials := NewDenials(log, time.Minute)
+
+	alice := auth.WithIdentity(context.Background(), &auth.Identity{Subject: "alice"})
+	bob := auth.WithIdentity(context.Background(), &auth.Identity{Subject: "bob"})
+	record := func(ctx context.Context, operation string) bool {
+		recorded, err := denials.Record(ctx, operation)
+		assert.NoError(t, err)
+		return recorded
+	}
+	assert.True(t, record(alice, "base:delete"))
+	for i := 0; i < 100; i++ {
+		assert.False(t, record(alice, "base:delete"), "repeated denials are not recorded again")
+	}
+	assert.True(t, record(alice, "admin:apikeys"), "another operation")
+	assert.True(t, record(bob, "base:delete"), "another caller")
+	now = now.Add(time.Minute)
+	assert.True(t, record(alice, "base:delete"), "once Every has passed")
+	assert.Len(t, store.entries, 4)
+
+	// Fresh keys are dropped oldest first once the map is full
+	denials.maxKeys = 10
+	for i := 0; i < denials.maxKeys; i++ {
+		now = now.Add(time.Millisecond)
+		record(auth.WithIdentity(context.Background(), &auth.Identity{Subject: strconv.Itoa(i)}), "base:delete")
+	}
+	assert.Len(t, denials.last, denials.maxKeys)
+	assert.True(t, record(alice, "base:delete"), "the oldest key was dropped")
+	assert.False(t, record(auth.WithIdentity(context.Background(), &auth.Identity{Subject: strconv.Itoa(denials.maxKeys - 1)}), "base:delete"))
+	assert.Len(t, denials.last, denials.maxKeys)
+}
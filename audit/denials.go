@@ -0,0 +1,63 @@
+package audit
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/Martin-Jast/go-microservice/tenancy"
+)
+
+// maxDenialKeys bounds the callers remembered by Denials, the expired ones and then the oldest are dropped when it is reached
+const maxDenialKeys = 10000
+
+// Denials appends the denied operations to a Log, a caller denied the same operation again within Every is not recorded again
+// so a caller retrying a forbidden request can't flood the chain
+type Denials struct {
+	Log   *Log
+	Every time.Duration
+
+	mu   sync.Mutex
+	last map[string]time.Time
+	// maxKeys is maxDenialKeys, smaller in tests
+	maxKeys int
+}
+
+// NewDenials creates a Denials recording at most one entry per tenant, caller and operation every every
+func NewDenials(log *Log, every time.Duration) *Denials {
+	return &Denials{Log: log, Every: every, last: map[string]time.Time{}, maxKeys: maxDenialKeys}
+}
+
+// Record appends an ActionDenied entry unless the caller in ctx was denied operation less than Every ago, recorded tells which
+func (d *Denials) Record(ctx context.Context, operation string) (recorded bool, err error) {
+	now := d.Log.now()
+	key := tenancy.TenantOrDefault(ctx) + "\x00" + actor(ctx) + "\x00" + operation
+	d.mu.Lock()
+	if last, ok := d.last[key]; ok && now.Sub(last) < d.Every {
+		d.mu.Unlock()
+		return false, nil
+	}
+	if _, ok := d.last[key]; !ok && len(d.last) >= d.maxKeys {
+		d.evict(now)
+	}
+	d.last[key] = now
+	d.mu.Unlock()
+	return true, d.Log.Record(ctx, Record{Action: ActionDenied, After: map[string]string{"operation": operation}})
+}
+
+// evict drops the expired keys, or the oldest one when they are all fresh
+func (d *Denials) evict(now time.Time) {
+	oldest, oldestAt := "", now
+	for k, last := range d.last {
+		if now.Sub(last) >= d.Every {
+			delete(d.last, k)
+			continue
+		}
+		if !last.After(oldestAt) {
+			oldest, oldestAt = k, last
+		}
+	}
+	if len(d.last) >= d.maxKeys {
+		delete(d.last, oldest)
+	}
+}
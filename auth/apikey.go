@@ -0,0 +1,167 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Martin-Jast/go-microservice/persistence"
+	"github.com/Martin-Jast/go-microservice/tenancy"
+)
+
+// APIKeyHeader is the header carrying the API key, "Authorization: ApiKey <key>" is accepted as well
+const APIKeyHeader = "X-API-Key"
+
+// keyPrefix makes the keys easy to recognize in logs and secret scanners
+const keyPrefix = "gms_"
+
+// HashAPIKey returns the representation of key that is stored, keys are random 256 bits so a plain SHA-256 is enough
+func HashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// apiKeyFromRequest returns the API key of the request, empty if there is none
+func apiKeyFromRequest(r *http.Request) string {
+	if key := r.Header.Get(APIKeyHeader); key != "" {
+		return key
+	}
+	scheme, key, found := strings.Cut(r.Header.Get("Authorization"), " ")
+	if found && strings.EqualFold(scheme, "ApiKey") {
+		return strings.TrimSpace(key)
+	}
+	return ""
+}
+
+// APIKeyManager creates, lists, revokes and authenticates API keys
+type APIKeyManager struct {
+	Store persistence.APIKeyStore
+	// Now is used to check expiration, time.Now if nil
+	Now func() time.Time
+	// Policy restricts the roles of a new key to the ones the caller in ctx can grant, they are not checked if nil
+	Policy *Policy
+}
+
+// NewAPIKeyManager creates a manager on top of store
+func NewAPIKeyManager(store persistence.APIKeyStore) *APIKeyManager {
+	return &APIKeyManager{Store: store}
+}
+
+func (m *APIKeyManager) now() time.Time {
+	if m.Now != nil {
+		return m.Now()
+	}
+	return time.Now()
+}
+
+// Create generates a new key for the tenant in ctx, the plain key is only returned here and never stored
+// A caller can't give the key a role granting more than its own roles, ErrForbidden is returned then
+func (m *APIKeyManager) Create(ctx context.Context, name string, roles []string, expiresAt *time.Time) (key string, stored persistence.APIKey, err error) {
+	if m.Policy != nil {
+		id := FromContext(ctx)
+		if id == nil {
+			return "", stored, ErrUnauthenticated
+		}
+		for _, role := range roles {
+			if !m.Policy.CanGrant(id.Roles, role) {
+				return "", stored, fmt.Errorf("%w: role %s grants more than the caller's roles", ErrForbidden, role)
+			}
+		}
+	}
+	if expiresAt != nil && !expiresAt.After(m.now()) {
+		return "", stored, fmt.Errorf("expiration must be in the future")
+	}
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", stored, err
+	}
+	key = keyPrefix + base64.RawURLEncoding.EncodeToString(secret)
+	createdAt := m.now()
+	stored = persistence.APIKey{
+		TenantID:  tenancy.TenantOrDefault(ctx),
+		Name:      name,
+		Prefix:    key[:len(keyPrefix)+6],
+		Hash:      HashAPIKey(key),
+		Roles:     roles,
+		CreatedAt: &createdAt,
+		ExpiresAt: expiresAt,
+	}
+	id, err := m.Store.CreateAPIKey(ctx, stored)
+	if err != nil {
+		return "", stored, err
+	}
+	stored.ID = &id
+	return key, stored, nil
+}
+
+// List lists the keys of the tenant in ctx
+func (m *APIKeyManager) List(ctx context.Context) ([]persistence.APIKey, error) {
+	return m.Store.ListAPIKeys(ctx)
+}
+
+// Revoke revokes a key of the tenant in ctx
+func (m *APIKeyManager) Revoke(ctx context.Context, id string) error {
+	return m.Store.RevokeAPIKey(ctx, id, m.now())
+}
+
+// Authenticate implements Authenticator for requests carrying an API key
+func (m *APIKeyManager) Authenticate(r *http.Request) (*Identity, error) {
+	key := apiKeyFromRequest(r)
+	if key == "" {
+		return nil, ErrNoCredentials
+	}
+	stored, err := m.Store.GetAPIKeyByHash(r.Context(), HashAPIKey(key))
+	if errors.Is(err, persistence.ErrAPIKeyNotFound) {
+		return nil, ErrInvalidCredentials
+	}
+	if err != nil {
+		return nil, err
+	}
+	if stored.RevokedAt != nil {
+		return nil, fmt.Errorf("%w: api key revoked", ErrInvalidCredentials)
+	}
+	if stored.ExpiresAt != nil && !stored.ExpiresAt.After(m.now()) {
+		return nil, fmt.Errorf("%w: api key expired", ErrInvalidCredentials)
+	}
+	return &Identity{
+		Subject: *stored.ID,
+		Tenant:  stored.TenantID,
+		Roles:   stored.Roles,
+		Method:  "apikey",
+	}, nil
+}
+
+// StaticKey authenticates a single key given through configuration, used to bootstrap the first admin
+type StaticKey struct {
+	hash     [sha256.Size]byte
+	identity Identity
+}
+
+// NewStaticKey creates an authenticator accepting only key and mapping it to identity
+func NewStaticKey(key string, identity Identity) StaticKey {
+	identity.Method = "apikey"
+	return StaticKey{hash: sha256.Sum256([]byte(key)), identity: identity}
+}
+
+// Authenticate implements Authenticator
+func (s StaticKey) Authenticate(r *http.Request) (*Identity, error) {
+	key := apiKeyFromRequest(r)
+	if key == "" {
+		return nil, ErrNoCredentials
+	}
+	hash := sha256.Sum256([]byte(key))
+	if subtle.ConstantTimeCompare(hash[:], s.hash[:]) != 1 {
+		// Let the other authenticators check the key
+		return nil, ErrNoCredentials
+	}
+	id := s.identity
+	return &id, nil
+}
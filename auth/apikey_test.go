@@ -0,0 +1,158 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Martin-Jast/go-microservice/persistence"
+	"github.com/Martin-Jast/go-microservice/tenancy"
+	"github.com/stretchr/testify/assert"
+)
+
+// memoryKeyStore keeps the keys in a map, scoped like the Mongo implementation
+type memoryKeyStore struct {
+	keys map[string]persistence.APIKey
+}
+
+func (m *memoryKeyStore) CreateAPIKey(ctx context.Context, key persistence.APIKey) (string, error) {
+	id := key.Hash[:8]
+	key.ID = &id
+	m.keys[id] = key
+	return id, nil
+}
+
+func (m *memoryKeyStore) GetAPIKeyByHash(ctx context.Context, hash string) (*persistence.APIKey, error) {
+	for _, k := range m.keys {
+		if k.Hash == hash {
+			return &k, nil
+		}
+	}
+	return nil, persistence.ErrAPIKeyNotFound
+}
+
+func (m *memoryKeyStore) ListAPIKeys(ctx context.Context) ([]persistence.APIKey, error) {
+	list := []persistence.APIKey{}
+	for _, k := range m.keys {
+		if k.TenantID == tenancy.TenantOrDefault(ctx) {
+			list = append(list, k)
+		}
+	}
+	return list, nil
+}
+
+func (m *memoryKeyStore) RevokeAPIKey(ctx context.Context, id string, at time.Time) error {
+	k, ok := m.keys[id]
+	if !ok || k.TenantID != tenancy.TenantOrDefault(ctx) {
+		return persistence.ErrAPIKeyNotFound
+	}
+	k.RevokedAt = &at
+	m.keys[id] = k
+	return nil
+}
+
+func TestAPIKeyMiddleware(t *testing.T) {
+	now := time.Now()
+	manager := NewAPIKeyManager(&memoryKeyStore{keys: map[string]persistence.APIKey{}})
+	manager.Now = func() time.Time { return now }
+	ctx := tenancy.WithTenant(context.Background(), "team-a")
+
+	validKey, stored, err := manager.Create(ctx, "valid", []string{"reader"}, nil)
+	assert.NoError(t, err)
+	assert.NotContains(t, stored.Hash, validKey)
+	expiresAt := now.Add(time.Hour)
+	expiringKey, _, err := manager.Create(ctx, "expiring", []string{"reader"}, &expiresAt)
+	assert.NoError(t, err)
+	revokedKey, revoked, err := manager.Create(ctx, "revoked", []string{"reader"}, nil)
+	assert.NoError(t, err)
+	assert.Error(t, manager.Revoke(context.Background(), *revoked.ID), "keys of other tenants can't be revoked")
+	assert.NoError(t, manager.Revoke(ctx, *revoked.ID))
+
+	handler := Middleware([]string{"/"},
+		NewStaticKey("bootstrap", Identity{Subject: "admin", Roles: []string{"admin"}}),
+		manager,
+	)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := FromContext(r.Context())
+		if id != nil {
+			w.Header().Set("Subject", id.Subject)
+			w.Header().Set("Tenant", id.Tenant)
+		}
+	}))
+
+	tt := []struct {
+		Name            string
+		Path            string
+		Header          http.Header
+		After           time.Duration
+		ExpectedCode    int
+		ExpectedSubject string
+		ExpectedTenant  string
+	}{
+		{Name: "success - public path", Path: "/", ExpectedCode: http.StatusOK},
+		{Name: "fail - no key", Path: "/base/1", ExpectedCode: http.StatusUnauthorized},
+		{Name: "fail - unknown key", Path: "/base/1", Header: http.Header{APIKeyHeader: {"gms_unknown"}}, ExpectedCode: http.StatusUnauthorized},
+		{Name: "success - stored key", Path: "/base/1", Header: http.Header{APIKeyHeader: {validKey}}, ExpectedCode: http.StatusOK, ExpectedSubject: *stored.ID, ExpectedTenant: "team-a"},
+		{Name: "success - authorization header", Path: "/base/1", Header: http.Header{"Authorization": {"ApiKey " + validKey}}, ExpectedCode: http.StatusOK, ExpectedSubject: *stored.ID, ExpectedTenant: "team-a"},
+		{Name: "success - bootstrap key", Path: "/base/1", Header: http.Header{APIKeyHeader: {"bootstrap"}}, ExpectedCode: http.StatusOK, ExpectedSubject: "admin"},
+		{Name: "success - key before expiration", Path: "/base/1", Header: http.Header{APIKeyHeader: {expiringKey}}, ExpectedCode: http.StatusOK, ExpectedTenant: "team-a"},
+		{Name: "fail - expired key", Path: "/base/1", Header: http.Header{APIKeyHeader: {expiringKey}}, After: 2 * time.Hour, ExpectedCode: http.StatusUnauthorized},
+		{Name: "fail - revoked key", Path: "/base/1", Header: http.Header{APIKeyHeader: {revokedKey}}, ExpectedCode: http.StatusUnauthorized},
+	}
+	for _, tc := range tt {
+		t.Run(tc.Name, func(t *testing.T) {
+			manager.Now = func() time.Time { return now.Add(tc.After) }
+			req := httptest.NewRequest(http.MethodGet, tc.Path, nil)
+			for k, v := range tc.Header {
+				req.Header.Set(k, v[0])
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			assert.Equal(t, tc.ExpectedCode, rec.Code)
+			if tc.ExpectedSubject != "" {
+				assert.Equal(t, tc.ExpectedSubject, rec.Header().Get("Subject"))
+			}
+			assert.Equal(t, tc.ExpectedTenant, rec.Header().Get("Tenant"))
+		})
+	}
+}
+
+func TestAPIKeyManager_CreateRoles(t *testing.T) {
+	manager := NewAPIKeyManager(&memoryKeyStore{keys: map[string]persistence.APIKey{}})
+	manager.Policy = &Policy{Roles: map[string][]string{
+		"reader":     {OpBaseRead},
+		"writer":     {"base:*"},
+		"keymanager": {OpAdminAPIKeys},
+		"admin":      {"*"},
+	}}
+
+	tt := []struct {
+		Name        string
+		Identity    *Identity
+		Roles       []string
+		ExpectedErr error
+	}{
+		{Name: "success - role held", Identity: &Identity{Roles: []string{"keymanager"}}, Roles: []string{"keymanager"}},
+		{Name: "success - operations covered", Identity: &Identity{Roles: []string{"writer"}}, Roles: []string{"reader"}},
+		{Name: "success - admin grants any role", Identity: &Identity{Roles: []string{"admin"}}, Roles: []string{"writer", "admin"}},
+		{Name: "fail - admin from a key manager", Identity: &Identity{Roles: []string{"keymanager"}}, Roles: []string{"admin"}, ExpectedErr: ErrForbidden},
+		{Name: "fail - one role over the caller's", Identity: &Identity{Roles: []string{"reader", "keymanager"}}, Roles: []string{"reader", "writer"}, ExpectedErr: ErrForbidden},
+		{Name: "fail - role outside the policy", Identity: &Identity{Roles: []string{"writer"}}, Roles: []string{"root"}, ExpectedErr: ErrForbidden},
+		{Name: "fail - no identity", Roles: []string{"reader"}, ExpectedErr: ErrUnauthenticated},
+	}
+	for _, tc := range tt {
+		t.Run(tc.Name, func(t *testing.T) {
+			ctx := context.Background()
+			if tc.Identity != nil {
+				ctx = WithIdentity(ctx, tc.Identity)
+			}
+			_, _, err := manager.Create(ctx, "key", tc.Roles, nil)
+			if tc.ExpectedErr != nil {
+				assert.ErrorIs(t, err, tc.ExpectedErr)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
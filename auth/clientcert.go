@@ -0,0 +1,49 @@
+package auth
+
+import (
+	"crypto/x509"
+	"fmt"
+	"net/http"
+)
+
+// ClientCertAuthenticator authenticates requests by the client certificate verified during the TLS handshake
+// The subject is the common name ( or the first DNS / URI SAN ), the tenant the first organization and
+// the roles the organizational units, unless Roles maps the subject to other roles
+type ClientCertAuthenticator struct {
+	// Roles replaces the roles of the listed subjects
+	Roles map[string][]string
+}
+
+// Authenticate implements Authenticator for requests over TLS with a verified client certificate
+func (c ClientCertAuthenticator) Authenticate(r *http.Request) (*Identity, error) {
+	// Certificates that were not verified against the client CAs are never trusted
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return nil, ErrNoCredentials
+	}
+	cert := r.TLS.VerifiedChains[0][0]
+	subject := certSubject(cert)
+	if subject == "" {
+		return nil, fmt.Errorf("%w: client certificate without subject", ErrInvalidCredentials)
+	}
+	id := &Identity{Subject: subject, Roles: cert.Subject.OrganizationalUnit, Method: "mtls"}
+	if len(cert.Subject.Organization) > 0 {
+		id.Tenant = cert.Subject.Organization[0]
+	}
+	if roles, ok := c.Roles[subject]; ok {
+		id.Roles = roles
+	}
+	return id, nil
+}
+
+func certSubject(cert *x509.Certificate) string {
+	if cert.Subject.CommonName != "" {
+		return cert.Subject.CommonName
+	}
+	if len(cert.DNSNames) > 0 {
+		return cert.DNSNames[0]
+	}
+	if len(cert.URIs) > 0 {
+		return cert.URIs[0].String()
+	}
+	return ""
+}
@@ -0,0 +1,57 @@
+package auth
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClientCertAuthenticator(t *testing.T) {
+	authenticator := ClientCertAuthenticator{Roles: map[string][]string{"ops": {"admin"}}}
+	tests := []struct {
+		name     string
+		tls      *tls.ConnectionState
+		expected *Identity
+		err      error
+	}{
+		{name: "plain HTTP", err: ErrNoCredentials},
+		{name: "no verified certificate", tls: &tls.ConnectionState{}, err: ErrNoCredentials},
+		{
+			name:     "subject mapped",
+			tls:      chain(&x509.Certificate{Subject: pkix.Name{CommonName: "billing", Organization: []string{"acme"}, OrganizationalUnit: []string{"reader", "writer"}}}),
+			expected: &Identity{Subject: "billing", Tenant: "acme", Roles: []string{"reader", "writer"}, Method: "mtls"},
+		},
+		{
+			name:     "roles replaced",
+			tls:      chain(&x509.Certificate{Subject: pkix.Name{CommonName: "ops", OrganizationalUnit: []string{"reader"}}}),
+			expected: &Identity{Subject: "ops", Roles: []string{"admin"}, Method: "mtls"},
+		},
+		{
+			name:     "SAN subject",
+			tls:      chain(&x509.Certificate{DNSNames: []string{"worker.internal"}}),
+			expected: &Identity{Subject: "worker.internal", Method: "mtls"},
+		},
+		{name: "no subject", tls: chain(&x509.Certificate{}), err: ErrInvalidCredentials},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/get/1", nil)
+			r.TLS = tt.tls
+			id, err := authenticator.Authenticate(r)
+			if tt.err != nil {
+				assert.ErrorIs(t, err, tt.err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, id)
+		})
+	}
+}
+
+func chain(cert *x509.Certificate) *tls.ConnectionState {
+	return &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert}}}
+}
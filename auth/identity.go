@@ -0,0 +1,132 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/Martin-Jast/go-microservice/tenancy"
+	"github.com/Martin-Jast/go-microservice/utils"
+)
+
+// Identity describes the authenticated caller of a request
+type Identity struct {
+	// Subject identifies the caller, e.g. the API key id
+	Subject string
+	// Tenant the caller belongs to, empty if the caller is not bound to a tenant
+	Tenant string
+	Roles  []string
+	// Method is the authentication method used ( "apikey", "jwt", ... )
+	Method string
+	// Claims of the token when authenticated with a JWT
+	Claims Claims
+}
+
+// HasRole checks if the identity was granted role
+func (i Identity) HasRole(role string) bool {
+	for _, r := range i.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// ErrNoCredentials is returned by an Authenticator when the request does not carry the kind of credentials it handles
+var ErrNoCredentials = errors.New("no credentials")
+
+// ErrInvalidCredentials is returned by an Authenticator when the request credentials are not valid
+var ErrInvalidCredentials = errors.New("invalid credentials")
+
+// Authenticator validates the credentials of a request and returns the caller identity
+type Authenticator interface {
+	Authenticate(r *http.Request) (*Identity, error)
+}
+
+type contextKey struct{}
+
+// WithIdentity returns a copy of ctx carrying the identity
+func WithIdentity(ctx context.Context, id *Identity) context.Context {
+	return context.WithValue(ctx, contextKey{}, id)
+}
+
+// FromContext returns the identity carried by ctx, nil if the request was not authenticated
+func FromContext(ctx context.Context) *Identity {
+	id, _ := ctx.Value(contextKey{}).(*Identity)
+	return id
+}
+
+// Middleware authenticates every request not in publicPaths using the first authenticator that recognizes its credentials
+// Requests without valid credentials get a 401
+func Middleware(publicPaths []string, authenticators ...Authenticator) func(http.Handler) http.Handler {
+	public := map[string]bool{}
+	for _, p := range publicPaths {
+		public[p] = true
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if public[r.URL.Path] {
+				next.ServeHTTP(w, r)
+				return
+			}
+			for _, authenticator := range authenticators {
+				id, err := authenticator.Authenticate(r)
+				if errors.Is(err, ErrNoCredentials) {
+					continue
+				}
+				if err != nil {
+					utils.WriteError(fmt.Errorf("unauthenticated: %v", err), w, http.StatusUnauthorized)
+					return
+				}
+				next.ServeHTTP(w, r.WithContext(WithIdentity(r.Context(), id)))
+				return
+			}
+			utils.WriteError(fmt.Errorf("unauthenticated: %v", ErrNoCredentials), w, http.StatusUnauthorized)
+		})
+	}
+}
+
+// TenantSource resolves the tenant from the authenticated identity, it must be placed after Middleware
+func TenantSource() tenancy.Source {
+	return func(r *http.Request) (string, bool) {
+		id := FromContext(r.Context())
+		if id == nil || id.Tenant == "" {
+			return "", false
+		}
+		return id.Tenant, true
+	}
+}
+
+// ErrTenantMismatch is returned when a request asks for another tenant than the one of its caller
+var ErrTenantMismatch = errors.New("tenant does not match the caller")
+
+// PinTenant keeps authenticated callers in their tenant, it must be placed after Middleware and before the tenancy middleware
+// requested are the sources the client controls ( header, subdomain ): a requested tenant must be the tenant of the identity,
+// identities without a tenant can only request one if they are granted OpTenantAny
+func PinTenant(authz Authorizer, requested ...tenancy.Source) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			id := FromContext(r.Context())
+			if id == nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+			for _, source := range requested {
+				tenant, found := source(r)
+				if !found {
+					continue
+				}
+				if id.Tenant != "" && tenant != id.Tenant {
+					utils.WriteError(fmt.Errorf("%w: %s", ErrTenantMismatch, tenant), w, http.StatusForbidden)
+					return
+				}
+				if id.Tenant == "" && (authz == nil || authz.Authorize(r.Context(), OpTenantAny) != nil) {
+					utils.WriteError(fmt.Errorf("%w: %s", ErrForbidden, OpTenantAny), w, http.StatusForbidden)
+					return
+				}
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
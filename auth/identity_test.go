@@ -0,0 +1,51 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Martin-Jast/go-microservice/tenancy"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPinTenant(t *testing.T) {
+	header := tenancy.HeaderSource("")
+	handler := Middleware([]string{"/"},
+		NewStaticKey("tenant-key", Identity{Subject: "team-a-writer", Tenant: "team-a", Roles: []string{"writer"}}),
+		NewStaticKey("tenantless-key", Identity{Subject: "writer", Roles: []string{"writer"}}),
+		NewStaticKey("admin-key", Identity{Subject: "bootstrap-admin", Roles: []string{"admin"}}),
+	)(PinTenant(DefaultPolicy(), header)(
+		tenancy.Middleware(false, TenantSource(), header)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Tenant", tenancy.TenantOrDefault(r.Context()))
+		})),
+	))
+
+	tt := []struct {
+		Name           string
+		Key            string
+		Tenant         string
+		ExpectedCode   int
+		ExpectedTenant string
+	}{
+		{Name: "success - tenant of the identity", Key: "tenant-key", ExpectedCode: http.StatusOK, ExpectedTenant: "team-a"},
+		{Name: "success - header matching the identity", Key: "tenant-key", Tenant: "team-a", ExpectedCode: http.StatusOK, ExpectedTenant: "team-a"},
+		{Name: "fail - header of another tenant", Key: "tenant-key", Tenant: "other", ExpectedCode: http.StatusForbidden},
+		{Name: "success - tenantless key without header", Key: "tenantless-key", ExpectedCode: http.StatusOK, ExpectedTenant: tenancy.DefaultTenant},
+		{Name: "fail - tenantless key choosing a tenant", Key: "tenantless-key", Tenant: "other", ExpectedCode: http.StatusForbidden},
+		{Name: "success - cross-tenant admin", Key: "admin-key", Tenant: "other", ExpectedCode: http.StatusOK, ExpectedTenant: "other"},
+	}
+	for _, tc := range tt {
+		t.Run(tc.Name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/base/1", nil)
+			req.Header.Set(APIKeyHeader, tc.Key)
+			if tc.Tenant != "" {
+				req.Header.Set(tenancy.DefaultHeader, tc.Tenant)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			assert.Equal(t, tc.ExpectedCode, rec.Code)
+			assert.Equal(t, tc.ExpectedTenant, rec.Header().Get("Tenant"))
+		})
+	}
+}
@@ -0,0 +1,194 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"os"
+	"sync"
+	"time"
+)
+
+// jwksCheckInterval is the minimum time between two checks for changes of the JWKS file
+const jwksCheckInterval = time.Second
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+type jwksKey struct {
+	alg string
+	key crypto.PublicKey
+}
+
+// JWKSFile holds the public keys of a local JWKS file, the file is reloaded when it changes on disk
+type JWKSFile struct {
+	path string
+
+	mu        sync.Mutex
+	keys      map[string]jwksKey
+	modTime   time.Time
+	size      int64
+	lastCheck time.Time
+}
+
+// LoadJWKSFile reads the keys of the JWKS file in path
+func LoadJWKSFile(path string) (*JWKSFile, error) {
+	j := &JWKSFile{path: path}
+	if err := j.Reload(); err != nil {
+		return nil, err
+	}
+	return j, nil
+}
+
+// Reload reads the file again, the current keys are kept if the new file is not valid
+func (j *JWKSFile) Reload() error {
+	info, err := os.Stat(j.path)
+	if err != nil {
+		return err
+	}
+	raw, err := os.ReadFile(j.path)
+	if err != nil {
+		return err
+	}
+	keys, err := parseJWKS(raw)
+	if err != nil {
+		return fmt.Errorf("invalid jwks file %s: %v", j.path, err)
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.keys = keys
+	j.modTime = info.ModTime()
+	j.size = info.Size()
+	j.lastCheck = time.Now()
+	return nil
+}
+
+// reloadIfChanged reloads the file if its modification time or size changed since it was loaded
+func (j *JWKSFile) reloadIfChanged() {
+	j.mu.Lock()
+	if time.Since(j.lastCheck) < jwksCheckInterval {
+		j.mu.Unlock()
+		return
+	}
+	j.lastCheck = time.Now()
+	modTime, size := j.modTime, j.size
+	j.mu.Unlock()
+
+	info, err := os.Stat(j.path)
+	if err != nil || (info.ModTime().Equal(modTime) && info.Size() == size) {
+		return
+	}
+	// A broken file keeps the previous keys, the next change will be picked up
+	_ = j.Reload()
+}
+
+// Key returns the key identified by kid that can be used with alg, if kid is empty the only key for alg is used
+func (j *JWKSFile) Key(kid, alg string) (crypto.PublicKey, error) {
+	j.reloadIfChanged()
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if kid != "" {
+		k, ok := j.keys[kid]
+		if !ok || k.alg != alg {
+			return nil, fmt.Errorf("unknown key: %q", kid)
+		}
+		return k.key, nil
+	}
+	var found crypto.PublicKey
+	for _, k := range j.keys {
+		if k.alg != alg {
+			continue
+		}
+		if found != nil {
+			return nil, fmt.Errorf("token has no kid and there are several %s keys", alg)
+		}
+		found = k.key
+	}
+	if found == nil {
+		return nil, fmt.Errorf("no %s key", alg)
+	}
+	return found, nil
+}
+
+func parseJWKS(raw []byte) (map[string]jwksKey, error) {
+	set := struct {
+		Keys []jwk `json:"keys"`
+	}{}
+	if err := json.Unmarshal(raw, &set); err != nil {
+		return nil, err
+	}
+	keys := map[string]jwksKey{}
+	for i, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		parsed, err := k.publicKey()
+		if err != nil {
+			return nil, fmt.Errorf("key %d: %v", i, err)
+		}
+		kid := k.Kid
+		if kid == "" {
+			kid = fmt.Sprintf("#%d", i)
+		}
+		keys[kid] = parsed
+	}
+	return keys, nil
+}
+
+func (k jwk) publicKey() (jwksKey, error) {
+	switch k.Kty {
+	case "RSA":
+		if k.Alg != "" && k.Alg != "RS256" {
+			return jwksKey{}, fmt.Errorf("unsupported algorithm %s", k.Alg)
+		}
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return jwksKey{}, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return jwksKey{}, err
+		}
+		return jwksKey{alg: "RS256", key: &rsa.PublicKey{N: n, E: int(e.Int64())}}, nil
+	case "EC":
+		if k.Crv != "P-256" || (k.Alg != "" && k.Alg != "ES256") {
+			return jwksKey{}, fmt.Errorf("unsupported curve %s", k.Crv)
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return jwksKey{}, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return jwksKey{}, err
+		}
+		key := &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}
+		if !key.Curve.IsOnCurve(x, y) {
+			return jwksKey{}, fmt.Errorf("point is not on the curve")
+		}
+		return jwksKey{alg: "ES256", key: key}, nil
+	}
+	return jwksKey{}, fmt.Errorf("unsupported key type %q", k.Kty)
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(raw), nil
+}
@@ -0,0 +1,216 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Claims are the claims of a validated JWT
+type Claims map[string]interface{}
+
+// String returns the claim as a string, empty if it is missing or not a string
+func (c Claims) String(name string) string {
+	s, _ := c[name].(string)
+	return s
+}
+
+// Strings returns the claim as a list of strings, a single string is returned as a one element list
+func (c Claims) Strings(name string) []string {
+	switch v := c[name].(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		list := []string{}
+		for _, elem := range v {
+			if s, ok := elem.(string); ok {
+				list = append(list, s)
+			}
+		}
+		return list
+	}
+	return nil
+}
+
+// time returns a NumericDate claim, false if it is missing
+func (c Claims) time(name string) (time.Time, bool, error) {
+	v, ok := c[name]
+	if !ok {
+		return time.Time{}, false, nil
+	}
+	n, ok := v.(float64)
+	if !ok {
+		return time.Time{}, false, fmt.Errorf("claim %s is not a numeric date", name)
+	}
+	return time.Unix(int64(n), 0), true, nil
+}
+
+// JWTAuthenticator validates bearer tokens signed with HS256 using Secret or RS256/ES256 using the keys of JWKS
+type JWTAuthenticator struct {
+	// Secret enables HS256 tokens
+	Secret []byte
+	// JWKS enables RS256 and ES256 tokens
+	JWKS *JWKSFile
+	// Issuer and Audience are checked against iss and aud when set
+	Issuer   string
+	Audience string
+	// Leeway tolerated when checking exp and nbf, exp is required
+	Leeway time.Duration
+	// TenantClaim and RolesClaim name the claims mapped into the Identity ( "tenant" and "roles" if empty )
+	TenantClaim string
+	RolesClaim  string
+	// Now is used to check exp and nbf, time.Now if nil
+	Now func() time.Time
+}
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+// Authenticate implements Authenticator for requests with an "Authorization: Bearer <token>" header
+func (j *JWTAuthenticator) Authenticate(r *http.Request) (*Identity, error) {
+	scheme, token, found := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !found || !strings.EqualFold(scheme, "Bearer") {
+		return nil, ErrNoCredentials
+	}
+	claims, err := j.Validate(strings.TrimSpace(token))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidCredentials, err)
+	}
+	tenantClaim, rolesClaim := j.TenantClaim, j.RolesClaim
+	if tenantClaim == "" {
+		tenantClaim = "tenant"
+	}
+	if rolesClaim == "" {
+		rolesClaim = "roles"
+	}
+	return &Identity{
+		Subject: claims.String("sub"),
+		Tenant:  claims.String(tenantClaim),
+		Roles:   claims.Strings(rolesClaim),
+		Method:  "jwt",
+		Claims:  claims,
+	}, nil
+}
+
+// Validate checks the signature and the registered claims of token and returns its claims
+func (j *JWTAuthenticator) Validate(token string) (Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed token")
+	}
+	header := jwtHeader{}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("malformed header: %v", err)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("malformed signature: %v", err)
+	}
+	if err := j.verify(header, []byte(parts[0]+"."+parts[1]), signature); err != nil {
+		return nil, err
+	}
+	claims := Claims{}
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("malformed claims: %v", err)
+	}
+	return claims, j.checkClaims(claims)
+}
+
+// verify checks the signature with the key matching the algorithm, the algorithm is only accepted if that kind of key was configured
+func (j *JWTAuthenticator) verify(header jwtHeader, signed, signature []byte) error {
+	switch header.Alg {
+	case "HS256":
+		if len(j.Secret) == 0 {
+			return errors.New("HS256 tokens are not accepted")
+		}
+		mac := hmac.New(sha256.New, j.Secret)
+		mac.Write(signed)
+		if !hmac.Equal(signature, mac.Sum(nil)) {
+			return errors.New("invalid signature")
+		}
+		return nil
+	case "RS256", "ES256":
+		if j.JWKS == nil {
+			return fmt.Errorf("%s tokens are not accepted", header.Alg)
+		}
+		key, err := j.JWKS.Key(header.Kid, header.Alg)
+		if err != nil {
+			return err
+		}
+		digest := sha256.Sum256(signed)
+		switch k := key.(type) {
+		case *rsa.PublicKey:
+			if err := rsa.VerifyPKCS1v15(k, crypto.SHA256, digest[:], signature); err != nil {
+				return errors.New("invalid signature")
+			}
+			return nil
+		case *ecdsa.PublicKey:
+			if len(signature) != 64 {
+				return errors.New("invalid signature")
+			}
+			r, s := new(big.Int).SetBytes(signature[:32]), new(big.Int).SetBytes(signature[32:])
+			if !ecdsa.Verify(k, digest[:], r, s) {
+				return errors.New("invalid signature")
+			}
+			return nil
+		}
+	}
+	return fmt.Errorf("unsupported algorithm: %q", header.Alg)
+}
+
+func (j *JWTAuthenticator) checkClaims(claims Claims) error {
+	now := time.Now()
+	if j.Now != nil {
+		now = j.Now()
+	}
+	// A token without exp would be valid forever
+	exp, ok, err := claims.time("exp")
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errors.New("token has no expiry")
+	}
+	if !now.Before(exp.Add(j.Leeway)) {
+		return errors.New("token expired")
+	}
+	nbf, ok, err := claims.time("nbf")
+	if err != nil {
+		return err
+	}
+	if ok && now.Add(j.Leeway).Before(nbf) {
+		return errors.New("token not valid yet")
+	}
+	if j.Issuer != "" && claims.String("iss") != j.Issuer {
+		return errors.New("invalid issuer")
+	}
+	if j.Audience != "" {
+		for _, aud := range claims.Strings("aud") {
+			if aud == j.Audience {
+				return nil
+			}
+		}
+		return errors.New("invalid audience")
+	}
+	return nil
+}
+
+func decodeSegment(segment string, v interface{}) error {
+	raw, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(raw, v)
+}
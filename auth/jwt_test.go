@@ -0,0 +1,141 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func b64(raw []byte) string {
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+// signToken creates a token signed with key, key is a []byte for HS256 or a private key for RS256/ES256
+func signToken(t *testing.T, alg, kid string, key interface{}, claims Claims) string {
+	header, _ := json.Marshal(jwtHeader{Alg: alg, Kid: kid})
+	payload, _ := json.Marshal(claims)
+	signed := b64(header) + "." + b64(payload)
+	digest := sha256.Sum256([]byte(signed))
+	var signature []byte
+	switch k := key.(type) {
+	case []byte:
+		mac := hmac.New(sha256.New, k)
+		mac.Write([]byte(signed))
+		signature = mac.Sum(nil)
+	case *rsa.PrivateKey:
+		var err error
+		signature, err = rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, digest[:])
+		assert.NoError(t, err)
+	case *ecdsa.PrivateKey:
+		r, s, err := ecdsa.Sign(rand.Reader, k, digest[:])
+		assert.NoError(t, err)
+		signature = make([]byte, 64)
+		r.FillBytes(signature[:32])
+		s.FillBytes(signature[32:])
+	}
+	return signed + "." + b64(signature)
+}
+
+func writeJWKS(t *testing.T, path string, keys ...jwk) {
+	raw, _ := json.Marshal(map[string]interface{}{"keys": keys})
+	assert.NoError(t, os.WriteFile(path, raw, 0600))
+}
+
+func TestJWTAuthenticator(t *testing.T) {
+	now := time.Now()
+	secret := []byte("shared-secret")
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	rotatedKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+
+	rsaJWK := jwk{Kty: "RSA", Kid: "rsa-1", N: b64(rsaKey.N.Bytes()), E: b64(big.NewInt(int64(rsaKey.E)).Bytes())}
+	ecJWK := jwk{Kty: "EC", Kid: "ec-1", Crv: "P-256", X: b64(ecKey.X.FillBytes(make([]byte, 32))), Y: b64(ecKey.Y.FillBytes(make([]byte, 32)))}
+	jwksPath := filepath.Join(t.TempDir(), "jwks.json")
+	writeJWKS(t, jwksPath, rsaJWK, ecJWK)
+	jwks, err := LoadJWKSFile(jwksPath)
+	assert.NoError(t, err)
+
+	authenticator := &JWTAuthenticator{
+		Secret:   secret,
+		JWKS:     jwks,
+		Issuer:   "platform",
+		Audience: "go-microservice",
+		Now:      func() time.Time { return now },
+	}
+	valid := func(extra Claims) Claims {
+		claims := Claims{"sub": "user-1", "iss": "platform", "aud": []string{"other", "go-microservice"}, "exp": now.Add(time.Minute).Unix(), "tenant": "team-a", "roles": []string{"writer"}}
+		for k, v := range extra {
+			claims[k] = v
+		}
+		return claims
+	}
+	noExpiry := valid(nil)
+	delete(noExpiry, "exp")
+
+	tt := []struct {
+		Name      string
+		Token     string
+		ExpectErr bool
+	}{
+		{Name: "success - HS256", Token: signToken(t, "HS256", "", secret, valid(nil))},
+		{Name: "success - RS256", Token: signToken(t, "RS256", "rsa-1", rsaKey, valid(nil))},
+		{Name: "success - ES256", Token: signToken(t, "ES256", "ec-1", ecKey, valid(nil))},
+		{Name: "success - RS256 with no kid", Token: signToken(t, "RS256", "", rsaKey, valid(nil))},
+		{Name: "fail - wrong secret", Token: signToken(t, "HS256", "", []byte("other"), valid(nil)), ExpectErr: true},
+		{Name: "fail - key not in jwks", Token: signToken(t, "RS256", "rsa-1", rotatedKey, valid(nil)), ExpectErr: true},
+		{Name: "fail - alg does not match key", Token: signToken(t, "ES256", "rsa-1", ecKey, valid(nil)), ExpectErr: true},
+		{Name: "fail - alg none", Token: signToken(t, "none", "", nil, valid(nil)), ExpectErr: true},
+		{Name: "fail - no expiry", Token: signToken(t, "HS256", "", secret, noExpiry), ExpectErr: true},
+		{Name: "fail - expired", Token: signToken(t, "HS256", "", secret, valid(Claims{"exp": now.Add(-time.Second).Unix()})), ExpectErr: true},
+		{Name: "fail - not valid yet", Token: signToken(t, "HS256", "", secret, valid(Claims{"nbf": now.Add(time.Minute).Unix()})), ExpectErr: true},
+		{Name: "fail - wrong issuer", Token: signToken(t, "HS256", "", secret, valid(Claims{"iss": "someone"})), ExpectErr: true},
+		{Name: "fail - wrong audience", Token: signToken(t, "HS256", "", secret, valid(Claims{"aud": "other"})), ExpectErr: true},
+		{Name: "fail - malformed", Token: "not.a-token", ExpectErr: true},
+	}
+	for _, tc := range tt {
+		t.Run(tc.Name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/base/1", nil)
+			req.Header.Set("Authorization", "Bearer "+tc.Token)
+			id, err := authenticator.Authenticate(req)
+			if tc.ExpectErr {
+				assert.ErrorIs(t, err, ErrInvalidCredentials)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, "user-1", id.Subject)
+			assert.Equal(t, "team-a", id.Tenant)
+			assert.Equal(t, []string{"writer"}, id.Roles)
+			assert.Equal(t, "platform", id.Claims.String("iss"))
+		})
+	}
+
+	t.Run("success - jwks reloaded on change", func(t *testing.T) {
+		rotatedJWK := jwk{Kty: "RSA", Kid: "rsa-2", N: b64(rotatedKey.N.Bytes()), E: b64(big.NewInt(int64(rotatedKey.E)).Bytes())}
+		writeJWKS(t, jwksPath, rotatedJWK)
+		jwks.lastCheck = time.Time{}
+		jwks.modTime = time.Time{}
+		_, err := authenticator.Validate(signToken(t, "RS256", "rsa-2", rotatedKey, valid(nil)))
+		assert.NoError(t, err)
+		_, err = authenticator.Validate(signToken(t, "RS256", "rsa-1", rsaKey, valid(nil)))
+		assert.Error(t, err)
+	})
+
+	t.Run("success - no bearer token", func(t *testing.T) {
+		_, err := authenticator.Authenticate(httptest.NewRequest("GET", "/base/1", nil))
+		assert.ErrorIs(t, err, ErrNoCredentials)
+	})
+}
@@ -0,0 +1,146 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// Operations checked by the service
+const (
+	OpBaseRead        = "base:read"
+	OpBaseCreate      = "base:create"
+	OpBaseDelete      = "base:delete"
+	OpAdminAPIKeys    = "admin:apikeys"
+	OpAdminShutdown   = "admin:shutdown"
+	OpAdminRateLimits = "admin:ratelimits"
+	OpAdminConfig     = "admin:config"
+	OpAdminDebug      = "admin:debug"
+	OpAuditRead       = "audit:read"
+	// OpTenantAny lets a caller without a tenant act in the tenant it asks for
+	OpTenantAny = "tenant:any"
+)
+
+// ErrUnauthenticated is returned by an Authorizer when the context carries no identity
+var ErrUnauthenticated = errors.New("unauthenticated")
+
+// ErrForbidden is returned by an Authorizer when the identity is not allowed to perform the operation
+var ErrForbidden = errors.New("forbidden")
+
+// Authorizer decides if the identity in ctx can perform operation
+type Authorizer interface {
+	Authorize(ctx context.Context, operation string) error
+}
+
+// Policy maps roles to the operations they grant, an operation can be "*" ( everything ) or end with ":*" ( every operation of a resource )
+type Policy struct {
+	Roles map[string][]string `json:"roles"`
+	// OnDenied is called for every denied operation, it only logs if nil
+	OnDenied func(ctx context.Context, operation string, id *Identity) `json:"-"`
+}
+
+// DefaultPolicy is used when no policy file is configured
+func DefaultPolicy() *Policy {
+	return &Policy{
+		Roles: map[string][]string{
+			"reader": {OpBaseRead},
+			"writer": {OpBaseRead, OpBaseCreate, OpBaseDelete},
+			"admin":  {"*"},
+		},
+	}
+}
+
+// LoadPolicyFile reads a policy from a JSON file like {"roles": {"reader": ["base:read"]}}
+func LoadPolicyFile(path string) (*Policy, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	policy := &Policy{}
+	if err := json.Unmarshal(raw, policy); err != nil {
+		return nil, fmt.Errorf("invalid policy file %s: %v", path, err)
+	}
+	if err := policy.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid policy file %s: %v", path, err)
+	}
+	return policy, nil
+}
+
+// Validate checks that every operation of the policy is well formed
+func (p *Policy) Validate() error {
+	for role, operations := range p.Roles {
+		for _, op := range operations {
+			if op == "*" {
+				continue
+			}
+			resource, action, found := strings.Cut(op, ":")
+			if !found || resource == "" || action == "" {
+				return fmt.Errorf("role %s: invalid operation %q", role, op)
+			}
+		}
+	}
+	return nil
+}
+
+// Allows checks if any of roles grants operation
+func (p *Policy) Allows(roles []string, operation string) bool {
+	resource, _, _ := strings.Cut(operation, ":")
+	for _, role := range roles {
+		for _, granted := range p.Roles[role] {
+			if granted == "*" || granted == operation || granted == resource+":*" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// CanGrant checks if a holder of roles may give role to another caller: it holds role, or role is in the policy
+// and every operation it grants is allowed to roles
+func (p *Policy) CanGrant(roles []string, role string) bool {
+	for _, held := range roles {
+		if held == role {
+			return true
+		}
+	}
+	operations, ok := p.Roles[role]
+	if !ok {
+		return false
+	}
+	for _, op := range operations {
+		if !p.Allows(roles, op) {
+			return false
+		}
+	}
+	return true
+}
+
+// Authorize implements Authorizer
+func (p *Policy) Authorize(ctx context.Context, operation string) error {
+	id := FromContext(ctx)
+	if id == nil {
+		p.denied(ctx, operation, id)
+		return ErrUnauthenticated
+	}
+	if !p.Allows(id.Roles, operation) {
+		p.denied(ctx, operation, id)
+		return fmt.Errorf("%w: %s", ErrForbidden, operation)
+	}
+	return nil
+}
+
+func (p *Policy) denied(ctx context.Context, operation string, id *Identity) {
+	if p.OnDenied != nil {
+		p.OnDenied(ctx, operation, id)
+		return
+	}
+	subject := "anonymous"
+	if id != nil {
+		subject = id.Subject
+	}
+	slog.WarnContext(ctx, "operation denied", "package", "auth", "operation", operation, "subject", subject)
+}
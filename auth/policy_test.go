@@ -0,0 +1,71 @@
+package auth
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPolicy(t *testing.T) {
+	denied := []string{}
+	policy := &Policy{
+		Roles: map[string][]string{
+			"reader":  {OpBaseRead},
+			"writer":  {"base:*"},
+			"admin":   {"*"},
+			"auditor": {"audit:read"},
+		},
+		OnDenied: func(ctx context.Context, operation string, id *Identity) {
+			denied = append(denied, operation)
+		},
+	}
+	tt := []struct {
+		Name        string
+		Identity    *Identity
+		Operation   string
+		ExpectedErr error
+	}{
+		{Name: "success - role grants operation", Identity: &Identity{Roles: []string{"reader"}}, Operation: OpBaseRead},
+		{Name: "success - resource wildcard", Identity: &Identity{Roles: []string{"writer"}}, Operation: OpBaseDelete},
+		{Name: "success - any of the roles", Identity: &Identity{Roles: []string{"auditor", "reader"}}, Operation: OpBaseRead},
+		{Name: "success - admin wildcard", Identity: &Identity{Roles: []string{"admin"}}, Operation: OpAdminShutdown},
+		{Name: "fail - role does not grant operation", Identity: &Identity{Roles: []string{"reader"}}, Operation: OpBaseCreate, ExpectedErr: ErrForbidden},
+		{Name: "fail - resource wildcard only covers its resource", Identity: &Identity{Roles: []string{"writer"}}, Operation: OpAdminShutdown, ExpectedErr: ErrForbidden},
+		{Name: "fail - unknown role", Identity: &Identity{Roles: []string{"root"}}, Operation: OpBaseRead, ExpectedErr: ErrForbidden},
+		{Name: "fail - no identity", Operation: OpBaseRead, ExpectedErr: ErrUnauthenticated},
+	}
+	for _, tc := range tt {
+		t.Run(tc.Name, func(t *testing.T) {
+			denied = []string{}
+			ctx := context.Background()
+			if tc.Identity != nil {
+				ctx = WithIdentity(ctx, tc.Identity)
+			}
+			err := policy.Authorize(ctx, tc.Operation)
+			if tc.ExpectedErr == nil {
+				assert.NoError(t, err)
+				assert.Empty(t, denied)
+				return
+			}
+			assert.ErrorIs(t, err, tc.ExpectedErr)
+			assert.Equal(t, []string{tc.Operation}, denied)
+		})
+	}
+}
+
+func TestLoadPolicyFile(t *testing.T) {
+	dir := t.TempDir()
+	valid := filepath.Join(dir, "valid.json")
+	assert.NoError(t, os.WriteFile(valid, []byte(`{"roles": {"reader": ["base:read"], "admin": ["*"]}}`), 0600))
+	invalid := filepath.Join(dir, "invalid.json")
+	assert.NoError(t, os.WriteFile(invalid, []byte(`{"roles": {"reader": ["read"]}}`), 0600))
+
+	policy, err := LoadPolicyFile(valid)
+	assert.NoError(t, err)
+	assert.True(t, policy.Allows([]string{"reader"}, OpBaseRead))
+	_, err = LoadPolicyFile(invalid)
+	assert.Error(t, err)
+}
@@ -0,0 +1,220 @@
+package certs
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// checkInterval is the minimum time between two checks for changes of the certificate files
+const checkInterval = time.Second
+
+// KeyPair holds the certificate of the server, the files are reloaded when they change on disk
+// so renewed certificates are served without a restart
+type KeyPair struct {
+	certFile string
+	keyFile  string
+
+	mu        sync.Mutex
+	cert      *tls.Certificate
+	files     [2]fileState
+	lastCheck time.Time
+	// rejected are the files of the last failed reload, logged once
+	rejected [2]fileState
+}
+
+type fileState struct {
+	modTime time.Time
+	size    int64
+}
+
+// LoadKeyPair reads the PEM certificate ( with its chain ) and key files
+func LoadKeyPair(certFile, keyFile string) (*KeyPair, error) {
+	k := &KeyPair{certFile: certFile, keyFile: keyFile}
+	if err := k.Reload(); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+func (k *KeyPair) stat() ([2]fileState, error) {
+	states := [2]fileState{}
+	for i, path := range []string{k.certFile, k.keyFile} {
+		info, err := os.Stat(path)
+		if err != nil {
+			return states, err
+		}
+		states[i] = fileState{modTime: info.ModTime(), size: info.Size()}
+	}
+	return states, nil
+}
+
+// Reload reads the files again, the current certificate is kept if the new files are not a valid pair
+func (k *KeyPair) Reload() error {
+	states, err := k.stat()
+	if err != nil {
+		return err
+	}
+	cert, err := tls.LoadX509KeyPair(k.certFile, k.keyFile)
+	if err != nil {
+		return fmt.Errorf("invalid certificate %s: %v", k.certFile, err)
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.cert = &cert
+	k.files = states
+	k.lastCheck = time.Now()
+	return nil
+}
+
+// reloadIfChanged reloads the files if the modification time or size of one of them changed since they were loaded
+func (k *KeyPair) reloadIfChanged() {
+	k.mu.Lock()
+	if time.Since(k.lastCheck) < checkInterval {
+		k.mu.Unlock()
+		return
+	}
+	k.lastCheck = time.Now()
+	files := k.files
+	k.mu.Unlock()
+
+	states, err := k.stat()
+	if err != nil || states == files {
+		return
+	}
+	// The certificate and the key are not replaced at the same instant, a mismatched pair
+	// keeps the previous certificate until the next change
+	if err := k.Reload(); err != nil {
+		k.mu.Lock()
+		logged := k.rejected == states
+		k.rejected = states
+		k.mu.Unlock()
+		if !logged {
+			slog.Error("could not reload the certificate, the previous one is served", "package", "certs", "file", k.certFile, "error", err)
+		}
+	}
+}
+
+// GetCertificate returns the current certificate, it is used as tls.Config.GetCertificate
+func (k *KeyPair) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	k.reloadIfChanged()
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return k.cert, nil
+}
+
+// Options are the TLS settings of the server
+type Options struct {
+	// MinVersion is "1.2" ( default ) or "1.3"
+	MinVersion string
+	// CipherSuites are the names of the TLS 1.2 suites allowed, the Go defaults if empty ( TLS 1.3 suites are not configurable )
+	CipherSuites []string
+	// ClientCAFile enables mutual TLS, client certificates must be signed by one of its CAs
+	ClientCAFile string
+	// ClientAuth is "request" ( a certificate is verified if sent ) or "require", only used with ClientCAFile
+	ClientAuth string
+}
+
+var versions = map[string]uint16{
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// ParseVersion returns the TLS version named by s, older versions than 1.2 are refused
+func ParseVersion(s string) (uint16, error) {
+	if s == "" {
+		return tls.VersionTLS12, nil
+	}
+	version, ok := versions[strings.TrimPrefix(s, "TLS")]
+	if !ok {
+		return 0, fmt.Errorf("unsupported TLS version: %q ( 1.2 or 1.3 )", s)
+	}
+	return version, nil
+}
+
+// ParseCipherSuites returns the ids of the named suites, insecure suites are refused
+func ParseCipherSuites(names []string) ([]uint16, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+	known := map[string]uint16{}
+	for _, suite := range tls.CipherSuites() {
+		known[suite.Name] = suite.ID
+	}
+	ids := []uint16{}
+	for _, name := range names {
+		id, ok := known[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown or insecure cipher suite: %q", name)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// CheckHTTP2Suites returns an error if suites is not empty and lacks the suite HTTP/2 requires of TLS 1.2 servers
+func CheckHTTP2Suites(suites []uint16) error {
+	if len(suites) == 0 {
+		return nil
+	}
+	for _, id := range suites {
+		if id == tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256 || id == tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256 {
+			return nil
+		}
+	}
+	return fmt.Errorf("HTTP/2 requires TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256 or TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256")
+}
+
+// ParseClientAuth returns how client certificates are checked, there are none without a CA
+func ParseClientAuth(clientAuth, caFile string) (tls.ClientAuthType, error) {
+	if caFile == "" {
+		return tls.NoClientCert, nil
+	}
+	switch clientAuth {
+	case "", "request":
+		return tls.VerifyClientCertIfGiven, nil
+	case "require":
+		return tls.RequireAndVerifyClientCert, nil
+	}
+	return 0, fmt.Errorf("unknown client auth: %q ( request or require )", clientAuth)
+}
+
+// NewConfig creates the TLS configuration serving the certificate of pair, HTTP/2 is negotiated with ALPN
+func NewConfig(pair *KeyPair, opts Options) (*tls.Config, error) {
+	minVersion, err := ParseVersion(opts.MinVersion)
+	if err != nil {
+		return nil, err
+	}
+	suites, err := ParseCipherSuites(opts.CipherSuites)
+	if err != nil {
+		return nil, err
+	}
+	clientAuth, err := ParseClientAuth(opts.ClientAuth, opts.ClientCAFile)
+	if err != nil {
+		return nil, err
+	}
+	config := &tls.Config{
+		GetCertificate: pair.GetCertificate,
+		MinVersion:     minVersion,
+		CipherSuites:   suites,
+		ClientAuth:     clientAuth,
+		NextProtos:     []string{"h2", "http/1.1"},
+	}
+	if opts.ClientCAFile != "" {
+		raw, err := os.ReadFile(opts.ClientCAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(raw) {
+			return nil, fmt.Errorf("no certificate in client CA file %s", opts.ClientCAFile)
+		}
+		config.ClientCAs = pool
+	}
+	return config, nil
+}
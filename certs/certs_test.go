@@ -0,0 +1,149 @@
+package certs
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// issue creates a certificate for name signed by parent ( self-signed if nil ) and returns it with its key
+func issue(t *testing.T, name string, parent *x509.Certificate, parentKey *ecdsa.PrivateKey, isCA bool) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	serial, _ := rand.Int(rand.Reader, big.NewInt(1<<62))
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: name},
+		DNSNames:              []string{name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  isCA,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+	raw, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	assert.NoError(t, err)
+	cert, err := x509.ParseCertificate(raw)
+	assert.NoError(t, err)
+	return cert, key
+}
+
+// writePair writes cert and key as PEM files in dir
+func writePair(t *testing.T, dir string, cert *x509.Certificate, key *ecdsa.PrivateKey) (certFile, keyFile string) {
+	certFile, keyFile = filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	rawKey, err := x509.MarshalECPrivateKey(key)
+	assert.NoError(t, err)
+	assert.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}), 0o600))
+	assert.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: rawKey}), 0o600))
+	return certFile, keyFile
+}
+
+func TestNewConfig(t *testing.T) {
+	dir := t.TempDir()
+	ca, caKey := issue(t, "ca", nil, nil, true)
+	server, serverKey := issue(t, "localhost", ca, caKey, false)
+	client, clientKey := issue(t, "client", ca, caKey, false)
+	certFile, keyFile := writePair(t, dir, server, serverKey)
+	caFile := filepath.Join(dir, "ca.pem")
+	assert.NoError(t, os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.Raw}), 0o600))
+
+	pair, err := LoadKeyPair(certFile, keyFile)
+	assert.NoError(t, err)
+	config, err := NewConfig(pair, Options{MinVersion: "1.2", ClientCAFile: caFile, ClientAuth: "require"})
+	assert.NoError(t, err)
+
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Proto + " " + r.TLS.VerifiedChains[0][0].Subject.CommonName))
+	}))
+	srv.TLS = config
+	srv.EnableHTTP2 = true
+	srv.StartTLS()
+	defer srv.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+	get := func(withClientCert bool) (string, error) {
+		tlsConfig := &tls.Config{RootCAs: roots, ServerName: "localhost"}
+		if withClientCert {
+			tlsConfig.Certificates = []tls.Certificate{{Certificate: [][]byte{client.Raw}, PrivateKey: clientKey}}
+		}
+		c := http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig, ForceAttemptHTTP2: true}}
+		res, err := c.Get(srv.URL)
+		if err != nil {
+			return "", err
+		}
+		defer res.Body.Close()
+		body := make([]byte, 64)
+		n, _ := res.Body.Read(body)
+		return string(body[:n]), nil
+	}
+	body, err := get(true)
+	assert.NoError(t, err)
+	assert.Equal(t, "HTTP/2.0 client", body)
+	_, err = get(false)
+	assert.Error(t, err, "a client certificate is required")
+
+	// A renewed certificate is served without a restart
+	renewed, renewedKey := issue(t, "localhost", ca, caKey, false)
+	writePair(t, dir, renewed, renewedKey)
+	pair.lastCheck = time.Time{}
+	served, err := pair.GetCertificate(nil)
+	assert.NoError(t, err)
+	assert.Equal(t, renewed.Raw, served.Certificate[0])
+
+	// An invalid pair keeps the current certificate and is logged
+	logs := &bytes.Buffer{}
+	defer slog.SetDefault(slog.Default())
+	slog.SetDefault(slog.New(slog.NewTextHandler(logs, nil)))
+	assert.NoError(t, os.WriteFile(keyFile, []byte("broken"), 0o600))
+	pair.lastCheck = time.Time{}
+	served, err = pair.GetCertificate(nil)
+	assert.NoError(t, err)
+	assert.Equal(t, renewed.Raw, served.Certificate[0])
+	assert.Contains(t, logs.String(), "could not reload the certificate")
+}
+
+func TestOptions_Errors(t *testing.T) {
+	tests := []struct {
+		name string
+		opts Options
+		want string
+	}{
+		{"old version", Options{MinVersion: "1.0"}, "unsupported TLS version"},
+		{"unknown suite", Options{CipherSuites: []string{"TLS_FAST"}}, "unknown or insecure cipher suite"},
+		{"insecure suite", Options{CipherSuites: []string{"TLS_RSA_WITH_RC4_128_SHA"}}, "unknown or insecure cipher suite"},
+		{"client auth", Options{ClientCAFile: "ca.pem", ClientAuth: "always"}, "unknown client auth"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewConfig(&KeyPair{}, tt.opts)
+			assert.ErrorContains(t, err, tt.want)
+		})
+	}
+
+	suites, err := ParseCipherSuites([]string{"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256"})
+	assert.NoError(t, err)
+	assert.Equal(t, []uint16{tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256}, suites)
+	assert.NoError(t, CheckHTTP2Suites(suites))
+	assert.NoError(t, CheckHTTP2Suites(nil), "the Go defaults include them")
+	assert.Error(t, CheckHTTP2Suites([]uint16{tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384}))
+}
@@ -0,0 +1,370 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/user"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/Martin-Jast/go-microservice/application"
+	"github.com/Martin-Jast/go-microservice/audit"
+	"github.com/Martin-Jast/go-microservice/auth"
+	"github.com/Martin-Jast/go-microservice/config"
+	"github.com/Martin-Jast/go-microservice/logging"
+	"github.com/Martin-Jast/go-microservice/metrics"
+	"github.com/Martin-Jast/go-microservice/persistence"
+	"github.com/Martin-Jast/go-microservice/tenancy"
+	"github.com/Martin-Jast/go-microservice/tracing"
+)
+
+// command is a subcommand of the binary, args are the arguments after its name
+type command struct {
+	name  string
+	usage string
+	run   func(ctx context.Context, args []string) error
+}
+
+// commands share the configuration and the adapters of the server, serve runs when no command is given
+var commands = []command{
+	{"serve", "serve                                   start the HTTP server", serve},
+	{"migrate", "migrate up|down [-steps n]|status       apply, undo or list the database migrations", migrate},
+	{"export", "export [-tenant t] [-out file]          write the documents of a tenant as NDJSON ( stdout by default )", exportDocuments},
+	{"import", "import [-tenant t] [-in file]           create the documents of an NDJSON file ( stdin by default ), existing ids are skipped", importDocuments},
+	{"seed", "seed [-tenant t] [-count n]             create n sample documents", seed},
+	{"check-config", "check-config                            validate the configuration and print it with its secrets masked", checkConfig},
+	{"purge", "purge -before date [-tenant t]          delete the documents created before date ( RFC3339 or 2006-01-02 )", purge},
+}
+
+// runCommand runs the command named by args and returns the exit code
+func runCommand(ctx context.Context, args []string) int {
+	name := "serve"
+	if len(args) > 0 {
+		name, args = args[0], args[1:]
+	}
+	if name == "help" || name == "-h" || name == "--help" {
+		printUsage(os.Stdout)
+		return 0
+	}
+	for _, c := range commands {
+		if c.name != name {
+			continue
+		}
+		if err := c.run(ctx, args); err != nil {
+			if errors.Is(err, flag.ErrHelp) {
+				return 0
+			}
+			fmt.Fprintf(os.Stderr, "%s: %v\n", name, err)
+			return 1
+		}
+		return 0
+	}
+	fmt.Fprintf(os.Stderr, "unknown command: %s\n", name)
+	printUsage(os.Stderr)
+	return 2
+}
+
+func printUsage(w io.Writer) {
+	fmt.Fprintln(w, "usage: go-microservice [command] [flags]")
+	fmt.Fprintln(w, "commands:")
+	for _, c := range commands {
+		fmt.Fprintf(w, "  %s\n", c.usage)
+	}
+}
+
+// loadConfig loads the configuration and sets the default logger, writing to w
+func loadConfig(w io.Writer) (*config.Config, *slog.Logger, *logging.Levels, error) {
+	// Every setting comes from the defaults, CONFIG_FILE, .env and the environment
+	cfg, err := config.Load(os.Getenv("CONFIG_FILE"), envFiles...)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	logger, levels := newLogger(w, cfg.LogLevels)
+	slog.SetDefault(logger)
+	logger.Debug("configuration loaded", "config", cfg.Masked())
+	return cfg, logger, levels, nil
+}
+
+// stores are the mongo clients and the adapters built on them
+type stores struct {
+	clients   *persistence.MongoClientHolder
+	options   persistence.MongoOptions
+	adapter   persistence.PersistenceAdapter
+	resilient *persistence.ResilientAdapter
+	cached    *persistence.CachedAdapter
+	// service is set by connect, the commands change the documents through it so they are authorized and audited as the HTTP routes
+	service application.Service
+}
+
+// newPersistence connects to mongo and decorates its adapter, tracer can be nil
+func newPersistence(ctx context.Context, cfg *config.Config, reg *metrics.Registry, tracer *tracing.Tracer) (stores, error) {
+	mongoClients, err := persistence.ConnectMongo(ctx, cfg.Mongo.URI)
+	if err != nil {
+		return stores{}, fmt.Errorf("could not connect to mongo: %w", err)
+	}
+	mongoOptions := cfg.MongoOptions()
+	mongoAdapter := persistence.NewMongoAdapterWithOptions(mongoClients, mongoOptions)
+	var adapter persistence.PersistenceAdapter = persistence.NewInstrumentedAdapter(mongoAdapter, reg)
+	// Each attempt is measured, the span covers the retries
+	resilientAdapter, err := newResilientAdapter(adapter, cfg.Resilience)
+	if err != nil {
+		return stores{}, err
+	}
+	adapter = resilientAdapter
+	if tracer != nil {
+		adapter = persistence.NewTracedAdapter(adapter, "mongodb")
+	}
+	// Outermost so the hits are neither measured nor traced as database calls
+	var cachedAdapter *persistence.CachedAdapter
+	if cacheOptions := cfg.CacheOptions(); cacheOptions != nil {
+		cachedAdapter = persistence.NewCachedAdapter(adapter, *cacheOptions, reg)
+		adapter = cachedAdapter
+	}
+	return stores{
+		clients:   mongoClients,
+		options:   mongoOptions,
+		adapter:   adapter,
+		resilient: resilientAdapter,
+		cached:    cachedAdapter,
+	}, nil
+}
+
+// connect loads the configuration and connects to mongo for the commands other than serve, their logs go to stderr
+func connect(ctx context.Context) (stores, error) {
+	cfg, _, _, err := loadConfig(os.Stderr)
+	if err != nil {
+		return stores{}, err
+	}
+	s, err := newPersistence(ctx, cfg, metrics.NewRegistry(), nil)
+	if err != nil {
+		return stores{}, err
+	}
+	s.service = application.NewService(s.adapter)
+	s.service.Audit = audit.NewLog(persistence.NewMongoAuditStore(s.clients, s.options))
+	if cfg.Auth.Enabled {
+		s.service.Authorizer = authPolicy(cfg.Auth)
+	}
+	return s, nil
+}
+
+// asOperator authenticates the commands as the operator running them, the audit entries name the local user
+func asOperator(ctx context.Context) context.Context {
+	subject := "cli"
+	if current, err := user.Current(); err == nil {
+		subject = "cli:" + current.Username
+	}
+	return auth.WithIdentity(ctx, &auth.Identity{Subject: subject, Roles: []string{"admin"}, Method: "cli"})
+}
+
+// tenantFlag adds the -tenant flag to fs, withTenant scopes ctx to the tenant once the flags are parsed
+func tenantFlag(fs *flag.FlagSet) (withTenant func(ctx context.Context) (context.Context, error)) {
+	tenant := fs.String("tenant", tenancy.DefaultTenant, "tenant of the documents")
+	return func(ctx context.Context) (context.Context, error) {
+		if err := tenancy.Validate(*tenant); err != nil {
+			return nil, err
+		}
+		return tenancy.WithTenant(ctx, *tenant), nil
+	}
+}
+
+// migrate applies, undoes or lists the migrations of the configured database
+func migrate(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("missing action: up, down or status")
+	}
+	action := args[0]
+	fs := flag.NewFlagSet("migrate "+action, flag.ContinueOnError)
+	steps := fs.Int("steps", 1, "number of migrations to undo")
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+	s, err := connect(ctx)
+	if err != nil {
+		return err
+	}
+	defer s.clients.Disconnect(ctx)
+	migrator := persistence.NewMigrator(persistence.NewMongoMigrationStore(s.clients, s.options), persistence.MongoMigrations(s.clients, s.options))
+
+	switch action {
+	case "up":
+		done, err := migrator.Up(ctx)
+		for _, m := range done {
+			fmt.Printf("applied %d %s\n", m.Version, m.Name)
+		}
+		return err
+	case "down":
+		done, err := migrator.Down(ctx, *steps)
+		for _, m := range done {
+			fmt.Printf("undone %d %s\n", m.Version, m.Name)
+		}
+		return err
+	case "status":
+		list, err := migrator.Status(ctx)
+		if err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED AT")
+		for _, m := range list {
+			appliedAt := "pending"
+			if m.AppliedAt != nil {
+				appliedAt = m.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Fprintf(w, "%d\t%s\t%s\n", m.Version, m.Name, appliedAt)
+		}
+		return w.Flush()
+	}
+	return fmt.Errorf("unknown action: %s", action)
+}
+
+// exportDocuments writes the documents of a tenant as NDJSON
+func exportDocuments(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	withTenant := tenantFlag(fs)
+	out := fs.String("out", "", "file to write, stdout if empty")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	ctx, err := withTenant(ctx)
+	if err != nil {
+		return err
+	}
+	var w io.Writer = os.Stdout
+	if *out != "" {
+		f, err := os.Create(*out)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+	s, err := connect(ctx)
+	if err != nil {
+		return err
+	}
+	defer s.clients.Disconnect(ctx)
+	count, err := persistence.ExportDocuments(asOperator(ctx), s.service.ListBaseDocuments, w)
+	if err != nil {
+		return err
+	}
+	slog.InfoContext(ctx, "documents exported", "tenant", tenancy.TenantOrDefault(ctx), "count", count)
+	return nil
+}
+
+// importDocuments creates the documents of an NDJSON file
+func importDocuments(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("import", flag.ContinueOnError)
+	withTenant := tenantFlag(fs)
+	in := fs.String("in", "", "file to read, stdin if empty")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	ctx, err := withTenant(ctx)
+	if err != nil {
+		return err
+	}
+	var r io.Reader = os.Stdin
+	if *in != "" {
+		f, err := os.Open(*in)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+	s, err := connect(ctx)
+	if err != nil {
+		return err
+	}
+	defer s.clients.Disconnect(ctx)
+	result, err := persistence.ImportDocuments(asOperator(ctx), s.service.ImportBaseDocument, r)
+	// The documents created before an error stay, importing the file again skips them
+	slog.InfoContext(ctx, "documents imported", "tenant", tenancy.TenantOrDefault(ctx), "created", result.Created, "skipped", result.Skipped)
+	return err
+}
+
+// seed creates sample documents
+func seed(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("seed", flag.ContinueOnError)
+	withTenant := tenantFlag(fs)
+	count := fs.Int("count", 10, "number of documents to create")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	ctx, err := withTenant(ctx)
+	if err != nil {
+		return err
+	}
+	s, err := connect(ctx)
+	if err != nil {
+		return err
+	}
+	defer s.clients.Disconnect(ctx)
+	operatorCtx := asOperator(ctx)
+	for i := 1; i <= *count; i++ {
+		if _, err := s.service.CreateBaseDocument(operatorCtx, fmt.Sprintf("seed document %d", i)); err != nil {
+			return fmt.Errorf("document %d: %w", i, err)
+		}
+	}
+	slog.InfoContext(ctx, "documents seeded", "tenant", tenancy.TenantOrDefault(ctx), "count", *count)
+	return nil
+}
+
+// checkConfig validates the configuration without connecting to anything and prints it masked
+func checkConfig(ctx context.Context, args []string) error {
+	if err := flag.NewFlagSet("check-config", flag.ContinueOnError).Parse(args); err != nil {
+		return err
+	}
+	cfg, err := config.Load(os.Getenv("CONFIG_FILE"), envFiles...)
+	if err != nil {
+		return err
+	}
+	return cfg.Print(os.Stdout)
+}
+
+// purge deletes the documents of a tenant created before a date
+func purge(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("purge", flag.ContinueOnError)
+	withTenant := tenantFlag(fs)
+	before := fs.String("before", "", "delete the documents created before this date ( RFC3339 or 2006-01-02 )")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	date, err := parseDate(*before)
+	if err != nil {
+		return err
+	}
+	ctx, err = withTenant(ctx)
+	if err != nil {
+		return err
+	}
+	s, err := connect(ctx)
+	if err != nil {
+		return err
+	}
+	defer s.clients.Disconnect(ctx)
+	count, err := persistence.PurgeDocuments(asOperator(ctx), s.service.ListBaseDocuments, s.service.DeleteBaseDocument, date)
+	slog.InfoContext(ctx, "documents purged", "tenant", tenancy.TenantOrDefault(ctx), "before", date, "count", count)
+	return err
+}
+
+// parseDate reads a RFC3339 date or a day, which starts at midnight UTC
+func parseDate(value string) (time.Time, error) {
+	if strings.TrimSpace(value) == "" {
+		return time.Time{}, fmt.Errorf("missing date")
+	}
+	if date, err := time.Parse(time.RFC3339, value); err == nil {
+		return date, nil
+	}
+	date, err := time.Parse(time.DateOnly, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid date %q: use RFC3339 or 2006-01-02", value)
+	}
+	return date, nil
+}
@@ -0,0 +1,310 @@
+package compression
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/Martin-Jast/go-microservice/utils"
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zlib"
+)
+
+// Encodings supported, in the order they are preferred when the client accepts several with the same weight
+const (
+	Brotli  = "br"
+	Gzip    = "gzip"
+	Deflate = "deflate"
+)
+
+var preferred = []string{Brotli, Gzip, Deflate}
+
+// Options defines when responses are compressed and which requests may send compressed bodies
+type Options struct {
+	// MinSize is the size from which responses are compressed, smaller ones gain nothing
+	MinSize int
+	// DecompressRoutes are the route templates accepting compressed bodies, every route if empty
+	DecompressRoutes []string
+}
+
+// DefaultOptions compresses responses from 1KiB and accepts compressed bodies on create
+var DefaultOptions = Options{
+	MinSize:          1024,
+	DecompressRoutes: []string{"/base/create"},
+}
+
+// encoder is implemented by every compressing writer used
+type encoder interface {
+	io.WriteCloser
+	Flush() error
+	Reset(w io.Writer)
+}
+
+var encoderPools = map[string]*sync.Pool{
+	Brotli:  {New: func() interface{} { return brotli.NewWriterLevel(nil, brotli.DefaultCompression) }},
+	Gzip:    {New: func() interface{} { return gzip.NewWriter(nil) }},
+	Deflate: {New: func() interface{} { return zlib.NewWriter(nil) }},
+}
+
+// Negotiate picks the encoding to use for an Accept-Encoding header, empty when the response must not be compressed
+func Negotiate(acceptEncoding string) string {
+	weights := map[string]float64{}
+	wildcard := -1.0
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				continue
+			}
+			q = parsed
+		}
+		if name == "*" {
+			wildcard = q
+			continue
+		}
+		weights[name] = q
+	}
+	best, bestQ := "", 0.0
+	for _, encoding := range preferred {
+		q, ok := weights[encoding]
+		if !ok {
+			q = wildcard
+		}
+		if q > bestQ {
+			best, bestQ = encoding, q
+		}
+	}
+	return best
+}
+
+// compressedTypes are content types already compressed, compressing them again only costs time
+var compressedTypes = []string{"image/", "video/", "audio/", "application/zip", "application/gzip", "application/x-gzip", "application/x-brotli", "application/octet-stream"}
+
+// skip tells if a response with header should be sent as it is
+func skip(header http.Header) bool {
+	if header.Get("Content-Encoding") != "" {
+		return true
+	}
+	contentType := header.Get("Content-Type")
+	if strings.HasPrefix(contentType, "text/event-stream") {
+		return true
+	}
+	for _, prefix := range compressedTypes {
+		if strings.HasPrefix(contentType, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// BaseETag removes from tag the suffix added when the response was compressed
+func BaseETag(tag string) string {
+	for _, encoding := range preferred {
+		if base, ok := strings.CutSuffix(tag, "-"+encoding+`"`); ok {
+			return base + `"`
+		}
+	}
+	return tag
+}
+
+// writer buffers the start of a response until it knows if it is worth compressing
+type writer struct {
+	http.ResponseWriter
+	encoding string
+	minSize  int
+	status   int
+	buf      []byte
+	// decided is set once the response is either compressing ( enc != nil ) or passing through
+	decided bool
+	enc     encoder
+}
+
+func (cw *writer) WriteHeader(code int) {
+	if cw.status == 0 {
+		cw.status = code
+	}
+	// Bodiless responses are never compressed
+	if code == http.StatusNoContent || code == http.StatusNotModified || code < 200 {
+		cw.passThrough()
+	}
+}
+
+func (cw *writer) Write(b []byte) (int, error) {
+	if cw.status == 0 {
+		cw.status = http.StatusOK
+	}
+	if cw.decided {
+		if cw.enc != nil {
+			return cw.enc.Write(b)
+		}
+		return cw.ResponseWriter.Write(b)
+	}
+	cw.buf = append(cw.buf, b...)
+	if len(cw.buf) >= cw.minSize {
+		if err := cw.start(); err != nil {
+			return 0, err
+		}
+	}
+	return len(b), nil
+}
+
+// start compresses the response, or sends it as it is if its headers say so
+func (cw *writer) start() error {
+	if skip(cw.Header()) {
+		return cw.passThrough()
+	}
+	cw.decided = true
+	cw.Header().Set("Content-Encoding", cw.encoding)
+	cw.Header().Del("Content-Length")
+	if tag := cw.Header().Get("ETag"); strings.HasSuffix(tag, `"`) && !strings.HasPrefix(tag, "W/") {
+		// A strong ETag names the exact bytes sent, the compressed body gets a tag of its own
+		cw.Header().Set("ETag", strings.TrimSuffix(tag, `"`)+"-"+cw.encoding+`"`)
+	}
+	cw.ResponseWriter.WriteHeader(cw.status)
+	cw.enc = encoderPools[cw.encoding].Get().(encoder)
+	cw.enc.Reset(cw.ResponseWriter)
+	buf := cw.buf
+	cw.buf = nil
+	_, err := cw.enc.Write(buf)
+	return err
+}
+
+// passThrough sends the headers and what was buffered without compressing
+func (cw *writer) passThrough() error {
+	if cw.decided {
+		return nil
+	}
+	cw.decided = true
+	if cw.status == 0 {
+		cw.status = http.StatusOK
+	}
+	cw.ResponseWriter.WriteHeader(cw.status)
+	buf := cw.buf
+	cw.buf = nil
+	if len(buf) == 0 {
+		return nil
+	}
+	_, err := cw.ResponseWriter.Write(buf)
+	return err
+}
+
+// Flush sends what was written so far, a response flushed before reaching MinSize is streaming and is not compressed
+func (cw *writer) Flush() {
+	if !cw.decided {
+		cw.passThrough()
+	}
+	if cw.enc != nil {
+		cw.enc.Flush()
+	}
+	if f, ok := cw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap gives http.ResponseController access to the wrapped writer
+func (cw *writer) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
+
+// close ends the response, responses smaller than MinSize are sent as they are
+func (cw *writer) close() error {
+	if !cw.decided {
+		if cw.status == 0 {
+			// The handler wrote nothing, net/http answers 200 with no body
+			return nil
+		}
+		return cw.passThrough()
+	}
+	if cw.enc == nil {
+		return nil
+	}
+	err := cw.enc.Close()
+	cw.enc.Reset(nil)
+	encoderPools[cw.encoding].Put(cw.enc)
+	cw.enc = nil
+	return err
+}
+
+// decoders open the request bodies of each encoding
+var decoders = map[string]func(io.Reader) (io.ReadCloser, error){
+	Brotli: func(r io.Reader) (io.ReadCloser, error) { return io.NopCloser(brotli.NewReader(r)), nil },
+	Gzip: func(r io.Reader) (io.ReadCloser, error) {
+		return gzip.NewReader(r)
+	},
+	Deflate: func(r io.Reader) (io.ReadCloser, error) { return zlib.NewReader(r) },
+}
+
+// body closes both the decoder and the original body
+type body struct {
+	io.ReadCloser
+	original io.Closer
+}
+
+func (b body) Close() error {
+	b.ReadCloser.Close()
+	return b.original.Close()
+}
+
+// decompress replaces the body of r by its decompressed content
+func decompress(r *http.Request) error {
+	encoding := strings.ToLower(strings.TrimSpace(r.Header.Get("Content-Encoding")))
+	if encoding == "" || encoding == "identity" || r.Body == nil || r.Body == http.NoBody {
+		return nil
+	}
+	open, ok := decoders[encoding]
+	if !ok {
+		return fmt.Errorf("unsupported content encoding: %s", encoding)
+	}
+	decoded, err := open(r.Body)
+	if err != nil {
+		return fmt.Errorf("invalid %s body: %v", encoding, err)
+	}
+	r.Body = body{ReadCloser: decoded, original: r.Body}
+	r.Header.Del("Content-Encoding")
+	r.Header.Del("Content-Length")
+	r.ContentLength = -1
+	return nil
+}
+
+// Middleware compresses the responses of clients accepting it and decompresses the request bodies of opts.DecompressRoutes
+// It must be used as a mux middleware so the route template is known, and before any body size limit so it bounds the decompressed body
+func Middleware(opts Options) func(http.Handler) http.Handler {
+	routes := map[string]bool{}
+	for _, route := range opts.DecompressRoutes {
+		routes[route] = true
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("Content-Encoding") != "" {
+				if len(routes) > 0 && !routes[utils.RouteTemplate(r)] {
+					utils.WriteError(fmt.Errorf("compressed bodies are not accepted on this route"), w, http.StatusUnsupportedMediaType)
+					return
+				}
+				if err := decompress(r); err != nil {
+					utils.WriteError(err, w, http.StatusUnsupportedMediaType)
+					return
+				}
+			}
+
+			w.Header().Add("Vary", "Accept-Encoding")
+			encoding := Negotiate(r.Header.Get("Accept-Encoding"))
+			if encoding == "" || r.Method == http.MethodHead {
+				next.ServeHTTP(w, r)
+				return
+			}
+			cw := &writer{ResponseWriter: w, encoding: encoding, minSize: opts.MinSize}
+			defer cw.close()
+			next.ServeHTTP(cw, r)
+		})
+	}
+}
@@ -0,0 +1,169 @@
+package compression
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+	"github.com/gorilla/mux"
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zlib"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNegotiate(t *testing.T) {
+	tests := []struct {
+		acceptEncoding string
+		want           string
+	}{
+		{acceptEncoding: "", want: ""},
+		{acceptEncoding: "identity", want: ""},
+		{acceptEncoding: "gzip", want: Gzip},
+		{acceptEncoding: "gzip, deflate, br", want: Brotli},
+		{acceptEncoding: "br;q=0.5, gzip;q=0.8", want: Gzip},
+		{acceptEncoding: "br;q=0, gzip;q=0", want: ""},
+		{acceptEncoding: "*", want: Brotli},
+		{acceptEncoding: "*;q=0.1, br;q=0", want: Gzip},
+		{acceptEncoding: "DEFLATE", want: Deflate},
+	}
+	for _, tt := range tests {
+		t.Run(tt.acceptEncoding, func(t *testing.T) {
+			assert.Equal(t, tt.want, Negotiate(tt.acceptEncoding))
+		})
+	}
+}
+
+// decode reads a response body compressed with encoding
+func decode(t *testing.T, encoding string, b []byte) string {
+	var r io.Reader
+	var err error
+	switch encoding {
+	case Brotli:
+		r = brotli.NewReader(bytes.NewReader(b))
+	case Gzip:
+		r, err = gzip.NewReader(bytes.NewReader(b))
+	case Deflate:
+		r, err = zlib.NewReader(bytes.NewReader(b))
+	default:
+		return string(b)
+	}
+	assert.NoError(t, err)
+	out, err := io.ReadAll(r)
+	assert.NoError(t, err)
+	return string(out)
+}
+
+func TestMiddleware_Responses(t *testing.T) {
+	large := strings.Repeat(`{"data":"some document"},`, 100)
+	router := mux.NewRouter()
+	router.Use(Middleware(Options{MinSize: 256}))
+	router.HandleFunc("/large", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		// Written in small pieces, the decision is taken once the threshold is reached
+		for i := 0; i < len(large); i += 100 {
+			end := i + 100
+			if end > len(large) {
+				end = len(large)
+			}
+			w.Write([]byte(large[i:end]))
+		}
+	})
+	router.HandleFunc("/small", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("small"))
+	})
+	router.HandleFunc("/image", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		w.Write([]byte(large))
+	})
+	router.HandleFunc("/stream", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("first event"))
+		w.(http.Flusher).Flush()
+		w.Write([]byte(large))
+	})
+
+	tests := []struct {
+		name             string
+		path             string
+		acceptEncoding   string
+		expectedEncoding string
+		expectedBody     string
+		expectedCode     int
+	}{
+		{name: "brotli", path: "/large", acceptEncoding: "gzip, br", expectedEncoding: Brotli, expectedBody: large, expectedCode: http.StatusCreated},
+		{name: "gzip", path: "/large", acceptEncoding: "gzip", expectedEncoding: Gzip, expectedBody: large, expectedCode: http.StatusCreated},
+		{name: "deflate", path: "/large", acceptEncoding: "deflate", expectedEncoding: Deflate, expectedBody: large, expectedCode: http.StatusCreated},
+		{name: "not accepted", path: "/large", acceptEncoding: "", expectedBody: large, expectedCode: http.StatusCreated},
+		{name: "below threshold", path: "/small", acceptEncoding: "gzip", expectedBody: "small", expectedCode: http.StatusOK},
+		{name: "already compressed", path: "/image", acceptEncoding: "gzip", expectedBody: large, expectedCode: http.StatusOK},
+		{name: "streaming", path: "/stream", acceptEncoding: "gzip", expectedBody: "first event" + large, expectedCode: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			req.Header.Set("Accept-Encoding", tt.acceptEncoding)
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+			assert.Equal(t, tt.expectedCode, rec.Code)
+			assert.Equal(t, tt.expectedEncoding, rec.Header().Get("Content-Encoding"))
+			assert.Equal(t, "Accept-Encoding", rec.Header().Get("Vary"))
+			assert.Equal(t, tt.expectedBody, decode(t, tt.expectedEncoding, rec.Body.Bytes()))
+			if tt.expectedEncoding != "" {
+				assert.Less(t, rec.Body.Len(), len(tt.expectedBody))
+			}
+		})
+	}
+}
+
+func TestMiddleware_Requests(t *testing.T) {
+	router := mux.NewRouter()
+	router.Use(Middleware(Options{MinSize: 256, DecompressRoutes: []string{"/base/create"}}))
+	echo := func(w http.ResponseWriter, r *http.Request) {
+		b, err := io.ReadAll(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.Write(b)
+	}
+	router.HandleFunc("/base/create", echo)
+	router.HandleFunc("/base/{id}", echo)
+
+	gzipped := &bytes.Buffer{}
+	gw := gzip.NewWriter(gzipped)
+	gw.Write([]byte(`{"Data":"compressed"}`))
+	gw.Close()
+
+	tests := []struct {
+		name            string
+		path            string
+		contentEncoding string
+		body            []byte
+		expectedCode    int
+		expectedBody    string
+	}{
+		{name: "gzip body", path: "/base/create", contentEncoding: "gzip", body: gzipped.Bytes(), expectedCode: http.StatusOK, expectedBody: `{"Data":"compressed"}`},
+		{name: "plain body", path: "/base/create", body: []byte(`{"Data":"plain"}`), expectedCode: http.StatusOK, expectedBody: `{"Data":"plain"}`},
+		{name: "unknown encoding", path: "/base/create", contentEncoding: "zstd", body: []byte("x"), expectedCode: http.StatusUnsupportedMediaType},
+		{name: "corrupt body", path: "/base/create", contentEncoding: "gzip", body: []byte("not gzip"), expectedCode: http.StatusUnsupportedMediaType},
+		{name: "route not accepting compressed bodies", path: "/base/1", contentEncoding: "gzip", body: gzipped.Bytes(), expectedCode: http.StatusUnsupportedMediaType},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, tt.path, bytes.NewReader(tt.body))
+			if tt.contentEncoding != "" {
+				req.Header.Set("Content-Encoding", tt.contentEncoding)
+			}
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+			assert.Equal(t, tt.expectedCode, rec.Code)
+			if tt.expectedBody != "" {
+				assert.Equal(t, tt.expectedBody, rec.Body.String())
+			}
+		})
+	}
+}
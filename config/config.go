@@ -0,0 +1,440 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Martin-Jast/go-microservice/certs"
+	"github.com/Martin-Jast/go-microservice/compression"
+	"github.com/Martin-Jast/go-microservice/cors"
+	"github.com/Martin-Jast/go-microservice/logging"
+	"github.com/Martin-Jast/go-microservice/persistence"
+	"github.com/Martin-Jast/go-microservice/secrets"
+)
+
+// Config is the configuration of the service
+// Each field is set in files by its json name ( mongo.uri ) and in the environment by its env name ( MONGO_STRING )
+// Fields tagged secret are masked when the configuration is printed and may reference a secret ( file://, env:// or secret:// )
+type Config struct {
+	Port        string            `json:"port" env:"PORT" required:"true"`
+	ServiceName string            `json:"serviceName" env:"SERVICE_NAME"`
+	Mongo       MongoConfig       `json:"mongo"`
+	HTTP        HTTPConfig        `json:"http"`
+	TLS         TLSConfig         `json:"tls"`
+	Admin       AdminConfig       `json:"admin"`
+	Auth        AuthConfig        `json:"auth"`
+	Tenancy     TenancyConfig     `json:"tenancy"`
+	Log         LogConfig         `json:"log"`
+	Tracing     TracingConfig     `json:"tracing"`
+	RateLimit   RateLimitConfig   `json:"rateLimit"`
+	CORS        CORSConfig        `json:"cors"`
+	Compression CompressionConfig `json:"compression"`
+	Cache       CacheConfig       `json:"cache"`
+	Resilience  ResilienceConfig  `json:"resilience"`
+	Secrets     SecretsConfig     `json:"secrets"`
+	Shutdown    ShutdownConfig    `json:"shutdown"`
+	// Features turns features on and off by name
+	Features map[string]bool `json:"features" env:"FEATURES"`
+
+	// refs are the secret references of the fields, by path ( mongo.uri )
+	refs     map[string]string
+	resolver *secrets.Resolver
+}
+
+// MongoConfig is where the documents are stored
+type MongoConfig struct {
+	URI        string `json:"uri" env:"MONGO_STRING" required:"true" secret:"true"`
+	Database   string `json:"database" env:"MONGO_DATABASE"`
+	Collection string `json:"collection" env:"MONGO_COLLECTION"`
+	// TenantIsolation is "shared" or "database"
+	TenantIsolation string `json:"tenantIsolation" env:"TENANT_ISOLATION"`
+}
+
+// HTTPConfig are the timeouts and limits of the server
+type HTTPConfig struct {
+	ReadTimeout       time.Duration            `json:"readTimeout" env:"READ_TIMEOUT"`
+	RequestTimeout    time.Duration            `json:"requestTimeout" env:"REQUEST_TIMEOUT"`
+	RouteTimeouts     map[string]time.Duration `json:"routeTimeouts" env:"ROUTE_TIMEOUTS"`
+	MaxBodyBytes      int64                    `json:"maxBodyBytes" env:"MAX_BODY_BYTES"`
+	RouteMaxBodyBytes map[string]int64         `json:"routeMaxBodyBytes" env:"ROUTE_MAX_BODY_BYTES"`
+	// ShutdownTimeout is how long the requests in progress have to finish once the server stops
+	ShutdownTimeout time.Duration `json:"shutdownTimeout" env:"SHUTDOWN_TIMEOUT"`
+	// CacheControl is separated by ";" in the environment since policies contain commas
+	CacheControl map[string]string `json:"cacheControl" env:"CACHE_CONTROL" sep:";"`
+}
+
+// TLSConfig enables TLS when CertFile and KeyFile are set, the files are reloaded when they change
+type TLSConfig struct {
+	CertFile string `json:"certFile" env:"TLS_CERT_FILE"`
+	KeyFile  string `json:"keyFile" env:"TLS_KEY_FILE"`
+	// MinVersion is "1.2" or "1.3"
+	MinVersion   string   `json:"minVersion" env:"TLS_MIN_VERSION"`
+	CipherSuites []string `json:"cipherSuites" env:"TLS_CIPHER_SUITES"`
+	// ClientCAFile enables mutual TLS, the client certificates signed by its CAs authenticate the requests
+	ClientCAFile string `json:"clientCaFile" env:"TLS_CLIENT_CA_FILE"`
+	// ClientAuth is "request" or "require"
+	ClientAuth string `json:"clientAuth" env:"TLS_CLIENT_AUTH"`
+	// ClientRoles replaces the roles ( organizational units ) of the listed certificate subjects, "ops=admin;billing=reader,writer" in the environment
+	ClientRoles map[string]string `json:"clientRoles" env:"TLS_CLIENT_ROLES" sep:";"`
+	// H2C serves HTTP/2 without TLS, behind a proxy terminating TLS
+	H2C bool `json:"h2c" env:"HTTP2_CLEARTEXT"`
+}
+
+// Enabled tells if the server is served with TLS
+func (c TLSConfig) Enabled() bool {
+	return c.CertFile != ""
+}
+
+// ClientCertRoles returns the roles of each subject of ClientRoles
+func (c TLSConfig) ClientCertRoles() map[string][]string {
+	roles := map[string][]string{}
+	for subject, list := range c.ClientRoles {
+		roles[subject] = []string{}
+		for _, role := range strings.Split(list, ",") {
+			if role = strings.TrimSpace(role); role != "" {
+				roles[subject] = append(roles[subject], role)
+			}
+		}
+	}
+	return roles
+}
+
+// AdminConfig is where the operational routes ( health, metrics, pprof, configuration, shutdown ) are served
+type AdminConfig struct {
+	// Addr is loopback by default, a wider one ( e.g. ":9090" for the orchestrator probes ) requires auth to be enabled
+	// since the listener is plain HTTP and serves the control routes
+	Addr string `json:"addr" env:"ADMIN_ADDR"`
+}
+
+// AuthConfig is how the callers are authenticated and authorized
+type AuthConfig struct {
+	Enabled     bool   `json:"enabled" env:"AUTH_ENABLED"`
+	AdminAPIKey string `json:"adminApiKey" env:"ADMIN_API_KEY" secret:"true"`
+	JWTSecret   string `json:"jwtHS256Secret" env:"JWT_HS256_SECRET" secret:"true"`
+	JWTIssuer   string `json:"jwtIssuer" env:"JWT_ISSUER"`
+	JWTAudience string `json:"jwtAudience" env:"JWT_AUDIENCE"`
+	JWKSFile    string `json:"jwksFile" env:"JWT_JWKS_FILE"`
+	PolicyFile  string `json:"policyFile" env:"AUTH_POLICY_FILE"`
+}
+
+// TenancyConfig is how the tenant of a request is found
+type TenancyConfig struct {
+	Header     string `json:"header" env:"TENANT_HEADER"`
+	BaseDomain string `json:"baseDomain" env:"TENANT_BASE_DOMAIN"`
+	Required   bool   `json:"required" env:"TENANT_REQUIRED"`
+}
+
+// LogConfig is the default level and the level of single packages
+type LogConfig struct {
+	Level  string            `json:"level" env:"LOG_LEVEL"`
+	Levels map[string]string `json:"levels" env:"LOG_LEVELS"`
+}
+
+// TracingConfig is where the spans are exported, tracing is disabled without an exporter
+type TracingConfig struct {
+	// Exporter is "otlp", "file" or empty
+	Exporter     string `json:"exporter" env:"TRACING_EXPORTER"`
+	OTLPEndpoint string `json:"otlpEndpoint" env:"OTLP_ENDPOINT"`
+	File         string `json:"file" env:"TRACING_FILE"`
+}
+
+// RateLimitConfig is where the rules and the buckets are kept
+type RateLimitConfig struct {
+	File string `json:"file" env:"RATE_LIMIT_FILE"`
+	// Store is "memory" or "mongo"
+	Store             string `json:"store" env:"RATE_LIMIT_STORE"`
+	TrustForwardedFor bool   `json:"trustForwardedFor" env:"TRUST_FORWARDED_FOR"`
+	// AddressLimit requests per AddressPeriod are allowed to each client address before the authentication, 0 disables it
+	AddressLimit  int64         `json:"addressLimit" env:"RATE_LIMIT_ADDRESS_LIMIT"`
+	AddressPeriod time.Duration `json:"addressPeriod" env:"RATE_LIMIT_ADDRESS_PERIOD"`
+}
+
+// CORSConfig enables CORS when AllowedOrigins is not empty
+type CORSConfig struct {
+	AllowedOrigins   []string      `json:"allowedOrigins" env:"CORS_ALLOWED_ORIGINS"`
+	AllowedMethods   []string      `json:"allowedMethods" env:"CORS_ALLOWED_METHODS"`
+	AllowedHeaders   []string      `json:"allowedHeaders" env:"CORS_ALLOWED_HEADERS"`
+	ExposedHeaders   []string      `json:"exposedHeaders" env:"CORS_EXPOSED_HEADERS"`
+	AllowCredentials bool          `json:"allowCredentials" env:"CORS_ALLOW_CREDENTIALS"`
+	MaxAge           time.Duration `json:"maxAge" env:"CORS_MAX_AGE"`
+}
+
+// CompressionConfig is when responses are compressed and where compressed bodies are accepted
+type CompressionConfig struct {
+	Enabled          bool     `json:"enabled" env:"COMPRESSION_ENABLED"`
+	MinSize          int      `json:"minSize" env:"COMPRESSION_MIN_SIZE"`
+	DecompressRoutes []string `json:"decompressRoutes" env:"COMPRESSION_DECOMPRESS_ROUTES"`
+}
+
+// CacheConfig enables the document cache when Size is not 0
+type CacheConfig struct {
+	Size        int           `json:"size" env:"PERSISTENCE_CACHE_SIZE"`
+	TTL         time.Duration `json:"ttl" env:"PERSISTENCE_CACHE_TTL"`
+	NegativeTTL time.Duration `json:"negativeTtl" env:"PERSISTENCE_CACHE_NEGATIVE_TTL"`
+}
+
+// ResilienceConfig is where the retry and breaker policies are read from, the defaults are used without a file
+type ResilienceConfig struct {
+	File string `json:"file" env:"PERSISTENCE_RESILIENCE_FILE"`
+}
+
+// SecretsConfig is where secret:// references are read from and how often rotated secrets are read again
+type SecretsConfig struct {
+	// File is the JSON store of the secret:// references, a stand-in for an external secret store
+	File            string        `json:"file" env:"SECRETS_FILE"`
+	RefreshInterval time.Duration `json:"refreshInterval" env:"SECRETS_REFRESH_INTERVAL"`
+}
+
+// ShutdownConfig is how the service stops: it fails readiness for DrainPeriod, then stops the subsystems within Deadline
+type ShutdownConfig struct {
+	// DrainPeriod gives the load balancers time to stop sending requests before the server closes
+	DrainPeriod time.Duration `json:"drainPeriod" env:"SHUTDOWN_DRAIN_PERIOD"`
+	// Deadline is how long the whole shutdown may take, drain included, before the process exits anyway
+	Deadline time.Duration `json:"deadline" env:"SHUTDOWN_DEADLINE"`
+}
+
+// Default returns the configuration used for everything that is not set
+func Default() Config {
+	return Config{
+		ServiceName: "go-microservice",
+		Mongo: MongoConfig{
+			Database:        persistence.DefaultMongoOptions.Database,
+			Collection:      persistence.DefaultMongoOptions.Collection,
+			TenantIsolation: "shared",
+		},
+		HTTP: HTTPConfig{
+			ReadTimeout:     30 * time.Second,
+			RequestTimeout:  30 * time.Second,
+			MaxBodyBytes:    1 << 20,
+			ShutdownTimeout: 10 * time.Second,
+		},
+		TLS:     TLSConfig{MinVersion: "1.2", ClientAuth: "request"},
+		Admin:   AdminConfig{Addr: "localhost:9090"},
+		Auth:    AuthConfig{Enabled: true},
+		Log:     LogConfig{Level: "info"},
+		Tracing: TracingConfig{OTLPEndpoint: "http://localhost:4318/v1/traces"},
+		RateLimit: RateLimitConfig{
+			Store:         "memory",
+			AddressLimit:  1000,
+			AddressPeriod: time.Second,
+		},
+		CORS: CORSConfig{
+			AllowedMethods: cors.DefaultOptions.AllowedMethods,
+			AllowedHeaders: cors.DefaultOptions.AllowedHeaders,
+			ExposedHeaders: cors.DefaultOptions.ExposedHeaders,
+			MaxAge:         cors.DefaultOptions.MaxAge,
+		},
+		Compression: CompressionConfig{
+			Enabled:          true,
+			MinSize:          compression.DefaultOptions.MinSize,
+			DecompressRoutes: compression.DefaultOptions.DecompressRoutes,
+		},
+		Cache: CacheConfig{
+			TTL:         persistence.DefaultCacheOptions.TTL,
+			NegativeTTL: persistence.DefaultCacheOptions.NegativeTTL,
+		},
+		Secrets:  SecretsConfig{RefreshInterval: time.Minute},
+		Shutdown: ShutdownConfig{Deadline: 30 * time.Second},
+	}
+}
+
+// Validate returns every invalid value of c at once
+func (c Config) Validate() error {
+	errs := missing(c)
+	check := func(field string, err error) {
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %v", field, err))
+		}
+	}
+	if c.Port != "" {
+		if port, err := strconv.Atoi(c.Port); err != nil || port < 1 || port > 65535 {
+			check("port", fmt.Errorf("must be a number between 1 and 65535, got %q", c.Port))
+		}
+	}
+	if c.Mongo.Database == "" || c.Mongo.Collection == "" {
+		check("mongo", fmt.Errorf("database and collection can't be empty"))
+	}
+	_, err := persistence.ParseTenantIsolation(c.Mongo.TenantIsolation)
+	check("mongo.tenantIsolation", err)
+
+	for name, d := range map[string]time.Duration{
+		"http.readTimeout": c.HTTP.ReadTimeout, "http.requestTimeout": c.HTTP.RequestTimeout, "http.shutdownTimeout": c.HTTP.ShutdownTimeout,
+		"cors.maxAge": c.CORS.MaxAge, "cache.ttl": c.Cache.TTL, "cache.negativeTtl": c.Cache.NegativeTTL,
+		"secrets.refreshInterval": c.Secrets.RefreshInterval, "shutdown.drainPeriod": c.Shutdown.DrainPeriod,
+	} {
+		if d < 0 {
+			check(name, fmt.Errorf("can't be negative"))
+		}
+	}
+	if (c.TLS.CertFile == "") != (c.TLS.KeyFile == "") {
+		check("tls", fmt.Errorf("certFile and keyFile must be set together"))
+	}
+	if c.TLS.ClientCAFile != "" && !c.TLS.Enabled() {
+		check("tls.clientCaFile", fmt.Errorf("mutual TLS requires a certificate"))
+	}
+	if c.TLS.H2C && c.TLS.Enabled() {
+		check("tls.h2c", fmt.Errorf("HTTP/2 is negotiated with TLS, h2c is only used without a certificate"))
+	}
+	_, err = certs.ParseVersion(c.TLS.MinVersion)
+	check("tls.minVersion", err)
+	suites, err := certs.ParseCipherSuites(c.TLS.CipherSuites)
+	if err == nil {
+		err = certs.CheckHTTP2Suites(suites)
+	}
+	check("tls.cipherSuites", err)
+	_, err = certs.ParseClientAuth(c.TLS.ClientAuth, c.TLS.ClientCAFile)
+	check("tls.clientAuth", err)
+	if host, port, err := net.SplitHostPort(c.Admin.Addr); err != nil {
+		check("admin.addr", err)
+	} else if port == c.Port {
+		check("admin.addr", fmt.Errorf("must not use the port of the public server"))
+	} else if !c.Auth.Enabled && !isLoopback(host) {
+		check("admin.addr", fmt.Errorf("the control routes are open without auth.enabled, only a loopback address is allowed"))
+	}
+	if c.Shutdown.Deadline <= c.Shutdown.DrainPeriod {
+		check("shutdown.deadline", fmt.Errorf("must be longer than the drain period"))
+	}
+	if c.HTTP.MaxBodyBytes < 0 {
+		check("http.maxBodyBytes", fmt.Errorf("can't be negative"))
+	}
+	for _, routes := range []struct {
+		field  string
+		routes []string
+	}{
+		{"http.routeTimeouts", keys(c.HTTP.RouteTimeouts)},
+		{"http.routeMaxBodyBytes", keys(c.HTTP.RouteMaxBodyBytes)},
+		{"http.cacheControl", keys(c.HTTP.CacheControl)},
+	} {
+		for _, route := range routes.routes {
+			if route != "*" && !strings.HasPrefix(route, "/") {
+				check(routes.field, fmt.Errorf("%q is not a route template", route))
+			}
+		}
+	}
+
+	_, err = logging.ParseLevel(c.Log.Level)
+	check("log.level", err)
+	for pkg, level := range c.Log.Levels {
+		_, err := logging.ParseLevel(level)
+		check("log.levels."+pkg, err)
+	}
+
+	switch c.Tracing.Exporter {
+	case "", "otlp":
+	case "file":
+		if c.Tracing.File == "" {
+			check("tracing.file", fmt.Errorf("required by the file exporter"))
+		}
+	default:
+		check("tracing.exporter", fmt.Errorf("unknown exporter %q, expected otlp or file", c.Tracing.Exporter))
+	}
+	switch c.RateLimit.Store {
+	case "memory", "mongo":
+	default:
+		check("rateLimit.store", fmt.Errorf("unknown store %q, expected memory or mongo", c.RateLimit.Store))
+	}
+	if c.RateLimit.AddressLimit < 0 {
+		check("rateLimit.addressLimit", fmt.Errorf("can't be negative"))
+	}
+	if c.RateLimit.AddressLimit > 0 && c.RateLimit.AddressPeriod <= 0 {
+		check("rateLimit.addressPeriod", fmt.Errorf("must be positive when addressLimit is set"))
+	}
+	if len(c.CORS.AllowedOrigins) > 0 {
+		check("cors", c.CORSOptions().Validate())
+	}
+	if c.Compression.MinSize < 0 {
+		check("compression.minSize", fmt.Errorf("can't be negative"))
+	}
+	if c.Cache.Size < 0 {
+		check("cache.size", fmt.Errorf("can't be negative"))
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+	return fmt.Errorf("invalid configuration:\n%w", errors.Join(errs...))
+}
+
+func keys[V any](m map[string]V) []string {
+	list := make([]string, 0, len(m))
+	for k := range m {
+		list = append(list, k)
+	}
+	return list
+}
+
+// TLSOptions returns the TLS options of the server
+func (c Config) TLSOptions() certs.Options {
+	return certs.Options{
+		MinVersion:   c.TLS.MinVersion,
+		CipherSuites: c.TLS.CipherSuites,
+		ClientCAFile: c.TLS.ClientCAFile,
+		ClientAuth:   c.TLS.ClientAuth,
+	}
+}
+
+// CORSOptions returns the CORS options, nil when no origin is allowed
+func (c Config) CORSOptions() *cors.Options {
+	if len(c.CORS.AllowedOrigins) == 0 {
+		return nil
+	}
+	return &cors.Options{
+		AllowedOrigins:   c.CORS.AllowedOrigins,
+		AllowedMethods:   c.CORS.AllowedMethods,
+		AllowedHeaders:   c.CORS.AllowedHeaders,
+		ExposedHeaders:   c.CORS.ExposedHeaders,
+		AllowCredentials: c.CORS.AllowCredentials,
+		MaxAge:           c.CORS.MaxAge,
+	}
+}
+
+// CompressionOptions returns the compression options, nil when compression is disabled
+func (c Config) CompressionOptions() *compression.Options {
+	if !c.Compression.Enabled {
+		return nil
+	}
+	return &compression.Options{MinSize: c.Compression.MinSize, DecompressRoutes: c.Compression.DecompressRoutes}
+}
+
+// CacheOptions returns the options of the document cache, nil when it is disabled
+func (c Config) CacheOptions() *persistence.CacheOptions {
+	if c.Cache.Size == 0 {
+		return nil
+	}
+	return &persistence.CacheOptions{Size: c.Cache.Size, TTL: c.Cache.TTL, NegativeTTL: c.Cache.NegativeTTL}
+}
+
+// MongoOptions returns where the documents are stored
+func (c Config) MongoOptions() persistence.MongoOptions {
+	isolation, _ := persistence.ParseTenantIsolation(c.Mongo.TenantIsolation)
+	return persistence.MongoOptions{Database: c.Mongo.Database, Collection: c.Mongo.Collection, Isolation: isolation}
+}
+
+// LogLevels returns the levels of the logger
+func (c Config) LogLevels() (*logging.Levels, error) {
+	def, err := logging.ParseLevel(c.Log.Level)
+	if err != nil {
+		return nil, err
+	}
+	levels := logging.NewLevels(def)
+	for pkg, name := range c.Log.Levels {
+		level, err := logging.ParseLevel(name)
+		if err != nil {
+			return nil, fmt.Errorf("invalid level for %s: %v", pkg, err)
+		}
+		levels.Set(pkg, level)
+	}
+	return levels, nil
+}
+
+// isLoopback tells if host only accepts local connections, an empty host listens on every interface
+func isLoopback(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
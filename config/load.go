@@ -0,0 +1,411 @@
+package config
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Martin-Jast/go-microservice/secrets"
+	"github.com/Martin-Jast/go-microservice/utils"
+	"gopkg.in/yaml.v3"
+)
+
+// Load builds the configuration from, each one overriding the previous:
+// the defaults, the YAML or JSON file at path, the env files at envPaths in order and the environment
+// The file is only read when path is set, missing env files are ignored
+func Load(path string, envPaths ...string) (*Config, error) {
+	cfg := Default()
+	if path != "" {
+		if err := loadFile(path, &cfg); err != nil {
+			return nil, err
+		}
+	}
+	existing := []string{}
+	for _, envPath := range envPaths {
+		if _, err := os.Stat(envPath); err == nil {
+			existing = append(existing, envPath)
+		}
+	}
+	envFile, err := utils.ReadEnvFiles(existing...)
+	if err != nil {
+		return nil, err
+	}
+	lookup := func(name string) (string, bool) {
+		if value, ok := os.LookupEnv(name); ok {
+			return value, true
+		}
+		value, ok := envFile[name]
+		return value, ok
+	}
+	if errs := applyEnv(reflect.ValueOf(&cfg).Elem(), "", lookup); len(errs) > 0 {
+		return nil, fmt.Errorf("invalid configuration:\n%w", errors.Join(errs...))
+	}
+	cfg.resolver = secrets.NewResolver()
+	if cfg.Secrets.File != "" {
+		cfg.resolver.Register("secret", secrets.NewLocalStore(cfg.Secrets.File))
+	}
+	if errs := cfg.resolveSecrets(context.Background()); len(errs) > 0 {
+		return nil, fmt.Errorf("invalid configuration:\n%w", errors.Join(errs...))
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
+
+// loadFile sets the fields present in the file, YAML being a superset of JSON both are read the same way
+func loadFile(path string, cfg *Config) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("could not read configuration file: %v", err)
+	}
+	values := map[string]interface{}{}
+	if err := yaml.Unmarshal(b, &values); err != nil {
+		return fmt.Errorf("invalid configuration file %s: %v", path, err)
+	}
+	if errs := applyMap(reflect.ValueOf(cfg).Elem(), "", values); len(errs) > 0 {
+		return fmt.Errorf("invalid configuration file %s:\n%w", path, errors.Join(errs...))
+	}
+	return nil
+}
+
+// fieldName is the name of a field in files, its json name
+func fieldName(f reflect.StructField) string {
+	name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+	if name == "" {
+		return f.Name
+	}
+	return name
+}
+
+func join(prefix, name string) string {
+	if prefix == "" {
+		return name
+	}
+	return prefix + "." + name
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// isSection tells if a field holds other fields
+func isSection(t reflect.Type) bool {
+	return t.Kind() == reflect.Struct
+}
+
+// applyMap sets the fields of the struct v from the values of a file, unknown keys are errors
+func applyMap(v reflect.Value, prefix string, values map[string]interface{}) []error {
+	errs := []error{}
+	fields := map[string]int{}
+	for i := 0; i < v.NumField(); i++ {
+		if v.Type().Field(i).IsExported() {
+			fields[fieldName(v.Type().Field(i))] = i
+		}
+	}
+	names := keys(values)
+	sort.Strings(names)
+	for _, name := range names {
+		i, ok := fields[name]
+		if !ok {
+			errs = append(errs, fmt.Errorf("%s: unknown key", join(prefix, name)))
+			continue
+		}
+		field := v.Field(i)
+		path := join(prefix, name)
+		if isSection(field.Type()) {
+			section, ok := values[name].(map[string]interface{})
+			if !ok {
+				errs = append(errs, fmt.Errorf("%s: expected a section", path))
+				continue
+			}
+			errs = append(errs, applyMap(field, path, section)...)
+			continue
+		}
+		if err := setValue(field, values[name], v.Type().Field(i).Tag.Get("sep")); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %v", path, err))
+		}
+	}
+	return errs
+}
+
+// setValue sets field from a value read from a file, lists and maps may also be written as in the environment
+func setValue(field reflect.Value, raw interface{}, sep string) error {
+	switch value := raw.(type) {
+	case nil:
+		field.Set(reflect.Zero(field.Type()))
+		return nil
+	case []interface{}:
+		if field.Kind() != reflect.Slice {
+			return fmt.Errorf("expected a single value, got a list")
+		}
+		list := reflect.MakeSlice(field.Type(), len(value), len(value))
+		for i, item := range value {
+			if err := setScalar(list.Index(i), fmt.Sprint(item)); err != nil {
+				return err
+			}
+		}
+		field.Set(list)
+		return nil
+	case map[string]interface{}:
+		if field.Kind() != reflect.Map {
+			return fmt.Errorf("expected a single value, got a map")
+		}
+		m := reflect.MakeMapWithSize(field.Type(), len(value))
+		for k, item := range value {
+			elem := reflect.New(field.Type().Elem()).Elem()
+			if err := setScalar(elem, fmt.Sprint(item)); err != nil {
+				return fmt.Errorf("%s: %v", k, err)
+			}
+			m.SetMapIndex(reflect.ValueOf(k), elem)
+		}
+		field.Set(m)
+		return nil
+	}
+	return setString(field, fmt.Sprint(raw), sep)
+}
+
+// applyEnv sets the fields of the struct v having an env name found by lookup
+func applyEnv(v reflect.Value, prefix string, lookup func(string) (string, bool)) []error {
+	errs := []error{}
+	for i := 0; i < v.NumField(); i++ {
+		f := v.Type().Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		path := join(prefix, fieldName(f))
+		if isSection(f.Type) {
+			errs = append(errs, applyEnv(v.Field(i), path, lookup)...)
+			continue
+		}
+		name := f.Tag.Get("env")
+		if name == "" {
+			continue
+		}
+		value, ok := lookup(name)
+		if !ok {
+			continue
+		}
+		if err := setString(v.Field(i), value, f.Tag.Get("sep")); err != nil {
+			errs = append(errs, fmt.Errorf("%s (%s): %v", path, name, err))
+		}
+	}
+	return errs
+}
+
+// setString sets field from its text form, lists are separated by commas and maps written as "key=value" separated by sep ( "," by default )
+func setString(field reflect.Value, s string, sep string) error {
+	if sep == "" {
+		sep = ","
+	}
+	switch field.Kind() {
+	case reflect.Slice:
+		list := reflect.MakeSlice(field.Type(), 0, 0)
+		for _, item := range strings.Split(s, ",") {
+			if item = strings.TrimSpace(item); item == "" {
+				continue
+			}
+			elem := reflect.New(field.Type().Elem()).Elem()
+			if err := setScalar(elem, item); err != nil {
+				return err
+			}
+			list = reflect.Append(list, elem)
+		}
+		field.Set(list)
+		return nil
+	case reflect.Map:
+		m := reflect.MakeMap(field.Type())
+		for _, entry := range strings.Split(s, sep) {
+			if entry = strings.TrimSpace(entry); entry == "" {
+				continue
+			}
+			k, item, ok := strings.Cut(entry, "=")
+			if !ok || strings.TrimSpace(k) == "" {
+				return fmt.Errorf("invalid entry %q, expected key=value", entry)
+			}
+			elem := reflect.New(field.Type().Elem()).Elem()
+			if err := setScalar(elem, strings.TrimSpace(item)); err != nil {
+				return fmt.Errorf("%s: %v", k, err)
+			}
+			m.SetMapIndex(reflect.ValueOf(strings.TrimSpace(k)), elem)
+		}
+		field.Set(m)
+		return nil
+	}
+	return setScalar(field, s)
+}
+
+// setScalar parses s into a string, bool, number or duration field
+func setScalar(field reflect.Value, s string) error {
+	s = strings.TrimSpace(s)
+	if field.Type() == durationType {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return fmt.Errorf("invalid duration %q", s)
+		}
+		field.SetInt(int64(d))
+		return nil
+	}
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(s)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return fmt.Errorf("invalid boolean %q", s)
+		}
+		field.SetBool(b)
+	case reflect.Int, reflect.Int64:
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid number %q", s)
+		}
+		field.SetInt(n)
+	default:
+		return fmt.Errorf("unsupported type %s", field.Type())
+	}
+	return nil
+}
+
+// missing lists the required fields left empty
+func missing(c Config) []error {
+	errs := []error{}
+	var walk func(v reflect.Value, prefix string)
+	walk = func(v reflect.Value, prefix string) {
+		for i := 0; i < v.NumField(); i++ {
+			f := v.Type().Field(i)
+			if !f.IsExported() {
+				continue
+			}
+			path := join(prefix, fieldName(f))
+			if isSection(f.Type) {
+				walk(v.Field(i), path)
+				continue
+			}
+			if f.Tag.Get("required") == "true" && v.Field(i).IsZero() {
+				errs = append(errs, fmt.Errorf("%s (%s): required", path, f.Tag.Get("env")))
+			}
+		}
+	}
+	walk(reflect.ValueOf(c), "")
+	return errs
+}
+
+// Masked returns the configuration as nested maps, with the secrets replaced and the durations written as "1m30s"
+// Secrets read from a reference show the reference instead
+func (c Config) Masked() map[string]interface{} {
+	var walk func(v reflect.Value, prefix string) map[string]interface{}
+	walk = func(v reflect.Value, prefix string) map[string]interface{} {
+		out := map[string]interface{}{}
+		for i := 0; i < v.NumField(); i++ {
+			f := v.Type().Field(i)
+			if !f.IsExported() {
+				continue
+			}
+			field := v.Field(i)
+			path := join(prefix, fieldName(f))
+			switch {
+			case isSection(f.Type):
+				out[fieldName(f)] = walk(field, path)
+			case f.Tag.Get("secret") == "true":
+				if ref, ok := c.refs[path]; ok {
+					out[fieldName(f)] = ref
+				} else if field.IsZero() {
+					out[fieldName(f)] = ""
+				} else {
+					out[fieldName(f)] = utils.Redacted
+				}
+			default:
+				out[fieldName(f)] = printable(field)
+			}
+		}
+		return out
+	}
+	return walk(reflect.ValueOf(c), "")
+}
+
+// resolveSecrets replaces the references of the secret fields with their value, keeping the references to read them again
+func (c *Config) resolveSecrets(ctx context.Context) []error {
+	errs := []error{}
+	c.refs = map[string]string{}
+	var walk func(v reflect.Value, prefix string)
+	walk = func(v reflect.Value, prefix string) {
+		for i := 0; i < v.NumField(); i++ {
+			f := v.Type().Field(i)
+			if !f.IsExported() {
+				continue
+			}
+			path := join(prefix, fieldName(f))
+			if isSection(f.Type) {
+				walk(v.Field(i), path)
+				continue
+			}
+			ref := v.Field(i).String()
+			if f.Tag.Get("secret") != "true" || !c.resolver.IsReference(ref) {
+				continue
+			}
+			value, err := c.resolver.Resolve(ctx, ref)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %v", path, err))
+				continue
+			}
+			c.refs[path] = ref
+			v.Field(i).SetString(value)
+		}
+	}
+	walk(reflect.ValueOf(c).Elem(), "")
+	return errs
+}
+
+// HasSecretReference tells if the secret at path ( mongo.uri ) was read from a reference, so it may rotate
+func (c Config) HasSecretReference(path string) bool {
+	_, ok := c.refs[path]
+	return ok
+}
+
+// ResolveSecret reads the secret at path from its reference again, secrets set directly never change
+func (c Config) ResolveSecret(ctx context.Context, path string) (string, error) {
+	ref, ok := c.refs[path]
+	if !ok {
+		return "", fmt.Errorf("%s is not a secret reference", path)
+	}
+	return c.resolver.Resolve(ctx, ref)
+}
+
+// printable converts durations to their text form, inside lists and maps as well
+func printable(v reflect.Value) interface{} {
+	if v.Type() == durationType {
+		return time.Duration(v.Int()).String()
+	}
+	switch v.Kind() {
+	case reflect.Slice:
+		list := make([]interface{}, v.Len())
+		for i := range list {
+			list[i] = printable(v.Index(i))
+		}
+		return list
+	case reflect.Map:
+		m := map[string]interface{}{}
+		iter := v.MapRange()
+		for iter.Next() {
+			m[iter.Key().String()] = printable(iter.Value())
+		}
+		return m
+	}
+	return v.Interface()
+}
+
+// Print writes the masked configuration to w as YAML, it can be used as a configuration file
+func (c Config) Print(w io.Writer) error {
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	if err := enc.Encode(c.Masked()); err != nil {
+		return err
+	}
+	return enc.Close()
+}
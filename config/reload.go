@@ -0,0 +1,212 @@
+package config
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/Martin-Jast/go-microservice/logging"
+)
+
+// ReloadHook prepares a subsystem for the next configuration, it returns an error if the configuration can't be used
+// apply is only called once every hook accepted the configuration, it must not fail
+type ReloadHook func(next *Config) (apply func(), err error)
+
+// Version describes the configuration in use
+type Version struct {
+	// Version is incremented on every applied reload, the loaded configuration is version 1
+	Version  int       `json:"version"`
+	Hash     string    `json:"hash"`
+	LoadedAt time.Time `json:"loadedAt"`
+	// RestartRequired lists the changed settings that are only read at start
+	RestartRequired []string `json:"restartRequired,omitempty"`
+	// LastError is why the last reload was rejected, cleared by the next applied one
+	LastError string `json:"lastError,omitempty"`
+}
+
+// Reloader loads the configuration again when asked or when its files change and applies the reloadable settings:
+// the log levels, the rate limit rules, CORS, the feature flags and the cache TTLs
+// A configuration rejected by Load or by any hook leaves the current one in place
+type Reloader struct {
+	path     string
+	envPaths []string
+	hooks    []ReloadHook
+
+	mu      sync.RWMutex
+	current *Config
+	version Version
+	// files are the modification time and size of the watched files when they were last checked
+	files map[string]fileState
+}
+
+type fileState struct {
+	modTime time.Time
+	size    int64
+}
+
+// NewReloader manages cfg, loaded from path and envPaths as Load does
+func NewReloader(cfg *Config, path string, envPaths ...string) *Reloader {
+	r := &Reloader{path: path, envPaths: envPaths, current: cfg, files: map[string]fileState{}}
+	r.version = Version{Version: 1, Hash: hash(cfg), LoadedAt: time.Now()}
+	r.changedFiles()
+	return r
+}
+
+// OnReload adds a hook run by every reload, hooks are prepared and applied in the order they were added
+func (r *Reloader) OnReload(hook ReloadHook) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.hooks = append(r.hooks, hook)
+}
+
+// Current returns the configuration in use, it must not be modified
+func (r *Reloader) Current() *Config {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.current
+}
+
+// Version returns the version of the configuration in use
+func (r *Reloader) Version() Version {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.version
+}
+
+// Reload loads the configuration and applies its reloadable settings at once, the other changes wait for a restart
+func (r *Reloader) Reload(ctx context.Context) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	err := r.reload()
+	if err != nil {
+		r.version.LastError = err.Error()
+		logging.For(ctx, "config").ErrorContext(ctx, "configuration rejected, the current one is kept", "error", err, "version", r.version.Version)
+		return err
+	}
+	logging.For(ctx, "config").InfoContext(ctx, "configuration reloaded", "version", r.version.Version, "restartRequired", r.version.RestartRequired)
+	return nil
+}
+
+func (r *Reloader) reload() error {
+	next, err := Load(r.path, r.envPaths...)
+	if err != nil {
+		return err
+	}
+	effective := *r.current
+	effective.Log = next.Log
+	effective.RateLimit.File = next.RateLimit.File
+	effective.CORS = next.CORS
+	effective.Features = next.Features
+	effective.Cache.TTL = next.Cache.TTL
+	effective.Cache.NegativeTTL = next.Cache.NegativeTTL
+
+	applies := []func(){}
+	errs := []error{}
+	for _, hook := range r.hooks {
+		apply, err := hook(&effective)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		applies = append(applies, apply)
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid configuration:\n%w", errors.Join(errs...))
+	}
+	for _, apply := range applies {
+		apply()
+	}
+
+	r.current = &effective
+	r.version = Version{
+		Version:         r.version.Version + 1,
+		Hash:            hash(&effective),
+		LoadedAt:        time.Now(),
+		RestartRequired: []string{},
+	}
+	for _, path := range differences(effective, *next) {
+		// Rotated secrets are read again from their reference by the subsystem using them
+		if ref, ok := effective.refs[path]; ok && next.refs[path] == ref {
+			continue
+		}
+		r.version.RestartRequired = append(r.version.RestartRequired, path)
+	}
+	return nil
+}
+
+// Watch reloads the configuration when one of its files changes, it checks them every interval until ctx is done
+func (r *Reloader) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if r.changedFiles() {
+				// Rejected configurations are logged, the next change is tried again
+				_ = r.Reload(ctx)
+			}
+		}
+	}
+}
+
+// changedFiles tells if a watched file changed since the last check, the rate limit rules are watched with the configuration
+func (r *Reloader) changedFiles() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	paths := append([]string{r.path, r.current.RateLimit.File}, r.envPaths...)
+	changed := false
+	for _, path := range paths {
+		if path == "" {
+			continue
+		}
+		state := fileState{}
+		if info, err := os.Stat(path); err == nil {
+			state = fileState{modTime: info.ModTime(), size: info.Size()}
+		}
+		if last, ok := r.files[path]; ok && last != state {
+			changed = true
+		}
+		r.files[path] = state
+	}
+	return changed
+}
+
+// hash identifies a configuration by its masked form, so it does not depend on the secrets
+func hash(c *Config) string {
+	b, _ := json.Marshal(c.Masked())
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:8])
+}
+
+// differences lists the paths of the settings that differ between a and b
+func differences(a, b Config) []string {
+	paths := []string{}
+	var walk func(a, b reflect.Value, prefix string)
+	walk = func(a, b reflect.Value, prefix string) {
+		for i := 0; i < a.NumField(); i++ {
+			f := a.Type().Field(i)
+			if !f.IsExported() {
+				continue
+			}
+			path := join(prefix, fieldName(f))
+			if isSection(f.Type) {
+				walk(a.Field(i), b.Field(i), path)
+				continue
+			}
+			if !reflect.DeepEqual(a.Field(i).Interface(), b.Field(i).Interface()) {
+				paths = append(paths, path)
+			}
+		}
+	}
+	walk(reflect.ValueOf(a), reflect.ValueOf(b), "")
+	return paths
+}
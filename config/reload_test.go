@@ -0,0 +1,69 @@
+package config
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReloader_Reload(t *testing.T) {
+	t.Setenv("PORT", "4000")
+	t.Setenv("MONGO_STRING", "mongodb://localhost")
+	path := writeFile(t, "config.yaml", "log:\n  level: info\n")
+	cfg, err := Load(path)
+	assert.NoError(t, err)
+	reloader := NewReloader(cfg, path)
+	ctx := context.Background()
+
+	applied := []string{}
+	var hookErr error
+	reloader.OnReload(func(next *Config) (func(), error) {
+		return func() { applied = append(applied, next.Log.Level) }, hookErr
+	})
+	write := func(content string) {
+		assert.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	}
+
+	write("log:\n  level: debug\nfeatures:\n  export: true\nmongo:\n  database: other\n")
+	assert.NoError(t, reloader.Reload(ctx))
+	assert.Equal(t, []string{"debug"}, applied)
+	version := reloader.Version()
+	assert.Equal(t, 2, version.Version)
+	assert.Equal(t, []string{"mongo.database"}, version.RestartRequired)
+	assert.Equal(t, "debug", reloader.Current().Log.Level)
+	assert.Equal(t, map[string]bool{"export": true}, reloader.Current().Features)
+	assert.Equal(t, "test", reloader.Current().Mongo.Database, "settings read at start are kept until a restart")
+
+	// Invalid configurations keep the current one
+	write("log:\n  level: loud\n")
+	assert.ErrorContains(t, reloader.Reload(ctx), "log.level")
+	hookErr = errors.New("invalid rate limit rules")
+	write("log:\n  level: warn\n")
+	assert.ErrorContains(t, reloader.Reload(ctx), "invalid rate limit rules")
+	assert.Equal(t, []string{"debug"}, applied, "nothing is applied when a hook rejects the configuration")
+	assert.Equal(t, 2, reloader.Version().Version)
+	assert.Contains(t, reloader.Version().LastError, "invalid rate limit rules")
+	assert.Equal(t, "debug", reloader.Current().Log.Level)
+
+	hookErr = nil
+	assert.NoError(t, reloader.Reload(ctx))
+	assert.Equal(t, 3, reloader.Version().Version)
+	assert.Empty(t, reloader.Version().LastError)
+}
+
+func TestReloader_ChangedFiles(t *testing.T) {
+	t.Setenv("PORT", "4000")
+	t.Setenv("MONGO_STRING", "mongodb://localhost")
+	path := writeFile(t, "config.yaml", "log:\n  level: info\n")
+	cfg, err := Load(path)
+	assert.NoError(t, err)
+	reloader := NewReloader(cfg, path)
+
+	assert.False(t, reloader.changedFiles())
+	assert.NoError(t, os.WriteFile(path, []byte("log:\n  level: debug\n"), 0o600))
+	assert.True(t, reloader.changedFiles())
+	assert.False(t, reloader.changedFiles())
+}
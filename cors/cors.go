@@ -0,0 +1,220 @@
+package cors
+
+import (
+	"fmt"
+	"net/http"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Martin-Jast/go-microservice/utils"
+	"github.com/gorilla/mux"
+)
+
+// Options defines which browser origins may call the service and what they may send and read
+type Options struct {
+	// AllowedOrigins are origins as https://ui.example.com, * matches any part of a host ( https://*.example.com ) and alone any origin
+	AllowedOrigins []string
+	// AllowedMethods bounds the methods announced in preflights, each route still only announces the methods it handles
+	AllowedMethods []string
+	// AllowedHeaders are the request headers the browser may send, case insensitive
+	AllowedHeaders []string
+	// ExposedHeaders are the response headers the browser lets the page read
+	ExposedHeaders []string
+	// AllowCredentials lets the browser send cookies and authorization headers
+	AllowCredentials bool
+	// MaxAge is how long the browser may cache a preflight, not sent if zero
+	MaxAge time.Duration
+}
+
+// DefaultOptions are completed with the allowed origins to get a working configuration
+var DefaultOptions = Options{
+	AllowedMethods: []string{http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete},
+	AllowedHeaders: []string{"Accept", "Content-Type", "Authorization", "X-API-Key", "X-Tenant-ID", "X-Request-ID", "traceparent", "tracestate"},
+	ExposedHeaders: []string{"X-Request-ID", "X-Trace-ID", "traceparent", "Retry-After", "RateLimit-Limit", "RateLimit-Remaining", "RateLimit-Reset", "RateLimit-Policy"},
+	MaxAge:         10 * time.Minute,
+}
+
+// originAllowed tells if origin matches one of the allowed origins
+func (o Options) originAllowed(origin string) bool {
+	origin = strings.ToLower(origin)
+	for _, allowed := range o.AllowedOrigins {
+		if allowed == "*" {
+			return true
+		}
+		if ok, _ := path.Match(strings.ToLower(allowed), origin); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// headersAllowed tells if every header of the comma separated list can be sent
+func (o Options) headersAllowed(requested string) bool {
+	for _, header := range strings.Split(requested, ",") {
+		header = strings.TrimSpace(header)
+		if header == "" {
+			continue
+		}
+		found := false
+		for _, allowed := range o.AllowedHeaders {
+			if strings.EqualFold(allowed, header) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// Validate checks the options can be used
+func (o Options) Validate() error {
+	for _, origin := range o.AllowedOrigins {
+		if _, err := path.Match(origin, ""); err != nil {
+			return fmt.Errorf("invalid allowed origin: %s", origin)
+		}
+	}
+	if o.MaxAge < 0 {
+		return fmt.Errorf("negative max age")
+	}
+	return nil
+}
+
+// methods returns the allowed methods the router handles for the path of r
+func methods(router *mux.Router, r *http.Request, allowed []string) []string {
+	found := []string{}
+	for _, method := range allowed {
+		probe := r.Clone(r.Context())
+		probe.Method = method
+		match := mux.RouteMatch{}
+		if router.Match(probe, &match) && match.MatchErr == nil {
+			found = append(found, method)
+		}
+	}
+	sort.Strings(found)
+	return found
+}
+
+// Register answers the OPTIONS requests of every path of router, it must be called after every other route is registered
+// Preflights are answered by the middleware before this handler is reached, other OPTIONS requests get the Allow header
+func Register(router *mux.Router) {
+	router.Methods(http.MethodOptions).HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		allowed := methods(router, r, DefaultOptions.AllowedMethods)
+		if len(allowed) == 0 {
+			utils.WriteError(fmt.Errorf("not found"), w, http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Allow", strings.Join(append(allowed, http.MethodOptions), ", "))
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+// Policy holds the Options in use, they can be replaced while the service runs
+type Policy struct {
+	mu   sync.RWMutex
+	opts *Options
+}
+
+// NewPolicy creates a Policy with opts, nil disables CORS until options are set
+func NewPolicy(opts *Options) *Policy {
+	return &Policy{opts: opts}
+}
+
+// Set replaces the options, the requests in progress keep the previous ones
+func (p *Policy) Set(opts *Options) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.opts = opts
+}
+
+// Options returns the options in use, nil if CORS is disabled
+func (p *Policy) Options() *Options {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.opts
+}
+
+// Middleware adds the CORS headers to the responses to allowed origins and answers their preflights
+// It must run before authentication since browsers never send credentials in a preflight
+func Middleware(router *mux.Router, opts Options) func(http.Handler) http.Handler {
+	return NewPolicy(&opts).Middleware(router)
+}
+
+// Middleware works as the Middleware function with the options in use for each request
+func (p *Policy) Middleware(router *mux.Router) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			origin := r.Header.Get("Origin")
+			current := p.Options()
+			if origin == "" || current == nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+			opts := *current
+			anyOrigin := len(opts.AllowedOrigins) == 1 && opts.AllowedOrigins[0] == "*" && !opts.AllowCredentials
+			w.Header().Add("Vary", "Origin")
+			preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+			if !opts.originAllowed(origin) {
+				if preflight {
+					utils.WriteError(fmt.Errorf("origin %s not allowed", origin), w, http.StatusForbidden)
+					return
+				}
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			if anyOrigin {
+				w.Header().Set("Access-Control-Allow-Origin", "*")
+			} else {
+				w.Header().Set("Access-Control-Allow-Origin", origin)
+			}
+			if opts.AllowCredentials {
+				w.Header().Set("Access-Control-Allow-Credentials", "true")
+			}
+			if !preflight {
+				if len(opts.ExposedHeaders) > 0 {
+					w.Header().Set("Access-Control-Expose-Headers", strings.Join(opts.ExposedHeaders, ", "))
+				}
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			w.Header().Add("Vary", "Access-Control-Request-Method")
+			w.Header().Add("Vary", "Access-Control-Request-Headers")
+			allowed := methods(router, r, opts.AllowedMethods)
+			requested := r.Header.Get("Access-Control-Request-Method")
+			if !contains(allowed, requested) {
+				utils.WriteError(fmt.Errorf("method %s not allowed", requested), w, http.StatusForbidden)
+				return
+			}
+			requestedHeaders := r.Header.Get("Access-Control-Request-Headers")
+			if !opts.headersAllowed(requestedHeaders) {
+				utils.WriteError(fmt.Errorf("headers %s not allowed", requestedHeaders), w, http.StatusForbidden)
+				return
+			}
+			w.Header().Set("Access-Control-Allow-Methods", strings.Join(allowed, ", "))
+			if requestedHeaders != "" {
+				w.Header().Set("Access-Control-Allow-Headers", requestedHeaders)
+			}
+			if opts.MaxAge > 0 {
+				w.Header().Set("Access-Control-Max-Age", strconv.Itoa(int(opts.MaxAge.Seconds())))
+			}
+			w.WriteHeader(http.StatusNoContent)
+		})
+	}
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,128 @@
+package cors
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+// newTestRouter mounts routes as the service does, behind a middleware rejecting requests without credentials
+func newTestRouter(opts Options) *mux.Router {
+	router := mux.NewRouter()
+	router.Use(Middleware(router, opts))
+	router.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("Authorization") == "" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	})
+	ok := func(w http.ResponseWriter, r *http.Request) {}
+	base := router.PathPrefix("/base").Subrouter()
+	base.Path("/create").Methods(http.MethodPost).HandlerFunc(ok)
+	base.Path("/delete/{id}").Methods(http.MethodGet).HandlerFunc(ok)
+	base.Path("/{id}").Methods(http.MethodGet).HandlerFunc(ok)
+	Register(router)
+	return router
+}
+
+func TestMiddleware_Preflight(t *testing.T) {
+	opts := DefaultOptions
+	opts.AllowedOrigins = []string{"https://ui.example.com", "https://*.internal.example.com"}
+	opts.AllowCredentials = true
+	router := newTestRouter(opts)
+
+	tests := []struct {
+		name           string
+		path           string
+		origin         string
+		method         string
+		headers        string
+		expectedCode   int
+		expectedMethod string
+	}{
+		{name: "create", path: "/base/create", origin: "https://ui.example.com", method: http.MethodPost, headers: "Content-Type, X-API-Key", expectedCode: http.StatusNoContent, expectedMethod: "GET, POST"},
+		{name: "read with wildcard origin", path: "/base/42", origin: "https://admin.internal.example.com", method: http.MethodGet, expectedCode: http.StatusNoContent, expectedMethod: "GET"},
+		{name: "method not handled by the route", path: "/base/delete/42", origin: "https://ui.example.com", method: http.MethodPost, expectedCode: http.StatusForbidden},
+		{name: "origin not allowed", path: "/base/create", origin: "https://evil.example.com", method: http.MethodPost, expectedCode: http.StatusForbidden},
+		{name: "header not allowed", path: "/base/create", origin: "https://ui.example.com", method: http.MethodPost, headers: "X-Custom", expectedCode: http.StatusForbidden},
+		{name: "unknown path", path: "/unknown", origin: "https://ui.example.com", method: http.MethodGet, expectedCode: http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodOptions, tt.path, nil)
+			req.Header.Set("Origin", tt.origin)
+			req.Header.Set("Access-Control-Request-Method", tt.method)
+			if tt.headers != "" {
+				req.Header.Set("Access-Control-Request-Headers", tt.headers)
+			}
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+			assert.Equal(t, tt.expectedCode, rec.Code)
+			if tt.expectedCode != http.StatusNoContent {
+				return
+			}
+			assert.Equal(t, tt.origin, rec.Header().Get("Access-Control-Allow-Origin"))
+			assert.Equal(t, "true", rec.Header().Get("Access-Control-Allow-Credentials"))
+			assert.Equal(t, tt.expectedMethod, rec.Header().Get("Access-Control-Allow-Methods"))
+			assert.Equal(t, tt.headers, rec.Header().Get("Access-Control-Allow-Headers"))
+			assert.Equal(t, "600", rec.Header().Get("Access-Control-Max-Age"))
+		})
+	}
+}
+
+func TestMiddleware_Request(t *testing.T) {
+	opts := DefaultOptions
+	opts.AllowedOrigins = []string{"*"}
+	opts.MaxAge = time.Minute
+	router := newTestRouter(opts)
+
+	// Errors can be read by the page too
+	req := httptest.NewRequest(http.MethodGet, "/base/42", nil)
+	req.Header.Set("Origin", "https://anywhere.example.com")
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	assert.Equal(t, "*", rec.Header().Get("Access-Control-Allow-Origin"))
+	assert.Contains(t, rec.Header().Get("Access-Control-Expose-Headers"), "X-Request-ID")
+	assert.Equal(t, "Origin", rec.Header().Get("Vary"))
+
+	// OPTIONS without CORS is a normal request listing the methods of the path
+	req = httptest.NewRequest(http.MethodOptions, "/base/create", nil)
+	req.Header.Set("Authorization", "ApiKey key")
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+	assert.Equal(t, "GET, POST, OPTIONS", rec.Header().Get("Allow"))
+}
+
+func TestPolicy_Set(t *testing.T) {
+	policy := NewPolicy(nil)
+	router := mux.NewRouter()
+	router.Use(policy.Middleware(router))
+	router.Path("/base/{id}").Methods(http.MethodGet).HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	get := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/base/42", nil)
+		req.Header.Set("Origin", "https://ui.example.com")
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	assert.Empty(t, get().Header().Get("Access-Control-Allow-Origin"), "nil options disable CORS")
+
+	opts := DefaultOptions
+	opts.AllowedOrigins = []string{"https://ui.example.com"}
+	policy.Set(&opts)
+	assert.Equal(t, "https://ui.example.com", get().Header().Get("Access-Control-Allow-Origin"))
+
+	opts.AllowedOrigins = []string{"https://other.example.com"}
+	policy.Set(&opts)
+	assert.Empty(t, get().Header().Get("Access-Control-Allow-Origin"))
+}
@@ -0,0 +1,74 @@
+package features
+
+import (
+	"context"
+	"net/http"
+	"sync"
+)
+
+// Flags turn features on and off by name, they can be replaced while the service runs
+type Flags struct {
+	mu    sync.RWMutex
+	flags map[string]bool
+}
+
+// NewFlags creates Flags with the given state, the features not listed are off
+func NewFlags(flags map[string]bool) *Flags {
+	f := &Flags{}
+	f.Set(flags)
+	return f
+}
+
+// Set replaces every flag at once
+func (f *Flags) Set(flags map[string]bool) {
+	copied := make(map[string]bool, len(flags))
+	for name, on := range flags {
+		copied[name] = on
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.flags = copied
+}
+
+// Enabled tells if the feature name is on, unknown features and nil Flags are off
+func (f *Flags) Enabled(name string) bool {
+	if f == nil {
+		return false
+	}
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.flags[name]
+}
+
+// All returns the state of every flag
+func (f *Flags) All() map[string]bool {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	all := make(map[string]bool, len(f.flags))
+	for name, on := range f.flags {
+		all[name] = on
+	}
+	return all
+}
+
+type flagsKey struct{}
+
+// WithFlags returns a copy of ctx carrying f
+func WithFlags(ctx context.Context, f *Flags) context.Context {
+	return context.WithValue(ctx, flagsKey{}, f)
+}
+
+// Enabled tells if the feature name is on for the flags carried by ctx, off if it carries none
+func Enabled(ctx context.Context, name string) bool {
+	f, _ := ctx.Value(flagsKey{}).(*Flags)
+	return f.Enabled(name)
+}
+
+// Middleware passes f to the handlers through the request context
+func Middleware(f *Flags) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			next.ServeHTTP(w, r.WithContext(WithFlags(r.Context(), f)))
+		})
+	}
+}
@@ -0,0 +1,22 @@
+package features
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFlags(t *testing.T) {
+	flags := NewFlags(map[string]bool{"history": true, "export": false})
+	ctx := WithFlags(context.Background(), flags)
+	assert.True(t, Enabled(ctx, "history"))
+	assert.False(t, Enabled(ctx, "export"))
+	assert.False(t, Enabled(ctx, "unknown"))
+	assert.False(t, Enabled(context.Background(), "history"), "a context without flags has every feature off")
+
+	flags.Set(map[string]bool{"export": true})
+	assert.False(t, Enabled(ctx, "history"))
+	assert.True(t, Enabled(ctx, "export"))
+	assert.Equal(t, map[string]bool{"export": true}, flags.All())
+}
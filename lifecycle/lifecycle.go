@@ -0,0 +1,240 @@
+package lifecycle
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Martin-Jast/go-microservice/logging"
+)
+
+// ErrDraining is reported by the readiness check once the shutdown started
+var ErrDraining = errors.New("shutting down")
+
+// ErrDeadline is returned when the subsystems did not stop before the deadline
+var ErrDeadline = errors.New("shutdown deadline exceeded")
+
+// ErrForced is returned when a second signal interrupts the shutdown
+var ErrForced = errors.New("shutdown forced")
+
+// Hook starts and stops a subsystem, both functions are optional
+// Start must not block, long running work is started in a goroutine that Stop ends
+type Hook struct {
+	Name  string
+	Start func(ctx context.Context) error
+	Stop  func(ctx context.Context) error
+}
+
+// Manager starts the subsystems in the order they were added and stops them in the reverse order
+// Once the shutdown is requested readiness fails for DrainPeriod so the load balancers stop sending requests,
+// then the subsystems are stopped, giving up after Deadline ( drain included )
+type Manager struct {
+	DrainPeriod time.Duration
+	Deadline    time.Duration
+
+	mu      sync.Mutex
+	hooks   []Hook
+	started int
+
+	shutdown     chan struct{}
+	shutdownOnce sync.Once
+}
+
+// New creates a Manager with the given drain period and deadline
+func New(drainPeriod, deadline time.Duration) *Manager {
+	return &Manager{DrainPeriod: drainPeriod, Deadline: deadline, shutdown: make(chan struct{})}
+}
+
+// Append adds a hook, it is started after and stopped before the hooks already added
+func (m *Manager) Append(hook Hook) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.hooks = append(m.hooks, hook)
+}
+
+// Worker adds a background worker, run must return once its ctx is done
+func (m *Manager) Worker(name string, run func(ctx context.Context)) {
+	var cancel context.CancelFunc
+	done := make(chan struct{})
+	m.Append(Hook{
+		Name: name,
+		Start: func(ctx context.Context) error {
+			var workerCtx context.Context
+			// The worker outlives the start, it is only stopped by Stop
+			workerCtx, cancel = context.WithCancel(context.WithoutCancel(ctx))
+			go func() {
+				defer close(done)
+				run(workerCtx)
+			}()
+			return nil
+		},
+		Stop: func(ctx context.Context) error {
+			cancel()
+			select {
+			case <-done:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		},
+	})
+}
+
+// Server adds an HTTP server, it listens when started and lets the requests in progress finish within timeout when stopped
+// The server is served with TLS when it has a TLSConfig, a server failing requests the shutdown of the others
+func (m *Manager) Server(name string, srv *http.Server, timeout time.Duration) {
+	m.Append(Hook{
+		Name: name,
+		Start: func(ctx context.Context) error {
+			ln, err := net.Listen("tcp", srv.Addr)
+			if err != nil {
+				return err
+			}
+			if srv.TLSConfig != nil {
+				ln = tls.NewListener(ln, srv.TLSConfig)
+			}
+			logger := logging.For(ctx, "lifecycle")
+			logger.InfoContext(ctx, "starting server", "server", name, "addr", ln.Addr().String())
+			go func() {
+				if err := srv.Serve(ln); err != http.ErrServerClosed {
+					logger.ErrorContext(ctx, "server failed", "server", name, "error", err)
+					m.Shutdown()
+				}
+			}()
+			return nil
+		},
+		Stop: func(ctx context.Context) error {
+			ctx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+			return srv.Shutdown(ctx)
+		},
+	})
+}
+
+// Start starts every hook in order, if one fails the started ones are stopped and its error is returned
+func (m *Manager) Start(ctx context.Context) error {
+	m.mu.Lock()
+	hooks := m.hooks
+	m.mu.Unlock()
+	for i, hook := range hooks {
+		if hook.Start != nil {
+			if err := hook.Start(ctx); err != nil {
+				m.setStarted(i)
+				stopCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), m.Deadline)
+				defer cancel()
+				return errors.Join(fmt.Errorf("%s could not start: %w", hook.Name, err), m.stopHooks(stopCtx))
+			}
+		}
+		m.setStarted(i + 1)
+	}
+	return nil
+}
+
+func (m *Manager) setStarted(n int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.started = n
+}
+
+// Shutdown requests the shutdown, it can be called any number of times from any goroutine
+func (m *Manager) Shutdown() {
+	m.shutdownOnce.Do(func() { close(m.shutdown) })
+}
+
+// Done is closed when the shutdown is requested
+func (m *Manager) Done() <-chan struct{} {
+	return m.shutdown
+}
+
+// Ready is a readiness check failing once the shutdown is requested
+func (m *Manager) Ready(ctx context.Context) (interface{}, error) {
+	select {
+	case <-m.shutdown:
+		return nil, ErrDraining
+	default:
+		return nil, nil
+	}
+}
+
+// Wait blocks until one of signals is received or the shutdown is requested, then stops the subsystems
+// A second signal stops waiting for them
+func (m *Manager) Wait(ctx context.Context, signals ...os.Signal) error {
+	received := make(chan os.Signal, 1)
+	signal.Notify(received, signals...)
+	defer signal.Stop(received)
+	logger := logging.For(ctx, "lifecycle")
+
+	select {
+	case sig := <-received:
+		logger.InfoContext(ctx, "shutdown requested", "signal", sig.String())
+	case <-m.shutdown:
+		logger.InfoContext(ctx, "shutdown requested")
+	}
+	stopped := make(chan error, 1)
+	go func() { stopped <- m.Stop(ctx) }()
+	select {
+	case err := <-stopped:
+		return err
+	case sig := <-received:
+		logger.WarnContext(ctx, "shutdown forced", "signal", sig.String())
+		return ErrForced
+	}
+}
+
+// Stop requests the shutdown, waits for the drain period and stops the started hooks in the reverse order
+// It returns ErrDeadline without waiting for the hooks still stopping once the deadline passed
+func (m *Manager) Stop(ctx context.Context) error {
+	m.Shutdown()
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), m.Deadline)
+	defer cancel()
+	if m.DrainPeriod > 0 {
+		logging.For(ctx, "lifecycle").InfoContext(ctx, "draining", "period", m.DrainPeriod)
+		select {
+		case <-time.After(m.DrainPeriod):
+		case <-ctx.Done():
+		}
+	}
+	return m.stopHooks(ctx)
+}
+
+func (m *Manager) stopHooks(ctx context.Context) error {
+	m.mu.Lock()
+	hooks := m.hooks[:m.started]
+	m.started = 0
+	m.mu.Unlock()
+	logger := logging.For(ctx, "lifecycle")
+
+	errs := []error{}
+	for i := len(hooks) - 1; i >= 0; i-- {
+		hook := hooks[i]
+		if hook.Stop == nil {
+			continue
+		}
+		stopped := make(chan error, 1)
+		go func() { stopped <- hook.Stop(ctx) }()
+		select {
+		case err := <-stopped:
+			if err != nil {
+				logger.ErrorContext(ctx, "could not stop", "hook", hook.Name, "error", err)
+				errs = append(errs, fmt.Errorf("%s: %w", hook.Name, err))
+				continue
+			}
+			logger.InfoContext(ctx, "stopped", "hook", hook.Name)
+		case <-ctx.Done():
+			pending := []string{}
+			for j := i; j >= 0; j-- {
+				pending = append(pending, hooks[j].Name)
+			}
+			return errors.Join(append(errs, fmt.Errorf("%w, not stopped: %s", ErrDeadline, strings.Join(pending, ", ")))...)
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,142 @@
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// recorder adds hooks writing their start and stop to events
+type recorder struct {
+	events []string
+}
+
+func (r *recorder) hook(name string, startErr error) Hook {
+	return Hook{
+		Name: name,
+		Start: func(ctx context.Context) error {
+			r.events = append(r.events, "start "+name)
+			return startErr
+		},
+		Stop: func(ctx context.Context) error {
+			r.events = append(r.events, "stop "+name)
+			return nil
+		},
+	}
+}
+
+func TestManager_Order(t *testing.T) {
+	tests := []struct {
+		name     string
+		failing  string
+		wantErr  bool
+		expected []string
+	}{
+		{
+			name:     "stopped in the reverse order",
+			expected: []string{"start mongo", "start worker", "start http", "stop http", "stop worker", "stop mongo"},
+		},
+		{
+			name:     "failed start stops the started hooks",
+			failing:  "worker",
+			wantErr:  true,
+			expected: []string{"start mongo", "start worker", "stop mongo"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &recorder{}
+			m := New(0, time.Second)
+			for _, name := range []string{"mongo", "worker", "http"} {
+				var err error
+				if name == tt.failing {
+					err = errors.New("failed")
+				}
+				m.Append(r.hook(name, err))
+			}
+			err := m.Start(context.Background())
+			if tt.wantErr {
+				assert.ErrorContains(t, err, "worker could not start")
+			} else {
+				assert.NoError(t, err)
+				assert.NoError(t, m.Stop(context.Background()))
+			}
+			assert.Equal(t, tt.expected, r.events)
+		})
+	}
+}
+
+func TestManager_Drain(t *testing.T) {
+	m := New(50*time.Millisecond, time.Second)
+	stopped := make(chan time.Time, 1)
+	m.Append(Hook{Name: "http", Stop: func(ctx context.Context) error {
+		stopped <- time.Now()
+		return nil
+	}})
+	assert.NoError(t, m.Start(context.Background()))
+	_, err := m.Ready(context.Background())
+	assert.NoError(t, err)
+
+	requested := time.Now()
+	go m.Stop(context.Background())
+	<-m.Done()
+	_, err = m.Ready(context.Background())
+	assert.ErrorIs(t, err, ErrDraining, "readiness fails while draining")
+	assert.GreaterOrEqual(t, (<-stopped).Sub(requested), 50*time.Millisecond, "hooks are stopped after the drain period")
+}
+
+func TestManager_Deadline(t *testing.T) {
+	m := New(0, 50*time.Millisecond)
+	m.Append(Hook{Name: "mongo", Stop: func(ctx context.Context) error { return nil }})
+	m.Append(Hook{Name: "stuck", Stop: func(ctx context.Context) error {
+		time.Sleep(time.Second)
+		return nil
+	}})
+	assert.NoError(t, m.Start(context.Background()))
+
+	start := time.Now()
+	err := m.Stop(context.Background())
+	assert.ErrorIs(t, err, ErrDeadline)
+	assert.ErrorContains(t, err, "not stopped: stuck, mongo")
+	assert.Less(t, time.Since(start), 500*time.Millisecond, "the stuck hook is not waited for")
+}
+
+func TestManager_Worker(t *testing.T) {
+	m := New(0, time.Second)
+	ticks := make(chan struct{}, 100)
+	m.Worker("ticker", func(ctx context.Context) {
+		ticker := time.NewTicker(time.Millisecond)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				ticks <- struct{}{}
+			}
+		}
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	assert.NoError(t, m.Start(ctx))
+	// Cancelling the start context does not stop the worker
+	cancel()
+	<-ticks
+	assert.NoError(t, m.Stop(context.Background()))
+}
+
+func TestManager_Server(t *testing.T) {
+	m := New(0, time.Second)
+	srv := &http.Server{Addr: "127.0.0.1:0", Handler: http.NotFoundHandler()}
+	m.Server("http", srv, time.Second)
+	assert.NoError(t, m.Start(context.Background()))
+	assert.NoError(t, m.Stop(context.Background()))
+
+	// A server that can't listen fails the start
+	m = New(0, time.Second)
+	m.Server("http", &http.Server{Addr: "invalid:address:0"}, time.Second)
+	assert.Error(t, m.Start(context.Background()))
+}
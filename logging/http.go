@@ -0,0 +1,35 @@
+package logging
+
+import (
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/Martin-Jast/go-microservice/utils"
+)
+
+// Middleware puts logger in the context of every request and writes one access log per request
+// It must be used as a mux middleware so the route template is known
+func Middleware(logger *slog.Logger) func(http.Handler) http.Handler {
+	access := logger.With(PackageKey, "http")
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			rec := utils.NewResponseRecorder(w)
+			next.ServeHTTP(rec, r.WithContext(WithLogger(r.Context(), logger)))
+
+			level := slog.LevelInfo
+			if rec.Status >= 500 {
+				level = slog.LevelError
+			}
+			access.LogAttrs(r.Context(), level, "request",
+				slog.String("method", r.Method),
+				slog.String("route", utils.RouteTemplate(r)),
+				slog.Int("status", rec.Status),
+				slog.Int64("bytes", rec.Bytes),
+				slog.Duration("duration", time.Since(start)),
+				slog.String("remote_addr", r.RemoteAddr),
+			)
+		})
+	}
+}
@@ -0,0 +1,231 @@
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"sync"
+
+	"github.com/Martin-Jast/go-microservice/requestid"
+	"github.com/Martin-Jast/go-microservice/tenancy"
+	"github.com/Martin-Jast/go-microservice/tracing"
+	"github.com/Martin-Jast/go-microservice/utils"
+)
+
+// PackageKey is the attribute naming the package that logs, it selects the level used for the record
+const PackageKey = "package"
+
+// ParseLevel converts the names used in configuration ( debug, info, warn, error ) to a slog.Level
+func ParseLevel(s string) (slog.Level, error) {
+	var level slog.Level
+	if s == "" {
+		return slog.LevelInfo, nil
+	}
+	if err := level.UnmarshalText([]byte(s)); err != nil {
+		return level, fmt.Errorf("unknown log level: %s", s)
+	}
+	return level, nil
+}
+
+// Levels keeps the minimum level of each package, packages without their own level use the default one
+// Levels can be changed while the service runs
+type Levels struct {
+	mu       sync.RWMutex
+	def      slog.Level
+	packages map[string]slog.Level
+}
+
+// NewLevels creates Levels where every package logs from def
+func NewLevels(def slog.Level) *Levels {
+	return &Levels{def: def, packages: map[string]slog.Level{}}
+}
+
+// ParseLevels creates Levels from the default level and a list as "http=warn,persistence=debug"
+func ParseLevels(def, perPackage string) (*Levels, error) {
+	level, err := ParseLevel(def)
+	if err != nil {
+		return nil, err
+	}
+	levels := NewLevels(level)
+	for _, entry := range strings.Split(perPackage, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		pkg, name, ok := strings.Cut(entry, "=")
+		if !ok || pkg == "" {
+			return nil, fmt.Errorf("invalid package log level: %s", entry)
+		}
+		level, err := ParseLevel(name)
+		if err != nil {
+			return nil, err
+		}
+		levels.Set(pkg, level)
+	}
+	return levels, nil
+}
+
+// Set changes the level of pkg, an empty pkg changes the default level
+func (l *Levels) Set(pkg string, level slog.Level) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if pkg == "" {
+		l.def = level
+		return
+	}
+	l.packages[pkg] = level
+}
+
+// Replace sets every level to the ones of from at once, the packages not in from use the default level again
+func (l *Levels) Replace(from *Levels) {
+	all := from.All()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.def = all[""]
+	delete(all, "")
+	l.packages = all
+}
+
+// Level returns the level used by pkg
+func (l *Levels) Level(pkg string) slog.Level {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	if level, ok := l.packages[pkg]; ok {
+		return level
+	}
+	return l.def
+}
+
+// All returns the default level ( under "" ) and the level of each package
+func (l *Levels) All() map[string]slog.Level {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	all := map[string]slog.Level{"": l.def}
+	for pkg, level := range l.packages {
+		all[pkg] = level
+	}
+	return all
+}
+
+// min is the lowest level of any package, records below it are never logged
+func (l *Levels) min() slog.Level {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	lowest := l.def
+	for _, level := range l.packages {
+		if level < lowest {
+			lowest = level
+		}
+	}
+	return lowest
+}
+
+// Handler filters records by the level of their package and adds the request id, trace id and tenant found in the context
+type Handler struct {
+	next   slog.Handler
+	levels *Levels
+	pkg    string
+}
+
+// NewHandler wraps next, which receives only the records enabled by levels
+func NewHandler(next slog.Handler, levels *Levels) *Handler {
+	return &Handler{next: next, levels: levels}
+}
+
+// Enabled implements slog.Handler, the package of a record is only known in Handle so only the lowest level is checked here
+func (h *Handler) Enabled(ctx context.Context, level slog.Level) bool {
+	if h.pkg != "" {
+		return level >= h.levels.Level(h.pkg)
+	}
+	return level >= h.levels.min()
+}
+
+// Handle implements slog.Handler
+func (h *Handler) Handle(ctx context.Context, r slog.Record) error {
+	pkg := h.pkg
+	if pkg == "" {
+		r.Attrs(func(a slog.Attr) bool {
+			if a.Key == PackageKey {
+				pkg = a.Value.String()
+				return false
+			}
+			return true
+		})
+	}
+	if r.Level < h.levels.Level(pkg) {
+		return nil
+	}
+	if ctx != nil {
+		if id := requestid.FromContext(ctx); id != "" {
+			r.AddAttrs(slog.String("request_id", id))
+		}
+		if id := tracing.TraceIDFromContext(ctx); id != "" {
+			r.AddAttrs(slog.String("trace_id", id))
+		}
+		if tenant, ok := tenancy.FromContext(ctx); ok {
+			r.AddAttrs(slog.String("tenant", tenant))
+		}
+	}
+	return h.next.Handle(ctx, r)
+}
+
+// WithAttrs implements slog.Handler
+func (h *Handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	clone := *h
+	for _, a := range attrs {
+		if a.Key == PackageKey {
+			clone.pkg = a.Value.String()
+		}
+	}
+	clone.next = h.next.WithAttrs(attrs)
+	return &clone
+}
+
+// WithGroup implements slog.Handler
+func (h *Handler) WithGroup(name string) slog.Handler {
+	clone := *h
+	clone.next = h.next.WithGroup(name)
+	return &clone
+}
+
+// Redact hides the value of attributes named like secrets and the passwords of urls, it is meant as a slog ReplaceAttr
+func Redact(groups []string, a slog.Attr) slog.Attr {
+	if a.Value.Kind() == slog.KindGroup {
+		return a
+	}
+	if utils.IsSecretName(a.Key) {
+		return slog.String(a.Key, utils.Redacted)
+	}
+	if a.Value.Kind() == slog.KindString {
+		return slog.String(a.Key, utils.RedactURL(a.Value.String()))
+	}
+	return a
+}
+
+// New creates a JSON logger writing to w, filtered by levels and with secrets redacted
+func New(w io.Writer, levels *Levels) *slog.Logger {
+	json := slog.NewJSONHandler(w, &slog.HandlerOptions{Level: slog.LevelDebug, ReplaceAttr: Redact})
+	return slog.New(NewHandler(json, levels))
+}
+
+type loggerKey struct{}
+
+// WithLogger returns a copy of ctx carrying l
+func WithLogger(ctx context.Context, l *slog.Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, l)
+}
+
+// FromContext returns the logger of ctx, slog.Default if there is none
+func FromContext(ctx context.Context) *slog.Logger {
+	if l, ok := ctx.Value(loggerKey{}).(*slog.Logger); ok {
+		return l
+	}
+	return slog.Default()
+}
+
+// For returns the logger of ctx for the records of pkg
+func For(ctx context.Context, pkg string) *slog.Logger {
+	return FromContext(ctx).With(PackageKey, pkg)
+}
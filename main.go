@@ -2,8 +2,10 @@ package main
 
 import (
 	"context"
+	"flag"
 	"fmt"
-	"log"
+	"io"
+	"log/slog"
 	"net/http"
 	"os"
 	"os/signal"
@@ -11,89 +13,473 @@ import (
 	"time"
 
 	"github.com/Martin-Jast/go-microservice/application"
+	"github.com/Martin-Jast/go-microservice/audit"
+	"github.com/Martin-Jast/go-microservice/auth"
+	"github.com/Martin-Jast/go-microservice/certs"
+	"github.com/Martin-Jast/go-microservice/config"
+	"github.com/Martin-Jast/go-microservice/cors"
+	"github.com/Martin-Jast/go-microservice/features"
+	"github.com/Martin-Jast/go-microservice/lifecycle"
+	"github.com/Martin-Jast/go-microservice/logging"
+	"github.com/Martin-Jast/go-microservice/metrics"
 	"github.com/Martin-Jast/go-microservice/persistence"
+	"github.com/Martin-Jast/go-microservice/ratelimit"
 	"github.com/Martin-Jast/go-microservice/server"
-	"github.com/Martin-Jast/go-microservice/utils"
+	"github.com/Martin-Jast/go-microservice/tenancy"
+	"github.com/Martin-Jast/go-microservice/tracing"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
 )
 
 func main() {
-	ctx := context.Background()
-	err := utils.SetupEnvVars(".env")
+	os.Exit(runCommand(context.Background(), os.Args[1:]))
+}
+
+// serve runs the HTTP server until a shutdown is requested
+func serve(ctx context.Context, args []string) error {
+	if err := flag.NewFlagSet("serve", flag.ContinueOnError).Parse(args); err != nil {
+		return err
+	}
+	// A service never starts with an invalid configuration
+	cfg, logger, logLevels, err := loadConfig(os.Stdout)
+	if err != nil {
+		return err
+	}
+	metricsRegistry := metrics.NewRegistryWithRuntime()
+	tracer := newTracer(cfg)
+	// Subsystems are stopped in the reverse order they are added, the traces are flushed last
+	lc := lifecycle.New(cfg.Shutdown.DrainPeriod, cfg.Shutdown.Deadline)
+	if tracer != nil {
+		lc.Append(lifecycle.Hook{Name: "tracer", Stop: tracer.Shutdown})
+	}
+
+	// Start by connecting to DB Clients and the Adapters
+	store, err := newPersistence(ctx, cfg, metricsRegistry, tracer)
+	if err != nil {
+		return err
+	}
+	mongoClients, mongoOptions, adapter := store.clients, store.options, store.adapter
+	resilientAdapter, cachedAdapter := store.resilient, store.cached
+	lc.Append(lifecycle.Hook{Name: "mongodb", Stop: mongoClients.Disconnect})
+	if cfg.HasSecretReference("mongo.uri") && cfg.Secrets.RefreshInterval > 0 {
+		lc.Worker("mongo credentials", func(ctx context.Context) { refreshMongoCredentials(ctx, cfg, mongoClients) })
+	}
+
+	// Start Application
+	service := application.NewService(adapter)
+	auditStore := persistence.NewMongoAuditStore(mongoClients, mongoOptions)
+	if err := auditStore.EnsureIndexes(ctx); err != nil {
+		panic(err)
+	}
+	auditLog := audit.NewLog(auditStore)
+	service.Audit = auditLog
+
+
+	// Start server
+	reqShutdown := make(chan bool)
+	apiKeys := auth.NewAPIKeyManager(persistence.NewMongoAPIKeyStore(mongoClients, mongoOptions))
+	middlewares := []func(http.Handler) http.Handler{}
+	var authorizer auth.Authorizer
+	var authenticate func(http.Handler) http.Handler
+	if cfg.Auth.Enabled {
+		authenticate = authMiddleware(apiKeys, cfg.Auth, cfg.TLS)
+		middlewares = append(middlewares, authenticate)
+		policy := authPolicy(cfg.Auth)
+		// Every denial is logged, the audit chain keeps one entry per caller and operation a minute
+		denials := audit.NewDenials(auditLog, time.Minute)
+		policy.OnDenied = func(ctx context.Context, operation string, id *auth.Identity) {
+			subject := "anonymous"
+			if id != nil {
+				subject = id.Subject
+			}
+			logger.WarnContext(ctx, "operation denied", "operation", operation, "subject", subject)
+			if _, err := denials.Record(ctx, operation); err != nil {
+				logger.ErrorContext(ctx, "could not audit denial", "operation", operation, "error", err)
+			}
+		}
+		authorizer = policy
+		service.Authorizer = authorizer
+		apiKeys.Policy = policy
+	}
+	middlewares = append(middlewares, tenantMiddleware(cfg.Tenancy, authorizer))
+	limits := requestLimits(cfg.HTTP)
+	rateLimitStore := newRateLimitStore(ctx, mongoClients, mongoOptions, cfg.RateLimit)
+	rateLimiter := newRateLimiter(rateLimitStore, cfg.RateLimit)
+	cachePolicies := cachePolicies(cfg.HTTP)
+	corsPolicy := cors.NewPolicy(cfg.CORSOptions())
+	flags := features.NewFlags(cfg.Features)
+	// The reloadable settings follow the configuration files and SIGHUP
+	reloader := config.NewReloader(cfg, os.Getenv("CONFIG_FILE"), envFiles...)
+	onReload(reloader, logLevels, rateLimiter, corsPolicy, flags, cachedAdapter)
+	lc.Worker("config watcher", func(ctx context.Context) { reloader.Watch(ctx, configCheckInterval) })
+	lc.Worker("config reload on SIGHUP", func(ctx context.Context) { reloadOnHangup(ctx, reloader) })
+	lc.Worker("shutdown requests", func(ctx context.Context) { shutdownOnRequest(ctx, lc, reqShutdown) })
+	handler := server.NewServerWithOptions(service, server.Options{
+		Middlewares:    middlewares,
+		APIKeys:        apiKeys,
+		Authorizer:     authorizer,
+		Audit:          auditLog,
+		Metrics:        metricsRegistry,
+		Tracer:         tracer,
+		Logger:         logger,
+		Limits:         limits,
+		RateLimiter:    rateLimiter,
+		AddressLimiter: newAddressLimiter(rateLimitStore, cfg.RateLimit),
+		CORS:           corsPolicy,
+		Compression:    cfg.CompressionOptions(),
+		CachePolicies:  cachePolicies,
+		Features:       flags,
+	})
+	// The operational routes are served on their own listener, it stops after the public one so /ready reports the drain
+	adminHandler := server.NewAdminServer(reqShutdown, server.AdminOptions{
+		Metrics:   metricsRegistry,
+		Config:    reloader,
+		LogLevels: logLevels,
+		Cache:     cachedAdapter,
+		Logger:    logger,
+		// The control routes take the credentials of the public server, the probes and /metrics stay open
+		Authentication: authenticate,
+		Authorizer:     authorizer,
+		ReadinessChecks: []server.ReadinessCheck{
+			{Name: "shutdown", Check: lc.Ready},
+			{Name: "mongodb", Check: func(ctx context.Context) (interface{}, error) { return nil, mongoClients.Ping(ctx) }},
+			{Name: "persistence", Check: resilientAdapter.Ready},
+		},
+	})
+	// No write timeout, CPU profiles and traces last as long as asked
+	lc.Server("admin", &http.Server{Addr: cfg.Admin.Addr, ReadTimeout: cfg.HTTP.ReadTimeout, Handler: adminHandler}, cfg.HTTP.ShutdownTimeout)
+	srv := http.Server{
+		Addr: fmt.Sprintf(":%s", cfg.Port),
+		ReadTimeout:  cfg.HTTP.ReadTimeout,
+		// The handlers must be able to answer before the connection is closed
+		WriteTimeout: limits.MaxTimeout() + 5*time.Second,
+		Handler: handler,
+	}
+	if err := configureHTTP2(&srv, cfg); err != nil {
+		return err
+	}
+	lc.Server("http", &srv, cfg.HTTP.ShutdownTimeout)
+
+	if err := lc.Start(ctx); err != nil {
+		return err
+	}
+	// The process exits with an error when the subsystems did not stop before the deadline
+	if err := lc.Wait(ctx, syscall.SIGINT, syscall.SIGTERM); err != nil {
+		return err
+	}
+    logger.Info("server gracefully shutdown")
+	return nil
+}
+
+// envFiles are read in order, a var of .env.local replaces the same var of .env
+var envFiles = []string{".env", ".env.local"}
+
+// configCheckInterval is how often the configuration files are checked for changes
+const configCheckInterval = 5 * time.Second
+
+// publicPaths are served without authentication nor tenant, the operational routes are on the admin listener
+var publicPaths = []string{"/"}
+
+// authMiddleware authenticates requests with API keys, JWTs or client certificates, the admin key can be set to bootstrap the first admin key
+func authMiddleware(apiKeys *auth.APIKeyManager, cfg config.AuthConfig, tlsCfg config.TLSConfig) func(http.Handler) http.Handler {
+	authenticators := []auth.Authenticator{}
+	if adminKey := cfg.AdminAPIKey; adminKey != "" {
+		authenticators = append(authenticators, auth.NewStaticKey(adminKey, auth.Identity{Subject: "bootstrap-admin", Roles: []string{"admin"}}))
+	}
+	authenticators = append(authenticators, apiKeys)
+
+	// Bearer tokens are accepted when a HS256 secret and/or a JWKS file are configured
+	jwtAuth := &auth.JWTAuthenticator{
+		Secret:   []byte(cfg.JWTSecret),
+		Issuer:   cfg.JWTIssuer,
+		Audience: cfg.JWTAudience,
+		Leeway:   30 * time.Second,
+	}
+	if path := cfg.JWKSFile; path != "" {
+		jwks, err := auth.LoadJWKSFile(path)
+		if err != nil {
+			panic(err)
+		}
+		jwtAuth.JWKS = jwks
+	}
+	if len(jwtAuth.Secret) > 0 || jwtAuth.JWKS != nil {
+		authenticators = append(authenticators, jwtAuth)
+	}
+	// Last so the keys and tokens sent by a client with a certificate are used
+	if tlsCfg.ClientCAFile != "" {
+		authenticators = append(authenticators, auth.ClientCertAuthenticator{Roles: tlsCfg.ClientCertRoles()})
+	}
+	return auth.Middleware(publicPaths, authenticators...)
+}
+
+// authPolicy loads the role policy from its file, the default roles ( reader, writer, admin ) are used if it is not set
+func authPolicy(cfg config.AuthConfig) *auth.Policy {
+	path := cfg.PolicyFile
+	if path == "" {
+		return auth.DefaultPolicy()
+	}
+	policy, err := auth.LoadPolicyFile(path)
+	if err != nil {
+		panic(err)
+	}
+	return policy
+}
+
+// configureHTTP2 serves srv with TLS and HTTP/2 when a certificate is configured, the certificate is reloaded when its files change
+// Without TLS HTTP/2 is only served in cleartext ( h2c ) when enabled
+func configureHTTP2(srv *http.Server, cfg *config.Config) error {
+	h2 := &http2.Server{}
+	if !cfg.TLS.Enabled() {
+		if cfg.TLS.H2C {
+			srv.Handler = h2c.NewHandler(srv.Handler, h2)
+		}
+		return nil
+	}
+	pair, err := certs.LoadKeyPair(cfg.TLS.CertFile, cfg.TLS.KeyFile)
+	if err != nil {
+		return err
+	}
+	srv.TLSConfig, err = certs.NewConfig(pair, cfg.TLSOptions())
+	if err != nil {
+		return err
+	}
+	// The cipher suites required by HTTP/2 are checked by config.Validate
+	return http2.ConfigureServer(srv, h2)
+}
+
+// requestLimits returns the deadline and the body limit of the routes
+func requestLimits(cfg config.HTTPConfig) server.Limits {
+	return server.Limits{
+		Timeout:           cfg.RequestTimeout,
+		RouteTimeouts:     cfg.RouteTimeouts,
+		MaxBodyBytes:      cfg.MaxBodyBytes,
+		RouteMaxBodyBytes: cfg.RouteMaxBodyBytes,
+	}
+}
+
+// newRateLimitStore keeps the buckets in memory or in mongo, the mongo store shares them between replicas
+func newRateLimitStore(ctx context.Context, clients *persistence.MongoClientHolder, opts persistence.MongoOptions, cfg config.RateLimitConfig) persistence.RateLimitStore {
+	switch cfg.Store {
+	case "", "memory":
+		return ratelimit.NewMemoryStore()
+	case "mongo":
+		store := persistence.NewMongoRateLimitStore(clients, opts)
+		if err := store.EnsureIndexes(ctx); err != nil {
+			panic(err)
+		}
+		return store
+	default:
+		panic(fmt.Errorf("unknown rate limit store: %s", cfg.Store))
+	}
+}
+
+// newRateLimiter limits the clients with the rules of the rate limit file
+func newRateLimiter(store persistence.RateLimitStore, cfg config.RateLimitConfig) *ratelimit.Limiter {
+	rules, err := rateLimitRules(cfg)
 	if err != nil {
 		panic(err)
 	}
-	// Safe check if the crucial env vars are set, this is hard coded on purpose here, could be set on a CI/CD pipeline to make sure no service goes up with missing Envs
-	err = utils.CheckIfNeededVarsAreSet([]string{"MONGO_STRING", "PORT"}, true)
+	limiter, err := ratelimit.NewLimiter(store, rules)
 	if err != nil {
 		panic(err)
 	}
+	limiter.TrustForwardedFor = cfg.TrustForwardedFor
+	return limiter
+}
 
-	// Start by connecting to DB Clients
-	mongoClient, err := persistence.CreateMongoConnection(ctx, os.Getenv("MONGO_STRING"))
+// newAddressLimiter limits each client address before the authentication, nil when the address limit is disabled
+func newAddressLimiter(store persistence.RateLimitStore, cfg config.RateLimitConfig) *ratelimit.Limiter {
+	if cfg.AddressLimit == 0 {
+		return nil
+	}
+	limiter, err := ratelimit.NewLimiter(store, []ratelimit.Rule{
+		{Route: ratelimit.AnyRoute, Key: ratelimit.KeyIP, Limit: cfg.AddressLimit, Period: ratelimit.Duration(cfg.AddressPeriod)},
+	})
 	if err != nil {
 		panic(err)
 	}
+	limiter.TrustForwardedFor = cfg.TrustForwardedFor
+	limiter.Prefix = "address|"
+	return limiter
+}
 
-	// Start Adapters
-	mongoAdapter := persistence.NewMongoAdapter(mongoClient)
+// rateLimitRules reads the rules of the rate limit file, there are none without a file
+func rateLimitRules(cfg config.RateLimitConfig) ([]ratelimit.Rule, error) {
+	if cfg.File == "" {
+		return []ratelimit.Rule{}, nil
+	}
+	rules, err := ratelimit.LoadRulesFile(cfg.File)
+	if err != nil {
+		return nil, err
+	}
+	for _, rule := range rules {
+		if err := rule.Validate(); err != nil {
+			return nil, fmt.Errorf("invalid rate limit file %s: %v", cfg.File, err)
+		}
+	}
+	return rules, nil
+}
 
-	// Start Application
-	service := application.NewService(&mongoAdapter)
+// newResilientAdapter retries the reads and opens a breaker per method with the policies of the resilience file, or the defaults
+func newResilientAdapter(next persistence.PersistenceAdapter, cfg config.ResilienceConfig) (*persistence.ResilientAdapter, error) {
+	opts := persistence.DefaultResilienceOptions
+	if path := cfg.File; path != "" {
+		var err error
+		if opts, err = persistence.LoadResilienceFile(path); err != nil {
+			return nil, err
+		}
+	}
+	return persistence.NewResilientAdapter(next, opts)
+}
 
+// cachePolicies adds the configured Cache-Control policies to the defaults, "*" replaces the default policy
+func cachePolicies(cfg config.HTTPConfig) server.CachePolicies {
+	policies := server.CachePolicies{}
+	for route, policy := range server.DefaultCachePolicies {
+		policies[route] = policy
+	}
+	for route, policy := range cfg.CacheControl {
+		policies[route] = policy
+	}
+	return policies
+}
 
-	// Start server
-	reqShutdown  := make(chan bool)
-	handler := server.NewServer(service,reqShutdown, nil)
-	srv := http.Server{
-		Addr: fmt.Sprintf(":%s", os.Getenv("PORT")),
-		ReadTimeout:  30 * time.Second,
-		WriteTimeout: 30 * time.Second,
-		Handler: handler,
+// refreshMongoCredentials reads the mongo uri from its secret reference at every interval and reconnects when it rotated
+func refreshMongoCredentials(ctx context.Context, cfg *config.Config, clients *persistence.MongoClientHolder) {
+	ticker := time.NewTicker(cfg.Secrets.RefreshInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		uri, err := cfg.ResolveSecret(ctx, "mongo.uri")
+		if err != nil {
+			slog.Error("could not refresh the mongo credentials", "error", err)
+			continue
+		}
+		reconnected, err := clients.Reconnect(ctx, uri)
+		if err != nil {
+			slog.Error("could not reconnect with the rotated mongo credentials", "error", err)
+			continue
+		}
+		if reconnected {
+			slog.Info("reconnected to mongo with rotated credentials")
+		}
 	}
-	
-	done  := make(chan bool)
-	go func() {
-		log.Printf("Starting Server at %v", srv.Addr)
-		if err := srv.ListenAndServe(); err != http.ErrServerClosed {
-			// Error starting or closing listener:
-			log.Printf("HTTP server ListenAndServe: %v", err)
+}
+
+// onReload applies the reloadable settings of every new configuration, each hook checks its part before anything is applied
+func onReload(reloader *config.Reloader, levels *logging.Levels, limiter *ratelimit.Limiter, corsPolicy *cors.Policy, flags *features.Flags, cache *persistence.CachedAdapter) {
+	reloader.OnReload(func(next *config.Config) (func(), error) {
+		nextLevels, err := next.LogLevels()
+		if err != nil {
+			return nil, err
+		}
+		return func() { levels.Replace(nextLevels) }, nil
+	})
+	// Rules set through /admin/ratelimits are replaced by the ones of the file
+	reloader.OnReload(func(next *config.Config) (func(), error) {
+		rules, err := rateLimitRules(next.RateLimit)
+		if err != nil {
+			return nil, err
+		}
+		return func() { limiter.SetRules(rules) }, nil
+	})
+	reloader.OnReload(func(next *config.Config) (func(), error) {
+		return func() {
+			corsPolicy.Set(next.CORSOptions())
+			flags.Set(next.Features)
+		}, nil
+	})
+	if cache != nil {
+		reloader.OnReload(func(next *config.Config) (func(), error) {
+			return func() { cache.SetOptions(*next.CacheOptions()) }, nil
+		})
+	}
+}
+
+// reloadOnHangup reloads the configuration on every SIGHUP
+func reloadOnHangup(ctx context.Context, reloader *config.Reloader) {
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	defer signal.Stop(hangup)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hangup:
+			// Rejected configurations are logged by the reloader
+			_ = reloader.Reload(ctx)
+		}
+	}
+}
+
+// shutdownOnRequest starts the shutdown when /shutdown is called, later calls are accepted and ignored
+func shutdownOnRequest(ctx context.Context, lc *lifecycle.Manager, reqShutdown chan bool) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-reqShutdown:
+			slog.InfoContext(ctx, "shutdown request", "signal", "/shutdown")
+			lc.Shutdown()
+		}
+	}
+}
+
+// newLogger creates the JSON logger writing to w with the configured levels, the levels can be changed while it runs
+func newLogger(w io.Writer, logLevels func() (*logging.Levels, error)) (*slog.Logger, *logging.Levels) {
+	levels, err := logLevels()
+	if err != nil {
+		panic(err)
+	}
+	return logging.New(w, levels), levels
+}
+
+// newTracer creates the tracer for the configured exporter ( "otlp" or "file" ), nil disables tracing
+func newTracer(cfg *config.Config) *tracing.Tracer {
+	switch cfg.Tracing.Exporter {
+	case "":
+		return nil
+	case "otlp":
+		return tracing.NewTracer(cfg.ServiceName, tracing.NewOTLPExporter(cfg.Tracing.OTLPEndpoint))
+	case "file":
+		exporter, err := tracing.NewFileExporter(cfg.Tracing.File)
+		if err != nil {
+			panic(err)
 		}
-        if err := srv.Shutdown(ctx); err != nil {
-            // Error from closing listeners, or context timeout:
-            log.Printf("HTTP server Shutdown: %v", err)
-        }
-        done <- true
-    }()
-	WaitShutdown(ctx, &srv, reqShutdown)
-
-    <-done
-    fmt.Println("Server gracefully shutdown.")
-
-}
-
-func WaitShutdown(ctx context.Context, s *http.Server, reqShutdown chan bool) {
-    irqSig := make(chan os.Signal, 1)
-    signal.Notify(irqSig, syscall.SIGINT, syscall.SIGTERM)
-
-    //Wait interrupt or shutdown request through /shutdown
-    select {
-    case sig := <-irqSig:
-        log.Printf("Shutdown request (signal: %v)", sig)
-    case sig := <-reqShutdown:
-        log.Printf("Shutdown request (/shutdown %v)", sig)
-		// After receiving a shutdown request it should not try again
-		close(reqShutdown)
-    }
-
-    log.Printf("Stoping http server ...")
-
-    //Create shutdown context with 10 second timeout
-    ctxWithTimeOut, cancel := context.WithTimeout(ctx, 10*time.Second)
-    defer cancel()
-
-    //shutdown the server
-    err := s.Shutdown(ctxWithTimeOut)
-    if err != nil {
-        log.Printf("Shutdown request error: %v", err)
-    }
-}
\ No newline at end of file
+		return tracing.NewTracer(cfg.ServiceName, exporter)
+	}
+	panic(fmt.Errorf("unknown tracing exporter: %s", cfg.Tracing.Exporter))
+}
+
+// tenantMiddleware resolves the tenant of each request from the caller identity, the tenant header or the subdomain of the base domain
+// With authentication ( authz not nil ) the header and the subdomain can't move a caller out of its tenant
+func tenantMiddleware(cfg config.TenancyConfig, authz auth.Authorizer) func(http.Handler) http.Handler {
+	requested := []tenancy.Source{tenancy.HeaderSource(cfg.Header)}
+	if baseDomain := cfg.BaseDomain; baseDomain != "" {
+		requested = append(requested, tenancy.SubdomainSource(baseDomain))
+	}
+	middleware := tenancy.Middleware(cfg.Required, append([]tenancy.Source{auth.TenantSource()}, requested...)...)
+	if authz != nil {
+		pin := auth.PinTenant(authz, requested...)
+		resolve := middleware
+		middleware = func(next http.Handler) http.Handler { return pin(resolve(next)) }
+	}
+	public := map[string]bool{}
+	for _, p := range publicPaths {
+		public[p] = true
+	}
+	return func(next http.Handler) http.Handler {
+		withTenant := middleware(next)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if public[r.URL.Path] {
+				next.ServeHTTP(w, r)
+				return
+			}
+			withTenant.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,43 @@
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Martin-Jast/go-microservice/utils"
+)
+
+// HTTPMetrics measures the requests handled by a mux router, labeled by route template so ids don't create new series
+type HTTPMetrics struct {
+	requests *CounterVec
+	duration *HistogramVec
+	inFlight *GaugeVec
+}
+
+// NewHTTPMetrics creates the HTTP metrics and registers them in reg
+func NewHTTPMetrics(reg *Registry) *HTTPMetrics {
+	m := &HTTPMetrics{
+		requests: NewCounterVec("http_requests_total", "Number of HTTP requests handled.", "method", "route", "code"),
+		duration: NewHistogramVec("http_request_duration_seconds", "Latency of the HTTP requests.", nil, "method", "route"),
+		inFlight: NewGaugeVec("http_requests_in_flight", "Number of HTTP requests being handled.", "route"),
+	}
+	reg.Register(m.requests, m.duration, m.inFlight)
+	return m
+}
+
+// Middleware measures every request, it must be used as a mux middleware so the route is known
+func (m *HTTPMetrics) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := utils.RouteTemplate(r)
+		inFlight := m.inFlight.With(route)
+		inFlight.Inc()
+		defer inFlight.Dec()
+
+		start := time.Now()
+		rec := utils.NewResponseRecorder(w)
+		next.ServeHTTP(rec, r)
+		m.duration.With(r.Method, route).Observe(time.Since(start).Seconds())
+		m.requests.With(r.Method, route, strconv.Itoa(rec.Status)).Inc()
+	})
+}
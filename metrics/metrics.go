@@ -0,0 +1,300 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultBuckets are the histogram buckets ( in seconds ) used for latencies
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Collector writes its metric families in the Prometheus text exposition format
+type Collector interface {
+	Collect(w io.Writer)
+}
+
+// Registry holds the collectors exposed by Handler
+type Registry struct {
+	mu         sync.Mutex
+	collectors []Collector
+}
+
+// NewRegistry creates an empty registry, NewRegistryWithRuntime includes the Go runtime stats
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// NewRegistryWithRuntime creates a registry already holding the Go runtime collector
+func NewRegistryWithRuntime() *Registry {
+	reg := NewRegistry()
+	reg.Register(RuntimeCollector{})
+	return reg
+}
+
+// Register adds collectors to the registry
+func (r *Registry) Register(collectors ...Collector) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.collectors = append(r.collectors, collectors...)
+}
+
+// Write writes every collector to w
+func (r *Registry) Write(w io.Writer) {
+	r.mu.Lock()
+	collectors := append([]Collector{}, r.collectors...)
+	r.mu.Unlock()
+	buf := bufio.NewWriter(w)
+	for _, c := range collectors {
+		c.Collect(buf)
+	}
+	buf.Flush()
+}
+
+// Handler serves the metrics of the registry
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		r.Write(w)
+	})
+}
+
+// vec keeps one series per combination of label values
+type vec[T any] struct {
+	name   string
+	help   string
+	kind   string
+	labels []string
+	create func() T
+
+	mu     sync.Mutex
+	series map[string]T
+	values map[string][]string
+}
+
+func newVec[T any](name, help, kind string, labels []string, create func() T) *vec[T] {
+	return &vec[T]{name: name, help: help, kind: kind, labels: labels, create: create, series: map[string]T{}, values: map[string][]string{}}
+}
+
+// with returns the series of the label values, creating it if needed
+func (v *vec[T]) with(labelValues ...string) T {
+	if len(labelValues) != len(v.labels) {
+		panic(fmt.Sprintf("metric %s: expected %d label values, got %d", v.name, len(v.labels), len(labelValues)))
+	}
+	key := strings.Join(labelValues, "\xff")
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	s, ok := v.series[key]
+	if !ok {
+		s = v.create()
+		v.series[key] = s
+		v.values[key] = append([]string{}, labelValues...)
+	}
+	return s
+}
+
+// each calls fn for every series sorted by label values
+func (v *vec[T]) each(fn func(labels string, s T)) {
+	v.mu.Lock()
+	keys := make([]string, 0, len(v.series))
+	for k := range v.series {
+		keys = append(keys, k)
+	}
+	v.mu.Unlock()
+	sort.Strings(keys)
+	for _, k := range keys {
+		v.mu.Lock()
+		s, values := v.series[k], v.values[k]
+		v.mu.Unlock()
+		fn(formatLabels(v.labels, values), s)
+	}
+}
+
+func (v *vec[T]) header(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", v.name, escapeHelp(v.help), v.name, v.kind)
+}
+
+// value is a float guarded by a mutex, simple and fast enough for request level metrics
+type value struct {
+	mu sync.Mutex
+	v  float64
+}
+
+func (v *value) add(delta float64) {
+	v.mu.Lock()
+	v.v += delta
+	v.mu.Unlock()
+}
+
+func (v *value) set(n float64) {
+	v.mu.Lock()
+	v.v = n
+	v.mu.Unlock()
+}
+
+func (v *value) get() float64 {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.v
+}
+
+// Counter is a value that only goes up
+type Counter struct{ value }
+
+// Inc adds one to the counter
+func (c *Counter) Inc() { c.add(1) }
+
+// Add adds delta ( must not be negative ) to the counter
+func (c *Counter) Add(delta float64) {
+	if delta < 0 {
+		panic("counter cannot decrease")
+	}
+	c.add(delta)
+}
+
+// CounterVec is a set of counters partitioned by labels
+type CounterVec struct{ *vec[*Counter] }
+
+// NewCounterVec creates a CounterVec, it still has to be registered
+func NewCounterVec(name, help string, labels ...string) *CounterVec {
+	return &CounterVec{newVec(name, help, "counter", labels, func() *Counter { return &Counter{} })}
+}
+
+// With returns the counter of the label values
+func (c *CounterVec) With(labelValues ...string) *Counter { return c.with(labelValues...) }
+
+// Collect implements Collector
+func (c *CounterVec) Collect(w io.Writer) {
+	c.header(w)
+	c.each(func(labels string, s *Counter) {
+		fmt.Fprintf(w, "%s%s %s\n", c.name, labels, formatFloat(s.get()))
+	})
+}
+
+// Gauge is a value that can go up and down
+type Gauge struct{ value }
+
+// Inc adds one to the gauge
+func (g *Gauge) Inc() { g.add(1) }
+
+// Dec removes one from the gauge
+func (g *Gauge) Dec() { g.add(-1) }
+
+// Set sets the gauge to n
+func (g *Gauge) Set(n float64) { g.set(n) }
+
+// GaugeVec is a set of gauges partitioned by labels
+type GaugeVec struct{ *vec[*Gauge] }
+
+// NewGaugeVec creates a GaugeVec, it still has to be registered
+func NewGaugeVec(name, help string, labels ...string) *GaugeVec {
+	return &GaugeVec{newVec(name, help, "gauge", labels, func() *Gauge { return &Gauge{} })}
+}
+
+// With returns the gauge of the label values
+func (g *GaugeVec) With(labelValues ...string) *Gauge { return g.with(labelValues...) }
+
+// Collect implements Collector
+func (g *GaugeVec) Collect(w io.Writer) {
+	g.header(w)
+	g.each(func(labels string, s *Gauge) {
+		fmt.Fprintf(w, "%s%s %s\n", g.name, labels, formatFloat(s.get()))
+	})
+}
+
+// Histogram counts observations in cumulative buckets
+type Histogram struct {
+	mu      sync.Mutex
+	buckets []float64
+	counts  []uint64
+	count   uint64
+	sum     float64
+}
+
+// Observe adds an observation to the histogram
+func (h *Histogram) Observe(v float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for i, upper := range h.buckets {
+		if v <= upper {
+			h.counts[i]++
+		}
+	}
+	h.count++
+	h.sum += v
+}
+
+// HistogramVec is a set of histograms partitioned by labels
+type HistogramVec struct{ *vec[*Histogram] }
+
+// NewHistogramVec creates a HistogramVec with the given buckets ( DefaultBuckets if nil ), it still has to be registered
+func NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	if buckets == nil {
+		buckets = DefaultBuckets
+	}
+	buckets = append([]float64{}, buckets...)
+	sort.Float64s(buckets)
+	return &HistogramVec{newVec(name, help, "histogram", labels, func() *Histogram {
+		return &Histogram{buckets: buckets, counts: make([]uint64, len(buckets))}
+	})}
+}
+
+// With returns the histogram of the label values
+func (h *HistogramVec) With(labelValues ...string) *Histogram { return h.with(labelValues...) }
+
+// Collect implements Collector
+func (h *HistogramVec) Collect(w io.Writer) {
+	h.header(w)
+	h.each(func(labels string, s *Histogram) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		// The le label is added to the labels of the series
+		withLE := func(le string) string {
+			if labels == "" {
+				return fmt.Sprintf(`{le="%s"}`, le)
+			}
+			return fmt.Sprintf(`%s,le="%s"}`, labels[:len(labels)-1], le)
+		}
+		for i, upper := range s.buckets {
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, withLE(formatFloat(upper)), s.counts[i])
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, withLE("+Inf"), s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, labels, formatFloat(s.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, labels, s.count)
+	})
+}
+
+func formatLabels(names, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+	pairs := make([]string, len(names))
+	for i := range names {
+		pairs[i] = fmt.Sprintf(`%s="%s"`, names[i], escapeLabel(values[i]))
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabel(s string) string { return labelEscaper.Replace(s) }
+
+var helpEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+
+func escapeHelp(s string) string { return helpEscaper.Replace(s) }
+
+func formatFloat(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "+Inf"
+	case math.IsInf(f, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
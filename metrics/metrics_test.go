@@ -0,0 +1,69 @@
+package metrics
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExposition(t *testing.T) {
+	reg := NewRegistry()
+	counter := NewCounterVec("jobs_total", "Jobs done.", "queue")
+	histogram := NewHistogramVec("job_duration_seconds", "Job latency.", []float64{1, 0.1}, "queue")
+	gauge := NewGaugeVec("workers", "Busy workers.")
+	reg.Register(counter, histogram, gauge)
+
+	counter.With(`a"b`).Add(2)
+	counter.With("default").Inc()
+	histogram.With("default").Observe(0.05)
+	histogram.With("default").Observe(0.5)
+	histogram.With("default").Observe(3)
+	gauge.With().Inc()
+	gauge.With().Inc()
+	gauge.With().Dec()
+
+	out := bytes.Buffer{}
+	reg.Write(&out)
+	assert.Equal(t, `# HELP jobs_total Jobs done.
+# TYPE jobs_total counter
+jobs_total{queue="a\"b"} 2
+jobs_total{queue="default"} 1
+# HELP job_duration_seconds Job latency.
+# TYPE job_duration_seconds histogram
+job_duration_seconds_bucket{queue="default",le="0.1"} 1
+job_duration_seconds_bucket{queue="default",le="1"} 2
+job_duration_seconds_bucket{queue="default",le="+Inf"} 3
+job_duration_seconds_sum{queue="default"} 3.55
+job_duration_seconds_count{queue="default"} 3
+# HELP workers Busy workers.
+# TYPE workers gauge
+workers 1
+`, out.String())
+}
+
+func TestHTTPMetrics(t *testing.T) {
+	reg := NewRegistryWithRuntime()
+	router := mux.NewRouter()
+	router.Use(NewHTTPMetrics(reg).Middleware)
+	sub := router.PathPrefix("/base").Subrouter()
+	sub.HandleFunc("/{id}", func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusNotFound) })
+	router.Handle("/metrics", reg.Handler())
+
+	for _, path := range []string{"/base/1", "/base/2", "/base/3"} {
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	body := rec.Body.String()
+
+	assert.Contains(t, body, `http_requests_total{method="GET",route="/base/{id}",code="404"} 3`)
+	assert.Contains(t, body, `http_request_duration_seconds_count{method="GET",route="/base/{id}"} 3`)
+	assert.Contains(t, body, `http_requests_in_flight{route="/metrics"} 1`)
+	assert.Contains(t, body, "go_goroutines ")
+	assert.False(t, strings.Contains(body, "/base/1"), "raw paths must not be used as labels")
+}
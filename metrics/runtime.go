@@ -0,0 +1,44 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"runtime"
+)
+
+// RuntimeCollector exposes the Go runtime stats using the same names as the official client
+type RuntimeCollector struct{}
+
+// Collect implements Collector
+func (RuntimeCollector) Collect(w io.Writer) {
+	stats := runtime.MemStats{}
+	runtime.ReadMemStats(&stats)
+
+	gauge := func(name, help string, v float64) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n%s %s\n", name, help, name, name, formatFloat(v))
+	}
+	counter := func(name, help string, v float64) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n%s %s\n", name, help, name, name, formatFloat(v))
+	}
+	fmt.Fprintf(w, "# HELP go_info Information about the Go environment.\n# TYPE go_info gauge\ngo_info{version=\"%s\"} 1\n", escapeLabel(runtime.Version()))
+	gauge("go_goroutines", "Number of goroutines that currently exist.", float64(runtime.NumGoroutine()))
+	gauge("go_threads", "Number of OS threads created.", float64(threads()))
+	gauge("go_memstats_alloc_bytes", "Number of bytes allocated and still in use.", float64(stats.Alloc))
+	counter("go_memstats_alloc_bytes_total", "Total number of bytes allocated, even if freed.", float64(stats.TotalAlloc))
+	gauge("go_memstats_sys_bytes", "Number of bytes obtained from system.", float64(stats.Sys))
+	counter("go_memstats_mallocs_total", "Total number of mallocs.", float64(stats.Mallocs))
+	counter("go_memstats_frees_total", "Total number of frees.", float64(stats.Frees))
+	gauge("go_memstats_heap_alloc_bytes", "Number of heap bytes allocated and still in use.", float64(stats.HeapAlloc))
+	gauge("go_memstats_heap_inuse_bytes", "Number of heap bytes that are in use.", float64(stats.HeapInuse))
+	gauge("go_memstats_heap_objects", "Number of allocated objects.", float64(stats.HeapObjects))
+	gauge("go_memstats_stack_inuse_bytes", "Number of bytes in use by the stack allocator.", float64(stats.StackInuse))
+	gauge("go_memstats_next_gc_bytes", "Number of heap bytes when next garbage collection will take place.", float64(stats.NextGC))
+	gauge("go_memstats_last_gc_time_seconds", "Number of seconds since 1970 of last garbage collection.", float64(stats.LastGC)/1e9)
+	counter("go_gc_cycles_total", "Number of completed GC cycles.", float64(stats.NumGC))
+	counter("go_gc_pause_seconds_total", "Total time spent in GC stop-the-world pauses.", float64(stats.PauseTotalNs)/1e9)
+}
+
+func threads() int {
+	n, _ := runtime.ThreadCreateProfile(nil)
+	return n
+}
@@ -0,0 +1,128 @@
+package persistence
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/Martin-Jast/go-microservice/tenancy"
+	"github.com/Martin-Jast/go-microservice/utils"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// APIKey is the stored form of an API key, only the hash of the key is kept
+type APIKey struct {
+	ID        *string    `bson:"_id"`
+	TenantID  string     `bson:"tenant_id"`
+	Name      string     `bson:"name"`
+	Prefix    string     `bson:"prefix"`
+	Hash      string     `bson:"hash"`
+	Roles     []string   `bson:"roles"`
+	CreatedAt *time.Time `bson:"created_at"`
+	ExpiresAt *time.Time `bson:"expires_at,omitempty"`
+	RevokedAt *time.Time `bson:"revoked_at,omitempty"`
+}
+
+// APIKeyStore defines how API keys are persisted
+// GetAPIKeyByHash is the only method not scoped to the tenant in ctx since it is used before the tenant is known
+type APIKeyStore interface {
+	CreateAPIKey(ctx context.Context, key APIKey) (id string, err error)
+	GetAPIKeyByHash(ctx context.Context, hash string) (*APIKey, error)
+	ListAPIKeys(ctx context.Context) ([]APIKey, error)
+	RevokeAPIKey(ctx context.Context, id string, at time.Time) error
+}
+
+// ErrAPIKeyNotFound is returned when no key matches the lookup
+var ErrAPIKeyNotFound = fmt.Errorf("api key not found")
+
+// MongoAPIKeyStore stores API keys in the api_keys collection of the configured database
+type MongoAPIKeyStore struct {
+	clients  *MongoClientHolder
+	database string
+}
+
+// NewMongoAPIKeyStore creates a MongoAPIKeyStore in the database defined by opts
+func NewMongoAPIKeyStore(clients *MongoClientHolder, opts MongoOptions) MongoAPIKeyStore {
+	return MongoAPIKeyStore{
+		clients:  clients,
+		database: opts.Database,
+	}
+}
+
+// collection is read from the current client, which changes when the credentials rotate
+func (m MongoAPIKeyStore) collection() *mongo.Collection {
+	return m.clients.Client().Database(m.database).Collection("api_keys")
+}
+
+// mongoAPIKey small extension of APIKey to accomodate mongoID
+type mongoAPIKey struct {
+	ID      *primitive.ObjectID `bson:"_id"`
+	*APIKey `bson:"inline"`
+}
+
+func (m MongoAPIKeyStore) CreateAPIKey(ctx context.Context, key APIKey) (id string, err error) {
+	objID := primitive.NewObjectID()
+	if key.CreatedAt == nil {
+		temp := time.Now()
+		key.CreatedAt = &temp
+	}
+	_, err = m.collection().InsertOne(ctx, mongoAPIKey{ID: &objID, APIKey: &key})
+	if err != nil {
+		return "", err
+	}
+	return objID.Hex(), nil
+}
+
+func (m MongoAPIKeyStore) GetAPIKeyByHash(ctx context.Context, hash string) (*APIKey, error) {
+	res := mongoAPIKey{}
+	err := m.collection().FindOne(ctx, bson.M{"hash": hash}).Decode(&res)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrAPIKeyNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	res.APIKey.ID = utils.StrPnt(res.ID.Hex())
+	return res.APIKey, nil
+}
+
+// ListAPIKeys lists the keys of the tenant in ctx
+func (m MongoAPIKeyStore) ListAPIKeys(ctx context.Context) ([]APIKey, error) {
+	result, err := m.collection().Find(ctx, bson.M{"tenant_id": tenancy.TenantOrDefault(ctx)})
+	if err != nil {
+		return nil, err
+	}
+	defer result.Close(ctx)
+
+	list := []APIKey{}
+	for result.Next(ctx) {
+		elem := mongoAPIKey{}
+		if err := result.Decode(&elem); err != nil {
+			return nil, err
+		}
+		elem.APIKey.ID = utils.StrPnt(elem.ID.Hex())
+		list = append(list, *elem.APIKey)
+	}
+	return list, result.Err()
+}
+
+// RevokeAPIKey marks a key of the tenant in ctx as revoked, the key is kept so it still shows up when listing
+func (m MongoAPIKeyStore) RevokeAPIKey(ctx context.Context, id string, at time.Time) error {
+	asObjID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return fmt.Errorf("invalid objectID to revoke")
+	}
+	res, err := m.collection().UpdateOne(ctx,
+		bson.M{"_id": asObjID, "tenant_id": tenancy.TenantOrDefault(ctx)},
+		bson.M{"$set": bson.M{"revoked_at": at}},
+	)
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return ErrAPIKeyNotFound
+	}
+	return nil
+}
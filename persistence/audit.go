@@ -0,0 +1,136 @@
+package persistence
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/Martin-Jast/go-microservice/tenancy"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// AuditEntry is a link of the audit chain of a tenant, Hash covers every other field including the hash of the previous entry
+type AuditEntry struct {
+	Seq        int64     `bson:"seq"`
+	TenantID   string    `bson:"tenant_id"`
+	Time       time.Time `bson:"time"`
+	Actor      string    `bson:"actor"`
+	Action     string    `bson:"action"`
+	DocumentID string    `bson:"document_id,omitempty"`
+	RequestID  string    `bson:"request_id,omitempty"`
+	// Before and After are JSON snapshots of the document, empty when it did not exist
+	Before   string `bson:"before,omitempty"`
+	After    string `bson:"after,omitempty"`
+	PrevHash string `bson:"prev_hash"`
+	Hash     string `bson:"hash"`
+}
+
+// AuditFilter restricts the entries listed, zero values are not applied
+type AuditFilter struct {
+	Actor      string
+	DocumentID string
+	From       time.Time
+	To         time.Time
+	Limit      int64
+}
+
+// ErrAuditConflict is returned when another entry with the same sequence was appended first
+var ErrAuditConflict = errors.New("audit entry sequence already taken")
+
+// AuditStore is an append-only store of audit entries, there is no way to update or delete entries through it
+// Every method is scoped to the tenant in ctx
+type AuditStore interface {
+	AppendAuditEntry(ctx context.Context, entry AuditEntry) error
+	LastAuditEntry(ctx context.Context) (*AuditEntry, error)
+	ListAuditEntries(ctx context.Context, filter AuditFilter) ([]AuditEntry, error)
+}
+
+// MongoAuditStore stores the entries in the audit collection of the configured database
+type MongoAuditStore struct {
+	clients  *MongoClientHolder
+	database string
+}
+
+// NewMongoAuditStore creates a MongoAuditStore in the database defined by opts
+func NewMongoAuditStore(clients *MongoClientHolder, opts MongoOptions) MongoAuditStore {
+	return MongoAuditStore{
+		clients:  clients,
+		database: opts.Database,
+	}
+}
+
+// collection is read from the current client, which changes when the credentials rotate
+func (m MongoAuditStore) collection() *mongo.Collection {
+	return m.clients.Client().Database(m.database).Collection("audit")
+}
+
+// EnsureIndexes creates the unique index that keeps two entries from taking the same place in a chain
+func (m MongoAuditStore) EnsureIndexes(ctx context.Context) error {
+	_, err := m.collection().Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "tenant_id", Value: 1}, {Key: "seq", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	return err
+}
+
+func (m MongoAuditStore) AppendAuditEntry(ctx context.Context, entry AuditEntry) error {
+	entry.TenantID = tenancy.TenantOrDefault(ctx)
+	_, err := m.collection().InsertOne(ctx, entry)
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrAuditConflict
+	}
+	return err
+}
+
+func (m MongoAuditStore) LastAuditEntry(ctx context.Context) (*AuditEntry, error) {
+	entry := AuditEntry{}
+	err := m.collection().FindOne(ctx,
+		bson.M{"tenant_id": tenancy.TenantOrDefault(ctx)},
+		options.FindOne().SetSort(bson.M{"seq": -1}),
+	).Decode(&entry)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &entry, nil
+}
+
+// ListAuditEntries lists the entries matching filter in chain order
+func (m MongoAuditStore) ListAuditEntries(ctx context.Context, filter AuditFilter) ([]AuditEntry, error) {
+	query := bson.M{"tenant_id": tenancy.TenantOrDefault(ctx)}
+	if filter.Actor != "" {
+		query["actor"] = filter.Actor
+	}
+	if filter.DocumentID != "" {
+		query["document_id"] = filter.DocumentID
+	}
+	timeRange := bson.M{}
+	if !filter.From.IsZero() {
+		timeRange["$gte"] = filter.From
+	}
+	if !filter.To.IsZero() {
+		timeRange["$lt"] = filter.To
+	}
+	if len(timeRange) > 0 {
+		query["time"] = timeRange
+	}
+	opts := options.Find().SetSort(bson.M{"seq": 1})
+	if filter.Limit > 0 {
+		opts.SetLimit(filter.Limit)
+	}
+	result, err := m.collection().Find(ctx, query, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer result.Close(ctx)
+
+	list := []AuditEntry{}
+	if err := result.All(ctx, &list); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
@@ -0,0 +1,275 @@
+package persistence
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/Martin-Jast/go-microservice/metrics"
+	"github.com/Martin-Jast/go-microservice/tenancy"
+)
+
+// CacheOptions sizes the cache of a CachedAdapter
+type CacheOptions struct {
+	// Size is the number of documents kept, the least recently used is evicted first
+	Size int
+	// TTL is how long a document is served from the cache, it bounds how stale the other replicas can be after a delete
+	TTL time.Duration
+	// NegativeTTL is how long a missing document is remembered, 0 disables negative caching
+	NegativeTTL time.Duration
+}
+
+// DefaultCacheOptions keeps 10000 documents for a minute and missing ones for 10 seconds
+var DefaultCacheOptions = CacheOptions{Size: 10000, TTL: time.Minute, NegativeTTL: 10 * time.Second}
+
+// cacheKey identifies a document, the tenant is part of it so tenants never see each other's documents
+type cacheKey struct {
+	tenant string
+	id     string
+}
+
+type cacheEntry struct {
+	key     cacheKey
+	doc     *BaseModel
+	expires time.Time
+}
+
+// cacheCall is a load in progress, the concurrent misses of a key wait for it instead of querying again
+type cacheCall struct {
+	done chan struct{}
+	doc  *BaseModel
+	err  error
+}
+
+// CachedAdapter decorates a PersistenceAdapter keeping the documents read by GetByID in memory
+// Deletes through the adapter invalidate the documents at once, changes made by other replicas are seen once the TTL expires
+type CachedAdapter struct {
+	next PersistenceAdapter
+	opts CacheOptions
+	// Now is the clock of the expirations, replaced in tests
+	Now func() time.Time
+
+	mu      sync.Mutex
+	entries map[cacheKey]*list.Element
+	lru     *list.List
+	calls   map[cacheKey]*cacheCall
+	// generation changes on every write so a load started before it is not cached
+	generation uint64
+
+	requests *metrics.CounterVec
+	size     *metrics.GaugeVec
+}
+
+// NewCachedAdapter wraps next, its hit and miss counters are registered in reg unless it is nil
+func NewCachedAdapter(next PersistenceAdapter, opts CacheOptions, reg *metrics.Registry) *CachedAdapter {
+	a := &CachedAdapter{
+		next:     next,
+		opts:     opts,
+		Now:      time.Now,
+		entries:  map[cacheKey]*list.Element{},
+		lru:      list.New(),
+		calls:    map[cacheKey]*cacheCall{},
+		requests: metrics.NewCounterVec("persistence_cache_requests_total", "Number of documents looked up in the persistence cache by result ( hit, negative_hit, miss ).", "result"),
+		size:     metrics.NewGaugeVec("persistence_cache_entries", "Number of documents in the persistence cache."),
+	}
+	if reg != nil {
+		reg.Register(a.requests, a.size)
+	}
+	return a
+}
+
+// lookup returns the cached answer for key, found is false when it must be loaded
+func (a *CachedAdapter) lookup(key cacheKey) (doc *BaseModel, found bool) {
+	element, ok := a.entries[key]
+	if !ok {
+		return nil, false
+	}
+	entry := element.Value.(*cacheEntry)
+	if !a.Now().Before(entry.expires) {
+		a.remove(element)
+		return nil, false
+	}
+	a.lru.MoveToFront(element)
+	return entry.doc, true
+}
+
+// store caches the answer for key, a nil doc remembers that it does not exist
+func (a *CachedAdapter) store(key cacheKey, doc *BaseModel) {
+	ttl := a.opts.TTL
+	if doc == nil {
+		ttl = a.opts.NegativeTTL
+	}
+	if ttl <= 0 || a.opts.Size <= 0 {
+		return
+	}
+	if element, ok := a.entries[key]; ok {
+		a.remove(element)
+	}
+	a.entries[key] = a.lru.PushFront(&cacheEntry{key: key, doc: doc, expires: a.Now().Add(ttl)})
+	for a.lru.Len() > a.opts.Size {
+		a.remove(a.lru.Back())
+	}
+	a.size.With().Set(float64(a.lru.Len()))
+}
+
+func (a *CachedAdapter) remove(element *list.Element) {
+	delete(a.entries, element.Value.(*cacheEntry).key)
+	a.lru.Remove(element)
+	a.size.With().Set(float64(a.lru.Len()))
+}
+
+// invalidate drops the cached answer for key and the loads in progress started before
+func (a *CachedAdapter) invalidate(key cacheKey) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.generation++
+	if element, ok := a.entries[key]; ok {
+		a.remove(element)
+	}
+}
+
+// SetOptions replaces the options, the cached documents expire no later than the new TTLs allow
+func (a *CachedAdapter) SetOptions(opts CacheOptions) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.opts = opts
+	now := a.Now()
+	for element := a.lru.Front(); element != nil; {
+		next := element.Next()
+		entry := element.Value.(*cacheEntry)
+		ttl := opts.TTL
+		if entry.doc == nil {
+			ttl = opts.NegativeTTL
+		}
+		if ttl <= 0 {
+			a.remove(element)
+		} else if limit := now.Add(ttl); entry.expires.After(limit) {
+			entry.expires = limit
+		}
+		element = next
+	}
+	for a.lru.Len() > opts.Size && a.lru.Len() > 0 {
+		a.remove(a.lru.Back())
+	}
+}
+
+// Flush empties the cache, for every tenant
+func (a *CachedAdapter) Flush() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.generation++
+	a.entries = map[cacheKey]*list.Element{}
+	a.lru.Init()
+	a.size.With().Set(0)
+}
+
+// copyDoc returns a copy of doc so the callers can't change the cached document
+func copyDoc(doc *BaseModel) *BaseModel {
+	if doc == nil {
+		return nil
+	}
+	copied := *doc
+	return &copied
+}
+
+func (a *CachedAdapter) GetByID(ctx context.Context, id string) (*BaseModel, error) {
+	key := cacheKey{tenant: tenancy.TenantOrDefault(ctx), id: id}
+	a.mu.Lock()
+	if doc, found := a.lookup(key); found {
+		a.mu.Unlock()
+		if doc == nil {
+			a.requests.With("negative_hit").Inc()
+			return nil, ErrNotFound
+		}
+		a.requests.With("hit").Inc()
+		return copyDoc(doc), nil
+	}
+	a.requests.With("miss").Inc()
+	call, loading := a.calls[key]
+	if !loading {
+		call = &cacheCall{done: make(chan struct{})}
+		a.calls[key] = call
+		go a.load(ctx, key, call, a.generation)
+	}
+	a.mu.Unlock()
+
+	select {
+	case <-call.done:
+		return copyDoc(call.doc), call.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// load reads key from the wrapped adapter for every caller waiting on call
+// It runs apart from the caller that started it, so a caller giving up does not fail the others
+func (a *CachedAdapter) load(ctx context.Context, key cacheKey, call *cacheCall, generation uint64) {
+	loadCtx := context.WithoutCancel(ctx)
+	if deadline, ok := ctx.Deadline(); ok {
+		var cancel context.CancelFunc
+		loadCtx, cancel = context.WithDeadline(loadCtx, deadline)
+		defer cancel()
+	}
+	call.doc, call.err = a.next.GetByID(loadCtx, key.id)
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	delete(a.calls, key)
+	close(call.done)
+	if a.generation != generation {
+		return
+	}
+	switch {
+	case call.err == nil:
+		a.store(key, copyDoc(call.doc))
+	case errors.Is(call.err, ErrNotFound):
+		a.store(key, nil)
+	}
+}
+
+func (a *CachedAdapter) Create(ctx context.Context, document BaseModel) (string, error) {
+	id, err := a.next.Create(ctx, document)
+	if err == nil {
+		// The id may have been remembered as missing
+		a.invalidate(cacheKey{tenant: tenancy.TenantOrDefault(ctx), id: id})
+	}
+	return id, err
+}
+
+func (a *CachedAdapter) Delete(ctx context.Context, id string) (*BaseModel, error) {
+	deleted, err := a.next.Delete(ctx, id)
+	// Invalidated even on errors, the delete may have happened anyway
+	a.invalidate(cacheKey{tenant: tenancy.TenantOrDefault(ctx), id: id})
+	return deleted, err
+}
+
+func (a *CachedAdapter) DeleteAll(ctx context.Context) error {
+	err := a.next.DeleteAll(ctx)
+	tenant := tenancy.TenantOrDefault(ctx)
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.generation++
+	for key, element := range a.entries {
+		if key.tenant == tenant {
+			a.remove(element)
+		}
+	}
+	return err
+}
+
+func (a *CachedAdapter) GetAllCreatedSince(ctx context.Context, date time.Time) ([]BaseModel, error) {
+	return a.next.GetAllCreatedSince(ctx, date)
+}
+
+func (a *CachedAdapter) GetPage(ctx context.Context, after string, limit int) ([]BaseModel, error) {
+	return a.next.GetPage(ctx, after, limit)
+}
+
+func (a *CachedAdapter) GetHistory(ctx context.Context, id string) ([]Revision, error) {
+	return a.next.GetHistory(ctx, id)
+}
+
+func (a *CachedAdapter) GetByIDAsOf(ctx context.Context, id string, asOf time.Time) (*BaseModel, error) {
+	return a.next.GetByIDAsOf(ctx, id, asOf)
+}
@@ -0,0 +1,177 @@
+package persistence
+
+import (
+	"bytes"
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Martin-Jast/go-microservice/metrics"
+	"github.com/Martin-Jast/go-microservice/tenancy"
+	"github.com/stretchr/testify/assert"
+)
+
+// countingAdapter serves docs by tenant and id and counts the calls to GetByID
+type countingAdapter struct {
+	PersistenceAdapter
+	mu    sync.Mutex
+	docs  map[cacheKey]BaseModel
+	calls int32
+	// release blocks GetByID until closed when set
+	release chan struct{}
+}
+
+func (c *countingAdapter) GetByID(ctx context.Context, id string) (*BaseModel, error) {
+	atomic.AddInt32(&c.calls, 1)
+	if c.release != nil {
+		<-c.release
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	doc, ok := c.docs[cacheKey{tenancy.TenantOrDefault(ctx), id}]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &doc, nil
+}
+
+func (c *countingAdapter) Delete(ctx context.Context, id string) (*BaseModel, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	key := cacheKey{tenancy.TenantOrDefault(ctx), id}
+	doc, ok := c.docs[key]
+	if !ok {
+		return nil, nil
+	}
+	delete(c.docs, key)
+	return &doc, nil
+}
+
+func (c *countingAdapter) Create(ctx context.Context, document BaseModel) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.docs[cacheKey{tenancy.TenantOrDefault(ctx), *document.ID}] = document
+	return *document.ID, nil
+}
+
+func TestCachedAdapter_GetByID(t *testing.T) {
+	id := "1"
+	next := &countingAdapter{docs: map[cacheKey]BaseModel{{tenancy.DefaultTenant, id}: {ID: &id, Data: "cached"}}}
+	reg := metrics.NewRegistry()
+	cache := NewCachedAdapter(next, CacheOptions{Size: 2, TTL: time.Minute, NegativeTTL: time.Second}, reg)
+	now := time.Date(2023, 7, 1, 10, 0, 0, 0, time.UTC)
+	cache.Now = func() time.Time { return now }
+	ctx := context.Background()
+
+	doc, err := cache.GetByID(ctx, id)
+	assert.NoError(t, err)
+	assert.Equal(t, "cached", doc.Data)
+	doc.Data = "changed by the caller"
+	doc, _ = cache.GetByID(ctx, id)
+	assert.Equal(t, "cached", doc.Data)
+	assert.EqualValues(t, 1, next.calls)
+
+	// Tenants have their own entries
+	_, err = cache.GetByID(tenancy.WithTenant(ctx, "other"), id)
+	assert.ErrorIs(t, err, ErrNotFound)
+	_, err = cache.GetByID(tenancy.WithTenant(ctx, "other"), id)
+	assert.ErrorIs(t, err, ErrNotFound)
+	assert.EqualValues(t, 2, next.calls)
+
+	// Missing documents are forgotten after NegativeTTL, documents after TTL
+	now = now.Add(2 * time.Second)
+	cache.GetByID(tenancy.WithTenant(ctx, "other"), id)
+	assert.EqualValues(t, 3, next.calls)
+	cache.GetByID(ctx, id)
+	assert.EqualValues(t, 3, next.calls)
+	now = now.Add(time.Minute)
+	cache.GetByID(ctx, id)
+	assert.EqualValues(t, 4, next.calls)
+
+	// The least recently used is evicted
+	cache.GetByID(ctx, "2")
+	cache.GetByID(ctx, "3")
+	cache.GetByID(ctx, id)
+	assert.EqualValues(t, 7, next.calls)
+
+	out := &bytes.Buffer{}
+	reg.Write(out)
+	assert.Contains(t, out.String(), `persistence_cache_requests_total{result="hit"} 2`)
+	assert.Contains(t, out.String(), `persistence_cache_requests_total{result="negative_hit"} 1`)
+	assert.Contains(t, out.String(), `persistence_cache_requests_total{result="miss"} 7`)
+	assert.Contains(t, out.String(), `persistence_cache_entries 2`)
+}
+
+func TestCachedAdapter_Invalidation(t *testing.T) {
+	id := "1"
+	next := &countingAdapter{docs: map[cacheKey]BaseModel{{tenancy.DefaultTenant, id}: {ID: &id, Data: "cached"}}}
+	cache := NewCachedAdapter(next, DefaultCacheOptions, nil)
+	ctx := context.Background()
+
+	cache.GetByID(ctx, id)
+	deleted, err := cache.Delete(ctx, id)
+	assert.NoError(t, err)
+	assert.Equal(t, id, *deleted.ID)
+	_, err = cache.GetByID(ctx, id)
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	// The missing document is remembered until it is created
+	_, err = cache.Create(ctx, BaseModel{ID: &id, Data: "recreated"})
+	assert.NoError(t, err)
+	doc, err := cache.GetByID(ctx, id)
+	assert.NoError(t, err)
+	assert.Equal(t, "recreated", doc.Data)
+
+	cache.Flush()
+	cache.GetByID(ctx, id)
+	assert.EqualValues(t, 4, next.calls)
+}
+
+func TestCachedAdapter_SetOptions(t *testing.T) {
+	id := "1"
+	next := &countingAdapter{docs: map[cacheKey]BaseModel{{tenancy.DefaultTenant, id}: {ID: &id, Data: "cached"}}}
+	cache := NewCachedAdapter(next, CacheOptions{Size: 10, TTL: time.Hour, NegativeTTL: time.Minute}, nil)
+	now := time.Date(2023, 7, 1, 10, 0, 0, 0, time.UTC)
+	cache.Now = func() time.Time { return now }
+	ctx := context.Background()
+
+	cache.GetByID(ctx, id)
+	cache.GetByID(ctx, "missing")
+	cache.SetOptions(CacheOptions{Size: 10, TTL: time.Minute})
+	cache.GetByID(ctx, "missing")
+	assert.EqualValues(t, 3, next.calls, "negative caching was disabled")
+
+	// The cached document expires with the new TTL
+	now = now.Add(2 * time.Minute)
+	cache.GetByID(ctx, id)
+	assert.EqualValues(t, 4, next.calls)
+}
+
+func TestCachedAdapter_ConcurrentMisses(t *testing.T) {
+	id := "1"
+	next := &countingAdapter{docs: map[cacheKey]BaseModel{{tenancy.DefaultTenant, id}: {ID: &id, Data: "cached"}}, release: make(chan struct{})}
+	cache := NewCachedAdapter(next, DefaultCacheOptions, nil)
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			doc, err := cache.GetByID(context.Background(), id)
+			assert.NoError(t, err)
+			assert.Equal(t, "cached", doc.Data)
+		}()
+	}
+	// A caller giving up does not fail the load of the others
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := cache.GetByID(ctx, id)
+	assert.ErrorIs(t, err, context.Canceled)
+
+	time.Sleep(10 * time.Millisecond)
+	close(next.release)
+	wg.Wait()
+	assert.EqualValues(t, 1, next.calls)
+}
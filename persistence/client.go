@@ -0,0 +1,83 @@
+package persistence
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// DefaultReconnectGrace is how long a replaced client stays open by default
+const DefaultReconnectGrace = 30 * time.Second
+
+// MongoClientHolder keeps the client shared by the adapter and the stores, Reconnect replaces it when the credentials rotate
+// Every operation asks for the client so the new one is used without a restart
+type MongoClientHolder struct {
+	mu     sync.RWMutex
+	client *mongo.Client
+	uri    string
+	// reconnecting serializes the reconnections
+	reconnecting sync.Mutex
+	// Grace is how long the replaced client is kept open for the operations that started with it
+	Grace time.Duration
+}
+
+// NewMongoClientHolder holds a client connected to uri
+func NewMongoClientHolder(client *mongo.Client, uri string) *MongoClientHolder {
+	return &MongoClientHolder{client: client, uri: uri, Grace: DefaultReconnectGrace}
+}
+
+// ConnectMongo connects to uri and holds the client
+func ConnectMongo(ctx context.Context, uri string) (*MongoClientHolder, error) {
+	client, err := CreateMongoConnection(ctx, uri)
+	if err != nil {
+		return nil, err
+	}
+	return NewMongoClientHolder(client, uri), nil
+}
+
+// Client returns the current client
+func (h *MongoClientHolder) Client() *mongo.Client {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.client
+}
+
+// Ping checks that the current client reaches the primary
+func (h *MongoClientHolder) Ping(ctx context.Context) error {
+	return h.Client().Ping(ctx, nil)
+}
+
+// Reconnect replaces the client with one connected to uri, it does nothing if uri did not change
+// The old client is kept until the new one answers a ping, so failed credentials never replace working ones
+func (h *MongoClientHolder) Reconnect(ctx context.Context, uri string) (reconnected bool, err error) {
+	h.reconnecting.Lock()
+	defer h.reconnecting.Unlock()
+	h.mu.RLock()
+	same := uri == h.uri
+	h.mu.RUnlock()
+	if same {
+		return false, nil
+	}
+
+	client, err := CreateMongoConnection(ctx, uri)
+	if err != nil {
+		return false, err
+	}
+	if err := client.Ping(ctx, nil); err != nil {
+		client.Disconnect(context.Background())
+		return false, err
+	}
+	h.mu.Lock()
+	old := h.client
+	h.client, h.uri = client, uri
+	h.mu.Unlock()
+	time.AfterFunc(h.Grace, func() { old.Disconnect(context.Background()) })
+	return true, nil
+}
+
+// Disconnect closes the current client
+func (h *MongoClientHolder) Disconnect(ctx context.Context) error {
+	return h.Client().Disconnect(ctx)
+}
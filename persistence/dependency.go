@@ -2,23 +2,56 @@ package persistence
 
 import (
 	"context"
+	"errors"
 	"time"
 )
 
 // BaseModel a simple generic DB document model to be used on this example
 type BaseModel struct {
 	ID *string `bson:"_id"`
+	TenantID string `bson:"tenant_id"`
 	Data string
+	CreatedBy string `bson:"created_by,omitempty"`
 	CreatedAt *time.Time `bson:"created_at"`
 	DeletedAt *time.Time `bson:"deleted_at,omitempty"`
 }
 
 
+// Revision operations
+const (
+	RevisionCreate = "create"
+	RevisionDelete = "delete"
+)
+
+// Revision is the state of a document from ValidFrom until the next revision
+type Revision struct {
+	DocumentID string    `bson:"document_id"`
+	TenantID   string    `bson:"tenant_id"`
+	Version    int64     `bson:"version"`
+	ValidFrom  time.Time `bson:"valid_from"`
+	Operation  string    `bson:"operation"`
+	Document   BaseModel `bson:"document"`
+}
+
+// ErrNotFound is returned when the document does not exist ( or did not exist at the requested time )
+var ErrNotFound = errors.New("document not found")
+
+// ErrAlreadyExists is returned when a document is created with the id of another one
+var ErrAlreadyExists = errors.New("document already exists")
+
 // PersistenceAdapter defines how the application can communicate with a persistence Layer with no knowledge about how it is built
+// Every operation is scoped to the tenant carried by ctx ( see tenancy.FromContext ), documents of other tenants are never visible
 type PersistenceAdapter interface {
 	Create(ctx context.Context, document BaseModel) (id string, err error)
 	GetByID(ctx context.Context, id string) ( doc *BaseModel, err error)
-	Delete(ctx context.Context, id string) error
+	// Delete deletes a document and returns it as it was read in the same operation, nil if there was none
+	Delete(ctx context.Context, id string) (deleted *BaseModel, err error)
 	GetAllCreatedSince(ctx context.Context, date time.Time) (docs []BaseModel, err error)
+	// GetPage lists up to limit documents ordered by id, starting after the id after ( from the first one if empty )
+	GetPage(ctx context.Context, after string, limit int) (docs []BaseModel, err error)
 	DeleteAll(ctx context.Context) error
+	// GetHistory lists every revision of a document, the revisions are written in the same operation as the change
+	GetHistory(ctx context.Context, id string) (revisions []Revision, err error)
+	// GetByIDAsOf returns the document as it was at asOf
+	GetByIDAsOf(ctx context.Context, id string, asOf time.Time) (doc *BaseModel, err error)
 }
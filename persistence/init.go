@@ -28,6 +28,8 @@ func CreateSQLConnection(ctx context.Context, user, pass, addr, dbName string) (
 		Net: "tcp",
 		Addr: addr,
 		DBName: "test",
+		// The DATETIME columns are scanned into time.Time
+		ParseTime: true,
 	}
 	// Get a database handle.
 	var err error
@@ -0,0 +1,91 @@
+package persistence
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/Martin-Jast/go-microservice/metrics"
+)
+
+// InstrumentedAdapter decorates a PersistenceAdapter measuring the latency and errors of every call
+type InstrumentedAdapter struct {
+	next     PersistenceAdapter
+	duration *metrics.HistogramVec
+	errors   *metrics.CounterVec
+}
+
+// NewInstrumentedAdapter wraps next and registers its metrics in reg
+func NewInstrumentedAdapter(next PersistenceAdapter, reg *metrics.Registry) *InstrumentedAdapter {
+	a := &InstrumentedAdapter{
+		next:     next,
+		duration: metrics.NewHistogramVec("persistence_call_duration_seconds", "Latency of the persistence adapter calls.", nil, "method"),
+		errors:   metrics.NewCounterVec("persistence_call_errors_total", "Number of persistence adapter calls that failed.", "method"),
+	}
+	reg.Register(a.duration, a.errors)
+	return a
+}
+
+// observe records a call started at start, ErrNotFound is an expected answer and not counted as an error
+func (a *InstrumentedAdapter) observe(method string, start time.Time, err error) {
+	a.duration.With(method).Observe(time.Since(start).Seconds())
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		a.errors.With(method).Inc()
+	}
+}
+
+func (a *InstrumentedAdapter) Create(ctx context.Context, document BaseModel) (id string, err error) {
+	start := time.Now()
+	id, err = a.next.Create(ctx, document)
+	a.observe("Create", start, err)
+	return id, err
+}
+
+func (a *InstrumentedAdapter) GetByID(ctx context.Context, id string) (doc *BaseModel, err error) {
+	start := time.Now()
+	doc, err = a.next.GetByID(ctx, id)
+	a.observe("GetByID", start, err)
+	return doc, err
+}
+
+func (a *InstrumentedAdapter) Delete(ctx context.Context, id string) (*BaseModel, error) {
+	start := time.Now()
+	deleted, err := a.next.Delete(ctx, id)
+	a.observe("Delete", start, err)
+	return deleted, err
+}
+
+func (a *InstrumentedAdapter) GetAllCreatedSince(ctx context.Context, date time.Time) (docs []BaseModel, err error) {
+	start := time.Now()
+	docs, err = a.next.GetAllCreatedSince(ctx, date)
+	a.observe("GetAllCreatedSince", start, err)
+	return docs, err
+}
+
+func (a *InstrumentedAdapter) GetPage(ctx context.Context, after string, limit int) (docs []BaseModel, err error) {
+	start := time.Now()
+	docs, err = a.next.GetPage(ctx, after, limit)
+	a.observe("GetPage", start, err)
+	return docs, err
+}
+
+func (a *InstrumentedAdapter) DeleteAll(ctx context.Context) error {
+	start := time.Now()
+	err := a.next.DeleteAll(ctx)
+	a.observe("DeleteAll", start, err)
+	return err
+}
+
+func (a *InstrumentedAdapter) GetHistory(ctx context.Context, id string) (revisions []Revision, err error) {
+	start := time.Now()
+	revisions, err = a.next.GetHistory(ctx, id)
+	a.observe("GetHistory", start, err)
+	return revisions, err
+}
+
+func (a *InstrumentedAdapter) GetByIDAsOf(ctx context.Context, id string, asOf time.Time) (doc *BaseModel, err error) {
+	start := time.Now()
+	doc, err = a.next.GetByIDAsOf(ctx, id, asOf)
+	a.observe("GetByIDAsOf", start, err)
+	return doc, err
+}
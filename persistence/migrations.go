@@ -0,0 +1,256 @@
+package persistence
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/Martin-Jast/go-microservice/tenancy"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Migration changes the schema of the database, Down undoes what Up did
+type Migration struct {
+	Version int
+	Name    string
+	Up      func(ctx context.Context) error
+	Down    func(ctx context.Context) error
+}
+
+// AppliedMigration records that a migration was applied
+type AppliedMigration struct {
+	Version   int       `bson:"_id" json:"version"`
+	Name      string    `bson:"name" json:"name"`
+	AppliedAt time.Time `bson:"applied_at" json:"appliedAt"`
+}
+
+// MigrationStore keeps the migrations applied to the database
+type MigrationStore interface {
+	AppliedMigrations(ctx context.Context) ([]AppliedMigration, error)
+	RecordMigration(ctx context.Context, applied AppliedMigration) error
+	RemoveMigration(ctx context.Context, version int) error
+}
+
+// MigrationStatus is a migration and when it was applied, nil if it is pending
+type MigrationStatus struct {
+	Version   int        `json:"version"`
+	Name      string     `json:"name"`
+	AppliedAt *time.Time `json:"appliedAt,omitempty"`
+}
+
+// Migrator applies the migrations in the order of their version
+type Migrator struct {
+	Store      MigrationStore
+	Migrations []Migration
+	// Now is the clock of the applied dates, replaced in tests
+	Now func() time.Time
+}
+
+// NewMigrator creates a Migrator for migrations, recording them in store
+func NewMigrator(store MigrationStore, migrations []Migration) *Migrator {
+	sorted := append([]Migration{}, migrations...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Version < sorted[j].Version })
+	return &Migrator{Store: store, Migrations: sorted, Now: time.Now}
+}
+
+func (m *Migrator) applied(ctx context.Context) (map[int]AppliedMigration, error) {
+	list, err := m.Store.AppliedMigrations(ctx)
+	if err != nil {
+		return nil, err
+	}
+	applied := map[int]AppliedMigration{}
+	for _, migration := range list {
+		applied[migration.Version] = migration
+	}
+	return applied, nil
+}
+
+// Status lists every migration, oldest first
+func (m *Migrator) Status(ctx context.Context) ([]MigrationStatus, error) {
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+	list := []MigrationStatus{}
+	for _, migration := range m.Migrations {
+		status := MigrationStatus{Version: migration.Version, Name: migration.Name}
+		if a, ok := applied[migration.Version]; ok {
+			appliedAt := a.AppliedAt
+			status.AppliedAt = &appliedAt
+		}
+		list = append(list, status)
+	}
+	return list, nil
+}
+
+// Up applies the pending migrations, it stops at the first one failing
+func (m *Migrator) Up(ctx context.Context) (done []Migration, err error) {
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, migration := range m.Migrations {
+		if _, ok := applied[migration.Version]; ok {
+			continue
+		}
+		if err := migration.Up(ctx); err != nil {
+			return done, fmt.Errorf("migration %d %s failed: %w", migration.Version, migration.Name, err)
+		}
+		if err := m.Store.RecordMigration(ctx, AppliedMigration{Version: migration.Version, Name: migration.Name, AppliedAt: m.Now()}); err != nil {
+			return done, err
+		}
+		done = append(done, migration)
+	}
+	return done, nil
+}
+
+// Down undoes the last steps applied migrations, newest first
+func (m *Migrator) Down(ctx context.Context, steps int) (done []Migration, err error) {
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for i := len(m.Migrations) - 1; i >= 0 && len(done) < steps; i-- {
+		migration := m.Migrations[i]
+		if _, ok := applied[migration.Version]; !ok {
+			continue
+		}
+		if err := migration.Down(ctx); err != nil {
+			return done, fmt.Errorf("migration %d %s could not be undone: %w", migration.Version, migration.Name, err)
+		}
+		if err := m.Store.RemoveMigration(ctx, migration.Version); err != nil {
+			return done, err
+		}
+		done = append(done, migration)
+	}
+	return done, nil
+}
+
+// MongoMigrationStore records the applied migrations in the schema_migrations collection of the configured database
+type MongoMigrationStore struct {
+	clients  *MongoClientHolder
+	database string
+}
+
+// NewMongoMigrationStore creates a MongoMigrationStore in the database defined by opts
+func NewMongoMigrationStore(clients *MongoClientHolder, opts MongoOptions) MongoMigrationStore {
+	return MongoMigrationStore{
+		clients:  clients,
+		database: opts.Database,
+	}
+}
+
+// collection is read from the current client, which changes when the credentials rotate
+func (m MongoMigrationStore) collection() *mongo.Collection {
+	return m.clients.Client().Database(m.database).Collection("schema_migrations")
+}
+
+func (m MongoMigrationStore) AppliedMigrations(ctx context.Context) ([]AppliedMigration, error) {
+	result, err := m.collection().Find(ctx, bson.M{}, options.Find().SetSort(bson.M{"_id": 1}))
+	if err != nil {
+		return nil, err
+	}
+	defer result.Close(ctx)
+	list := []AppliedMigration{}
+	if err := result.All(ctx, &list); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+func (m MongoMigrationStore) RecordMigration(ctx context.Context, applied AppliedMigration) error {
+	_, err := m.collection().InsertOne(ctx, applied)
+	return err
+}
+
+func (m MongoMigrationStore) RemoveMigration(ctx context.Context, version int) error {
+	_, err := m.collection().DeleteOne(ctx, bson.M{"_id": version})
+	return err
+}
+
+// index creates or drops a named index of a collection of the configured database
+type index struct {
+	clients    *MongoClientHolder
+	database   string
+	collection string
+	model      mongo.IndexModel
+}
+
+func (i index) up(ctx context.Context) error {
+	_, err := i.clients.Client().Database(i.database).Collection(i.collection).Indexes().CreateOne(ctx, i.model)
+	return err
+}
+
+func (i index) down(ctx context.Context) error {
+	_, err := i.clients.Client().Database(i.database).Collection(i.collection).Indexes().DropOne(ctx, *i.model.Options.Name)
+	return err
+}
+
+// documentsByCreation serves GetAllCreatedSince, it is created by the migrations and on the first use of a tenant database
+var documentsByCreation = mongo.IndexModel{
+	Keys:    bson.D{{Key: "tenant_id", Value: 1}, {Key: "created_at", Value: 1}},
+	Options: options.Index().SetName("tenant_id_1_created_at_1"),
+}
+
+// revisionsByDocument serves the history and keeps two revisions from taking the same version
+var revisionsByDocument = mongo.IndexModel{
+	Keys:    bson.D{{Key: "tenant_id", Value: 1}, {Key: "document_id", Value: 1}, {Key: "version", Value: 1}},
+	Options: options.Index().SetName("tenant_id_1_document_id_1_version_1").SetUnique(true),
+}
+
+// MongoMigrations are the migrations of the database defined by opts
+// With DatabasePerTenant they only migrate the shared database, the MongoAdapter creates the document indexes of a tenant database
+// the first time it uses it
+func MongoMigrations(clients *MongoClientHolder, opts MongoOptions) []Migration {
+	indexes := []struct {
+		version int
+		name    string
+		index   index
+	}{
+		{1, "audit sequence index", index{clients, opts.Database, "audit", mongo.IndexModel{
+			Keys:    bson.D{{Key: "tenant_id", Value: 1}, {Key: "seq", Value: 1}},
+			Options: options.Index().SetName("tenant_id_1_seq_1").SetUnique(true),
+		}}},
+		{2, "rate limit expiry index", index{clients, opts.Database, "rate_limits", mongo.IndexModel{
+			Keys:    bson.D{{Key: "updated_at", Value: 1}},
+			Options: options.Index().SetName("updated_at_1").SetExpireAfterSeconds(int32((24 * time.Hour).Seconds())),
+		}}},
+		{3, "documents by creation index", index{clients, opts.Database, opts.Collection, documentsByCreation}},
+		{4, "revisions by document index", index{clients, opts.Database, opts.Collection + "_revisions", revisionsByDocument}},
+		{5, "api key hash index", index{clients, opts.Database, "api_keys", mongo.IndexModel{
+			Keys:    bson.D{{Key: "hash", Value: 1}},
+			Options: options.Index().SetName("hash_1").SetUnique(true),
+		}}},
+	}
+	migrations := []Migration{}
+	for _, i := range indexes {
+		migrations = append(migrations, Migration{Version: i.version, Name: i.name, Up: i.index.up, Down: i.index.down})
+	}
+	backfill := defaultTenantBackfill{clients, opts.Database, opts.Collection}
+	migrations = append(migrations, Migration{Version: 6, Name: "default tenant of the documents written before tenants", Up: backfill.up, Down: backfill.down})
+	return migrations
+}
+
+// defaultTenantBackfill gives the default tenant to the documents written before every query was scoped by tenant_id,
+// they would not be found otherwise
+type defaultTenantBackfill struct {
+	clients    *MongoClientHolder
+	database   string
+	collection string
+}
+
+func (b defaultTenantBackfill) up(ctx context.Context) error {
+	_, err := b.clients.Client().Database(b.database).Collection(b.collection).UpdateMany(ctx,
+		bson.M{"tenant_id": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"tenant_id": tenancy.DefaultTenant}},
+	)
+	return err
+}
+
+// down keeps the tenant, the backfilled documents can't be told apart from the ones created in the default tenant since
+func (b defaultTenantBackfill) down(ctx context.Context) error {
+	return nil
+}
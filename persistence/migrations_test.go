@@ -0,0 +1,82 @@
+package persistence
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type memoryMigrationStore struct {
+	applied []AppliedMigration
+}
+
+func (m *memoryMigrationStore) AppliedMigrations(ctx context.Context) ([]AppliedMigration, error) {
+	return m.applied, nil
+}
+
+func (m *memoryMigrationStore) RecordMigration(ctx context.Context, applied AppliedMigration) error {
+	m.applied = append(m.applied, applied)
+	return nil
+}
+
+func (m *memoryMigrationStore) RemoveMigration(ctx context.Context, version int) error {
+	for i, applied := range m.applied {
+		if applied.Version == version {
+			m.applied = append(m.applied[:i], m.applied[i+1:]...)
+		}
+	}
+	return nil
+}
+
+func TestMigrator(t *testing.T) {
+	ctx := context.Background()
+	calls := []string{}
+	var failing error
+	migration := func(version int, name string) Migration {
+		return Migration{
+			Version: version,
+			Name:    name,
+			Up: func(ctx context.Context) error {
+				calls = append(calls, "up "+name)
+				return failing
+			},
+			Down: func(ctx context.Context) error {
+				calls = append(calls, "down "+name)
+				return nil
+			},
+		}
+	}
+	store := &memoryMigrationStore{}
+	migrator := NewMigrator(store, []Migration{migration(2, "second"), migration(1, "first"), migration(3, "third")})
+	now := time.Date(2023, 7, 1, 10, 0, 0, 0, time.UTC)
+	migrator.Now = func() time.Time { return now }
+
+	done, err := migrator.Up(ctx)
+	assert.NoError(t, err)
+	assert.Len(t, done, 3)
+	assert.Equal(t, []string{"up first", "up second", "up third"}, calls)
+
+	// Applied migrations are not run again
+	done, err = migrator.Up(ctx)
+	assert.NoError(t, err)
+	assert.Empty(t, done)
+
+	calls = nil
+	done, err = migrator.Down(ctx, 2)
+	assert.NoError(t, err)
+	assert.Len(t, done, 2)
+	assert.Equal(t, []string{"down third", "down second"}, calls)
+	status, err := migrator.Status(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, []MigrationStatus{{Version: 1, Name: "first", AppliedAt: &now}, {Version: 2, Name: "second"}, {Version: 3, Name: "third"}}, status)
+
+	// A failed migration is not recorded and stops the ones after it
+	calls, failing = nil, errors.New("index build failed")
+	_, err = migrator.Up(ctx)
+	assert.ErrorContains(t, err, "migration 2 second failed")
+	assert.Equal(t, []string{"up second"}, calls)
+	assert.Len(t, store.applied, 1)
+}
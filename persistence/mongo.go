@@ -2,25 +2,150 @@ package persistence
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"sync"
 	"time"
 
+	"github.com/Martin-Jast/go-microservice/tenancy"
 	"github.com/Martin-Jast/go-microservice/utils"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
+// TenantIsolation defines how the documents of different tenants are kept apart in Mongo
+type TenantIsolation int
+
+const (
+	// SharedCollection keeps every tenant in the same collection and scopes every query by the tenant_id field
+	SharedCollection TenantIsolation = iota
+	// DatabasePerTenant keeps each tenant in its own database named <Database>_<tenant>
+	DatabasePerTenant
+)
+
+// ParseTenantIsolation converts the names used in configuration ( "shared" or "database" ) to a TenantIsolation
+func ParseTenantIsolation(s string) (TenantIsolation, error) {
+	switch s {
+	case "", "shared":
+		return SharedCollection, nil
+	case "database":
+		return DatabasePerTenant, nil
+	}
+	return SharedCollection, fmt.Errorf("unknown tenant isolation: %s", s)
+}
+
+// MongoOptions defines where the documents are stored
+type MongoOptions struct {
+	Database   string
+	Collection string
+	Isolation  TenantIsolation
+}
+
+// DefaultMongoOptions are the options used by NewMongoAdapter
+var DefaultMongoOptions = MongoOptions{
+	Database:   "test",
+	Collection: "base",
+	Isolation:  SharedCollection,
+}
+
 type MongoAdapter struct {
-	mongoConnection *mongo.Collection
+	clients *MongoClientHolder
+	options MongoOptions
+	// indexed holds the tenant databases whose indexes were created by this replica
+	indexed *sync.Map
 }
 
 func NewMongoAdapter(dbClient *mongo.Client) MongoAdapter {
+	return NewMongoAdapterWithOptions(NewMongoClientHolder(dbClient, ""), DefaultMongoOptions)
+}
+
+// NewMongoAdapterWithOptions creates a MongoAdapter storing the documents as defined by opts
+func NewMongoAdapterWithOptions(clients *MongoClientHolder, opts MongoOptions) MongoAdapter {
 	return MongoAdapter{
-		mongoConnection: dbClient.Database("test").Collection("base"),
+		clients: clients,
+		options: opts,
+		indexed: &sync.Map{},
 	}
 }
 
+// scope returns the collection holding the documents of the tenant in ctx and the filter restricting queries to it
+// The tenant filter is kept even when each tenant has its own database so a misrouted query still can't leak data
+func (m MongoAdapter) scope(ctx context.Context) (*mongo.Collection, bson.M, error) {
+	tenant := tenancy.TenantOrDefault(ctx)
+	if err := tenancy.Validate(tenant); err != nil {
+		return nil, nil, err
+	}
+	dbName := m.options.Database
+	if m.options.Isolation == DatabasePerTenant {
+		dbName = fmt.Sprintf("%s_%s", dbName, tenant)
+	}
+	collection := m.clients.Client().Database(dbName).Collection(m.options.Collection)
+	if m.options.Isolation == DatabasePerTenant {
+		if err := m.ensureIndexes(ctx, collection); err != nil {
+			return nil, nil, err
+		}
+	}
+	return collection, bson.M{"tenant_id": tenant}, nil
+}
+
+// ensureIndexes creates the indexes of a tenant database the first time this replica uses it, the migrations only cover the shared database
+// Creating an index that exists does nothing, so replicas racing on a new tenant are fine
+func (m MongoAdapter) ensureIndexes(ctx context.Context, collection *mongo.Collection) error {
+	dbName := collection.Database().Name()
+	if _, ok := m.indexed.Load(dbName); ok {
+		return nil
+	}
+	if _, err := collection.Indexes().CreateOne(ctx, documentsByCreation); err != nil {
+		return fmt.Errorf("could not create the indexes of %s: %w", dbName, err)
+	}
+	if _, err := m.revisions(collection).Indexes().CreateOne(ctx, revisionsByDocument); err != nil {
+		return fmt.Errorf("could not create the indexes of %s: %w", dbName, err)
+	}
+	m.indexed.Store(dbName, true)
+	return nil
+}
+
+// revisions returns the collection keeping the history of the documents of collection
+func (m MongoAdapter) revisions(collection *mongo.Collection) *mongo.Collection {
+	return collection.Database().Collection(m.options.Collection + "_revisions")
+}
+
+// withTransaction runs fn in a transaction so a document and its revision are always written together
+// Standalone servers ( e.g. a local docker ) don't support transactions, there fn runs without one
+func (m MongoAdapter) withTransaction(ctx context.Context, fn func(ctx mongo.SessionContext) error) error {
+	session, err := m.clients.Client().StartSession()
+	if err != nil {
+		return err
+	}
+	defer session.EndSession(ctx)
+	_, err = session.WithTransaction(ctx, func(sc mongo.SessionContext) (interface{}, error) {
+		return nil, fn(sc)
+	})
+	var cmdErr mongo.CommandError
+	if errors.As(err, &cmdErr) && cmdErr.Code == illegalOperationCode {
+		return mongo.WithSession(ctx, session, fn)
+	}
+	return err
+}
+
+// illegalOperationCode is returned by servers that are not part of a replica set when a transaction is started
+const illegalOperationCode = 20
+
+// lastVersion returns the version of the latest revision of a document, 0 if it has none
+func (m MongoAdapter) lastVersion(ctx context.Context, revisions *mongo.Collection, tenant, id string) (int64, error) {
+	last := Revision{}
+	err := revisions.FindOne(ctx,
+		bson.M{"tenant_id": tenant, "document_id": id},
+		options.FindOne().SetSort(bson.M{"version": -1}),
+	).Decode(&last)
+	if err == mongo.ErrNoDocuments {
+		return 0, nil
+	}
+	return last.Version, err
+}
+
 
 // MongoBaseModel small extension of the generic baseModel to accomodate mongoID
 type MongoBaseModel struct {
@@ -30,28 +155,58 @@ type MongoBaseModel struct {
 
 
 func (m MongoAdapter) Create(ctx context.Context, document BaseModel) (id string, err error) {
+	collection, _, err := m.scope(ctx)
+	if err != nil {
+		return "", err
+	}
 	mBase := MongoBaseModel{}
 
 	if document.ID == nil {
 		temp := primitive.NewObjectID()
 		mBase.ID = &temp
+	} else {
+		// Imported documents keep their id
+		objID, err := primitive.ObjectIDFromHex(*document.ID)
+		if err != nil {
+			return "", fmt.Errorf("invalid objectID to create")
+		}
+		mBase.ID = &objID
 	}
+	document.TenantID = tenancy.TenantOrDefault(ctx)
 	mBase.BaseModel = &document
 	if mBase.CreatedAt == nil {
 		temp := time.Now()
 		mBase.CreatedAt = &temp
 	}
-	res, err := m.mongoConnection.InsertOne(ctx, mBase)
+	err = m.withTransaction(ctx, func(sc mongo.SessionContext) error {
+		res, err := collection.InsertOne(sc, mBase)
+		if mongo.IsDuplicateKeyError(err) {
+			return ErrAlreadyExists
+		}
+		if err != nil {
+			return err
+		}
+		respID, ok := res.InsertedID.(primitive.ObjectID)
+		if !ok {
+			return fmt.Errorf("could not insert document")
+		}
+		id = respID.Hex()
+		revision := Revision{
+			DocumentID: id,
+			TenantID:   document.TenantID,
+			Version:    1,
+			ValidFrom:  *document.CreatedAt,
+			Operation:  RevisionCreate,
+			Document:   document,
+		}
+		revision.Document.ID = &id
+		_, err = m.revisions(collection).InsertOne(sc, revision)
+		return err
+	})
 	if err != nil {
 		return "", err
 	}
-
-	respID, ok := res.InsertedID.(primitive.ObjectID)
-	if ok {
-		return respID.Hex(), nil
-	}
-
-	return "", fmt.Errorf("could not insert document")
+	return id, nil
 }
 
 func (m MongoAdapter) GetByID(ctx context.Context, id string) (doc *BaseModel, err error) {
@@ -62,7 +217,15 @@ func (m MongoAdapter) GetByID(ctx context.Context, id string) (doc *BaseModel, e
 	if err != nil {
 		return nil, fmt.Errorf("invalid objectID to find")
 	}
-	result := m.mongoConnection.FindOne(ctx, bson.M{"_id": asObjID})
+	collection, filter, err := m.scope(ctx)
+	if err != nil {
+		return nil, err
+	}
+	filter["_id"] = asObjID
+	result := collection.FindOne(ctx, filter)
+	if result.Err() == mongo.ErrNoDocuments {
+		return nil, ErrNotFound
+	}
 	if result.Err() != nil {
 		return nil, result.Err()
 
@@ -76,20 +239,67 @@ func (m MongoAdapter) GetByID(ctx context.Context, id string) (doc *BaseModel, e
 	return res.BaseModel, nil
 }
 
-func (m MongoAdapter) Delete(ctx context.Context, id string) error {
+func (m MongoAdapter) Delete(ctx context.Context, id string) (deleted *BaseModel, err error) {
 	if id == "" {
-		return fmt.Errorf("cannot delete with no id")
+		return nil, fmt.Errorf("cannot delete with no id")
 	}
 	asObjID, err := primitive.ObjectIDFromHex(id)
 	if err != nil {
-		return fmt.Errorf("invalid objectID to delete")
+		return nil, fmt.Errorf("invalid objectID to delete")
 	}
-	_, err = m.mongoConnection.DeleteOne(ctx, bson.M{"_id": asObjID})
-	return err
+	collection, filter, err := m.scope(ctx)
+	if err != nil {
+		return nil, err
+	}
+	filter["_id"] = asObjID
+	err = m.withTransaction(ctx, func(sc mongo.SessionContext) error {
+		// A retried transaction starts over
+		deleted = nil
+		current := MongoBaseModel{}
+		err := collection.FindOneAndDelete(sc, filter).Decode(&current)
+		if err == mongo.ErrNoDocuments {
+			// Nothing to delete, so nothing changed
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		revisions := m.revisions(collection)
+		version, err := m.lastVersion(sc, revisions, current.TenantID, id)
+		if err != nil {
+			return err
+		}
+		deletedAt := time.Now()
+		revision := Revision{
+			DocumentID: id,
+			TenantID:   current.TenantID,
+			Version:    version + 1,
+			ValidFrom:  deletedAt,
+			Operation:  RevisionDelete,
+			Document:   *current.BaseModel,
+		}
+		revision.Document.ID = &id
+		revision.Document.DeletedAt = &deletedAt
+		if _, err := revisions.InsertOne(sc, revision); err != nil {
+			return err
+		}
+		deleted = current.BaseModel
+		deleted.ID = &id
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return deleted, nil
 }
 
 func (m MongoAdapter) GetAllCreatedSince(ctx context.Context, date time.Time) (docs []BaseModel, err error) {
-	result, err := m.mongoConnection.Find(ctx, bson.M{"created_at": bson.M{"$gt": primitive.NewDateTimeFromTime(date)}})
+	collection, filter, err := m.scope(ctx)
+	if err != nil {
+		return nil, err
+	}
+	filter["created_at"] = bson.M{"$gt": primitive.NewDateTimeFromTime(date)}
+	result, err := collection.Find(ctx, filter)
 	if err != nil {
 		return nil, err
 	}
@@ -109,7 +319,134 @@ func (m MongoAdapter) GetAllCreatedSince(ctx context.Context, date time.Time) (d
 	return list, nil
 }
 
+// GetPage lists up to limit documents of the tenant in ctx ordered by id, after the id after
+func (m MongoAdapter) GetPage(ctx context.Context, after string, limit int) (docs []BaseModel, err error) {
+	collection, filter, err := m.scope(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if after != "" {
+		afterID, err := primitive.ObjectIDFromHex(after)
+		if err != nil {
+			return nil, fmt.Errorf("invalid objectID to page after")
+		}
+		filter["_id"] = bson.M{"$gt": afterID}
+	}
+	result, err := collection.Find(ctx, filter, options.Find().SetSort(bson.M{"_id": 1}).SetLimit(int64(limit)))
+	if err != nil {
+		return nil, err
+	}
+	defer result.Close(ctx)
+
+	list := []BaseModel{}
+	for result.Next(ctx) {
+		elem := MongoBaseModel{}
+		if err := result.Decode(&elem); err != nil {
+			return nil, err
+		}
+		elem.BaseModel.ID = utils.StrPnt(elem.ID.Hex())
+		list = append(list, *elem.BaseModel)
+	}
+	return list, result.Err()
+}
+
+// DeleteAll deletes every document of the tenant in ctx together with its history
 func (m MongoAdapter) DeleteAll(ctx context.Context) (error) {
-	_, err := m.mongoConnection.DeleteMany(ctx, bson.M{})
-	return err
+	collection, filter, err := m.scope(ctx)
+	if err != nil {
+		return err
+	}
+	return m.withTransaction(ctx, func(sc mongo.SessionContext) error {
+		if _, err := collection.DeleteMany(sc, filter); err != nil {
+			return err
+		}
+		_, err := m.revisions(collection).DeleteMany(sc, filter)
+		return err
+	})
+}
+
+// GetHistory lists the revisions of a document of the tenant in ctx, oldest first
+func (m MongoAdapter) GetHistory(ctx context.Context, id string) (revisions []Revision, err error) {
+	if id == "" {
+		return nil, fmt.Errorf("cannot GetHistory with no id")
+	}
+	collection, filter, err := m.scope(ctx)
+	if err != nil {
+		return nil, err
+	}
+	filter["document_id"] = id
+	result, err := m.revisions(collection).Find(ctx, filter, options.Find().SetSort(bson.M{"version": 1}))
+	if err != nil {
+		return nil, err
+	}
+	defer result.Close(ctx)
+
+	list := []Revision{}
+	if err := result.All(ctx, &list); err != nil {
+		return nil, err
+	}
+	if len(list) == 0 {
+		return nil, ErrNotFound
+	}
+	return list, nil
+}
+
+// GetByIDAsOf returns the document of the tenant in ctx from the latest revision valid at asOf
+func (m MongoAdapter) GetByIDAsOf(ctx context.Context, id string, asOf time.Time) (doc *BaseModel, err error) {
+	if id == "" {
+		return nil, fmt.Errorf("cannot GetByIDAsOf with no id")
+	}
+	collection, filter, err := m.scope(ctx)
+	if err != nil {
+		return nil, err
+	}
+	filter["document_id"] = id
+	filter["valid_from"] = bson.M{"$lte": asOf}
+	revision := Revision{}
+	err = m.revisions(collection).FindOne(ctx, filter, options.FindOne().SetSort(bson.M{"version": -1})).Decode(&revision)
+	if err == mongo.ErrNoDocuments || (err == nil && revision.Operation == RevisionDelete) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &revision.Document, nil
+}
+
+// transientMongoCodes are the server errors of a failover or a shutdown, the next attempt reaches the new primary
+var transientMongoCodes = []int{
+	6,     // HostUnreachable
+	7,     // HostNotFound
+	89,    // NetworkTimeout
+	91,    // ShutdownInProgress
+	189,   // PrimarySteppedDown
+	9001,  // SocketException
+	10107, // NotWritablePrimary
+	11600, // InterruptedAtShutdown
+	11602, // InterruptedDueToReplStateChange
+	13435, // NotPrimaryNoSecondaryOk
+	13436, // NotPrimaryOrSecondary
+}
+
+// IsTransientMongoError tells if err comes from an unreachable server or a failover, errors of the request itself are not
+// A timeout may come from the deadline of the caller, ResilientAdapter checks the context before counting it
+func IsTransientMongoError(err error) bool {
+	if err == nil || errors.Is(err, ErrNotFound) || errors.Is(err, context.Canceled) {
+		return false
+	}
+	if mongo.IsNetworkError(err) || mongo.IsTimeout(err) || errors.Is(err, mongo.ErrClientDisconnected) {
+		return true
+	}
+	var serverErr mongo.ServerError
+	if errors.As(err, &serverErr) {
+		if serverErr.HasErrorLabel("RetryableWriteError") || serverErr.HasErrorLabel("TransientTransactionError") {
+			return true
+		}
+		for _, code := range transientMongoCodes {
+			if serverErr.HasErrorCode(code) {
+				return true
+			}
+		}
+	}
+	return false
 }
@@ -0,0 +1,101 @@
+package persistence
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// exportPageSize is the number of documents held in memory at once by ExportDocuments and PurgeDocuments
+const exportPageSize = 500
+
+// PageFunc lists up to limit documents ordered by id after the id after, as PersistenceAdapter.GetPage
+type PageFunc func(ctx context.Context, after string, limit int) ([]BaseModel, error)
+
+// eachDocument calls fn for every document listed by page, a page at a time
+func eachDocument(ctx context.Context, page PageFunc, fn func(doc BaseModel) error) error {
+	after := ""
+	for {
+		docs, err := page(ctx, after, exportPageSize)
+		if err != nil {
+			return err
+		}
+		for _, doc := range docs {
+			if err := fn(doc); err != nil {
+				return err
+			}
+		}
+		if len(docs) < exportPageSize || docs[len(docs)-1].ID == nil {
+			return nil
+		}
+		after = *docs[len(docs)-1].ID
+	}
+}
+
+// ExportDocuments writes every document listed by page to w as NDJSON, one document per line
+func ExportDocuments(ctx context.Context, page PageFunc, w io.Writer) (count int, err error) {
+	enc := json.NewEncoder(w)
+	err = eachDocument(ctx, page, func(doc BaseModel) error {
+		if err := enc.Encode(doc); err != nil {
+			return err
+		}
+		count++
+		return nil
+	})
+	return count, err
+}
+
+// ImportResult counts the documents read by ImportDocuments
+type ImportResult struct {
+	Created int `json:"created"`
+	// Skipped are the documents whose id already exists
+	Skipped int `json:"skipped"`
+}
+
+// ImportDocuments creates the documents of the NDJSON read from r with create, keeping their id and creation date
+// Importing the same file twice creates nothing the second time, it stops at the first invalid line
+func ImportDocuments(ctx context.Context, create func(ctx context.Context, doc BaseModel) (string, error), r io.Reader) (ImportResult, error) {
+	result := ImportResult{}
+	scanner := bufio.NewScanner(r)
+	// Documents can be larger than the default 64k line limit
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		doc := BaseModel{}
+		if err := json.Unmarshal(scanner.Bytes(), &doc); err != nil {
+			return result, fmt.Errorf("line %d: %v", line, err)
+		}
+		doc.DeletedAt = nil
+		_, err := create(ctx, doc)
+		if errors.Is(err, ErrAlreadyExists) {
+			result.Skipped++
+			continue
+		}
+		if err != nil {
+			return result, fmt.Errorf("line %d: %w", line, err)
+		}
+		result.Created++
+	}
+	return result, scanner.Err()
+}
+
+// PurgeDocuments deletes with remove the documents listed by page created before date
+func PurgeDocuments(ctx context.Context, page PageFunc, remove func(ctx context.Context, id string) error, before time.Time) (count int, err error) {
+	err = eachDocument(ctx, page, func(doc BaseModel) error {
+		if doc.ID == nil || doc.CreatedAt == nil || !doc.CreatedAt.Before(before) {
+			return nil
+		}
+		if err := remove(ctx, *doc.ID); err != nil {
+			return err
+		}
+		count++
+		return nil
+	})
+	return count, err
+}
@@ -0,0 +1,107 @@
+package persistence
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// memoryAdapter keeps the documents of a single tenant in memory
+type memoryAdapter struct {
+	PersistenceAdapter
+	docs map[string]BaseModel
+}
+
+func (m *memoryAdapter) Create(ctx context.Context, document BaseModel) (string, error) {
+	if _, ok := m.docs[*document.ID]; ok {
+		return "", ErrAlreadyExists
+	}
+	m.docs[*document.ID] = document
+	return *document.ID, nil
+}
+
+func (m *memoryAdapter) Delete(ctx context.Context, id string) (*BaseModel, error) {
+	doc, ok := m.docs[id]
+	if !ok {
+		return nil, nil
+	}
+	delete(m.docs, id)
+	return &doc, nil
+}
+
+func (m *memoryAdapter) GetPage(ctx context.Context, after string, limit int) ([]BaseModel, error) {
+	list := []BaseModel{}
+	for _, doc := range m.docs {
+		if *doc.ID > after {
+			list = append(list, doc)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return *list[i].ID < *list[j].ID })
+	if len(list) > limit {
+		list = list[:limit]
+	}
+	return list, nil
+}
+
+func TestDocuments_ExportImportPurge(t *testing.T) {
+	ctx := context.Background()
+	day := func(d int) *time.Time {
+		date := time.Date(2023, 7, d, 0, 0, 0, 0, time.UTC)
+		return &date
+	}
+	id1, id2 := "64a000000000000000000001", "64a000000000000000000002"
+	source := &memoryAdapter{docs: map[string]BaseModel{
+		id1: {ID: &id1, Data: "first", CreatedAt: day(1)},
+		id2: {ID: &id2, Data: "second", CreatedAt: day(2)},
+	}}
+
+	out := &bytes.Buffer{}
+	count, err := ExportDocuments(ctx, source.GetPage, out)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, count)
+	assert.Equal(t, 2, strings.Count(out.String(), "\n"))
+
+	target := &memoryAdapter{docs: map[string]BaseModel{}}
+	result, err := ImportDocuments(ctx, target.Create, bytes.NewReader(out.Bytes()))
+	assert.NoError(t, err)
+	assert.Equal(t, ImportResult{Created: 2}, result)
+	assert.Equal(t, source.docs, target.docs)
+	result, err = ImportDocuments(ctx, target.Create, bytes.NewReader(out.Bytes()))
+	assert.NoError(t, err)
+	assert.Equal(t, ImportResult{Skipped: 2}, result)
+
+	_, err = ImportDocuments(ctx, target.Create, strings.NewReader("\n{\"Data\": "))
+	assert.ErrorContains(t, err, "line 2")
+
+	count, err = PurgeDocuments(ctx, target.GetPage, func(ctx context.Context, id string) error {
+		_, err := target.Delete(ctx, id)
+		return err
+	}, *day(2))
+	assert.NoError(t, err)
+	assert.Equal(t, 1, count)
+	assert.Contains(t, target.docs, id2)
+}
+
+func TestExportDocuments_Pages(t *testing.T) {
+	source := &memoryAdapter{docs: map[string]BaseModel{}}
+	for i := 0; i < 2*exportPageSize+1; i++ {
+		id := fmt.Sprintf("64a%021d", i)
+		source.docs[id] = BaseModel{ID: &id, Data: "doc"}
+	}
+	pages := 0
+	page := func(ctx context.Context, after string, limit int) ([]BaseModel, error) {
+		pages++
+		return source.GetPage(ctx, after, limit)
+	}
+	out := &bytes.Buffer{}
+	count, err := ExportDocuments(context.Background(), page, out)
+	assert.NoError(t, err)
+	assert.Equal(t, 2*exportPageSize+1, count)
+	assert.Equal(t, 3, pages)
+}
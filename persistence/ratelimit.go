@@ -0,0 +1,113 @@
+package persistence
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// TokenBucket is the state of a rate limit bucket, Tokens were left at UpdatedAt
+type TokenBucket struct {
+	Key       string    `bson:"_id"`
+	Tokens    float64   `bson:"tokens"`
+	UpdatedAt time.Time `bson:"updated_at"`
+}
+
+// Refill returns the tokens of the bucket at now, refilled at rate tokens per second up to capacity
+func (b TokenBucket) Refill(capacity, rate float64, now time.Time) float64 {
+	elapsed := now.Sub(b.UpdatedAt).Seconds()
+	if elapsed < 0 {
+		// Clocks of different replicas are never exactly in sync
+		elapsed = 0
+	}
+	tokens := b.Tokens + elapsed*rate
+	if tokens > capacity {
+		return capacity
+	}
+	return tokens
+}
+
+// RateLimitStore keeps the token buckets used to rate limit clients
+type RateLimitStore interface {
+	// Take refills the bucket of key and takes one token from it if there is one, returning the tokens left
+	// A bucket that does not exist yet starts full
+	Take(ctx context.Context, key string, capacity, rate float64, now time.Time) (tokens float64, allowed bool, err error)
+}
+
+// ErrRateLimitConflict is returned when a bucket kept changing under concurrent updates
+var ErrRateLimitConflict = fmt.Errorf("rate limit bucket updated concurrently")
+
+// rateLimitRetries bounds the compare-and-swap attempts of a Take
+const rateLimitRetries = 5
+
+// MongoRateLimitStore shares the buckets between replicas in the rate_limits collection of the configured database
+type MongoRateLimitStore struct {
+	clients  *MongoClientHolder
+	database string
+}
+
+// NewMongoRateLimitStore creates a MongoRateLimitStore in the database defined by opts
+func NewMongoRateLimitStore(clients *MongoClientHolder, opts MongoOptions) MongoRateLimitStore {
+	return MongoRateLimitStore{
+		clients:  clients,
+		database: opts.Database,
+	}
+}
+
+// collection is read from the current client, which changes when the credentials rotate
+func (m MongoRateLimitStore) collection() *mongo.Collection {
+	return m.clients.Client().Database(m.database).Collection("rate_limits")
+}
+
+// EnsureIndexes expires the buckets not used for a day, by then any bucket is full again and is the same as a new one
+func (m MongoRateLimitStore) EnsureIndexes(ctx context.Context) error {
+	_, err := m.collection().Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "updated_at", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(int32((24 * time.Hour).Seconds())),
+	})
+	return err
+}
+
+// Take implements RateLimitStore, the bucket is only written if its state is still the one read ( compare-and-swap )
+func (m MongoRateLimitStore) Take(ctx context.Context, key string, capacity, rate float64, now time.Time) (tokens float64, allowed bool, err error) {
+	// Mongo keeps milliseconds, the same precision is used in memory so the swap filter matches
+	now = now.Truncate(time.Millisecond)
+	for attempt := 0; attempt < rateLimitRetries; attempt++ {
+		current := TokenBucket{}
+		err := m.collection().FindOne(ctx, bson.M{"_id": key}).Decode(&current)
+		if err == mongo.ErrNoDocuments {
+			tokens = capacity - 1
+			_, err = m.collection().InsertOne(ctx, TokenBucket{Key: key, Tokens: tokens, UpdatedAt: now})
+			if mongo.IsDuplicateKeyError(err) {
+				continue
+			}
+			if err != nil {
+				return 0, false, err
+			}
+			return tokens, true, nil
+		}
+		if err != nil {
+			return 0, false, err
+		}
+		tokens = current.Refill(capacity, rate, now)
+		if tokens < 1 {
+			// Nothing is taken so the bucket is left as it is
+			return tokens, false, nil
+		}
+		res, err := m.collection().UpdateOne(ctx,
+			bson.M{"_id": key, "tokens": current.Tokens, "updated_at": current.UpdatedAt},
+			bson.M{"$set": bson.M{"tokens": tokens - 1, "updated_at": now}},
+		)
+		if err != nil {
+			return 0, false, err
+		}
+		if res.MatchedCount == 1 {
+			return tokens - 1, true, nil
+		}
+	}
+	return 0, false, ErrRateLimitConflict
+}
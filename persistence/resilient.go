@@ -0,0 +1,367 @@
+package persistence
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is returned without calling the wrapped adapter while the breaker of a method is open
+var ErrCircuitOpen = errors.New("persistence unavailable, circuit open")
+
+// AnyMethod is the key of the ResilienceOptions policy used by the methods not listed
+const AnyMethod = "*"
+
+// idempotentMethods are the only methods that may be retried, retrying a write could apply it twice
+var idempotentMethods = map[string]bool{"GetByID": true, "GetAllCreatedSince": true, "GetPage": true, "GetHistory": true, "GetByIDAsOf": true}
+
+// MethodPolicy defines how the calls of a method are retried and when its breaker opens
+type MethodPolicy struct {
+	// Attempts is the number of calls made before giving up, 1 disables the retries
+	Attempts int
+	// BaseDelay is the backoff before the first retry, it doubles on every retry up to MaxDelay and is jittered
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	// Failures is the number of consecutive failed calls that opens the breaker, 0 disables the breaker
+	Failures int
+	// OpenFor is how long the breaker stays open before letting a single call try again
+	OpenFor time.Duration
+}
+
+// methodPolicyJSON is MethodPolicy with the durations written as "1m30s"
+type methodPolicyJSON struct {
+	Attempts  int    `json:"attempts"`
+	BaseDelay string `json:"baseDelay"`
+	MaxDelay  string `json:"maxDelay"`
+	Failures  int    `json:"failures"`
+	OpenFor   string `json:"openFor"`
+}
+
+func (p *MethodPolicy) UnmarshalJSON(b []byte) error {
+	raw := methodPolicyJSON{}
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return err
+	}
+	*p = MethodPolicy{Attempts: raw.Attempts, Failures: raw.Failures}
+	for _, d := range []struct {
+		value  string
+		target *time.Duration
+	}{{raw.BaseDelay, &p.BaseDelay}, {raw.MaxDelay, &p.MaxDelay}, {raw.OpenFor, &p.OpenFor}} {
+		if d.value == "" {
+			continue
+		}
+		parsed, err := time.ParseDuration(d.value)
+		if err != nil {
+			return err
+		}
+		*d.target = parsed
+	}
+	return nil
+}
+
+// ResilienceOptions are the policies of each method of the PersistenceAdapter, by method name or AnyMethod
+type ResilienceOptions struct {
+	Policies map[string]MethodPolicy
+	// Transient tells the errors worth retrying and counted by the breakers, IsTransientMongoError if nil
+	Transient func(error) bool
+}
+
+// DefaultResilienceOptions retries the reads 3 times and opens the breakers after 5 consecutive failures for 30 seconds
+var DefaultResilienceOptions = ResilienceOptions{
+	Policies: map[string]MethodPolicy{
+		AnyMethod:            {Attempts: 1, Failures: 5, OpenFor: 30 * time.Second},
+		"GetByID":            {Attempts: 3, BaseDelay: 50 * time.Millisecond, MaxDelay: time.Second, Failures: 5, OpenFor: 30 * time.Second},
+		"GetAllCreatedSince": {Attempts: 3, BaseDelay: 50 * time.Millisecond, MaxDelay: time.Second, Failures: 5, OpenFor: 30 * time.Second},
+	},
+}
+
+// LoadResilienceFile reads the policies of a JSON file, {"GetByID": {"attempts": 3, "baseDelay": "50ms", ...}, "*": {...}}
+// The methods it lists replace the default policies
+func LoadResilienceFile(path string) (ResilienceOptions, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return ResilienceOptions{}, fmt.Errorf("could not read resilience policies: %v", err)
+	}
+	policies := map[string]MethodPolicy{}
+	if err := json.Unmarshal(b, &policies); err != nil {
+		return ResilienceOptions{}, fmt.Errorf("invalid resilience policies: %v", err)
+	}
+	opts := ResilienceOptions{Policies: map[string]MethodPolicy{}}
+	for method, policy := range DefaultResilienceOptions.Policies {
+		opts.Policies[method] = policy
+	}
+	for method, policy := range policies {
+		opts.Policies[method] = policy
+	}
+	return opts, opts.Validate()
+}
+
+// Validate checks the policies, only the reads can be retried
+func (o ResilienceOptions) Validate() error {
+	for method, policy := range o.Policies {
+		if method != AnyMethod && !adapterMethods[method] {
+			return fmt.Errorf("unknown persistence method: %s", method)
+		}
+		if policy.Attempts < 0 || policy.Failures < 0 || policy.BaseDelay < 0 || policy.MaxDelay < 0 || policy.OpenFor < 0 {
+			return fmt.Errorf("invalid policy for %s: negative values", method)
+		}
+		if policy.Attempts > 1 && method != AnyMethod && !idempotentMethods[method] {
+			return fmt.Errorf("invalid policy for %s: only reads can be retried", method)
+		}
+		if policy.Failures > 0 && policy.OpenFor <= 0 {
+			return fmt.Errorf("invalid policy for %s: the breaker needs openFor", method)
+		}
+	}
+	return nil
+}
+
+// policy returns the policy of method, the retries of AnyMethod only apply to the reads
+func (o ResilienceOptions) policy(method string) MethodPolicy {
+	policy, ok := o.Policies[method]
+	if !ok {
+		policy = o.Policies[AnyMethod]
+		if !idempotentMethods[method] {
+			policy.Attempts = 1
+		}
+	}
+	if policy.Attempts < 1 {
+		policy.Attempts = 1
+	}
+	return policy
+}
+
+var adapterMethods = map[string]bool{"Create": true, "GetByID": true, "Delete": true, "GetAllCreatedSince": true, "GetPage": true, "DeleteAll": true, "GetHistory": true, "GetByIDAsOf": true}
+
+// Breaker states
+const (
+	BreakerClosed   = "closed"
+	BreakerOpen     = "open"
+	BreakerHalfOpen = "half-open"
+)
+
+// breaker counts the consecutive failures of a method
+type breaker struct {
+	mu       sync.Mutex
+	failures int
+	openedAt time.Time
+	// probing is set while the single call let through after OpenFor is running
+	probing bool
+}
+
+// ResilientAdapter decorates a PersistenceAdapter retrying the reads that fail with transient errors and failing fast
+// with ErrCircuitOpen once a method keeps failing
+type ResilientAdapter struct {
+	next PersistenceAdapter
+	opts ResilienceOptions
+	// Now and Sleep are replaced in tests
+	Now   func() time.Time
+	Sleep func(ctx context.Context, d time.Duration) error
+
+	breakers map[string]*breaker
+}
+
+// NewResilientAdapter wraps next with the policies of opts
+func NewResilientAdapter(next PersistenceAdapter, opts ResilienceOptions) (*ResilientAdapter, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+	if opts.Transient == nil {
+		opts.Transient = IsTransientMongoError
+	}
+	a := &ResilientAdapter{next: next, opts: opts, Now: time.Now, Sleep: sleep, breakers: map[string]*breaker{}}
+	for method := range adapterMethods {
+		a.breakers[method] = &breaker{}
+	}
+	return a, nil
+}
+
+// sleep waits for d unless ctx is done first
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// backoff is the delay before the retry following attempt, with full jitter so the replicas don't retry together
+func backoff(policy MethodPolicy, attempt int) time.Duration {
+	delay := policy.BaseDelay << (attempt - 1)
+	if (policy.MaxDelay > 0 && delay > policy.MaxDelay) || delay < 0 {
+		delay = policy.MaxDelay
+	}
+	if delay <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(delay) + 1))
+}
+
+// allow tells if a call can be made, after OpenFor a single call is let through to probe the adapter, probe tells if it is this one
+func (a *ResilientAdapter) allow(method string, policy MethodPolicy) (ok bool, probe bool) {
+	if policy.Failures <= 0 {
+		return true, false
+	}
+	b := a.breakers[method]
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.failures < policy.Failures {
+		return true, false
+	}
+	if b.probing || a.Now().Sub(b.openedAt) < policy.OpenFor {
+		return false, false
+	}
+	b.probing = true
+	return true, true
+}
+
+// record counts the result of a call, only transient errors are failures
+// Only the probe ends the half-open state, a call started before the breaker opened can end while the probe runs
+func (a *ResilientAdapter) record(ctx context.Context, method string, policy MethodPolicy, probe bool, err error) {
+	b := a.breakers[method]
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if probe {
+		b.probing = false
+	}
+	if err != nil && (ctx.Err() != nil || errors.Is(err, context.Canceled)) {
+		// The caller gave up or ran out of time ( the timeouts of the driver look the same ), nothing was learnt about the adapter
+		return
+	}
+	if err == nil || !a.opts.Transient(err) {
+		b.failures = 0
+		return
+	}
+	b.failures++
+	if policy.Failures > 0 && (probe || b.failures == policy.Failures) {
+		b.openedAt = a.Now()
+	}
+}
+
+// call runs fn with the policy of method
+func (a *ResilientAdapter) call(ctx context.Context, method string, fn func(ctx context.Context) error) error {
+	policy := a.opts.policy(method)
+	var err error
+	for attempt := 1; ; attempt++ {
+		ok, probe := a.allow(method, policy)
+		if !ok {
+			if err != nil {
+				return fmt.Errorf("%w: %v", ErrCircuitOpen, err)
+			}
+			return ErrCircuitOpen
+		}
+		err = fn(ctx)
+		a.record(ctx, method, policy, probe, err)
+		if err == nil || attempt >= policy.Attempts || !a.opts.Transient(err) || ctx.Err() != nil {
+			return err
+		}
+		if a.Sleep(ctx, backoff(policy, attempt)) != nil {
+			return err
+		}
+	}
+}
+
+// BreakerStates returns the state of the breaker of every method
+func (a *ResilientAdapter) BreakerStates() map[string]string {
+	states := map[string]string{}
+	for method, b := range a.breakers {
+		policy := a.opts.policy(method)
+		b.mu.Lock()
+		switch {
+		case policy.Failures <= 0 || b.failures < policy.Failures:
+			states[method] = BreakerClosed
+		case b.probing || a.Now().Sub(b.openedAt) >= policy.OpenFor:
+			states[method] = BreakerHalfOpen
+		default:
+			states[method] = BreakerOpen
+		}
+		b.mu.Unlock()
+	}
+	return states
+}
+
+// Ready returns the breaker states and an error naming the methods whose breaker is open
+func (a *ResilientAdapter) Ready(ctx context.Context) (interface{}, error) {
+	states := a.BreakerStates()
+	open := []string{}
+	for method, state := range states {
+		if state == BreakerOpen {
+			open = append(open, method)
+		}
+	}
+	if len(open) > 0 {
+		sort.Strings(open)
+		return states, fmt.Errorf("%w for %v", ErrCircuitOpen, open)
+	}
+	return states, nil
+}
+
+func (a *ResilientAdapter) Create(ctx context.Context, document BaseModel) (id string, err error) {
+	err = a.call(ctx, "Create", func(ctx context.Context) (err error) {
+		id, err = a.next.Create(ctx, document)
+		return err
+	})
+	return id, err
+}
+
+func (a *ResilientAdapter) GetByID(ctx context.Context, id string) (doc *BaseModel, err error) {
+	err = a.call(ctx, "GetByID", func(ctx context.Context) (err error) {
+		doc, err = a.next.GetByID(ctx, id)
+		return err
+	})
+	return doc, err
+}
+
+func (a *ResilientAdapter) Delete(ctx context.Context, id string) (deleted *BaseModel, err error) {
+	err = a.call(ctx, "Delete", func(ctx context.Context) (err error) {
+		deleted, err = a.next.Delete(ctx, id)
+		return err
+	})
+	return deleted, err
+}
+
+func (a *ResilientAdapter) GetAllCreatedSince(ctx context.Context, date time.Time) (docs []BaseModel, err error) {
+	err = a.call(ctx, "GetAllCreatedSince", func(ctx context.Context) (err error) {
+		docs, err = a.next.GetAllCreatedSince(ctx, date)
+		return err
+	})
+	return docs, err
+}
+
+func (a *ResilientAdapter) GetPage(ctx context.Context, after string, limit int) (docs []BaseModel, err error) {
+	err = a.call(ctx, "GetPage", func(ctx context.Context) (err error) {
+		docs, err = a.next.GetPage(ctx, after, limit)
+		return err
+	})
+	return docs, err
+}
+
+func (a *ResilientAdapter) DeleteAll(ctx context.Context) error {
+	return a.call(ctx, "DeleteAll", func(ctx context.Context) error {
+		return a.next.DeleteAll(ctx)
+	})
+}
+
+func (a *ResilientAdapter) GetHistory(ctx context.Context, id string) (revisions []Revision, err error) {
+	err = a.call(ctx, "GetHistory", func(ctx context.Context) (err error) {
+		revisions, err = a.next.GetHistory(ctx, id)
+		return err
+	})
+	return revisions, err
+}
+
+func (a *ResilientAdapter) GetByIDAsOf(ctx context.Context, id string, asOf time.Time) (doc *BaseModel, err error) {
+	err = a.call(ctx, "GetByIDAsOf", func(ctx context.Context) (err error) {
+		doc, err = a.next.GetByIDAsOf(ctx, id, asOf)
+		return err
+	})
+	return doc, err
+}
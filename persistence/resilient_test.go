@@ -0,0 +1,161 @@
+package persistence
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var errFailover = errors.New("not writable primary")
+
+// failingAdapter fails the first failures calls to GetByID and Create with errFailover
+type failingAdapter struct {
+	PersistenceAdapter
+	failures int
+	calls    int
+}
+
+func (f *failingAdapter) GetByID(ctx context.Context, id string) (*BaseModel, error) {
+	f.calls++
+	if f.calls <= f.failures {
+		return nil, errFailover
+	}
+	if id == "missing" {
+		return nil, ErrNotFound
+	}
+	return &BaseModel{ID: &id}, nil
+}
+
+func (f *failingAdapter) Create(ctx context.Context, document BaseModel) (string, error) {
+	f.calls++
+	if f.calls <= f.failures {
+		return "", errFailover
+	}
+	return "1", nil
+}
+
+func newTestResilientAdapter(t *testing.T, next PersistenceAdapter, policy MethodPolicy) (*ResilientAdapter, *time.Time) {
+	adapter, err := NewResilientAdapter(next, ResilienceOptions{
+		Policies:  map[string]MethodPolicy{AnyMethod: policy},
+		Transient: func(err error) bool { return errors.Is(err, errFailover) },
+	})
+	assert.NoError(t, err)
+	now := time.Date(2023, 7, 1, 10, 0, 0, 0, time.UTC)
+	adapter.Now = func() time.Time { return now }
+	adapter.Sleep = func(ctx context.Context, d time.Duration) error { return nil }
+	return adapter, &now
+}
+
+func TestResilientAdapter_Retries(t *testing.T) {
+	next := &failingAdapter{failures: 2}
+	adapter, _ := newTestResilientAdapter(t, next, MethodPolicy{Attempts: 3, BaseDelay: time.Millisecond})
+
+	doc, err := adapter.GetByID(context.Background(), "1")
+	assert.NoError(t, err)
+	assert.Equal(t, "1", *doc.ID)
+	assert.Equal(t, 3, next.calls)
+
+	// Errors of the request itself are not retried
+	_, err = adapter.GetByID(context.Background(), "missing")
+	assert.ErrorIs(t, err, ErrNotFound)
+	assert.Equal(t, 4, next.calls)
+
+	// Writes are never retried
+	next.calls, next.failures = 0, 1
+	_, err = adapter.Create(context.Background(), BaseModel{})
+	assert.ErrorIs(t, err, errFailover)
+	assert.Equal(t, 1, next.calls)
+}
+
+func TestResilientAdapter_Breaker(t *testing.T) {
+	next := &failingAdapter{failures: 3}
+	adapter, now := newTestResilientAdapter(t, next, MethodPolicy{Attempts: 1, Failures: 2, OpenFor: time.Minute})
+	ctx := context.Background()
+
+	adapter.GetByID(ctx, "1")
+	adapter.GetByID(ctx, "1")
+	assert.Equal(t, BreakerOpen, adapter.BreakerStates()["GetByID"])
+	assert.Equal(t, BreakerClosed, adapter.BreakerStates()["Create"])
+	_, err := adapter.Ready(ctx)
+	assert.ErrorIs(t, err, ErrCircuitOpen)
+
+	_, err = adapter.GetByID(ctx, "1")
+	assert.ErrorIs(t, err, ErrCircuitOpen)
+	assert.Equal(t, 2, next.calls, "an open breaker fails without calling the adapter")
+
+	// A failed probe opens the breaker again
+	*now = now.Add(time.Minute)
+	assert.Equal(t, BreakerHalfOpen, adapter.BreakerStates()["GetByID"])
+	_, err = adapter.GetByID(ctx, "1")
+	assert.ErrorIs(t, err, errFailover)
+	_, err = adapter.GetByID(ctx, "1")
+	assert.ErrorIs(t, err, ErrCircuitOpen)
+
+	// A successful probe closes it
+	*now = now.Add(time.Minute)
+	_, err = adapter.GetByID(ctx, "1")
+	assert.NoError(t, err)
+	assert.Equal(t, BreakerClosed, adapter.BreakerStates()["GetByID"])
+	_, err = adapter.Ready(ctx)
+	assert.NoError(t, err)
+}
+
+func TestResilientAdapter_CallerDeadline(t *testing.T) {
+	next := &failingAdapter{failures: 3}
+	adapter, _ := newTestResilientAdapter(t, next, MethodPolicy{Attempts: 3, Failures: 1, OpenFor: time.Minute})
+	ctx, cancel := context.WithTimeout(context.Background(), -time.Second)
+	defer cancel()
+
+	_, err := adapter.GetByID(ctx, "1")
+	assert.ErrorIs(t, err, errFailover)
+	assert.Equal(t, 1, next.calls, "no retry once the caller's deadline passed")
+	assert.Equal(t, BreakerClosed, adapter.BreakerStates()["GetByID"], "the caller's deadline is not a failure of the adapter")
+}
+
+func TestResilientAdapter_SingleProbe(t *testing.T) {
+	adapter, now := newTestResilientAdapter(t, &failingAdapter{}, MethodPolicy{Attempts: 1, Failures: 1, OpenFor: time.Minute})
+	ctx := context.Background()
+	policy := adapter.opts.policy("GetByID")
+
+	// A call starts while the breaker is closed, then another one opens it
+	ok, stale := adapter.allow("GetByID", policy)
+	assert.True(t, ok)
+	adapter.record(ctx, "GetByID", policy, false, errFailover)
+
+	*now = now.Add(time.Minute)
+	ok, probe := adapter.allow("GetByID", policy)
+	assert.True(t, ok)
+	assert.True(t, probe)
+	adapter.record(ctx, "GetByID", policy, stale, errFailover)
+	ok, _ = adapter.allow("GetByID", policy)
+	assert.False(t, ok, "the call started before the breaker opened does not end the probe")
+
+	adapter.record(ctx, "GetByID", policy, probe, nil)
+	assert.Equal(t, BreakerClosed, adapter.BreakerStates()["GetByID"])
+}
+
+func TestLoadResilienceFile(t *testing.T) {
+	dir := t.TempDir()
+	write := func(content string) string {
+		path := filepath.Join(dir, "resilience.json")
+		assert.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+		return path
+	}
+
+	opts, err := LoadResilienceFile(write(`{"GetByID": {"attempts": 5, "baseDelay": "10ms", "maxDelay": "200ms", "failures": 10, "openFor": "1m"}}`))
+	assert.NoError(t, err)
+	assert.Equal(t, MethodPolicy{Attempts: 5, BaseDelay: 10 * time.Millisecond, MaxDelay: 200 * time.Millisecond, Failures: 10, OpenFor: time.Minute}, opts.Policies["GetByID"])
+	assert.Equal(t, DefaultResilienceOptions.Policies[AnyMethod], opts.Policies[AnyMethod])
+
+	_, err = LoadResilienceFile(write(`{"Create": {"attempts": 2}}`))
+	assert.ErrorContains(t, err, "only reads can be retried")
+	_, err = LoadResilienceFile(write(`{"Update": {"attempts": 1}}`))
+	assert.ErrorContains(t, err, "unknown persistence method")
+	_, err = LoadResilienceFile(write(`{"GetByID": {"baseDelay": "soon"}}`))
+	assert.Error(t, err)
+}
@@ -3,12 +3,16 @@ package persistence
 import (
 	"context"
 	"database/sql"
-	"fmt"
+	"errors"
 	"time"
 
+	"github.com/Martin-Jast/go-microservice/tenancy"
+	"github.com/Martin-Jast/go-microservice/utils"
+	"github.com/go-sql-driver/mysql"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
+// SQLAdapter stores the documents in the test table of MySQL, SQLMigrations brings the table of the first release to its schema
 type SQLAdapter struct {
 	sqlConnection *sql.DB
 }
@@ -20,37 +24,109 @@ func NewSQLAdapter(dbConnection *sql.DB) SQLAdapter {
 	}
 }
 
+// withTx runs fn in a transaction so a document and its revision are always written together
+func (s SQLAdapter) withTx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	tx, err := s.sqlConnection.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// insertRevision adds the next revision of a document to the test_revisions table
+func insertRevision(ctx context.Context, tx *sql.Tx, operation string, validFrom time.Time, doc BaseModel) error {
+	var version int64
+	err := tx.QueryRowContext(ctx, "SELECT COALESCE(MAX(version), 0) FROM test_revisions WHERE document_id = ? AND tenant_id = ?", *doc.ID, doc.TenantID).Scan(&version)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, "INSERT INTO test_revisions (document_id, tenant_id, version, valid_from, operation, data, created_by, created_at, deleted_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		*doc.ID, doc.TenantID, version+1, validFrom, operation, doc.Data, doc.CreatedBy, doc.CreatedAt, doc.DeletedAt)
+	return err
+}
+
+// mysqlDuplicateEntry is the error number of a unique key violation
+const mysqlDuplicateEntry = 1062
+
 func (s SQLAdapter) Create(ctx context.Context, document BaseModel) (id string, err error) {
-	lId := primitive.NewObjectID()
-	_, err = s.sqlConnection.Exec("INSERT INTO test (id, data, created_at, deleted_at) VALUES (?, ?, ?)", lId.Hex(), document.Data, document.CreatedAt, document.DeletedAt)
+	if document.ID == nil {
+		document.ID = utils.StrPnt(primitive.NewObjectID().Hex())
+	}
+	document.TenantID = tenancy.TenantOrDefault(ctx)
+	if document.CreatedAt == nil {
+		temp := time.Now()
+		document.CreatedAt = &temp
+	}
+	err = s.withTx(ctx, func(tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, "INSERT INTO test (id, tenant_id, data, created_by, created_at, deleted_at) VALUES (?, ?, ?, ?, ?, ?)", *document.ID, document.TenantID, document.Data, document.CreatedBy, document.CreatedAt, document.DeletedAt)
+		var mysqlErr *mysql.MySQLError
+		if errors.As(err, &mysqlErr) && mysqlErr.Number == mysqlDuplicateEntry {
+			return ErrAlreadyExists
+		}
+		if err != nil {
+			return err
+		}
+		return insertRevision(ctx, tx, RevisionCreate, *document.CreatedAt, document)
+	})
 	if err != nil {
 		return "", err
 	}
-    return lId.Hex(), nil
+    return *document.ID, nil
 }
 
 func (s SQLAdapter) GetByID(ctx context.Context, id string) (doc *BaseModel, err error) {
-	row := s.sqlConnection.QueryRow("SELECT * FROM album WHERE id = ?", id)
+	row := s.sqlConnection.QueryRowContext(ctx, "SELECT id, tenant_id, data, created_by, created_at, deleted_at FROM test WHERE id = ? AND tenant_id = ?", id, tenancy.TenantOrDefault(ctx))
 	elem := BaseModel{}
-    if err := row.Scan(&elem.ID, &elem.Data, &elem.CreatedAt, &elem.DeletedAt); err != nil {
+    if err := row.Scan(&elem.ID, &elem.TenantID, &elem.Data, &elem.CreatedBy, &elem.CreatedAt, &elem.DeletedAt); err != nil {
 		if err == sql.ErrNoRows {
-			return nil, fmt.Errorf("no document with id: %s", id)
+			return nil, ErrNotFound
 		}
     	return nil, err
     }
     return &elem, nil
 }
 
-func (s SQLAdapter) Delete(ctx context.Context, id string) error {
-	_, err := s.sqlConnection.Exec("DELETE FROM test WHERE id = ?", id)
+func (s SQLAdapter) Delete(ctx context.Context, id string) (deleted *BaseModel, err error) {
+	err = s.withTx(ctx, func(tx *sql.Tx) error {
+		// Read and locked in the transaction so the revision holds the document that is deleted
+		row := tx.QueryRowContext(ctx, "SELECT id, tenant_id, data, created_by, created_at, deleted_at FROM test WHERE id = ? AND tenant_id = ? FOR UPDATE", id, tenancy.TenantOrDefault(ctx))
+		current := &BaseModel{}
+		err := row.Scan(&current.ID, &current.TenantID, &current.Data, &current.CreatedBy, &current.CreatedAt, &current.DeletedAt)
+		if err == sql.ErrNoRows {
+			// Nothing to delete, so nothing changed
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		res, err := tx.ExecContext(ctx, "DELETE FROM test WHERE id = ? AND tenant_id = ?", id, current.TenantID)
+		if err != nil {
+			return err
+		}
+		if count, err := res.RowsAffected(); err != nil || count == 0 {
+			return err
+		}
+		before := *current
+		deletedAt := time.Now()
+		current.DeletedAt = &deletedAt
+		if err := insertRevision(ctx, tx, RevisionDelete, deletedAt, *current); err != nil {
+			return err
+		}
+		deleted = &before
+		return nil
+	})
 	if err != nil {
-		return err
+		return nil, err
 	}
-    return nil
+	return deleted, nil
 }
 
 func (s SQLAdapter) GetAllCreatedSince(ctx context.Context, date time.Time) (docs []BaseModel, err error) {
-	rows, err := s.sqlConnection.Query("SELECT * FROM album WHERE created_at > ?", date)
+	rows, err := s.sqlConnection.QueryContext(ctx, "SELECT id, tenant_id, data, created_by, created_at, deleted_at FROM test WHERE created_at > ? AND tenant_id = ?", date, tenancy.TenantOrDefault(ctx))
 	if err != nil {
         return nil, err
     }
@@ -58,7 +134,7 @@ func (s SQLAdapter) GetAllCreatedSince(ctx context.Context, date time.Time) (doc
 	result := []BaseModel{}
 	for rows.Next() {
 		elem := BaseModel{}
-		if err := rows.Scan(&elem.ID, &elem.Data, &elem.CreatedAt, &elem.DeletedAt); err != nil {
+		if err := rows.Scan(&elem.ID, &elem.TenantID, &elem.Data, &elem.CreatedBy, &elem.CreatedAt, &elem.DeletedAt); err != nil {
 			return nil, err
 		}
 		result = append(result, elem)
@@ -68,3 +144,80 @@ func (s SQLAdapter) GetAllCreatedSince(ctx context.Context, date time.Time) (doc
     }
     return result, nil
 }
+
+// GetPage lists up to limit documents of the tenant in ctx ordered by id, after the id after
+func (s SQLAdapter) GetPage(ctx context.Context, after string, limit int) (docs []BaseModel, err error) {
+	rows, err := s.sqlConnection.QueryContext(ctx, "SELECT id, tenant_id, data, created_by, created_at, deleted_at FROM test WHERE tenant_id = ? AND id > ? ORDER BY id LIMIT ?", tenancy.TenantOrDefault(ctx), after, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	result := []BaseModel{}
+	for rows.Next() {
+		elem := BaseModel{}
+		if err := rows.Scan(&elem.ID, &elem.TenantID, &elem.Data, &elem.CreatedBy, &elem.CreatedAt, &elem.DeletedAt); err != nil {
+			return nil, err
+		}
+		result = append(result, elem)
+	}
+	return result, rows.Err()
+}
+
+// DeleteAll deletes every document of the tenant in ctx together with its history
+func (s SQLAdapter) DeleteAll(ctx context.Context) error {
+	tenant := tenancy.TenantOrDefault(ctx)
+	return s.withTx(ctx, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, "DELETE FROM test WHERE tenant_id = ?", tenant); err != nil {
+			return err
+		}
+		_, err := tx.ExecContext(ctx, "DELETE FROM test_revisions WHERE tenant_id = ?", tenant)
+		return err
+	})
+}
+
+const selectRevision = "SELECT document_id, tenant_id, version, valid_from, operation, data, created_by, created_at, deleted_at FROM test_revisions"
+
+func scanRevision(scan func(dest ...interface{}) error) (Revision, error) {
+	rev := Revision{}
+	err := scan(&rev.DocumentID, &rev.TenantID, &rev.Version, &rev.ValidFrom, &rev.Operation, &rev.Document.Data, &rev.Document.CreatedBy, &rev.Document.CreatedAt, &rev.Document.DeletedAt)
+	rev.Document.ID = utils.StrPnt(rev.DocumentID)
+	rev.Document.TenantID = rev.TenantID
+	return rev, err
+}
+
+// GetHistory lists the revisions of a document of the tenant in ctx, oldest first
+func (s SQLAdapter) GetHistory(ctx context.Context, id string) (revisions []Revision, err error) {
+	rows, err := s.sqlConnection.QueryContext(ctx, selectRevision+" WHERE document_id = ? AND tenant_id = ? ORDER BY version", id, tenancy.TenantOrDefault(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	result := []Revision{}
+	for rows.Next() {
+		rev, err := scanRevision(rows.Scan)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, rev)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(result) == 0 {
+		return nil, ErrNotFound
+	}
+	return result, nil
+}
+
+// GetByIDAsOf returns the document of the tenant in ctx from the latest revision valid at asOf
+func (s SQLAdapter) GetByIDAsOf(ctx context.Context, id string, asOf time.Time) (doc *BaseModel, err error) {
+	row := s.sqlConnection.QueryRowContext(ctx, selectRevision+" WHERE document_id = ? AND tenant_id = ? AND valid_from <= ? ORDER BY version DESC LIMIT 1", id, tenancy.TenantOrDefault(ctx), asOf)
+	rev, err := scanRevision(row.Scan)
+	if err == sql.ErrNoRows || (err == nil && rev.Operation == RevisionDelete) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &rev.Document, nil
+}
@@ -0,0 +1,98 @@
+package persistence
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/Martin-Jast/go-microservice/tenancy"
+)
+
+// SQLMigrationStore records the applied migrations in the schema_migrations table, created on first use
+type SQLMigrationStore struct {
+	db *sql.DB
+}
+
+// NewSQLMigrationStore creates a SQLMigrationStore on db
+func NewSQLMigrationStore(db *sql.DB) SQLMigrationStore {
+	return SQLMigrationStore{db: db}
+}
+
+func (s SQLMigrationStore) AppliedMigrations(ctx context.Context) ([]AppliedMigration, error) {
+	_, err := s.db.ExecContext(ctx, "CREATE TABLE IF NOT EXISTS schema_migrations (version INT PRIMARY KEY, name VARCHAR(255) NOT NULL, applied_at DATETIME(6) NOT NULL)")
+	if err != nil {
+		return nil, err
+	}
+	rows, err := s.db.QueryContext(ctx, "SELECT version, name, applied_at FROM schema_migrations ORDER BY version")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	list := []AppliedMigration{}
+	for rows.Next() {
+		applied := AppliedMigration{}
+		if err := rows.Scan(&applied.Version, &applied.Name, &applied.AppliedAt); err != nil {
+			return nil, err
+		}
+		list = append(list, applied)
+	}
+	return list, rows.Err()
+}
+
+func (s SQLMigrationStore) RecordMigration(ctx context.Context, applied AppliedMigration) error {
+	_, err := s.db.ExecContext(ctx, "INSERT INTO schema_migrations (version, name, applied_at) VALUES (?, ?, ?)", applied.Version, applied.Name, applied.AppliedAt)
+	return err
+}
+
+func (s SQLMigrationStore) RemoveMigration(ctx context.Context, version int) error {
+	_, err := s.db.ExecContext(ctx, "DELETE FROM schema_migrations WHERE version = ?", version)
+	return err
+}
+
+// statements runs each statement in order, MySQL commits DDL at once so they are not in a transaction
+func statements(db *sql.DB, list ...string) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		for _, statement := range list {
+			if _, err := db.ExecContext(ctx, statement); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// SQLMigrations are the migrations of the tables of the SQLAdapter, from the test table of the first release
+// The documents written before the tenants existed are given the default tenant
+func SQLMigrations(db *sql.DB) []Migration {
+	return []Migration{
+		{
+			Version: 1,
+			Name:    "tenant and creator columns",
+			Up: statements(db,
+				fmt.Sprintf("ALTER TABLE test ADD COLUMN tenant_id VARCHAR(48) NOT NULL DEFAULT '%s', ADD COLUMN created_by VARCHAR(255) NOT NULL DEFAULT ''", tenancy.DefaultTenant),
+				"CREATE INDEX tenant_id_created_at ON test (tenant_id, created_at)",
+			),
+			Down: statements(db,
+				"DROP INDEX tenant_id_created_at ON test",
+				"ALTER TABLE test DROP COLUMN created_by, DROP COLUMN tenant_id",
+			),
+		},
+		{
+			Version: 2,
+			Name:    "document revisions table",
+			Up: statements(db, `CREATE TABLE test_revisions (
+	document_id VARCHAR(255) NOT NULL,
+	tenant_id VARCHAR(48) NOT NULL,
+	version BIGINT NOT NULL,
+	valid_from DATETIME(6) NOT NULL,
+	operation VARCHAR(16) NOT NULL,
+	data TEXT NOT NULL,
+	created_by VARCHAR(255) NOT NULL DEFAULT '',
+	created_at DATETIME(6) NULL,
+	deleted_at DATETIME(6) NULL,
+	PRIMARY KEY (tenant_id, document_id, version)
+)`),
+			Down: statements(db, "DROP TABLE test_revisions"),
+		},
+	}
+}
@@ -0,0 +1,92 @@
+package persistence
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/Martin-Jast/go-microservice/tracing"
+)
+
+// TracedAdapter decorates a PersistenceAdapter creating a client span for every call
+type TracedAdapter struct {
+	next PersistenceAdapter
+	// System is recorded as db.system, e.g. "mongodb"
+	System string
+}
+
+// NewTracedAdapter wraps next, the spans are only recorded when the context carries a tracer
+func NewTracedAdapter(next PersistenceAdapter, system string) *TracedAdapter {
+	return &TracedAdapter{next: next, System: system}
+}
+
+func (a *TracedAdapter) start(ctx context.Context, method string) (context.Context, *tracing.Span) {
+	ctx, span := tracing.StartWithKind(ctx, "PersistenceAdapter."+method, tracing.KindClient)
+	span.SetAttribute("db.system", a.System)
+	span.SetAttribute("db.operation", method)
+	return ctx, span
+}
+
+// endSpan closes the span, ErrNotFound is an expected answer and not marked as an error
+func endSpan(span *tracing.Span, err error) {
+	if !errors.Is(err, ErrNotFound) {
+		span.SetError(err)
+	}
+	span.End()
+}
+
+func (a *TracedAdapter) Create(ctx context.Context, document BaseModel) (id string, err error) {
+	ctx, span := a.start(ctx, "Create")
+	id, err = a.next.Create(ctx, document)
+	endSpan(span, err)
+	return id, err
+}
+
+func (a *TracedAdapter) GetByID(ctx context.Context, id string) (doc *BaseModel, err error) {
+	ctx, span := a.start(ctx, "GetByID")
+	doc, err = a.next.GetByID(ctx, id)
+	endSpan(span, err)
+	return doc, err
+}
+
+func (a *TracedAdapter) Delete(ctx context.Context, id string) (*BaseModel, error) {
+	ctx, span := a.start(ctx, "Delete")
+	deleted, err := a.next.Delete(ctx, id)
+	endSpan(span, err)
+	return deleted, err
+}
+
+func (a *TracedAdapter) GetAllCreatedSince(ctx context.Context, date time.Time) (docs []BaseModel, err error) {
+	ctx, span := a.start(ctx, "GetAllCreatedSince")
+	docs, err = a.next.GetAllCreatedSince(ctx, date)
+	endSpan(span, err)
+	return docs, err
+}
+
+func (a *TracedAdapter) GetPage(ctx context.Context, after string, limit int) (docs []BaseModel, err error) {
+	ctx, span := a.start(ctx, "GetPage")
+	docs, err = a.next.GetPage(ctx, after, limit)
+	endSpan(span, err)
+	return docs, err
+}
+
+func (a *TracedAdapter) DeleteAll(ctx context.Context) error {
+	ctx, span := a.start(ctx, "DeleteAll")
+	err := a.next.DeleteAll(ctx)
+	endSpan(span, err)
+	return err
+}
+
+func (a *TracedAdapter) GetHistory(ctx context.Context, id string) (revisions []Revision, err error) {
+	ctx, span := a.start(ctx, "GetHistory")
+	revisions, err = a.next.GetHistory(ctx, id)
+	endSpan(span, err)
+	return revisions, err
+}
+
+func (a *TracedAdapter) GetByIDAsOf(ctx context.Context, id string, asOf time.Time) (doc *BaseModel, err error) {
+	ctx, span := a.start(ctx, "GetByIDAsOf")
+	doc, err = a.next.GetByIDAsOf(ctx, id, asOf)
+	endSpan(span, err)
+	return doc, err
+}
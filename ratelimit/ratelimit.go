@@ -0,0 +1,318 @@
+package ratelimit
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Martin-Jast/go-microservice/auth"
+	"github.com/Martin-Jast/go-microservice/logging"
+	"github.com/Martin-Jast/go-microservice/persistence"
+	"github.com/Martin-Jast/go-microservice/tenancy"
+	"github.com/Martin-Jast/go-microservice/utils"
+)
+
+// KeyKind defines who shares a bucket
+type KeyKind string
+
+const (
+	// KeyAPIKey gives a bucket to each authenticated caller ( the API key id or the token subject ), anonymous callers are keyed by IP
+	KeyAPIKey KeyKind = "apikey"
+	// KeyTenant gives a bucket to each tenant
+	KeyTenant KeyKind = "tenant"
+	// KeyIP gives a bucket to each client address
+	KeyIP KeyKind = "ip"
+)
+
+// AnyRoute matches every route in a Rule
+const AnyRoute = "*"
+
+// Duration is a time.Duration written as "1m30s" in JSON
+type Duration time.Duration
+
+// MarshalJSON implements json.Marshaler
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// UnmarshalJSON implements json.Unmarshaler
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// Rule allows Limit requests per Period to each key of the matching requests, Burst requests can be made at once ( Limit if not set )
+type Rule struct {
+	// Route is the route template, e.g. /base/create, or * for every route
+	Route string `json:"route"`
+	// Method restricts the rule to a HTTP method, every method if empty
+	Method string   `json:"method,omitempty"`
+	Key    KeyKind  `json:"key"`
+	Limit  int64    `json:"limit"`
+	Period Duration `json:"period"`
+	Burst  int64    `json:"burst,omitempty"`
+}
+
+// Validate checks the rule can be used
+func (r Rule) Validate() error {
+	if r.Route == "" {
+		return fmt.Errorf("rule without route")
+	}
+	switch r.Key {
+	case KeyAPIKey, KeyTenant, KeyIP:
+	default:
+		return fmt.Errorf("unknown key %q in rule of %s", r.Key, r.Route)
+	}
+	if r.Limit <= 0 || r.Period <= 0 || r.Burst < 0 {
+		return fmt.Errorf("limit and period of the rule of %s must be positive", r.Route)
+	}
+	return nil
+}
+
+// matches tells if the rule applies to the request on route
+func (r Rule) matches(route, method string) bool {
+	return (r.Route == AnyRoute || r.Route == route) && (r.Method == "" || strings.EqualFold(r.Method, method))
+}
+
+// capacity is the size of the bucket
+func (r Rule) capacity() float64 {
+	if r.Burst > 0 {
+		return float64(r.Burst)
+	}
+	return float64(r.Limit)
+}
+
+// rate is the number of tokens added to the bucket each second
+func (r Rule) rate() float64 {
+	return float64(r.Limit) / time.Duration(r.Period).Seconds()
+}
+
+// LoadRulesFile reads a JSON list of rules
+func LoadRulesFile(path string) ([]Rule, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	rules := []Rule{}
+	if err := json.Unmarshal(content, &rules); err != nil {
+		return nil, fmt.Errorf("invalid rate limit file %s: %v", path, err)
+	}
+	return rules, nil
+}
+
+// Limiter rate limits requests with the first of its rules matching each one
+type Limiter struct {
+	Store persistence.RateLimitStore
+	// TrustForwardedFor keys KeyIP by the first address of X-Forwarded-For, only safe behind a proxy that sets it
+	TrustForwardedFor bool
+	// Prefix starts the keys of the buckets so limiters sharing a store don't share their buckets
+	Prefix string
+	Now    func() time.Time
+
+	mu    sync.RWMutex
+	rules []Rule
+}
+
+// NewLimiter creates a Limiter keeping its buckets in store
+func NewLimiter(store persistence.RateLimitStore, rules []Rule) (*Limiter, error) {
+	l := &Limiter{Store: store, Now: time.Now}
+	if err := l.SetRules(rules); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// SetRules replaces the rules, they are checked in order so the most specific should come first
+// The buckets are kept, a rule with a new limit starts from the tokens left
+func (l *Limiter) SetRules(rules []Rule) error {
+	for _, rule := range rules {
+		if err := rule.Validate(); err != nil {
+			return err
+		}
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.rules = append([]Rule{}, rules...)
+	return nil
+}
+
+// Rules returns the rules in use
+func (l *Limiter) Rules() []Rule {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return append([]Rule{}, l.rules...)
+}
+
+// rule returns the first rule matching the request
+func (l *Limiter) rule(route, method string) (Rule, bool) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	for _, rule := range l.rules {
+		if rule.matches(route, method) {
+			return rule, true
+		}
+	}
+	return Rule{}, false
+}
+
+// key returns the bucket of the request for rule
+func (l *Limiter) key(r *http.Request, rule Rule) string {
+	var client string
+	switch rule.Key {
+	case KeyAPIKey:
+		if id := auth.FromContext(r.Context()); id != nil {
+			client = "subject:" + id.Subject
+		}
+	case KeyTenant:
+		client = "tenant:" + tenancy.TenantOrDefault(r.Context())
+	}
+	if client == "" {
+		client = "ip:" + l.clientIP(r)
+	}
+	return l.Prefix + strings.Join([]string{rule.Method, rule.Route, client}, "|")
+}
+
+func (l *Limiter) clientIP(r *http.Request) string {
+	if l.TrustForwardedFor {
+		if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+			first, _, _ := strings.Cut(forwarded, ",")
+			return strings.TrimSpace(first)
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// Result is the state of the bucket of a request after it was checked
+type Result struct {
+	Rule      Rule
+	Allowed   bool
+	Remaining int64
+	// Reset is the time until the bucket is full again
+	Reset time.Duration
+	// RetryAfter is the time until the next request is allowed, zero if this one was
+	RetryAfter time.Duration
+}
+
+// Allow takes a token for the request from the bucket of the matching rule, ok is false if no rule matches
+func (l *Limiter) Allow(r *http.Request) (res Result, ok bool, err error) {
+	rule, ok := l.rule(utils.RouteTemplate(r), r.Method)
+	if !ok {
+		return res, false, nil
+	}
+	capacity, rate := rule.capacity(), rule.rate()
+	tokens, allowed, err := l.Store.Take(r.Context(), l.key(r, rule), capacity, rate, l.Now())
+	if err != nil {
+		return res, true, err
+	}
+	res = Result{
+		Rule:      rule,
+		Allowed:   allowed,
+		Remaining: int64(math.Floor(tokens)),
+		Reset:     time.Duration((capacity - tokens) / rate * float64(time.Second)),
+	}
+	if !allowed {
+		res.RetryAfter = time.Duration((1 - tokens) / rate * float64(time.Second))
+	}
+	return res, true, nil
+}
+
+// seconds rounds d up to whole seconds, as used by the headers
+func seconds(d time.Duration) string {
+	return strconv.FormatInt(int64(math.Ceil(d.Seconds())), 10)
+}
+
+// Middleware answers 429 to the requests over the limit of their rule
+// It must run after the authentication and tenant middlewares for the KeyAPIKey and KeyTenant rules, before them a limiter only keys by IP
+// Requests are let through if the store fails, an unavailable store should not take the service down
+func (l *Limiter) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		res, ok, err := l.Allow(r)
+		if err != nil {
+			logging.For(r.Context(), "ratelimit").WarnContext(r.Context(), "could not check rate limit", "error", err)
+		}
+		if !ok || err != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Set("RateLimit-Limit", strconv.FormatInt(res.Rule.Limit, 10))
+		w.Header().Set("RateLimit-Remaining", strconv.FormatInt(res.Remaining, 10))
+		w.Header().Set("RateLimit-Reset", seconds(res.Reset))
+		w.Header().Set("RateLimit-Policy", fmt.Sprintf("%d;w=%s", res.Rule.Limit, seconds(time.Duration(res.Rule.Period))))
+		if !res.Allowed {
+			w.Header().Set("Retry-After", seconds(res.RetryAfter))
+			utils.WriteError(fmt.Errorf("rate limit exceeded, retry in %ss", seconds(res.RetryAfter)), w, http.StatusTooManyRequests)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// MemoryStore keeps the buckets in memory, each replica limits on its own
+type MemoryStore struct {
+	mu        sync.Mutex
+	buckets   map[string]memoryBucket
+	lastSweep time.Time
+}
+
+// memoryBucket keeps the size of the bucket so it can be swept without its rule
+type memoryBucket struct {
+	persistence.TokenBucket
+	capacity float64
+	rate     float64
+}
+
+// NewMemoryStore creates an empty MemoryStore
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{buckets: map[string]memoryBucket{}}
+}
+
+// sweepPeriod is how often full buckets are dropped, a missing bucket is the same as a full one
+const sweepPeriod = time.Minute
+
+// Take implements persistence.RateLimitStore
+func (m *MemoryStore) Take(ctx context.Context, key string, capacity, rate float64, now time.Time) (float64, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if now.Sub(m.lastSweep) > sweepPeriod {
+		for k, b := range m.buckets {
+			if b.Refill(b.capacity, b.rate, now) >= b.capacity {
+				delete(m.buckets, k)
+			}
+		}
+		m.lastSweep = now
+	}
+	bucket, ok := m.buckets[key]
+	if !ok {
+		bucket.TokenBucket = persistence.TokenBucket{Key: key, Tokens: capacity, UpdatedAt: now}
+	}
+	tokens := bucket.Refill(capacity, rate, now)
+	if tokens < 1 {
+		return tokens, false, nil
+	}
+	m.buckets[key] = memoryBucket{
+		TokenBucket: persistence.TokenBucket{Key: key, Tokens: tokens - 1, UpdatedAt: now},
+		capacity:    capacity,
+		rate:        rate,
+	}
+	return tokens - 1, true, nil
+}
@@ -0,0 +1,108 @@
+package ratelimit
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Martin-Jast/go-microservice/auth"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+// newTestRouter serves /base/create and /base/{id} with the limiter, the caller subject is taken from the X-Subject header
+func newTestRouter(limiter *Limiter) *mux.Router {
+	router := mux.NewRouter()
+	router.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if subject := r.Header.Get("X-Subject"); subject != "" {
+				r = r.WithContext(auth.WithIdentity(r.Context(), &auth.Identity{Subject: subject}))
+			}
+			next.ServeHTTP(w, r)
+		})
+	})
+	router.Use(limiter.Middleware)
+	ok := func(w http.ResponseWriter, r *http.Request) {}
+	router.HandleFunc("/base/create", ok).Methods(http.MethodPost)
+	router.HandleFunc("/base/{id}", ok).Methods(http.MethodGet)
+	return router
+}
+
+func TestLimiter(t *testing.T) {
+	now := time.Date(2023, 7, 1, 10, 0, 0, 0, time.UTC)
+	limiter, err := NewLimiter(NewMemoryStore(), []Rule{
+		{Route: "/base/create", Key: KeyAPIKey, Limit: 2, Period: Duration(time.Minute)},
+		{Route: AnyRoute, Method: http.MethodGet, Key: KeyIP, Limit: 10, Period: Duration(time.Second), Burst: 3},
+	})
+	assert.NoError(t, err)
+	limiter.Now = func() time.Time { return now }
+	router := newTestRouter(limiter)
+
+	do := func(method, path, subject string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		req.Header.Set("X-Subject", subject)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// Each caller has its own bucket on create
+	rec := do(http.MethodPost, "/base/create", "a")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "2", rec.Header().Get("RateLimit-Limit"))
+	assert.Equal(t, "1", rec.Header().Get("RateLimit-Remaining"))
+	assert.Equal(t, "30", rec.Header().Get("RateLimit-Reset"))
+	assert.Equal(t, "2;w=60", rec.Header().Get("RateLimit-Policy"))
+	assert.Equal(t, http.StatusOK, do(http.MethodPost, "/base/create", "a").Code)
+	rec = do(http.MethodPost, "/base/create", "a")
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+	assert.Equal(t, "30", rec.Header().Get("Retry-After"))
+	assert.Equal(t, "0", rec.Header().Get("RateLimit-Remaining"))
+	assert.Equal(t, http.StatusOK, do(http.MethodPost, "/base/create", "b").Code)
+
+	// Reads share the bucket of the address whatever the caller, up to the burst
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, http.StatusOK, do(http.MethodGet, "/base/1", "a").Code)
+	}
+	assert.Equal(t, http.StatusTooManyRequests, do(http.MethodGet, "/base/2", "b").Code)
+
+	// Tokens come back with time
+	now = now.Add(30 * time.Second)
+	assert.Equal(t, http.StatusOK, do(http.MethodPost, "/base/create", "a").Code)
+	assert.Equal(t, http.StatusTooManyRequests, do(http.MethodPost, "/base/create", "a").Code)
+	assert.Equal(t, http.StatusOK, do(http.MethodGet, "/base/1", "a").Code)
+
+	// Rules can be replaced at runtime, requests no rule matches are not limited
+	assert.NoError(t, limiter.SetRules([]Rule{{Route: "/base/{id}", Key: KeyTenant, Limit: 1, Period: Duration(time.Hour)}}))
+	rec = do(http.MethodPost, "/base/create", "a")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "", rec.Header().Get("RateLimit-Limit"))
+}
+
+func TestRule_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		rule    string
+		wantErr bool
+	}{
+		{name: "valid", rule: `{"route":"/base/create","key":"apikey","limit":10,"period":"1m"}`},
+		{name: "valid with burst", rule: `{"route":"*","method":"GET","key":"ip","limit":100,"period":"1s","burst":200}`},
+		{name: "missing route", rule: `{"key":"ip","limit":10,"period":"1m"}`, wantErr: true},
+		{name: "unknown key", rule: `{"route":"*","key":"user","limit":10,"period":"1m"}`, wantErr: true},
+		{name: "no limit", rule: `{"route":"*","key":"ip","period":"1m"}`, wantErr: true},
+		{name: "no period", rule: `{"route":"*","key":"ip","limit":10}`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule := Rule{}
+			assert.NoError(t, json.Unmarshal([]byte(tt.rule), &rule))
+			if tt.wantErr {
+				assert.Error(t, rule.Validate())
+				return
+			}
+			assert.NoError(t, rule.Validate())
+		})
+	}
+}
@@ -0,0 +1,127 @@
+package secrets
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+)
+
+// ErrNotFound is returned by a Provider that has no secret with the requested name
+var ErrNotFound = errors.New("secret not found")
+
+// Provider returns the current value of a secret, it is asked again every time the secret is refreshed
+type Provider interface {
+	Secret(ctx context.Context, name string) (string, error)
+}
+
+// ProviderFunc adapts a function to a Provider
+type ProviderFunc func(ctx context.Context, name string) (string, error)
+
+func (f ProviderFunc) Secret(ctx context.Context, name string) (string, error) {
+	return f(ctx, name)
+}
+
+// FileProvider reads the secret from the file at name, as mounted by docker or kubernetes ( file:///run/secrets/mongo )
+// The trailing newline written by most editors is removed
+var FileProvider = ProviderFunc(func(ctx context.Context, name string) (string, error) {
+	b, err := os.ReadFile(name)
+	if errors.Is(err, os.ErrNotExist) {
+		return "", fmt.Errorf("%w: %s", ErrNotFound, name)
+	}
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(b), "\r\n"), nil
+})
+
+// EnvProvider reads the secret from the env var named name ( env://MONGO_PASSWORD )
+var EnvProvider = ProviderFunc(func(ctx context.Context, name string) (string, error) {
+	value, ok := os.LookupEnv(name)
+	if !ok {
+		return "", fmt.Errorf("%w: env var %s is not set", ErrNotFound, name)
+	}
+	return value, nil
+})
+
+// LocalStore is a file based stand-in for an external secret store, the file is a JSON object of names to values
+// It is read on every call so editing the file rotates the secrets
+type LocalStore struct {
+	Path string
+}
+
+// NewLocalStore creates a LocalStore reading the file at path
+func NewLocalStore(path string) *LocalStore {
+	return &LocalStore{Path: path}
+}
+
+func (s *LocalStore) Secret(ctx context.Context, name string) (string, error) {
+	b, err := os.ReadFile(s.Path)
+	if err != nil {
+		return "", fmt.Errorf("could not read secret store: %v", err)
+	}
+	values := map[string]string{}
+	if err := json.Unmarshal(b, &values); err != nil {
+		return "", fmt.Errorf("invalid secret store %s: %v", s.Path, err)
+	}
+	value, ok := values[name]
+	if !ok {
+		return "", fmt.Errorf("%w: %s", ErrNotFound, name)
+	}
+	return value, nil
+}
+
+// Resolver replaces references ( <scheme>://<name> ) with the secret returned by the provider of the scheme
+// Values without a registered scheme are not references and are kept as they are, so mongodb:// urls stay untouched
+type Resolver struct {
+	mu        sync.RWMutex
+	providers map[string]Provider
+}
+
+// NewResolver creates a Resolver with the file:// and env:// schemes
+func NewResolver() *Resolver {
+	r := &Resolver{providers: map[string]Provider{}}
+	r.Register("file", FileProvider)
+	r.Register("env", EnvProvider)
+	return r
+}
+
+// Register makes the references with scheme resolved by p, replacing the provider already registered for it
+func (r *Resolver) Register(scheme string, p Provider) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.providers[scheme] = p
+}
+
+// provider returns the provider and the secret name of a reference, nil if value is not one
+func (r *Resolver) provider(value string) (Provider, string) {
+	scheme, name, ok := strings.Cut(value, "://")
+	if !ok {
+		return nil, ""
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.providers[scheme], name
+}
+
+// IsReference tells if value is resolved by a provider
+func (r *Resolver) IsReference(value string) bool {
+	p, _ := r.provider(value)
+	return p != nil
+}
+
+// Resolve returns the secret referenced by value, or value itself if it is not a reference
+func (r *Resolver) Resolve(ctx context.Context, value string) (string, error) {
+	p, name := r.provider(value)
+	if p == nil {
+		return value, nil
+	}
+	secret, err := p.Secret(ctx, name)
+	if err != nil {
+		return "", fmt.Errorf("could not resolve %s: %w", value, err)
+	}
+	return secret, nil
+}
@@ -0,0 +1,53 @@
+package secrets
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResolver_Resolve(t *testing.T) {
+	dir := t.TempDir()
+	secretFile := filepath.Join(dir, "mongo")
+	assert.NoError(t, os.WriteFile(secretFile, []byte("mongodb://user:pass@db\n"), 0o600))
+	store := filepath.Join(dir, "store.json")
+	assert.NoError(t, os.WriteFile(store, []byte(`{"jwt": "s3cret"}`), 0o600))
+	t.Setenv("SECRETS_TEST_VAR", "from-env")
+
+	r := NewResolver()
+	r.Register("secret", NewLocalStore(store))
+	tests := []struct {
+		value    string
+		want     string
+		notFound bool
+	}{
+		{value: "plain", want: "plain"},
+		{value: "mongodb://localhost:27017", want: "mongodb://localhost:27017"},
+		{value: "file://" + secretFile, want: "mongodb://user:pass@db"},
+		{value: "env://SECRETS_TEST_VAR", want: "from-env"},
+		{value: "secret://jwt", want: "s3cret"},
+		{value: "file://" + filepath.Join(dir, "missing"), notFound: true},
+		{value: "env://SECRETS_TEST_MISSING", notFound: true},
+		{value: "secret://missing", notFound: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := r.Resolve(context.Background(), tt.value)
+			if tt.notFound {
+				assert.ErrorIs(t, err, ErrNotFound)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+
+	// The store is read again so rotated values are returned
+	assert.NoError(t, os.WriteFile(store, []byte(`{"jwt": "rotated"}`), 0o600))
+	got, err := r.Resolve(context.Background(), "secret://jwt")
+	assert.NoError(t, err)
+	assert.Equal(t, "rotated", got)
+}
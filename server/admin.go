@@ -0,0 +1,167 @@
+package server
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/pprof"
+	"strings"
+
+	"github.com/Martin-Jast/go-microservice/auth"
+	"github.com/Martin-Jast/go-microservice/config"
+	"github.com/Martin-Jast/go-microservice/logging"
+	"github.com/Martin-Jast/go-microservice/metrics"
+	"github.com/Martin-Jast/go-microservice/persistence"
+	"github.com/Martin-Jast/go-microservice/requestid"
+	"github.com/Martin-Jast/go-microservice/utils"
+	"github.com/gorilla/mux"
+)
+
+// AdminOptions holds the optional parts of the admin server, the zero value only serves the health, pprof and shutdown routes
+type AdminOptions struct {
+	// Metrics enables the /metrics endpoint
+	Metrics *metrics.Registry
+	// ReadinessChecks are run by /ready, the replica is ready when all of them pass
+	ReadinessChecks []ReadinessCheck
+	// Config enables the /config endpoints
+	Config *config.Reloader
+	// LogLevels enables the /loglevels endpoints
+	LogLevels *logging.Levels
+	// Cache enables the /cache/flush endpoint
+	Cache *persistence.CachedAdapter
+	// Logger writes the access logs
+	Logger *slog.Logger
+	// Authentication is applied to the control routes ( shutdown, configuration, log levels, cache, pprof ), the probes and /metrics stay open
+	Authentication func(http.Handler) http.Handler
+	// Authorizer checks the operation of every control route, they are not checked if nil
+	Authorizer auth.Authorizer
+}
+
+// NewAdminServer creates the router of the operational routes, served on its own listener so they never face public traffic
+// The probes and /metrics are not authenticated so the orchestrator and the scrapers can reach them
+func NewAdminServer(reqShutdown chan bool, opts AdminOptions) *mux.Router {
+	router := mux.NewRouter()
+	router.Use(requestid.Middleware)
+	if opts.Logger != nil {
+		router.Use(logging.Middleware(opts.Logger))
+	}
+	// Operational answers are never cached
+	router.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Cache-Control", "no-store")
+			next.ServeHTTP(w, r)
+		})
+	})
+	router.Use(recoverPanics)
+
+	router.HandleFunc("/heartbeat", handleHeartbeat).Methods(http.MethodGet)
+	router.HandleFunc("/ready", handleReady(opts.ReadinessChecks)).Methods(http.MethodGet)
+	if opts.Metrics != nil {
+		router.Handle("/metrics", opts.Metrics.Handler()).Methods(http.MethodGet)
+	}
+
+	control := router.NewRoute().Subrouter()
+	if opts.Authentication != nil {
+		control.Use(opts.Authentication)
+	}
+	authz := opts.Authorizer
+	control.HandleFunc("/shutdown", authorize(authz, auth.OpAdminShutdown, func(w http.ResponseWriter, r *http.Request) {
+		reqShutdown <- true
+		w.WriteHeader(http.StatusAccepted)
+	})).Methods(http.MethodPost)
+
+	// Index also serves the named profiles ( heap, goroutine, block, ... )
+	control.HandleFunc("/debug/pprof/cmdline", authorize(authz, auth.OpAdminDebug, pprof.Cmdline))
+	control.HandleFunc("/debug/pprof/profile", authorize(authz, auth.OpAdminDebug, pprof.Profile))
+	control.HandleFunc("/debug/pprof/symbol", authorize(authz, auth.OpAdminDebug, pprof.Symbol))
+	control.HandleFunc("/debug/pprof/trace", authorize(authz, auth.OpAdminDebug, pprof.Trace))
+	control.PathPrefix("/debug/pprof/").HandlerFunc(authorize(authz, auth.OpAdminDebug, pprof.Index))
+
+	if opts.Config != nil {
+		newConfigPort(control, opts.Config, authz)
+	}
+	if opts.LogLevels != nil {
+		newLogLevelPort(control, opts.LogLevels, authz)
+	}
+	if opts.Cache != nil {
+		control.HandleFunc("/cache/flush", authorize(authz, auth.OpAdminConfig, func(w http.ResponseWriter, r *http.Request) {
+			opts.Cache.Flush()
+			logging.For(r.Context(), "server").InfoContext(r.Context(), "document cache flushed")
+			w.WriteHeader(http.StatusNoContent)
+		})).Methods(http.MethodPost)
+	}
+	return router
+}
+
+type logLevelPort struct {
+	*mux.Router
+	levels *logging.Levels
+}
+
+func newLogLevelPort(parent *mux.Router, levels *logging.Levels, authz auth.Authorizer) logLevelPort {
+	router := parent.PathPrefix("/loglevels").Subrouter()
+	handler := logLevelPort{
+		router,
+		levels,
+	}
+
+	router.Path("").
+		Methods(http.MethodGet).HandlerFunc(authorize(authz, auth.OpAdminConfig, handler.handleGet))
+	router.Path("").
+		Methods(http.MethodPut).HandlerFunc(authorize(authz, auth.OpAdminConfig, handler.handleSet))
+
+	return handler
+}
+
+// logLevels is the default level and the level of single packages, as in the configuration
+type logLevels struct {
+	Level  string            `json:"level,omitempty"`
+	Levels map[string]string `json:"levels,omitempty"`
+}
+
+func (h logLevelPort) current() logLevels {
+	current := logLevels{Levels: map[string]string{}}
+	for pkg, level := range h.levels.All() {
+		if pkg == "" {
+			current.Level = strings.ToLower(level.String())
+			continue
+		}
+		current.Levels[pkg] = strings.ToLower(level.String())
+	}
+	return current
+}
+
+// handleGet returns the levels in use
+func (h logLevelPort) handleGet(w http.ResponseWriter, r *http.Request) {
+	writeResponse(w, r, h.current(), 200)
+}
+
+// handleSet changes the default level and/or the level of the listed packages, the next configuration reload replaces them
+func (h logLevelPort) handleSet(w http.ResponseWriter, r *http.Request) {
+	body := logLevels{}
+	if err := decodeBody(r, &body); err != nil {
+		utils.WriteError(fmt.Errorf("invalid body: %v", err), w, http.StatusBadRequest)
+		return
+	}
+	// Every level is checked before any is changed
+	changes := map[string]slog.Level{}
+	if body.Levels == nil {
+		body.Levels = map[string]string{}
+	}
+	if body.Level != "" {
+		body.Levels[""] = body.Level
+	}
+	for pkg, name := range body.Levels {
+		level, err := logging.ParseLevel(name)
+		if err != nil {
+			utils.WriteError(err, w, http.StatusBadRequest)
+			return
+		}
+		changes[pkg] = level
+	}
+	for pkg, level := range changes {
+		h.levels.Set(pkg, level)
+	}
+	logging.For(r.Context(), "server").InfoContext(r.Context(), "log levels changed", "levels", body.Levels)
+	writeResponse(w, r, h.current(), 200)
+}
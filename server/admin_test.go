@@ -0,0 +1,114 @@
+package server
+
+import (
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Martin-Jast/go-microservice/auth"
+	"github.com/Martin-Jast/go-microservice/logging"
+	"github.com/Martin-Jast/go-microservice/metrics"
+	"github.com/Martin-Jast/go-microservice/persistence"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServer_Admin(t *testing.T) {
+	reqShutdown := make(chan bool, 1)
+	levels := logging.NewLevels(slog.LevelInfo)
+	reg := metrics.NewRegistry()
+	admin := NewAdminServer(reqShutdown, AdminOptions{
+		Metrics:   reg,
+		LogLevels: levels,
+		Cache:     persistence.NewCachedAdapter(nil, persistence.DefaultCacheOptions, reg),
+	})
+	do := func(router http.Handler, method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		if body != "" {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	tests := []struct {
+		method string
+		path   string
+		body   string
+		code   int
+	}{
+		{http.MethodGet, "/heartbeat", "", http.StatusOK},
+		{http.MethodGet, "/ready", "", http.StatusOK},
+		{http.MethodGet, "/metrics", "", http.StatusOK},
+		{http.MethodGet, "/debug/pprof/", "", http.StatusOK},
+		{http.MethodGet, "/debug/pprof/goroutine?debug=1", "", http.StatusOK},
+		{http.MethodPost, "/cache/flush", "", http.StatusNoContent},
+		{http.MethodGet, "/loglevels", "", http.StatusOK},
+		{http.MethodPut, "/loglevels", `{"level": "warn", "levels": {"http": "debug"}}`, http.StatusOK},
+		{http.MethodPut, "/loglevels", `{"levels": {"persistence": "debug", "http": "loud"}}`, http.StatusBadRequest},
+		{http.MethodGet, "/shutdown", "", http.StatusMethodNotAllowed},
+		{http.MethodPost, "/shutdown", "", http.StatusAccepted},
+	}
+	for _, tt := range tests {
+		rec := do(admin, tt.method, tt.path, tt.body)
+		assert.Equal(t, tt.code, rec.Code, "%s %s", tt.method, tt.path)
+		if tt.code < 300 {
+			assert.Equal(t, "no-store", rec.Header().Get("Cache-Control"), "%s %s", tt.method, tt.path)
+		}
+	}
+	assert.True(t, <-reqShutdown)
+	assert.Equal(t, slog.LevelWarn, levels.Level(""))
+	assert.Equal(t, slog.LevelDebug, levels.Level("http"))
+	assert.Equal(t, slog.LevelWarn, levels.Level("persistence"), "an invalid level changes nothing")
+	assert.JSONEq(t, `{"level": "warn", "levels": {"http": "debug"}}`, do(admin, http.MethodGet, "/loglevels", "").Body.String())
+
+	// The public router no longer serves the operational routes
+	public := NewServerWithOptions(stubService{}, Options{Metrics: reg})
+	for _, path := range []string{"/metrics", "/ready", "/heartbeat", "/shutdown", "/debug/pprof/"} {
+		assert.Equal(t, http.StatusNotFound, do(public, http.MethodGet, path, "").Code, path)
+	}
+}
+
+func TestServer_AdminAuthorization(t *testing.T) {
+	reqShutdown := make(chan bool, 1)
+	admin := NewAdminServer(reqShutdown, AdminOptions{
+		Metrics:   metrics.NewRegistry(),
+		LogLevels: logging.NewLevels(slog.LevelInfo),
+		Authentication: auth.Middleware(nil,
+			auth.NewStaticKey("admin-key", auth.Identity{Subject: "admin", Roles: []string{"admin"}}),
+			auth.NewStaticKey("reader-key", auth.Identity{Subject: "reader", Roles: []string{"reader"}}),
+		),
+		Authorizer: auth.DefaultPolicy(),
+	})
+
+	tests := []struct {
+		method string
+		path   string
+		key    string
+		code   int
+	}{
+		{http.MethodGet, "/heartbeat", "", http.StatusOK},
+		{http.MethodGet, "/ready", "", http.StatusOK},
+		{http.MethodGet, "/metrics", "", http.StatusOK},
+		{http.MethodGet, "/debug/pprof/", "", http.StatusUnauthorized},
+		{http.MethodGet, "/debug/pprof/", "reader-key", http.StatusForbidden},
+		{http.MethodGet, "/debug/pprof/", "admin-key", http.StatusOK},
+		{http.MethodPut, "/loglevels", "", http.StatusUnauthorized},
+		{http.MethodPut, "/loglevels", "reader-key", http.StatusForbidden},
+		{http.MethodPost, "/shutdown", "", http.StatusUnauthorized},
+		{http.MethodPost, "/shutdown", "reader-key", http.StatusForbidden},
+		{http.MethodPost, "/shutdown", "admin-key", http.StatusAccepted},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(tt.method, tt.path, nil)
+		if tt.key != "" {
+			req.Header.Set(auth.APIKeyHeader, tt.key)
+		}
+		rec := httptest.NewRecorder()
+		admin.ServeHTTP(rec, req)
+		assert.Equal(t, tt.code, rec.Code, "%s %s %s", tt.method, tt.path, tt.key)
+	}
+	assert.True(t, <-reqShutdown)
+}
@@ -0,0 +1,115 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Martin-Jast/go-microservice/auth"
+	"github.com/Martin-Jast/go-microservice/persistence"
+	"github.com/Martin-Jast/go-microservice/transformers"
+	"github.com/Martin-Jast/go-microservice/utils"
+	"github.com/gorilla/mux"
+)
+
+type apiKeyPort struct {
+	*mux.Router
+	keys *auth.APIKeyManager
+}
+
+func newAPIKeyPort(parent *mux.Router, keys *auth.APIKeyManager, authz auth.Authorizer) apiKeyPort {
+	router := parent.PathPrefix("/admin/apikeys").Subrouter()
+	handler := apiKeyPort{
+		router,
+		keys,
+	}
+
+	router.Path("/create").
+		Methods(http.MethodPost).HandlerFunc(authorize(authz, auth.OpAdminAPIKeys, handler.handleCreate))
+	router.Path("/revoke/{id}").
+		Methods(http.MethodPost).HandlerFunc(authorize(authz, auth.OpAdminAPIKeys, handler.handleRevoke))
+	router.Path("").
+		Methods(http.MethodGet).HandlerFunc(authorize(authz, auth.OpAdminAPIKeys, handler.handleList))
+
+	return handler
+}
+
+type createAPIKeyRequest struct {
+	Name      string
+	Roles     []string
+	ExpiresAt *time.Time
+}
+
+// Build parses the request into our internal structure
+func (cr *createAPIKeyRequest) Build(r *http.Request) error {
+	err := decodeBody(r, cr)
+	if err != nil && err != io.EOF {
+		return err
+	}
+	return nil
+}
+
+// Validate validates the request, should only check contract errors, never business logic
+func (cr *createAPIKeyRequest) Validate() error {
+	var missingParams []string
+	if cr.Name == "" {
+		missingParams = append(missingParams, "Name")
+	}
+	if len(cr.Roles) == 0 {
+		missingParams = append(missingParams, "Roles")
+	}
+	if len(missingParams) > 0 {
+		return fmt.Errorf("missing parameters: %s", strings.Join(missingParams, "; "))
+	}
+	return nil
+}
+
+// handleCreate creates a key for the tenant of the request, the plain key is only returned in this response
+func (h apiKeyPort) handleCreate(w http.ResponseWriter, r *http.Request) {
+	req := &createAPIKeyRequest{}
+	if err := req.Build(r); err != nil {
+		utils.WriteError(err, w, statusFromError(err, 400))
+		return
+	}
+	if err := req.Validate(); err != nil {
+		utils.WriteError(err, w, 400)
+		return
+	}
+	key, stored, err := h.keys.Create(r.Context(), req.Name, req.Roles, req.ExpiresAt)
+	if err != nil {
+		utils.WriteError(fmt.Errorf("could not create api key: %w", err), w, statusFromError(err, 500))
+		return
+	}
+
+	writeResponse(w, r, transformers.CreateAPIKeyResponse{APIKeyResponse: transformers.ToAPIKeyResponse(stored), Key: key}, 200)
+}
+
+// handleRevoke revokes a key of the tenant of the request
+func (h apiKeyPort) handleRevoke(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	err := h.keys.Revoke(r.Context(), id)
+	if errors.Is(err, persistence.ErrAPIKeyNotFound) {
+		utils.WriteError(err, w, 404)
+		return
+	}
+	if err != nil {
+		utils.WriteError(fmt.Errorf("could not revoke api key: %v", err), w, 500)
+		return
+	}
+
+	writeResponse(w, r, nil, 200)
+}
+
+// handleList lists the keys of the tenant of the request
+func (h apiKeyPort) handleList(w http.ResponseWriter, r *http.Request) {
+	keys, err := h.keys.List(r.Context())
+	if err != nil {
+		utils.WriteError(fmt.Errorf("could not list api keys: %v", err), w, 500)
+		return
+	}
+
+	writeResponse(w, r, transformers.ToAPIKeyResponseArray(keys), 200)
+}
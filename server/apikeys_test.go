@@ -0,0 +1,72 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Martin-Jast/go-microservice/auth"
+	"github.com/Martin-Jast/go-microservice/persistence"
+	"github.com/stretchr/testify/assert"
+)
+
+// stubKeyStore records the created keys and finds none, listing and revoking panic
+type stubKeyStore struct {
+	created []persistence.APIKey
+}
+
+func (s *stubKeyStore) CreateAPIKey(ctx context.Context, key persistence.APIKey) (string, error) {
+	s.created = append(s.created, key)
+	return key.Prefix, nil
+}
+func (s *stubKeyStore) GetAPIKeyByHash(ctx context.Context, hash string) (*persistence.APIKey, error) {
+	return nil, persistence.ErrAPIKeyNotFound
+}
+func (s *stubKeyStore) ListAPIKeys(ctx context.Context) ([]persistence.APIKey, error) {
+	panic("not stubbed")
+}
+func (s *stubKeyStore) RevokeAPIKey(ctx context.Context, id string, at time.Time) error {
+	panic("not stubbed")
+}
+
+func TestServer_APIKeyRoles(t *testing.T) {
+	policy := auth.DefaultPolicy()
+	policy.Roles["keymanager"] = []string{auth.OpAdminAPIKeys}
+	store := &stubKeyStore{}
+	keys := auth.NewAPIKeyManager(store)
+	keys.Policy = policy
+	router := NewServerWithOptions(stubService{}, Options{
+		Middlewares: []func(http.Handler) http.Handler{auth.Middleware(nil,
+			auth.NewStaticKey("manager-key", auth.Identity{Subject: "manager", Roles: []string{"keymanager"}}),
+			auth.NewStaticKey("admin-key", auth.Identity{Subject: "admin", Roles: []string{"admin"}}),
+		)},
+		APIKeys:    keys,
+		Authorizer: policy,
+	})
+
+	tests := []struct {
+		name string
+		key  string
+		body string
+		code int
+	}{
+		{name: "key manager creates an admin key", key: "manager-key", body: `{"Name":"escalated","Roles":["admin"]}`, code: http.StatusForbidden},
+		{name: "key manager creates a reader key", key: "manager-key", body: `{"Name":"reader","Roles":["reader"]}`, code: http.StatusForbidden},
+		{name: "key manager creates a key manager", key: "manager-key", body: `{"Name":"deputy","Roles":["keymanager"]}`, code: http.StatusOK},
+		{name: "admin creates an admin key", key: "admin-key", body: `{"Name":"admin","Roles":["admin"]}`, code: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/admin/apikeys/create", strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set(auth.APIKeyHeader, tt.key)
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+			assert.Equal(t, tt.code, rec.Code, rec.Body.String())
+		})
+	}
+	assert.Len(t, store.created, 2)
+}
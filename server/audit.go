@@ -0,0 +1,107 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Martin-Jast/go-microservice/audit"
+	"github.com/Martin-Jast/go-microservice/auth"
+	"github.com/Martin-Jast/go-microservice/persistence"
+	"github.com/Martin-Jast/go-microservice/transformers"
+	"github.com/Martin-Jast/go-microservice/utils"
+	"github.com/gorilla/mux"
+)
+
+type auditPort struct {
+	*mux.Router
+	log *audit.Log
+}
+
+func newAuditPort(parent *mux.Router, log *audit.Log, authz auth.Authorizer) auditPort {
+	router := parent.PathPrefix("/audit").Subrouter()
+	handler := auditPort{
+		router,
+		log,
+	}
+
+	router.Path("").
+		Methods(http.MethodGet).HandlerFunc(authorize(authz, auth.OpAuditRead, handler.handleList))
+	router.Path("/verify").
+		Methods(http.MethodGet).HandlerFunc(authorize(authz, auth.OpAuditRead, handler.handleVerify))
+
+	return handler
+}
+
+type listAuditRequest struct {
+	persistence.AuditFilter
+}
+
+// Build parses the query ( actor, document, from, to, limit ) into our internal structure
+func (lr *listAuditRequest) Build(r *http.Request) error {
+	query := r.URL.Query()
+	lr.Actor = query.Get("actor")
+	lr.DocumentID = query.Get("document")
+	var err error
+	if from := query.Get("from"); from != "" {
+		if lr.From, err = time.Parse(time.RFC3339, from); err != nil {
+			return fmt.Errorf("invalid from date sent: %s", from)
+		}
+	}
+	if to := query.Get("to"); to != "" {
+		if lr.To, err = time.Parse(time.RFC3339, to); err != nil {
+			return fmt.Errorf("invalid to date sent: %s", to)
+		}
+	}
+	if limit := query.Get("limit"); limit != "" {
+		if lr.Limit, err = strconv.ParseInt(limit, 10, 64); err != nil || lr.Limit < 0 {
+			return fmt.Errorf("invalid limit sent: %s", limit)
+		}
+	}
+	return nil
+}
+
+// Validate validates the request, should only check contract errors, never business logic
+func (lr *listAuditRequest) Validate() error {
+	if !lr.From.IsZero() && !lr.To.IsZero() && !lr.From.Before(lr.To) {
+		return fmt.Errorf("from must be before to")
+	}
+	return nil
+}
+
+// handleList lists the audit entries of the tenant of the request
+func (h auditPort) handleList(w http.ResponseWriter, r *http.Request) {
+	req := &listAuditRequest{}
+	if err := req.Build(r); err != nil {
+		utils.WriteError(err, w, 400)
+		return
+	}
+	if err := req.Validate(); err != nil {
+		utils.WriteError(err, w, 400)
+		return
+	}
+	entries, err := h.log.Query(r.Context(), req.AuditFilter)
+	if err != nil {
+		utils.WriteError(fmt.Errorf("could not list audit entries: %v", err), w, 500)
+		return
+	}
+
+	writeResponse(w, r, transformers.ToAuditEntryResponseArray(entries), 200)
+}
+
+// handleVerify checks the hash chain of the tenant of the request, only a broken chain is reported as invalid
+func (h auditPort) handleVerify(w http.ResponseWriter, r *http.Request) {
+	entries, err := h.log.Verify(r.Context())
+	if err != nil && !errors.Is(err, audit.ErrChainBroken) {
+		utils.WriteError(fmt.Errorf("could not verify audit chain: %w", err), w, statusFromError(err, 500))
+		return
+	}
+	resp := transformers.AuditVerifyResponse{Valid: err == nil, Entries: entries}
+	if err != nil {
+		resp.Error = err.Error()
+	}
+
+	writeResponse(w, r, resp, 200)
+}
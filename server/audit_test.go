@@ -0,0 +1,59 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Martin-Jast/go-microservice/audit"
+	"github.com/Martin-Jast/go-microservice/persistence"
+	"github.com/stretchr/testify/assert"
+)
+
+// stubAuditStore lists its entries or fails with err, appending panics
+type stubAuditStore struct {
+	entries []persistence.AuditEntry
+	err     error
+}
+
+func (s stubAuditStore) AppendAuditEntry(ctx context.Context, entry persistence.AuditEntry) error {
+	panic("not stubbed")
+}
+func (s stubAuditStore) LastAuditEntry(ctx context.Context) (*persistence.AuditEntry, error) {
+	panic("not stubbed")
+}
+func (s stubAuditStore) ListAuditEntries(ctx context.Context, filter persistence.AuditFilter) ([]persistence.AuditEntry, error) {
+	return s.entries, s.err
+}
+
+func TestServer_AuditVerify(t *testing.T) {
+	entry := persistence.AuditEntry{Seq: 1, Action: audit.ActionCreate}
+	entry.Hash = audit.Hash(entry)
+	tampered := entry
+	tampered.Actor = "mallory"
+
+	tests := []struct {
+		name  string
+		store stubAuditStore
+		code  int
+		body  string
+	}{
+		{name: "valid chain", store: stubAuditStore{entries: []persistence.AuditEntry{entry}}, code: http.StatusOK, body: `{"valid": true, "entries": 1}`},
+		{name: "tampered chain", store: stubAuditStore{entries: []persistence.AuditEntry{tampered}}, code: http.StatusOK, body: `{"valid": false, "entries": 0, "error": "audit chain broken at entry 1: hash does not match its content"}`},
+		{name: "store unavailable", store: stubAuditStore{err: errors.New("connection refused")}, code: http.StatusInternalServerError},
+		{name: "store timeout", store: stubAuditStore{err: context.DeadlineExceeded}, code: http.StatusGatewayTimeout},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := NewServerWithOptions(stubService{}, Options{Audit: audit.NewLog(tt.store)})
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/audit/verify", nil))
+			assert.Equal(t, tt.code, rec.Code)
+			if tt.body != "" {
+				assert.JSONEq(t, tt.body, rec.Body.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,50 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/Martin-Jast/go-microservice/auth"
+	"github.com/Martin-Jast/go-microservice/persistence"
+	"github.com/Martin-Jast/go-microservice/utils"
+)
+
+// authorize only lets through requests whose identity is allowed to perform operation, no check is done if authz is nil
+func authorize(authz auth.Authorizer, operation string, next http.HandlerFunc) http.HandlerFunc {
+	if authz == nil {
+		return next
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := authz.Authorize(r.Context(), operation); err != nil {
+			utils.WriteError(err, w, statusFromError(err, http.StatusForbidden))
+			return
+		}
+		next(w, r)
+	}
+}
+
+// statusFromError maps the known errors to their status code, fallback is used for the others
+func statusFromError(err error, fallback int) int {
+	switch {
+	case errors.Is(err, auth.ErrUnauthenticated):
+		return http.StatusUnauthorized
+	case errors.Is(err, auth.ErrForbidden):
+		return http.StatusForbidden
+	case errors.Is(err, persistence.ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, persistence.ErrAlreadyExists):
+		return http.StatusConflict
+	case errors.Is(err, context.DeadlineExceeded):
+		return http.StatusGatewayTimeout
+	case errors.Is(err, persistence.ErrCircuitOpen):
+		return http.StatusServiceUnavailable
+	case errors.Is(err, ErrUnsupportedMediaType):
+		return http.StatusUnsupportedMediaType
+	}
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return http.StatusRequestEntityTooLarge
+	}
+	return fallback
+}
@@ -0,0 +1,124 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Martin-Jast/go-microservice/compression"
+	"github.com/Martin-Jast/go-microservice/utils"
+)
+
+// AnyRoute is the key of the CachePolicies applied to the routes not listed
+const AnyRoute = "*"
+
+// CachePolicies are the Cache-Control values sent by the successful GET responses of each route template
+type CachePolicies map[string]string
+
+// DefaultCachePolicies lets clients keep the responses but revalidate them, a deleted document must not be served from a cache
+var DefaultCachePolicies = CachePolicies{AnyRoute: "private, no-cache"}
+
+// ParseCachePolicies parses "route=policy;route=policy", policies may contain commas ( e.g. "/base/{id}=private, max-age=60" )
+func ParseCachePolicies(value string) (CachePolicies, error) {
+	policies := CachePolicies{}
+	for _, part := range strings.Split(value, ";") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		route, policy, ok := strings.Cut(part, "=")
+		route = strings.TrimSpace(route)
+		if !ok || (route != AnyRoute && !strings.HasPrefix(route, "/")) {
+			return nil, fmt.Errorf("invalid cache policy %q, expected route=policy", part)
+		}
+		policies[route] = strings.TrimSpace(policy)
+	}
+	return policies, nil
+}
+
+// For returns the policy of a route template, empty when none applies
+func (p CachePolicies) For(route string) string {
+	if policy, ok := p[route]; ok {
+		return policy
+	}
+	return p[AnyRoute]
+}
+
+type cachePoliciesKey struct{}
+
+// withCachePolicies makes policies available to writeResponse
+func withCachePolicies(policies CachePolicies) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), cachePoliciesKey{}, policies)))
+		})
+	}
+}
+
+func cachePoliciesFromContext(ctx context.Context) CachePolicies {
+	policies, _ := ctx.Value(cachePoliciesKey{}).(CachePolicies)
+	return policies
+}
+
+// etag is a strong validator of a response body
+func etag(body []byte) string {
+	sum := sha256.Sum256(body)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// matchingETag returns the tag of an If-None-Match header matching current, the compression suffixes are ignored
+// since the body they stand for is the same once decoded
+func matchingETag(ifNoneMatch, current string) (string, bool) {
+	for _, tag := range strings.Split(ifNoneMatch, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" {
+			return current, true
+		}
+		if compression.BaseETag(strings.TrimPrefix(tag, "W/")) == current {
+			return tag, true
+		}
+	}
+	return "", false
+}
+
+// notModified tells if the client already has the response, If-Modified-Since is only used without If-None-Match
+func notModified(r *http.Request, tag string, lastModified time.Time) (string, bool) {
+	if ifNoneMatch := r.Header.Get("If-None-Match"); ifNoneMatch != "" {
+		return matchingETag(ifNoneMatch, tag)
+	}
+	if lastModified.IsZero() {
+		return tag, false
+	}
+	since, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	if err != nil {
+		return tag, false
+	}
+	// Last-Modified is sent with a precision of one second
+	return tag, !lastModified.Truncate(time.Second).After(since)
+}
+
+// writeCacheable sends the encoded body of a GET response with its validators, or 304 when the client has it already
+func writeCacheable(w http.ResponseWriter, r *http.Request, body []byte, lastModified time.Time) {
+	header := w.Header()
+	if policy := cachePoliciesFromContext(r.Context()).For(utils.RouteTemplate(r)); policy != "" {
+		header.Set("Cache-Control", policy)
+	}
+	tag := etag(body)
+	if !lastModified.IsZero() {
+		header.Set("Last-Modified", lastModified.UTC().Format(http.TimeFormat))
+	}
+	if matched, ok := notModified(r, tag, lastModified); ok {
+		// The tag the client holds, it may carry the suffix of a compressed response
+		header.Set("ETag", matched)
+		header.Del("Content-Type")
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	header.Set("ETag", tag)
+	w.WriteHeader(http.StatusOK)
+	w.Write(body)
+}
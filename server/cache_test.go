@@ -0,0 +1,123 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Martin-Jast/go-microservice/compression"
+	"github.com/Martin-Jast/go-microservice/persistence"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseCachePolicies(t *testing.T) {
+	policies, err := ParseCachePolicies("/base/{id}=private, max-age=60; *=no-store")
+	assert.NoError(t, err)
+	assert.Equal(t, "private, max-age=60", policies.For("/base/{id}"))
+	assert.Equal(t, "no-store", policies.For("/base/since/{date}"))
+
+	_, err = ParseCachePolicies("private, max-age=60")
+	assert.Error(t, err)
+}
+
+func TestServer_ConditionalGet(t *testing.T) {
+	createdAt := time.Date(2023, 7, 1, 10, 0, 0, 0, time.UTC)
+	service := stubService{
+		get: func(ctx context.Context, id string) (*persistence.BaseModel, error) {
+			return &persistence.BaseModel{ID: &id, Data: strings.Repeat("document ", 200), CreatedAt: &createdAt}, nil
+		},
+	}
+	router := NewServerWithOptions(service, Options{
+		Compression:   &compression.Options{MinSize: 1024},
+		CachePolicies: CachePolicies{"/base/{id}": "private, max-age=60"},
+	})
+	get := func(headers map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/base/1", nil)
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	first := get(nil)
+	assert.Equal(t, http.StatusOK, first.Code)
+	tag := first.Header().Get("ETag")
+	assert.Regexp(t, `^"[0-9a-f]{32}"$`, tag)
+	assert.Equal(t, "Sat, 01 Jul 2023 10:00:00 GMT", first.Header().Get("Last-Modified"))
+	assert.Equal(t, "private, max-age=60", first.Header().Get("Cache-Control"))
+	assert.Equal(t, tag, get(nil).Header().Get("ETag"), "the tag must be stable")
+
+	compressed := get(map[string]string{"Accept-Encoding": "gzip"})
+	gzipTag := compressed.Header().Get("ETag")
+	assert.Equal(t, strings.TrimSuffix(tag, `"`)+`-gzip"`, gzipTag)
+	xmlTag := get(map[string]string{"Accept": "application/xml"}).Header().Get("ETag")
+	assert.NotEqual(t, tag, xmlTag, "each representation has its own tag")
+
+	tests := []struct {
+		name         string
+		headers      map[string]string
+		expectedCode int
+		expectedTag  string
+	}{
+		{name: "matching tag", headers: map[string]string{"If-None-Match": tag}, expectedCode: http.StatusNotModified, expectedTag: tag},
+		{name: "one of the tags", headers: map[string]string{"If-None-Match": `"other", ` + tag}, expectedCode: http.StatusNotModified, expectedTag: tag},
+		{name: "weak tag", headers: map[string]string{"If-None-Match": "W/" + tag}, expectedCode: http.StatusNotModified, expectedTag: "W/" + tag},
+		{name: "tag of the compressed response", headers: map[string]string{"If-None-Match": gzipTag, "Accept-Encoding": "gzip"}, expectedCode: http.StatusNotModified, expectedTag: gzipTag},
+		{name: "any tag", headers: map[string]string{"If-None-Match": "*"}, expectedCode: http.StatusNotModified, expectedTag: tag},
+		{name: "tag of another representation", headers: map[string]string{"If-None-Match": xmlTag}, expectedCode: http.StatusOK, expectedTag: tag},
+		{name: "not modified since", headers: map[string]string{"If-Modified-Since": "Sat, 01 Jul 2023 10:00:00 GMT"}, expectedCode: http.StatusNotModified, expectedTag: tag},
+		{name: "modified since", headers: map[string]string{"If-Modified-Since": "Sat, 01 Jul 2023 09:59:59 GMT"}, expectedCode: http.StatusOK, expectedTag: tag},
+		{name: "tag wins over date", headers: map[string]string{"If-None-Match": `"other"`, "If-Modified-Since": "Sat, 01 Jul 2023 10:00:00 GMT"}, expectedCode: http.StatusOK, expectedTag: tag},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := get(tt.headers)
+			assert.Equal(t, tt.expectedCode, rec.Code)
+			if tt.headers["Accept-Encoding"] == "" {
+				assert.Equal(t, tt.expectedTag, rec.Header().Get("ETag"))
+			}
+			assert.Equal(t, "private, max-age=60", rec.Header().Get("Cache-Control"))
+			if tt.expectedCode == http.StatusNotModified {
+				assert.Empty(t, rec.Body.Bytes())
+			}
+		})
+	}
+}
+
+func TestServer_ConditionalList(t *testing.T) {
+	createdAt := time.Date(2023, 7, 1, 10, 0, 0, 0, time.UTC)
+	newest := createdAt.Add(time.Hour)
+	id1, id2 := "1", "2"
+	docs := []persistence.BaseModel{{ID: &id1, CreatedAt: &createdAt}, {ID: &id2, CreatedAt: &newest}}
+	service := stubService{
+		since: func(ctx context.Context, date time.Time) ([]persistence.BaseModel, error) {
+			return docs, nil
+		},
+	}
+	router := NewServerWithOptions(service, Options{})
+	get := func(headers map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/base/since/2023-01-01T00:00:00Z", nil)
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	first := get(nil)
+	assert.Equal(t, http.StatusOK, first.Code)
+	assert.Equal(t, "Sat, 01 Jul 2023 11:00:00 GMT", first.Header().Get("Last-Modified"), "the newest creation in the page")
+	tag := first.Header().Get("ETag")
+	assert.Equal(t, http.StatusNotModified, get(map[string]string{"If-None-Match": tag}).Code)
+
+	// A deletion leaves the newest creation as it was, only the ETag tells the change
+	docs = docs[1:]
+	assert.Equal(t, http.StatusOK, get(map[string]string{"If-Modified-Since": "Sat, 01 Jul 2023 11:00:00 GMT"}).Code)
+	assert.Equal(t, http.StatusOK, get(map[string]string{"If-None-Match": tag}).Code)
+}
@@ -0,0 +1,333 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Martin-Jast/go-microservice/utils"
+)
+
+// Codec serializes the responses and parses the request bodies of one format
+type Codec interface {
+	// ContentTypes are the media types of the format, the first one is sent as Content-Type
+	ContentTypes() []string
+	Encode(w io.Writer, v interface{}) error
+	Decode(r io.Reader, v interface{}) error
+}
+
+// ErrUnsupportedValue is returned by a Codec that can't represent a value ( e.g. CSV for a single document )
+var ErrUnsupportedValue = errors.New("value not supported by the format")
+
+// ErrUnsupportedMediaType is returned when no codec reads the Content-Type of a request
+var ErrUnsupportedMediaType = errors.New("unsupported media type")
+
+// Codecs is the registry of the formats the service speaks, the first registered is used when the client has no preference
+type Codecs struct {
+	mu     sync.RWMutex
+	codecs []Codec
+}
+
+// NewCodecs creates a registry with codecs
+func NewCodecs(codecs ...Codec) *Codecs {
+	c := &Codecs{}
+	for _, codec := range codecs {
+		c.Register(codec)
+	}
+	return c
+}
+
+// DefaultCodecs creates a registry with JSON ( the default ), XML, CSV and MessagePack
+func DefaultCodecs() *Codecs {
+	return NewCodecs(JSONCodec{}, XMLCodec{}, CSVCodec{}, MsgPackCodec{})
+}
+
+// Register adds codec, it replaces a codec registered before for the same media types
+func (c *Codecs) Register(codec Codec) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i, existing := range c.codecs {
+		if existing.ContentTypes()[0] == codec.ContentTypes()[0] {
+			c.codecs[i] = codec
+			return
+		}
+	}
+	c.codecs = append(c.codecs, codec)
+}
+
+// ForContentType returns the codec reading a Content-Type, JSON when it is missing or blank as before the codecs existed
+func (c *Codecs) ForContentType(contentType string) (Codec, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if strings.TrimSpace(contentType) == "" {
+		return JSONCodec{}, true
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return nil, false
+	}
+	for _, codec := range c.codecs {
+		for _, t := range codec.ContentTypes() {
+			if t == mediaType {
+				return codec, true
+			}
+		}
+	}
+	return nil, false
+}
+
+// acceptRange is a media range of an Accept header
+type acceptRange struct {
+	mediaType string
+	q         float64
+}
+
+// specificity ranks */* below type/* below a full media type
+func (a acceptRange) specificity() int {
+	return 2 - strings.Count(a.mediaType, "*")
+}
+
+// Negotiate lists the codecs acceptable for an Accept header, the preferred first
+// Each codec takes the weight of the most specific range matching it, so "*/*, application/xml;q=0" excludes XML
+func (c *Codecs) Negotiate(accept string) []Codec {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if strings.TrimSpace(accept) == "" {
+		return append([]Codec{}, c.codecs...)
+	}
+	ranges := []acceptRange{}
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+		ranges = append(ranges, acceptRange{mediaType, q})
+	}
+
+	type candidate struct {
+		codec Codec
+		best  acceptRange
+	}
+	candidates := []candidate{}
+	for _, codec := range c.codecs {
+		best, found := acceptRange{}, false
+		for _, r := range ranges {
+			if !matchesRange(codec, r.mediaType) {
+				continue
+			}
+			if !found || r.specificity() > best.specificity() || (r.specificity() == best.specificity() && r.q > best.q) {
+				best, found = r, true
+			}
+		}
+		if found && best.q > 0 {
+			candidates = append(candidates, candidate{codec, best})
+		}
+	}
+	// Among equal weights the more specific range wins, then the registration order
+	sort.SliceStable(candidates, func(i, j int) bool {
+		if candidates[i].best.q != candidates[j].best.q {
+			return candidates[i].best.q > candidates[j].best.q
+		}
+		return candidates[i].best.specificity() > candidates[j].best.specificity()
+	})
+	chosen := make([]Codec, len(candidates))
+	for i, candidate := range candidates {
+		chosen[i] = candidate.codec
+	}
+	return chosen
+}
+
+func matchesRange(codec Codec, mediaRange string) bool {
+	if mediaRange == "*/*" {
+		return true
+	}
+	for _, t := range codec.ContentTypes() {
+		if t == mediaRange {
+			return true
+		}
+		if prefix, ok := strings.CutSuffix(mediaRange, "/*"); ok && strings.HasPrefix(t, prefix+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+type codecsKey struct{}
+
+// withCodecs makes codecs available to the handlers
+func withCodecs(codecs *Codecs) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), codecsKey{}, codecs)))
+		})
+	}
+}
+
+func codecsFromContext(ctx context.Context) *Codecs {
+	if codecs, ok := ctx.Value(codecsKey{}).(*Codecs); ok {
+		return codecs
+	}
+	return DefaultCodecs()
+}
+
+// writeResponse sends data in the format the client prefers among those able to represent it, 406 if there is none
+// Errors are always sent as JSON with utils.WriteError
+func writeResponse(w http.ResponseWriter, r *http.Request, data interface{}, code int) {
+	writeModifiedResponse(w, r, data, code, time.Time{})
+}
+
+// writeModifiedResponse is writeResponse for data last changed at lastModified, zero if unknown
+// Successful GET responses carry an ETag and their Cache-Control policy and are answered with 304 when the client has them
+func writeModifiedResponse(w http.ResponseWriter, r *http.Request, data interface{}, code int, lastModified time.Time) {
+	if data == nil {
+		w.WriteHeader(code)
+		return
+	}
+	accept := r.Header.Get("Accept")
+	buf := &bytes.Buffer{}
+	for _, codec := range codecsFromContext(r.Context()).Negotiate(accept) {
+		buf.Reset()
+		err := codec.Encode(buf, data)
+		if errors.Is(err, ErrUnsupportedValue) {
+			continue
+		}
+		if err != nil {
+			utils.WriteError(fmt.Errorf("could not encode response: %v", err), w, http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", codec.ContentTypes()[0])
+		w.Header().Add("Vary", "Accept")
+		if r.Method == http.MethodGet && code == http.StatusOK {
+			writeCacheable(w, r, buf.Bytes(), lastModified)
+			return
+		}
+		w.WriteHeader(code)
+		w.Write(buf.Bytes())
+		return
+	}
+	utils.WriteError(fmt.Errorf("no acceptable format for the response: %s", accept), w, http.StatusNotAcceptable)
+}
+
+// decodeBody parses the body of r into v with the codec of its Content-Type
+// io.EOF is returned as is for empty bodies, a body over the size limit keeps its *http.MaxBytesError
+func decodeBody(r *http.Request, v interface{}) error {
+	codec, ok := codecsFromContext(r.Context()).ForContentType(r.Header.Get("Content-Type"))
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrUnsupportedMediaType, r.Header.Get("Content-Type"))
+	}
+	err := codec.Decode(r.Body, v)
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return fmt.Errorf("request body larger than %d bytes: %w", tooLarge.Limit, err)
+	}
+	if err == nil || err == io.EOF {
+		return err
+	}
+	return fmt.Errorf("invalid request")
+}
+
+// JSONCodec is the default format
+type JSONCodec struct{}
+
+func (JSONCodec) ContentTypes() []string { return []string{"application/json"} }
+
+func (JSONCodec) Encode(w io.Writer, v interface{}) error {
+	return json.NewEncoder(w).Encode(v)
+}
+
+func (JSONCodec) Decode(r io.Reader, v interface{}) error {
+	return json.NewDecoder(r).Decode(v)
+}
+
+// XMLCodec uses the Go field names as elements, lists are wrapped in an <items> element
+type XMLCodec struct{}
+
+func (XMLCodec) ContentTypes() []string { return []string{"application/xml", "text/xml"} }
+
+func (XMLCodec) Encode(w io.Writer, v interface{}) error {
+	enc := xml.NewEncoder(w)
+	value := reflect.ValueOf(v)
+	var err error
+	if value.Kind() == reflect.Slice || value.Kind() == reflect.Array {
+		err = encodeXMLList(enc, value)
+	} else {
+		err = enc.Encode(v)
+	}
+	var unsupported *xml.UnsupportedTypeError
+	if errors.As(err, &unsupported) {
+		return fmt.Errorf("%w: %v", ErrUnsupportedValue, err)
+	}
+	return err
+}
+
+// encodeXMLList writes the items of list as children of an <items> element
+func encodeXMLList(enc *xml.Encoder, list reflect.Value) error {
+	items := xml.StartElement{Name: xml.Name{Local: "items"}}
+	if err := enc.EncodeToken(items); err != nil {
+		return err
+	}
+	for i := 0; i < list.Len(); i++ {
+		if err := enc.Encode(list.Index(i).Interface()); err != nil {
+			return err
+		}
+	}
+	if err := enc.EncodeToken(items.End()); err != nil {
+		return err
+	}
+	return enc.Flush()
+}
+
+func (XMLCodec) Decode(r io.Reader, v interface{}) error {
+	value := reflect.ValueOf(v)
+	if value.Kind() == reflect.Ptr && value.Elem().Kind() == reflect.Slice {
+		return decodeXMLList(r, value.Elem())
+	}
+	return xml.NewDecoder(r).Decode(v)
+}
+
+// decodeXMLList reads the children of the root element as the items of list
+func decodeXMLList(r io.Reader, list reflect.Value) error {
+	dec := xml.NewDecoder(r)
+	depth := 0
+	for {
+		token, err := dec.Token()
+		if err != nil {
+			if err == io.EOF && depth == 0 && list.Len() == 0 {
+				return io.EOF
+			}
+			return err
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			if depth == 0 {
+				depth++
+				continue
+			}
+			item := reflect.New(list.Type().Elem())
+			if err := dec.DecodeElement(item.Interface(), &t); err != nil {
+				return err
+			}
+			list.Set(reflect.Append(list, item.Elem()))
+		case xml.EndElement:
+			return nil
+		}
+	}
+}
@@ -0,0 +1,192 @@
+package server
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+)
+
+// CSVCodec writes lists of documents as a header row named after the JSON fields and one row per item
+// Nested values are written as JSON, single values are not supported
+type CSVCodec struct{}
+
+func (CSVCodec) ContentTypes() []string { return []string{"text/csv"} }
+
+// csvField is a column of a CSV file, index is the path to the struct field
+type csvField struct {
+	name  string
+	index []int
+}
+
+// csvFields lists the columns of a struct type, the fields of embedded structs are columns of their own
+func csvFields(t reflect.Type) []csvField {
+	fields := []csvField{}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		name := f.Name
+		if tag, ok := f.Tag.Lookup("json"); ok {
+			tagName, _, _ := strings.Cut(tag, ",")
+			if tagName == "-" {
+				continue
+			}
+			if tagName != "" {
+				name = tagName
+			}
+		}
+		if f.Anonymous && f.Type.Kind() == reflect.Struct && f.Tag.Get("json") == "" {
+			for _, inner := range csvFields(f.Type) {
+				fields = append(fields, csvField{name: inner.name, index: append([]int{i}, inner.index...)})
+			}
+			continue
+		}
+		fields = append(fields, csvField{name: name, index: []int{i}})
+	}
+	return fields
+}
+
+// structType returns the struct type of the items of a list, following pointers
+func structType(t reflect.Type) (reflect.Type, bool) {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t, t.Kind() == reflect.Struct
+}
+
+// csvCell formats a value as its JSON, without the quotes of strings
+func csvCell(v reflect.Value) (string, error) {
+	b, err := json.Marshal(v.Interface())
+	if err != nil {
+		return "", err
+	}
+	if string(b) == "null" {
+		return "", nil
+	}
+	var s string
+	if json.Unmarshal(b, &s) == nil {
+		return s, nil
+	}
+	return string(b), nil
+}
+
+func (CSVCodec) Encode(w io.Writer, v interface{}) error {
+	list := reflect.ValueOf(v)
+	if list.Kind() != reflect.Slice && list.Kind() != reflect.Array {
+		return ErrUnsupportedValue
+	}
+	itemType, ok := structType(list.Type().Elem())
+	if !ok {
+		return ErrUnsupportedValue
+	}
+	fields := csvFields(itemType)
+	writer := csv.NewWriter(w)
+	header := make([]string, len(fields))
+	for i, f := range fields {
+		header[i] = f.name
+	}
+	if err := writer.Write(header); err != nil {
+		return err
+	}
+	for i := 0; i < list.Len(); i++ {
+		item := reflect.Indirect(list.Index(i))
+		row := make([]string, len(fields))
+		if item.IsValid() {
+			for j, f := range fields {
+				cell, err := csvCell(item.FieldByIndex(f.index))
+				if err != nil {
+					return err
+				}
+				row[j] = cell
+			}
+		}
+		if err := writer.Write(row); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// setCSVCell parses a cell into a struct field, strings are taken as they are and other values as JSON
+func setCSVCell(field reflect.Value, cell string) error {
+	if cell == "" {
+		return nil
+	}
+	if field.Kind() == reflect.String {
+		field.SetString(cell)
+		return nil
+	}
+	target := field.Addr().Interface()
+	if json.Valid([]byte(cell)) && json.Unmarshal([]byte(cell), target) == nil {
+		return nil
+	}
+	quoted, _ := json.Marshal(cell)
+	return json.Unmarshal(quoted, target)
+}
+
+// Decode reads a single item into a struct or every row into a list, columns are matched by name ignoring case
+func (CSVCodec) Decode(r io.Reader, v interface{}) error {
+	target := reflect.ValueOf(v)
+	if target.Kind() != reflect.Ptr {
+		return fmt.Errorf("csv decode needs a pointer")
+	}
+	target = target.Elem()
+	isList := target.Kind() == reflect.Slice
+	itemType := target.Type()
+	if isList {
+		itemType = itemType.Elem()
+	}
+	structT, ok := structType(itemType)
+	if !ok {
+		return ErrUnsupportedValue
+	}
+
+	reader := csv.NewReader(r)
+	header, err := reader.Read()
+	if err != nil {
+		return err
+	}
+	byName := map[string]csvField{}
+	for _, f := range csvFields(structT) {
+		byName[strings.ToLower(f.name)] = f
+	}
+	for {
+		row, err := reader.Read()
+		if err == io.EOF {
+			if !isList {
+				return io.EOF
+			}
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		item := reflect.New(structT).Elem()
+		for i, cell := range row {
+			if i >= len(header) {
+				break
+			}
+			f, ok := byName[strings.ToLower(strings.TrimSpace(header[i]))]
+			if !ok {
+				continue
+			}
+			if err := setCSVCell(item.FieldByIndex(f.index), cell); err != nil {
+				return fmt.Errorf("invalid value for %s: %v", f.name, err)
+			}
+		}
+		value := item
+		if itemType.Kind() == reflect.Ptr {
+			value = item.Addr()
+		}
+		if !isList {
+			target.Set(value)
+			return nil
+		}
+		target.Set(reflect.Append(target, value))
+	}
+}
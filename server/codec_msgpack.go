@@ -0,0 +1,275 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+)
+
+// MsgPackCodec writes the same document as the JSON codec in MessagePack, so the JSON field names and formats apply
+type MsgPackCodec struct{}
+
+func (MsgPackCodec) ContentTypes() []string {
+	return []string{"application/msgpack", "application/x-msgpack", "application/vnd.msgpack"}
+}
+
+func (MsgPackCodec) Encode(w io.Writer, v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	var generic interface{}
+	if err := unmarshalNumbers(b, &generic); err != nil {
+		return err
+	}
+	bw := bufio.NewWriter(w)
+	if err := writeMsgPack(bw, generic); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+func (MsgPackCodec) Decode(r io.Reader, v interface{}) error {
+	generic, err := readMsgPack(bufio.NewReader(r))
+	if err != nil {
+		return err
+	}
+	b, err := json.Marshal(generic)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+// unmarshalNumbers keeps the numbers as json.Number so integers are written as integers
+func unmarshalNumbers(b []byte, v interface{}) error {
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	return dec.Decode(v)
+}
+
+func writeMsgPack(w *bufio.Writer, v interface{}) error {
+	switch t := v.(type) {
+	case nil:
+		return w.WriteByte(0xc0)
+	case bool:
+		if t {
+			return w.WriteByte(0xc3)
+		}
+		return w.WriteByte(0xc2)
+	case json.Number:
+		if i, err := t.Int64(); err == nil {
+			return writeMsgPackInt(w, i)
+		}
+		f, err := t.Float64()
+		if err != nil {
+			return err
+		}
+		w.WriteByte(0xcb)
+		return binary.Write(w, binary.BigEndian, math.Float64bits(f))
+	case string:
+		n := len(t)
+		switch {
+		case n < 32:
+			w.WriteByte(0xa0 | byte(n))
+		case n <= math.MaxUint8:
+			w.Write([]byte{0xd9, byte(n)})
+		case n <= math.MaxUint16:
+			w.WriteByte(0xda)
+			binary.Write(w, binary.BigEndian, uint16(n))
+		default:
+			w.WriteByte(0xdb)
+			binary.Write(w, binary.BigEndian, uint32(n))
+		}
+		_, err := w.WriteString(t)
+		return err
+	case []interface{}:
+		writeMsgPackHeader(w, len(t), 0x90, 0xdc, 0xdd)
+		for _, item := range t {
+			if err := writeMsgPack(w, item); err != nil {
+				return err
+			}
+		}
+		return nil
+	case map[string]interface{}:
+		writeMsgPackHeader(w, len(t), 0x80, 0xde, 0xdf)
+		// Sorted so the same document is always encoded the same way
+		keys := make([]string, 0, len(t))
+		for k := range t {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			if err := writeMsgPack(w, k); err != nil {
+				return err
+			}
+			if err := writeMsgPack(w, t[k]); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return fmt.Errorf("msgpack: unexpected type %T", v)
+}
+
+// writeMsgPackHeader writes the size of an array or map with its fix, 16 or 32 bits form
+func writeMsgPackHeader(w *bufio.Writer, n int, fix, b16, b32 byte) {
+	switch {
+	case n < 16:
+		w.WriteByte(fix | byte(n))
+	case n <= math.MaxUint16:
+		w.WriteByte(b16)
+		binary.Write(w, binary.BigEndian, uint16(n))
+	default:
+		w.WriteByte(b32)
+		binary.Write(w, binary.BigEndian, uint32(n))
+	}
+}
+
+func writeMsgPackInt(w *bufio.Writer, i int64) error {
+	switch {
+	case i >= 0 && i < 128:
+		return w.WriteByte(byte(i))
+	case i < 0 && i >= -32:
+		return w.WriteByte(byte(int8(i)))
+	case i >= math.MinInt8 && i <= math.MaxInt8:
+		w.WriteByte(0xd0)
+		return w.WriteByte(byte(int8(i)))
+	case i >= math.MinInt16 && i <= math.MaxInt16:
+		w.WriteByte(0xd1)
+		return binary.Write(w, binary.BigEndian, int16(i))
+	case i >= math.MinInt32 && i <= math.MaxInt32:
+		w.WriteByte(0xd2)
+		return binary.Write(w, binary.BigEndian, int32(i))
+	}
+	w.WriteByte(0xd3)
+	return binary.Write(w, binary.BigEndian, i)
+}
+
+// maxMsgPackDepth bounds the nesting of the documents read
+const maxMsgPackDepth = 64
+
+func readMsgPack(r *bufio.Reader) (interface{}, error) {
+	return readMsgPackValue(r, 0)
+}
+
+func readMsgPackValue(r *bufio.Reader, depth int) (interface{}, error) {
+	if depth > maxMsgPackDepth {
+		return nil, fmt.Errorf("msgpack: document too deep")
+	}
+	b, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case b <= 0x7f:
+		return int64(b), nil
+	case b >= 0xe0:
+		return int64(int8(b)), nil
+	case b&0xe0 == 0xa0:
+		return readMsgPackString(r, int(b&0x1f))
+	case b&0xf0 == 0x90:
+		return readMsgPackArray(r, int(b&0x0f), depth)
+	case b&0xf0 == 0x80:
+		return readMsgPackMap(r, int(b&0x0f), depth)
+	}
+	switch b {
+	case 0xc0:
+		return nil, nil
+	case 0xc2:
+		return false, nil
+	case 0xc3:
+		return true, nil
+	case 0xcc, 0xcd, 0xce, 0xcf:
+		n, err := readMsgPackUint(r, 1<<(b-0xcc))
+		return n, err
+	case 0xd0, 0xd1, 0xd2, 0xd3:
+		size := 1 << (b - 0xd0)
+		n, err := readMsgPackUint(r, size)
+		if err != nil {
+			return nil, err
+		}
+		// Sign extend from the size read
+		shift := 64 - 8*size
+		return int64(n<<shift) >> shift, nil
+	case 0xca:
+		n, err := readMsgPackUint(r, 4)
+		return float64(math.Float32frombits(uint32(n))), err
+	case 0xcb:
+		n, err := readMsgPackUint(r, 8)
+		return math.Float64frombits(n), err
+	case 0xd9, 0xda, 0xdb, 0xc4, 0xc5, 0xc6:
+		// Binary values are read as strings, JSON has nothing closer
+		sizes := map[byte]int{0xd9: 1, 0xda: 2, 0xdb: 4, 0xc4: 1, 0xc5: 2, 0xc6: 4}
+		n, err := readMsgPackUint(r, sizes[b])
+		if err != nil {
+			return nil, err
+		}
+		return readMsgPackString(r, int(n))
+	case 0xdc, 0xdd:
+		n, err := readMsgPackUint(r, 2<<(b-0xdc))
+		if err != nil {
+			return nil, err
+		}
+		return readMsgPackArray(r, int(n), depth)
+	case 0xde, 0xdf:
+		n, err := readMsgPackUint(r, 2<<(b-0xde))
+		if err != nil {
+			return nil, err
+		}
+		return readMsgPackMap(r, int(n), depth)
+	}
+	return nil, fmt.Errorf("msgpack: unsupported type 0x%x", b)
+}
+
+func readMsgPackUint(r *bufio.Reader, size int) (uint64, error) {
+	buf := make([]byte, 8)
+	if _, err := io.ReadFull(r, buf[8-size:]); err != nil {
+		return 0, err
+	}
+	return binary.BigEndian.Uint64(buf), nil
+}
+
+func readMsgPackString(r *bufio.Reader, n int) (string, error) {
+	// The length comes from the client, the buffer grows with what is actually read so a false length can't allocate it all
+	buf := &bytes.Buffer{}
+	if _, err := io.CopyN(buf, r, int64(n)); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+func readMsgPackArray(r *bufio.Reader, n, depth int) ([]interface{}, error) {
+	list := make([]interface{}, 0, min(n, 1024))
+	for i := 0; i < n; i++ {
+		item, err := readMsgPackValue(r, depth+1)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, item)
+	}
+	return list, nil
+}
+
+func readMsgPackMap(r *bufio.Reader, n, depth int) (map[string]interface{}, error) {
+	m := make(map[string]interface{}, min(n, 1024))
+	for i := 0; i < n; i++ {
+		key, err := readMsgPackValue(r, depth+1)
+		if err != nil {
+			return nil, err
+		}
+		k, ok := key.(string)
+		if !ok {
+			return nil, fmt.Errorf("msgpack: map keys must be strings")
+		}
+		if m[k], err = readMsgPackValue(r, depth+1); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
@@ -0,0 +1,132 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Martin-Jast/go-microservice/persistence"
+	"github.com/Martin-Jast/go-microservice/transformers"
+	"github.com/Martin-Jast/go-microservice/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCodecs_Negotiate(t *testing.T) {
+	codecs := DefaultCodecs()
+	tests := []struct {
+		accept string
+		want   []string
+	}{
+		{accept: "", want: []string{"application/json", "application/xml", "text/csv", "application/msgpack"}},
+		{accept: "application/xml", want: []string{"application/xml"}},
+		{accept: "text/xml", want: []string{"application/xml"}},
+		{accept: "text/*", want: []string{"application/xml", "text/csv"}},
+		{accept: "text/csv, application/json;q=0.5", want: []string{"text/csv", "application/json"}},
+		{accept: "*/*;q=0.1, application/x-msgpack", want: []string{"application/msgpack", "application/json", "application/xml", "text/csv"}},
+		{accept: "*/*, application/xml;q=0", want: []string{"application/json", "text/csv", "application/msgpack"}},
+		{accept: "image/png", want: []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.accept, func(t *testing.T) {
+			got := []string{}
+			for _, codec := range codecs.Negotiate(tt.accept) {
+				got = append(got, codec.ContentTypes()[0])
+			}
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestCodecs_RoundTrip(t *testing.T) {
+	createdAt := time.Date(2023, 7, 1, 10, 0, 0, 0, time.UTC)
+	docs := []transformers.BaseModelResponse{
+		{ID: utils.StrPnt("1"), TenantID: "acme", Data: "first, with a comma", CreatedAt: createdAt},
+		{ID: utils.StrPnt("2"), TenantID: "acme", Data: "second", CreatedBy: "key-1", CreatedAt: createdAt, DeletedAt: &createdAt},
+	}
+	for _, codec := range DefaultCodecs().Negotiate("") {
+		t.Run(codec.ContentTypes()[0], func(t *testing.T) {
+			buf := &bytes.Buffer{}
+			assert.NoError(t, codec.Encode(buf, docs))
+			got := []transformers.BaseModelResponse{}
+			assert.NoError(t, codec.Decode(buf, &got))
+			assert.Equal(t, docs, got)
+
+			buf.Reset()
+			err := codec.Encode(buf, docs[1])
+			if _, isCSV := codec.(CSVCodec); isCSV {
+				assert.ErrorIs(t, err, ErrUnsupportedValue)
+				return
+			}
+			assert.NoError(t, err)
+			single := transformers.BaseModelResponse{}
+			assert.NoError(t, codec.Decode(buf, &single))
+			assert.Equal(t, docs[1], single)
+		})
+	}
+}
+
+func TestServer_ContentNegotiation(t *testing.T) {
+	createdAt := time.Date(2023, 7, 1, 10, 0, 0, 0, time.UTC)
+	var created string
+	service := stubService{
+		create: func(ctx context.Context, data string) (string, error) {
+			created = data
+			return "1", nil
+		},
+		get: func(ctx context.Context, id string) (*persistence.BaseModel, error) {
+			return &persistence.BaseModel{ID: &id, Data: "document", CreatedAt: &createdAt}, nil
+		},
+	}
+	router := NewServerWithOptions(service, Options{})
+
+	tests := []struct {
+		name                string
+		method              string
+		path                string
+		accept              string
+		contentType         string
+		body                []byte
+		expectedCode        int
+		expectedContentType string
+		expectedCreated     string
+	}{
+		{name: "json by default", method: http.MethodGet, path: "/base/1", expectedCode: http.StatusOK, expectedContentType: "application/json"},
+		{name: "xml", method: http.MethodGet, path: "/base/1", accept: "application/xml", expectedCode: http.StatusOK, expectedContentType: "application/xml"},
+		{name: "msgpack", method: http.MethodGet, path: "/base/1", accept: "application/msgpack", expectedCode: http.StatusOK, expectedContentType: "application/msgpack"},
+		{name: "csv of a single document", method: http.MethodGet, path: "/base/1", accept: "text/csv", expectedCode: http.StatusNotAcceptable, expectedContentType: "application/json"},
+		{name: "csv falls back to json", method: http.MethodGet, path: "/base/1", accept: "text/csv, application/json;q=0.1", expectedCode: http.StatusOK, expectedContentType: "application/json"},
+		{name: "create from csv", method: http.MethodPost, path: "/base/create", contentType: "text/csv", body: []byte("Data\nfrom csv\n"), expectedCode: http.StatusOK, expectedContentType: "application/json", expectedCreated: "from csv"},
+		{name: "create from xml", method: http.MethodPost, path: "/base/create", contentType: "application/xml; charset=utf-8", body: []byte("<createBaseDocumentRequest><Data>from xml</Data></createBaseDocumentRequest>"), expectedCode: http.StatusOK, expectedContentType: "application/json", expectedCreated: "from xml"},
+		{name: "create from msgpack", method: http.MethodPost, path: "/base/create", contentType: "application/msgpack", body: []byte("\x81\xa4Data\xa8from msg"), expectedCode: http.StatusOK, expectedContentType: "application/json", expectedCreated: "from msg"},
+		{name: "create without content type", method: http.MethodPost, path: "/base/create", body: []byte(`{"Data": "from json"}`), expectedCode: http.StatusOK, expectedContentType: "application/json", expectedCreated: "from json"},
+		{name: "create with a blank content type", method: http.MethodPost, path: "/base/create", contentType: " ", body: []byte(`{"Data": "from json"}`), expectedCode: http.StatusOK, expectedContentType: "application/json", expectedCreated: "from json"},
+		{name: "unknown content type", method: http.MethodPost, path: "/base/create", contentType: "text/plain", body: []byte("Data"), expectedCode: http.StatusUnsupportedMediaType, expectedContentType: "application/json"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			created = ""
+			req := httptest.NewRequest(tt.method, tt.path, bytes.NewReader(tt.body))
+			if tt.accept != "" {
+				req.Header.Set("Accept", tt.accept)
+			}
+			if tt.contentType != "" {
+				req.Header.Set("Content-Type", tt.contentType)
+			}
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+			assert.Equal(t, tt.expectedCode, rec.Code)
+			assert.Equal(t, tt.expectedContentType, rec.Header().Get("Content-Type"))
+			assert.Equal(t, tt.expectedCreated, created)
+			if tt.expectedCode == http.StatusOK && tt.method == http.MethodGet {
+				codec, _ := DefaultCodecs().ForContentType(tt.expectedContentType)
+				doc := transformers.BaseModelResponse{}
+				assert.NoError(t, codec.Decode(strings.NewReader(rec.Body.String()), &doc))
+				assert.Equal(t, "document", doc.Data)
+			}
+		})
+	}
+}
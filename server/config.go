@@ -0,0 +1,49 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/Martin-Jast/go-microservice/auth"
+	"github.com/Martin-Jast/go-microservice/config"
+	"github.com/Martin-Jast/go-microservice/utils"
+	"github.com/gorilla/mux"
+)
+
+type configPort struct {
+	*mux.Router
+	reloader *config.Reloader
+}
+
+func newConfigPort(parent *mux.Router, reloader *config.Reloader, authz auth.Authorizer) configPort {
+	router := parent.PathPrefix("/config").Subrouter()
+	handler := configPort{
+		router,
+		reloader,
+	}
+
+	router.Path("").
+		Methods(http.MethodGet).HandlerFunc(authorize(authz, auth.OpAdminConfig, handler.handleGet))
+	router.Path("/reload").
+		Methods(http.MethodPost).HandlerFunc(authorize(authz, auth.OpAdminConfig, handler.handleReload))
+
+	return handler
+}
+
+type configResponse struct {
+	config.Version
+	Config map[string]interface{} `json:"config"`
+}
+
+// handleGet returns the version of the configuration in use and its settings, with the secrets masked
+func (h configPort) handleGet(w http.ResponseWriter, r *http.Request) {
+	writeResponse(w, r, configResponse{h.reloader.Version(), h.reloader.Current().Masked()}, 200)
+}
+
+// handleReload loads the configuration again, as a SIGHUP does
+func (h configPort) handleReload(w http.ResponseWriter, r *http.Request) {
+	if err := h.reloader.Reload(r.Context()); err != nil {
+		utils.WriteError(err, w, http.StatusUnprocessableEntity)
+		return
+	}
+	writeResponse(w, r, h.reloader.Version(), 200)
+}
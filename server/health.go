@@ -0,0 +1,48 @@
+package server
+
+import (
+	"context"
+	"net/http"
+)
+
+// ReadinessCheck reports if a dependency can serve requests, its details are shown on /ready
+type ReadinessCheck struct {
+	Name  string
+	Check func(ctx context.Context) (details interface{}, err error)
+}
+
+type checkResult struct {
+	Status  string      `json:"status"`
+	Error   string      `json:"error,omitempty"`
+	Details interface{} `json:"details,omitempty"`
+}
+
+type readinessResponse struct {
+	Status string                 `json:"status"`
+	Checks map[string]checkResult `json:"checks"`
+}
+
+// handleHeartbeat tells the process is up, it checks nothing else
+func handleHeartbeat(w http.ResponseWriter, r *http.Request) {
+	writeResponse(w, r, map[string]string{"status": "ok"}, 200)
+}
+
+// handleReady runs every check, the replica is ready only when all of them pass
+func handleReady(checks []ReadinessCheck) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		response := readinessResponse{Status: "ready", Checks: map[string]checkResult{}}
+		code := http.StatusOK
+		for _, check := range checks {
+			details, err := check.Check(r.Context())
+			result := checkResult{Status: "ok", Details: details}
+			if err != nil {
+				result.Status = "failed"
+				result.Error = err.Error()
+				response.Status = "unavailable"
+				code = http.StatusServiceUnavailable
+			}
+			response.Checks[check.Name] = result
+		}
+		writeResponse(w, r, response, code)
+	}
+}
@@ -0,0 +1,51 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Martin-Jast/go-microservice/persistence"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServer_Health(t *testing.T) {
+	var persistenceErr error
+	router := NewAdminServer(make(chan bool), AdminOptions{
+		ReadinessChecks: []ReadinessCheck{
+			{Name: "mongodb", Check: func(ctx context.Context) (interface{}, error) { return nil, nil }},
+			{Name: "persistence", Check: func(ctx context.Context) (interface{}, error) {
+				return map[string]string{"GetByID": "open"}, persistenceErr
+			}},
+		},
+	})
+	get := func(path string) (*httptest.ResponseRecorder, readinessResponse) {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		response := readinessResponse{}
+		json.Unmarshal(rec.Body.Bytes(), &response)
+		return rec, response
+	}
+
+	rec, _ := get("/heartbeat")
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	rec, response := get("/ready")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "ready", response.Status)
+	assert.Equal(t, "ok", response.Checks["mongodb"].Status)
+
+	persistenceErr = errors.New("circuit open for [GetByID]")
+	rec, response = get("/ready")
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	assert.Equal(t, "unavailable", response.Status)
+	assert.Equal(t, "failed", response.Checks["persistence"].Status)
+	assert.Equal(t, map[string]interface{}{"GetByID": "open"}, response.Checks["persistence"].Details)
+}
+
+func TestStatusFromError_CircuitOpen(t *testing.T) {
+	assert.Equal(t, http.StatusServiceUnavailable, statusFromError(persistence.ErrCircuitOpen, 500))
+}
@@ -44,8 +44,7 @@ func createTestHandler() (*testHandler, context.Context) {
 
 func (th testHandler) createHTTPExpect(t *testing.T) *httpexpect.Expect {
 	service := th.application
-	reqShutdown  := make(chan bool)
-	handler := NewServer(service, reqShutdown, nil)
+	handler := NewServer(service, nil)
 
 	api := mux.NewRouter()
 	api.PathPrefix("/").Handler(handler)
@@ -0,0 +1,141 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Martin-Jast/go-microservice/logging"
+	"github.com/Martin-Jast/go-microservice/utils"
+)
+
+// Limits bounds the time and the body size of every request, routes are keyed by their template ( e.g. /base/since/{date} )
+// A zero value means no limit
+type Limits struct {
+	Timeout           time.Duration
+	RouteTimeouts     map[string]time.Duration
+	MaxBodyBytes      int64
+	RouteMaxBodyBytes map[string]int64
+}
+
+// timeout returns the deadline of route
+func (l Limits) timeout(route string) time.Duration {
+	if d, ok := l.RouteTimeouts[route]; ok {
+		return d
+	}
+	return l.Timeout
+}
+
+// maxBodyBytes returns the body limit of route
+func (l Limits) maxBodyBytes(route string) int64 {
+	if n, ok := l.RouteMaxBodyBytes[route]; ok {
+		return n
+	}
+	return l.MaxBodyBytes
+}
+
+// MaxTimeout is the longest deadline of any route, the server write timeout must not be shorter
+func (l Limits) MaxTimeout() time.Duration {
+	longest := l.Timeout
+	for _, d := range l.RouteTimeouts {
+		if d > longest {
+			longest = d
+		}
+	}
+	return longest
+}
+
+// ParseRouteTimeouts parses a list as "/base/since/{date}=30s,/base/create=5s"
+func ParseRouteTimeouts(s string) (map[string]time.Duration, error) {
+	timeouts := map[string]time.Duration{}
+	err := parseRouteList(s, func(route, value string) error {
+		d, err := time.ParseDuration(value)
+		if err != nil || d < 0 {
+			return fmt.Errorf("invalid timeout for %s: %s", route, value)
+		}
+		timeouts[route] = d
+		return nil
+	})
+	return timeouts, err
+}
+
+// ParseRouteSizes parses a list of body limits in bytes as "/base/create=1048576"
+func ParseRouteSizes(s string) (map[string]int64, error) {
+	sizes := map[string]int64{}
+	err := parseRouteList(s, func(route, value string) error {
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || n < 0 {
+			return fmt.Errorf("invalid body limit for %s: %s", route, value)
+		}
+		sizes[route] = n
+		return nil
+	})
+	return sizes, err
+}
+
+func parseRouteList(s string, set func(route, value string) error) error {
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		route, value, ok := strings.Cut(entry, "=")
+		if !ok || route == "" {
+			return fmt.Errorf("invalid route entry: %s", entry)
+		}
+		if err := set(strings.TrimSpace(route), strings.TrimSpace(value)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// recoverPanics turns a panic of any handler into a 500 error envelope, the stack is only logged
+func recoverPanics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			rec := recover()
+			if rec == nil {
+				return
+			}
+			// The handler asked to abort the response, let net/http do it
+			if rec == http.ErrAbortHandler {
+				panic(rec)
+			}
+			logging.For(r.Context(), "server").ErrorContext(r.Context(), "panic serving request",
+				"route", utils.RouteTemplate(r),
+				"error", fmt.Sprint(rec),
+				"stack", string(debug.Stack()),
+			)
+			utils.WriteError(fmt.Errorf("internal server error"), w, http.StatusInternalServerError)
+		}()
+		next.ServeHTTP(w, r)
+	})
+}
+
+// limitRequests sets the deadline of the route in the request context and bounds the size of its body
+// The deadline is not enforced on the handler, every call made with the context fails once it expires
+func limitRequests(limits Limits) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			route := utils.RouteTemplate(r)
+			if timeout := limits.timeout(route); timeout > 0 {
+				ctx, cancel := context.WithTimeout(r.Context(), timeout)
+				defer cancel()
+				r = r.WithContext(ctx)
+			}
+			if limit := limits.maxBodyBytes(route); limit > 0 && r.Body != nil {
+				if r.ContentLength > limit {
+					utils.WriteError(fmt.Errorf("request body larger than %d bytes", limit), w, http.StatusRequestEntityTooLarge)
+					return
+				}
+				r.Body = http.MaxBytesReader(w, r.Body, limit)
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
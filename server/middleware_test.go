@@ -0,0 +1,171 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Martin-Jast/go-microservice/auth"
+	"github.com/Martin-Jast/go-microservice/persistence"
+	"github.com/Martin-Jast/go-microservice/ratelimit"
+	"github.com/Martin-Jast/go-microservice/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+// stubService answers with its funcs, methods without one panic
+type stubService struct {
+	create func(ctx context.Context, data string) (string, error)
+	get    func(ctx context.Context, id string) (*persistence.BaseModel, error)
+	since  func(ctx context.Context, date time.Time) ([]persistence.BaseModel, error)
+}
+
+func (s stubService) CreateBaseDocument(ctx context.Context, data string) (string, error) {
+	return s.create(ctx, data)
+}
+func (s stubService) DeleteBaseDocument(ctx context.Context, id string) error { panic("not stubbed") }
+func (s stubService) GetBaseDocumentByID(ctx context.Context, id string) (*persistence.BaseModel, error) {
+	return s.get(ctx, id)
+}
+func (s stubService) GetAllCreatedSince(ctx context.Context, date time.Time) ([]persistence.BaseModel, error) {
+	return s.since(ctx, date)
+}
+func (s stubService) GetBaseDocumentHistory(ctx context.Context, id string) ([]persistence.Revision, error) {
+	panic("not stubbed")
+}
+func (s stubService) GetBaseDocumentAsOf(ctx context.Context, id string, asOf time.Time) (*persistence.BaseModel, error) {
+	panic("not stubbed")
+}
+
+func TestMiddleware_Limits(t *testing.T) {
+	var deadline time.Duration
+	service := stubService{
+		create: func(ctx context.Context, data string) (string, error) {
+			return "1", nil
+		},
+		get: func(ctx context.Context, id string) (*persistence.BaseModel, error) {
+			if id == "panic" {
+				panic("boom")
+			}
+			if d, ok := ctx.Deadline(); ok {
+				deadline = time.Until(d)
+			}
+			if id == "slow" {
+				<-ctx.Done()
+				return nil, ctx.Err()
+			}
+			return &persistence.BaseModel{ID: &id}, nil
+		},
+	}
+	router := NewServerWithOptions(service, Options{Limits: Limits{
+		Timeout:           time.Minute,
+		RouteTimeouts:     map[string]time.Duration{"/base/{id}": 50 * time.Millisecond},
+		MaxBodyBytes:      16,
+		RouteMaxBodyBytes: map[string]int64{"/admin/apikeys/create": 1 << 10},
+	}})
+
+	tests := []struct {
+		name         string
+		method       string
+		path         string
+		body         string
+		expectedCode int
+	}{
+		{name: "panic is recovered", method: http.MethodGet, path: "/base/panic", expectedCode: http.StatusInternalServerError},
+		{name: "deadline exceeded", method: http.MethodGet, path: "/base/slow", expectedCode: http.StatusGatewayTimeout},
+		{name: "body within limit", method: http.MethodPost, path: "/base/create", body: `{"Data":"a"}`, expectedCode: http.StatusOK},
+		{name: "body over limit", method: http.MethodPost, path: "/base/create", body: `{"Data":"a longer document"}`, expectedCode: http.StatusRequestEntityTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+			assert.Equal(t, tt.expectedCode, rec.Code)
+			if tt.expectedCode >= 400 {
+				resp := utils.ErrorResponse{}
+				assert.NoError(t, json.NewDecoder(rec.Body).Decode(&resp))
+				assert.Equal(t, tt.expectedCode, resp.Status)
+				assert.NotEmpty(t, resp.RequestID)
+				assert.NotContains(t, resp.Error, "boom")
+			}
+		})
+	}
+
+	// A chunked body has no length, the limit is enforced while reading
+	req := httptest.NewRequest(http.MethodPost, "/base/create", strings.NewReader(`{"Data":"a longer document"}`))
+	req.ContentLength = -1
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, rec.Code)
+
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/base/1", nil))
+	assert.InDelta(t, 50*time.Millisecond, deadline, float64(50*time.Millisecond))
+}
+
+func TestParseRouteTimeouts(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		want    map[string]time.Duration
+		wantErr bool
+	}{
+		{name: "empty", value: "", want: map[string]time.Duration{}},
+		{
+			name:  "several routes",
+			value: "/base/since/{date}=60s, /base/create=5s",
+			want:  map[string]time.Duration{"/base/since/{date}": time.Minute, "/base/create": 5 * time.Second},
+		},
+		{name: "invalid duration", value: "/base/create=soon", wantErr: true},
+		{name: "missing route", value: "=5s", wantErr: true},
+		{name: "missing value", value: "/base/create", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseRouteTimeouts(tt.value)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestMiddleware_AddressLimit(t *testing.T) {
+	store := ratelimit.NewMemoryStore()
+	rule := ratelimit.Rule{Route: ratelimit.AnyRoute, Key: ratelimit.KeyIP, Limit: 3, Period: ratelimit.Duration(time.Minute)}
+	addressLimiter, err := ratelimit.NewLimiter(store, []ratelimit.Rule{rule})
+	assert.NoError(t, err)
+	addressLimiter.Prefix = "address|"
+	rateLimiter, err := ratelimit.NewLimiter(store, []ratelimit.Rule{rule})
+	assert.NoError(t, err)
+	router := NewServerWithOptions(stubService{
+		get: func(ctx context.Context, id string) (*persistence.BaseModel, error) {
+			createdAt := time.Now()
+			return &persistence.BaseModel{ID: &id, CreatedAt: &createdAt}, nil
+		},
+	}, Options{
+		Middlewares:    []func(http.Handler) http.Handler{auth.Middleware(nil, auth.NewStaticKey("valid-key", auth.Identity{Subject: "reader", Roles: []string{"reader"}}))},
+		RateLimiter:    rateLimiter,
+		AddressLimiter: addressLimiter,
+	})
+	do := func(key string) int {
+		req := httptest.NewRequest(http.MethodGet, "/base/1", nil)
+		req.Header.Set(auth.APIKeyHeader, key)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	// Invalid credentials take from the bucket of the address, the buckets of the two limiters are kept apart
+	assert.Equal(t, http.StatusUnauthorized, do("invalid-key"))
+	assert.Equal(t, http.StatusUnauthorized, do("invalid-key"))
+	assert.Equal(t, http.StatusOK, do("valid-key"))
+	assert.Equal(t, http.StatusTooManyRequests, do("invalid-key"))
+	assert.Equal(t, http.StatusTooManyRequests, do("valid-key"))
+}
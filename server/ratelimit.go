@@ -0,0 +1,79 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/Martin-Jast/go-microservice/auth"
+	"github.com/Martin-Jast/go-microservice/ratelimit"
+	"github.com/Martin-Jast/go-microservice/utils"
+	"github.com/gorilla/mux"
+)
+
+type rateLimitPort struct {
+	*mux.Router
+	limiter *ratelimit.Limiter
+}
+
+func newRateLimitPort(parent *mux.Router, limiter *ratelimit.Limiter, authz auth.Authorizer) rateLimitPort {
+	router := parent.PathPrefix("/admin/ratelimits").Subrouter()
+	handler := rateLimitPort{
+		router,
+		limiter,
+	}
+
+	router.Path("").
+		Methods(http.MethodGet).HandlerFunc(authorize(authz, auth.OpAdminRateLimits, handler.handleList))
+	router.Path("").
+		Methods(http.MethodPut).HandlerFunc(authorize(authz, auth.OpAdminRateLimits, handler.handleSet))
+
+	return handler
+}
+
+type setRateLimitsRequest struct {
+	Rules []ratelimit.Rule
+}
+
+// Build parses the request, a list of rules, into our internal structure
+func (sr *setRateLimitsRequest) Build(r *http.Request) error {
+	err := decodeBody(r, &sr.Rules)
+	if err == io.EOF {
+		return fmt.Errorf("invalid request")
+	}
+	return err
+}
+
+// Validate validates the request, should only check contract errors, never business logic
+func (sr *setRateLimitsRequest) Validate() error {
+	for _, rule := range sr.Rules {
+		if err := rule.Validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// handleList lists the rules in use
+func (h rateLimitPort) handleList(w http.ResponseWriter, r *http.Request) {
+	writeResponse(w, r, h.limiter.Rules(), 200)
+}
+
+// handleSet replaces the rules of this replica only, they are not persisted and the next configuration reload replaces them
+func (h rateLimitPort) handleSet(w http.ResponseWriter, r *http.Request) {
+	req := &setRateLimitsRequest{}
+	if err := req.Build(r); err != nil {
+		utils.WriteError(err, w, statusFromError(err, 400))
+		return
+	}
+	if err := req.Validate(); err != nil {
+		utils.WriteError(err, w, 400)
+		return
+	}
+	if err := h.limiter.SetRules(req.Rules); err != nil {
+		utils.WriteError(err, w, 400)
+		return
+	}
+
+	writeResponse(w, r, h.limiter.Rules(), 200)
+}
@@ -1,28 +1,137 @@
 package server
 
 import (
-	"fmt"
+	"log/slog"
 	"net/http"
 
 	"github.com/Martin-Jast/go-microservice/application"
+	"github.com/Martin-Jast/go-microservice/audit"
+	"github.com/Martin-Jast/go-microservice/auth"
+	"github.com/Martin-Jast/go-microservice/compression"
+	"github.com/Martin-Jast/go-microservice/cors"
+	"github.com/Martin-Jast/go-microservice/features"
+	"github.com/Martin-Jast/go-microservice/logging"
+	"github.com/Martin-Jast/go-microservice/metrics"
+	"github.com/Martin-Jast/go-microservice/ratelimit"
+	"github.com/Martin-Jast/go-microservice/requestid"
+	"github.com/Martin-Jast/go-microservice/tracing"
 	"github.com/gorilla/mux"
 )
 
+// Options holds the optional parts of the server, the zero value gives a server with only the base routes
+type Options struct {
+	// Middlewares are applied to every route in the given order
+	Middlewares []func(http.Handler) http.Handler
+	// APIKeys enables the /admin/apikeys endpoints
+	APIKeys *auth.APIKeyManager
+	// Authorizer checks the operation of every route, routes are not checked if nil
+	Authorizer auth.Authorizer
+	// Audit enables the /audit endpoints
+	Audit *audit.Log
+	// Metrics enables the HTTP metrics, they are served by the admin server
+	Metrics *metrics.Registry
+	// Tracer enables a server span for every request and the propagation of the W3C trace context
+	Tracer *tracing.Tracer
+	// Logger is passed to the handlers through the request context and writes the access logs
+	Logger *slog.Logger
+	// Limits sets the deadline and the body limit of the routes
+	Limits Limits
+	// RateLimiter limits the requests of each client and enables the /admin/ratelimits endpoints
+	RateLimiter *ratelimit.Limiter
+	// AddressLimiter limits the requests of each client address before the authentication, so invalid credentials are limited too
+	AddressLimiter *ratelimit.Limiter
+	// CORS lets the allowed browser origins call the routes and answers their preflights, its options can change while the server runs
+	CORS *cors.Policy
+	// Compression compresses the responses and accepts compressed bodies on the routes it lists
+	Compression *compression.Options
+	// Codecs are the formats of the responses and request bodies, DefaultCodecs if nil
+	Codecs *Codecs
+	// CachePolicies are the Cache-Control of the GET responses of each route, DefaultCachePolicies if nil
+	CachePolicies CachePolicies
+	// Features are passed to the handlers through the request context
+	Features *features.Flags
+}
+
 // New creates a new router
-func NewServer(service application.IService, reqShutdown chan bool, middleware func(http.Handler) http.Handler) *mux.Router {
+func NewServer(service application.IService, middleware func(http.Handler) http.Handler) *mux.Router {
+	opts := Options{}
+	if middleware != nil {
+		opts.Middlewares = append(opts.Middlewares, middleware)
+	}
+	return NewServerWithOptions(service, opts)
+}
+
+// NewServerWithOptions creates a new router with the optional parts defined in opts
+// The operational routes ( health, metrics, configuration, shutdown ) are served by NewAdminServer
+func NewServerWithOptions(service application.IService, opts Options) *mux.Router {
 	router := mux.NewRouter()
-	// router.HandleFunc("/heartbeat", h.HealthzHandler)
-	router.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {fmt.Println("arrived")})
+	router.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {logging.For(r.Context(), "server").DebugContext(r.Context(), "arrived")})
 
+	// Measure first so rejected requests are counted as well
+	if opts.Metrics != nil {
+		router.Use(metrics.NewHTTPMetrics(opts.Metrics).Middleware)
+	}
+	// The span wraps everything after the metrics so the trace id is set before any error is written
+	if opts.Tracer != nil {
+		router.Use(tracing.Middleware(opts.Tracer))
+	}
+	// Every request gets an id, used to correlate audit entries and errors
+	router.Use(requestid.Middleware)
+	// Logged after the id and the span exist so the access log can be correlated
+	if opts.Logger != nil {
+		router.Use(logging.Middleware(opts.Logger))
+	}
+	// Inside the access log so it records the bytes sent, and before the body limit so it bounds the decompressed body
+	if opts.Compression != nil {
+		router.Use(compression.Middleware(*opts.Compression))
+	}
+	codecs := opts.Codecs
+	if codecs == nil {
+		codecs = DefaultCodecs()
+	}
+	router.Use(withCodecs(codecs))
+	cachePolicies := opts.CachePolicies
+	if cachePolicies == nil {
+		cachePolicies = DefaultCachePolicies
+	}
+	router.Use(withCachePolicies(cachePolicies))
+	if opts.Features != nil {
+		router.Use(features.Middleware(opts.Features))
+	}
+	// A panic past this point still gets a response with the request and trace ids
+	router.Use(recoverPanics)
+	router.Use(limitRequests(opts.Limits))
+	if opts.AddressLimiter != nil {
+		router.Use(opts.AddressLimiter.Middleware)
+	}
+	// Preflights carry no credentials so they are answered before the authentication
+	if opts.CORS != nil {
+		router.Use(opts.CORS.Middleware(router))
+	}
 	// In case we want to add any root middlewares
-	if middleware != nil {
+	for _, middleware := range opts.Middlewares {
 		router.Use(middleware)
 	}
+	// Limited last, once the caller and its tenant are known
+	if opts.RateLimiter != nil {
+		router.Use(opts.RateLimiter.Middleware)
+	}
 
-	// endpoint to handle shutdown
-	router.HandleFunc("/shutdown",func (w http.ResponseWriter, r *http.Request) { reqShutdown <-true})
-	// Declare the prefix for which this service will handle request and assign it
-	router.PathPrefix("/base").Handler(newServicePort(service))
+	// Each port declares the prefix for which it will handle requests as a subrouter, so the middlewares see the full route template
+	newServicePort(router, service, opts.Authorizer)
+	if opts.APIKeys != nil {
+		newAPIKeyPort(router, opts.APIKeys, opts.Authorizer)
+	}
+	if opts.Audit != nil {
+		newAuditPort(router, opts.Audit, opts.Authorizer)
+	}
+	if opts.RateLimiter != nil {
+		newRateLimitPort(router, opts.RateLimiter, opts.Authorizer)
+	}
+	// Registered last, it answers OPTIONS for every path no other route handles
+	if opts.CORS != nil {
+		cors.Register(router)
+	}
 
 	return router
 }
@@ -32,23 +141,27 @@ type servicePort struct {
 	service application.IService
 }
 
-func newServicePort(service application.IService) servicePort {
-router := mux.NewRouter().PathPrefix("/base").Subrouter()
+// newServicePort registers the routes of the service, each one checked against the operation it performs
+func newServicePort(parent *mux.Router, service application.IService, authz auth.Authorizer) servicePort {
+router := parent.PathPrefix("/base").Subrouter()
 handler := servicePort{
 	router,
 	service,
 }
 
 router.Path("/create").
-	Methods(http.MethodPost).HandlerFunc(handler.handleCreate)
+	Methods(http.MethodPost).HandlerFunc(authorize(authz, auth.OpBaseCreate, handler.handleCreate))
 router.Path("/delete/{id}").
-	Methods(http.MethodGet).HandlerFunc(handler.handleDelete)
+	Methods(http.MethodGet).HandlerFunc(authorize(authz, auth.OpBaseDelete, handler.handleDelete))
 // Here we have a api to get documents since a date. 
 // Another ( in my opinion better ) option would be to have a listAll api to get the documents and pass as queries the filters needed
 router.Path("/since/{date}").
-	Methods(http.MethodGet).HandlerFunc(handler.handleGetSince)
+	Methods(http.MethodGet).HandlerFunc(authorize(authz, auth.OpBaseRead, handler.handleGetSince))
+router.Path("/{id}/history").
+	Methods(http.MethodGet).HandlerFunc(authorize(authz, auth.OpBaseRead, handler.handleGetHistory))
+// Accepts ?asOf=<RFC3339> to get the document as it was at that moment
 router.Path("/{id}").
-	Methods(http.MethodGet).HandlerFunc(handler.handleGet)
+	Methods(http.MethodGet).HandlerFunc(authorize(authz, auth.OpBaseRead, handler.handleGet))
 	
 
 return handler
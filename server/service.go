@@ -1,13 +1,13 @@
 package server
 
 import (
-	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
 	"strings"
 	"time"
 
+	"github.com/Martin-Jast/go-microservice/persistence"
 	"github.com/Martin-Jast/go-microservice/transformers"
 	"github.com/Martin-Jast/go-microservice/utils"
 	"github.com/gorilla/mux"
@@ -22,9 +22,9 @@ type createBaseDocumentRequest struct{
 
 // Build parses the request into our internal structure
 func (cr *createBaseDocumentRequest) Build(r *http.Request) error {
-	err := json.NewDecoder(r.Body).Decode(&cr)
+	err := decodeBody(r, cr)
 	if err != nil && err != io.EOF {
-		return fmt.Errorf("invalid request")
+		return err
 	}
 
 
@@ -49,19 +49,23 @@ func (cr *createBaseDocumentRequest) Validate() error {
 func (h servicePort) handleCreate(w http.ResponseWriter, r *http.Request) {
 	// Parse and Validate request
 	req := &createBaseDocumentRequest{}
-	req.Build(r)
+	// Malformed bodies are reported by Validate as missing data, only a body over the limit or in an unknown format is rejected here
+	if err := req.Build(r); err != nil && statusFromError(err, 400) != 400 {
+		utils.WriteError(err, w, statusFromError(err, 400))
+		return;
+	}
 	if err:= req.Validate(); err != nil {
-		utils.WriteJson(err, w, 400)
+		utils.WriteError(err, w, 400)
 		return;
 	}
 	// Deal with the request in application layer
 	response, err := h.service.CreateBaseDocument(r.Context(), req.Data)
 	if err != nil {
-		utils.WriteError(fmt.Errorf("could not create document: %v", err), w, 500)
+		utils.WriteError(fmt.Errorf("could not create document: %v", err), w, statusFromError(err, 500))
 		return;
 	}
 
-	utils.WriteJson(transformers.CreateBaseDocResponse{ID: response}, w, 200)
+	writeResponse(w, r, transformers.CreateBaseDocResponse{ID: response}, 200)
 }
 
 // handleDelete handles the request for the deletion of new documents
@@ -75,11 +79,11 @@ func (h servicePort) handleDelete(w http.ResponseWriter, r *http.Request) {
 	// Deal with the request in application layer
 	err := h.service.DeleteBaseDocument(r.Context(), id)
 	if err != nil {
-		utils.WriteError(fmt.Errorf("could not delete document: %v", err), w, 500)
+		utils.WriteError(fmt.Errorf("could not delete document: %v", err), w, statusFromError(err, 500))
 		return;
 	}
 
-	utils.WriteJson(nil, w, 200)
+	writeResponse(w, r, nil, 200)
 }
 
 // handleGet handles the request for getting documents by their ids
@@ -91,13 +95,24 @@ func (h servicePort) handleGet(w http.ResponseWriter, r *http.Request) {
 		return;
 	}
 	// Deal with the request in application layer
-	doc, err := h.service.GetBaseDocumentByID(r.Context(), id)
+	var doc *persistence.BaseModel
+	var err error
+	if asOfString := r.URL.Query().Get("asOf"); asOfString != "" {
+		asOf, parseErr := time.Parse(time.RFC3339, asOfString)
+		if parseErr != nil {
+			utils.WriteError(fmt.Errorf("invalid asOf date sent: %s", asOfString), w, 400)
+			return;
+		}
+		doc, err = h.service.GetBaseDocumentAsOf(r.Context(), id, asOf)
+	} else {
+		doc, err = h.service.GetBaseDocumentByID(r.Context(), id)
+	}
 	if err != nil || doc == nil {
-		utils.WriteError(fmt.Errorf("could not find document: %v", err), w, 500)
+		utils.WriteError(fmt.Errorf("could not find document: %v", err), w, statusFromError(err, 500))
 		return;
 	}
 
-	utils.WriteJson(transformers.ToBaseModelResponse(*doc), w, 200)
+	writeModifiedResponse(w, r, transformers.ToBaseModelResponse(*doc), 200, lastModified(*doc))
 }
 
 
@@ -117,9 +132,56 @@ func (h servicePort) handleGetSince(w http.ResponseWriter, r *http.Request) {
 	// Deal with the request in application layer
 	docs, err := h.service.GetAllCreatedSince(r.Context(), date)
 	if err != nil || docs == nil {
-		utils.WriteError(fmt.Errorf("could not find documents: %v", err), w, 500)
+		utils.WriteError(fmt.Errorf("could not find documents: %v", err), w, statusFromError(err, 500))
 		return;
 	}
 
-	utils.WriteJson(transformers.ToBaseModelResponseArray(docs), w, 200)
+	// Last-Modified is the newest creation in the page, it does not move when a document of the list is deleted,
+	// so it is only informative and the ETag alone answers the conditional requests
+	var newest time.Time
+	for _, doc := range docs {
+		if doc.CreatedAt != nil && doc.CreatedAt.After(newest) {
+			newest = *doc.CreatedAt
+		}
+	}
+	if !newest.IsZero() {
+		w.Header().Set("Last-Modified", newest.UTC().Format(http.TimeFormat))
+	}
+	writeResponse(w, r, transformers.ToBaseModelResponseArray(docs), 200)
+}
+
+// handleGetHistory handles the request for listing every revision of a document
+func (h servicePort) handleGetHistory(w http.ResponseWriter, r *http.Request) {
+	// Parse and Validate request
+	id := mux.Vars(r)["id"]
+	if id=="" {
+		utils.WriteError(fmt.Errorf("missing id to find"), w, 400)
+		return;
+	}
+	// Deal with the request in application layer
+	revisions, err := h.service.GetBaseDocumentHistory(r.Context(), id)
+	if err != nil {
+		utils.WriteError(fmt.Errorf("could not find document history: %v", err), w, statusFromError(err, 500))
+		return;
+	}
+
+	var modified time.Time
+	for _, revision := range revisions {
+		if revision.ValidFrom.After(modified) {
+			modified = revision.ValidFrom
+		}
+	}
+	writeModifiedResponse(w, r, transformers.ToRevisionResponseArray(revisions), 200, modified)
+}
+
+// lastModified is the last time doc changed, documents only change when they are created or deleted
+func lastModified(doc persistence.BaseModel) time.Time {
+	var modified time.Time
+	if doc.CreatedAt != nil {
+		modified = *doc.CreatedAt
+	}
+	if doc.DeletedAt != nil && doc.DeletedAt.After(modified) {
+		modified = *doc.DeletedAt
+	}
+	return modified
 }
@@ -128,6 +128,106 @@ func TestService_Mongo(t *testing.T) {
 				return nil
 			},
 		},
+		// History
+		{
+			Name:         "success - Get history of deleted document",
+			HTTPMethod:   "GET",
+			SetupPreTestDBs: func(ctx context.Context, th *testHandler) *SetupResult {
+				doc1 := persistence.BaseModel{
+					Data: "test-data",
+				}
+				res1, err := th.dbAddapter.Create(ctx, doc1)
+				if err != nil {
+					panic(err)
+				}
+				doc1.ID = &res1
+				if _, err := th.dbAddapter.Delete(ctx, res1); err != nil {
+					panic(err)
+				}
+				return &SetupResult{
+					BaseDoc: []persistence.BaseModel{
+						doc1,
+					},
+				}
+			},
+			MountPath: func(sr *SetupResult) (string, interface{}) {
+				return fmt.Sprintf("/base/%s/history", *sr.BaseDoc[0].ID), nil
+			},
+			ExpectedCode: http.StatusOK,
+			AssertPosTestDBStates: func(ctx context.Context, th *testHandler, sr *SetupResult, res *httpexpect.Response) error {
+				cr := []transformers.RevisionResponse{}
+				err := json.Unmarshal([]byte(res.Body().Raw()), &cr)
+				if err != nil && err != io.EOF {
+					return fmt.Errorf("invalid response")
+				}
+				assert.Equal(t, 2, len(cr))
+				assert.Equal(t, persistence.RevisionCreate, cr[0].Operation)
+				assert.Equal(t, persistence.RevisionDelete, cr[1].Operation)
+				assert.NotNil(t, cr[1].Document.DeletedAt)
+				return nil
+			},
+		},
+		{
+			Name:         "success - Get deleted document as it was before the deletion",
+			HTTPMethod:   "GET",
+			SetupPreTestDBs: func(ctx context.Context, th *testHandler) *SetupResult {
+				// Created a minute ago so asOf can fall between the creation and the deletion
+				createdAt := time.Now().Add(-time.Minute)
+				doc1 := persistence.BaseModel{
+					Data: "test-data",
+					CreatedAt: &createdAt,
+				}
+				res1, err := th.dbAddapter.Create(ctx, doc1)
+				if err != nil {
+					panic(err)
+				}
+				doc1.ID = &res1
+				if _, err := th.dbAddapter.Delete(ctx, res1); err != nil {
+					panic(err)
+				}
+				return &SetupResult{
+					BaseDoc: []persistence.BaseModel{
+						doc1,
+					},
+				}
+			},
+			MountPath: func(sr *SetupResult) (string, interface{}) {
+				return fmt.Sprintf("/base/%s", *sr.BaseDoc[0].ID), map[string]string{"asOf": time.Now().Add(-30 * time.Second).UTC().Format(time.RFC3339)}
+			},
+			ExpectedCode: http.StatusOK,
+			AssertPosTestDBStates: func(ctx context.Context, th *testHandler, sr *SetupResult, res *httpexpect.Response) error {
+				cr := transformers.BaseModelResponse{}
+				err := json.Unmarshal([]byte(res.Body().Raw()), &cr)
+				if err != nil && err != io.EOF {
+					return fmt.Errorf("invalid response")
+				}
+				assert.Equal(t, "test-data", cr.Data)
+				return nil
+			},
+		},
+		{
+			Name:         "fail - Get document before it existed",
+			HTTPMethod:   "GET",
+			SetupPreTestDBs: func(ctx context.Context, th *testHandler) *SetupResult {
+				doc1 := persistence.BaseModel{
+					Data: "test-data",
+				}
+				res1, err := th.dbAddapter.Create(ctx, doc1)
+				if err != nil {
+					panic(err)
+				}
+				doc1.ID = &res1
+				return &SetupResult{
+					BaseDoc: []persistence.BaseModel{
+						doc1,
+					},
+				}
+			},
+			MountPath: func(sr *SetupResult) (string, interface{}) {
+				return fmt.Sprintf("/base/%s", *sr.BaseDoc[0].ID), map[string]string{"asOf": time.Now().Add(-time.Hour).UTC().Format(time.RFC3339)}
+			},
+			ExpectedCode: http.StatusNotFound,
+		},
 		// Get All since
 		{
 			Name:         "success - Get all documents since 1 hour ago",
@@ -0,0 +1,112 @@
+package tenancy
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/Martin-Jast/go-microservice/utils"
+)
+
+// DefaultTenant is the tenant used when the request does not carry one and tenants are not mandatory
+const DefaultTenant = "default"
+
+// DefaultHeader is the header checked by the HeaderSource when no other name is given
+const DefaultHeader = "X-Tenant-ID"
+
+type contextKey struct{}
+
+// validTenant restricts tenant ids to something that is safe to use as part of a database name
+var validTenant = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_-]{0,47}$`)
+
+// WithTenant returns a copy of ctx carrying the tenant id
+func WithTenant(ctx context.Context, tenant string) context.Context {
+	return context.WithValue(ctx, contextKey{}, tenant)
+}
+
+// FromContext returns the tenant carried by ctx and whether one was set
+func FromContext(ctx context.Context) (string, bool) {
+	tenant, ok := ctx.Value(contextKey{}).(string)
+	return tenant, ok && tenant != ""
+}
+
+// TenantOrDefault returns the tenant carried by ctx or DefaultTenant if there is none
+func TenantOrDefault(ctx context.Context) string {
+	if tenant, ok := FromContext(ctx); ok {
+		return tenant
+	}
+	return DefaultTenant
+}
+
+// Validate checks if the tenant id can be safely used to scope data
+func Validate(tenant string) error {
+	if !validTenant.MatchString(tenant) {
+		return fmt.Errorf("invalid tenant: %q", tenant)
+	}
+	return nil
+}
+
+// Source extracts the tenant from a request, returning false if the request does not carry one
+// Sources based on token claims can be plugged in by the authentication layer
+type Source func(r *http.Request) (string, bool)
+
+// HeaderSource reads the tenant from the given header ( DefaultHeader if empty )
+func HeaderSource(header string) Source {
+	if header == "" {
+		header = DefaultHeader
+	}
+	return func(r *http.Request) (string, bool) {
+		tenant := strings.TrimSpace(r.Header.Get(header))
+		return tenant, tenant != ""
+	}
+}
+
+// SubdomainSource reads the tenant from the first label of the host when it is a subdomain of baseDomain
+// e.g. with baseDomain "api.example.com" a request to "team-a.api.example.com" belongs to "team-a"
+func SubdomainSource(baseDomain string) Source {
+	suffix := "." + strings.TrimPrefix(strings.ToLower(baseDomain), ".")
+	return func(r *http.Request) (string, bool) {
+		host := strings.ToLower(r.Host)
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if !strings.HasSuffix(host, suffix) {
+			return "", false
+		}
+		tenant := strings.TrimSuffix(host, suffix)
+		if tenant == "" || strings.Contains(tenant, ".") {
+			return "", false
+		}
+		return tenant, true
+	}
+}
+
+// Middleware resolves the tenant of each request using the sources in order and stores it in the request context
+// If no source finds a tenant the request is rejected when required is true, otherwise DefaultTenant is used
+func Middleware(required bool, sources ...Source) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			tenant, found := "", false
+			for _, source := range sources {
+				if tenant, found = source(r); found {
+					break
+				}
+			}
+			if !found {
+				if required {
+					utils.WriteError(fmt.Errorf("missing tenant"), w, http.StatusBadRequest)
+					return
+				}
+				tenant = DefaultTenant
+			}
+			if err := Validate(tenant); err != nil {
+				utils.WriteError(err, w, http.StatusBadRequest)
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(WithTenant(r.Context(), tenant)))
+		})
+	}
+}
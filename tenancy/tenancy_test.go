@@ -0,0 +1,47 @@
+package tenancy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMiddleware(t *testing.T) {
+	tt := []struct {
+		Name           string
+		Required       bool
+		Host           string
+		Header         string
+		ExpectedCode   int
+		ExpectedTenant string
+	}{
+		{Name: "success - tenant from header", Header: "team-a", ExpectedCode: http.StatusOK, ExpectedTenant: "team-a"},
+		{Name: "success - tenant from subdomain", Host: "team-b.api.example.com:4000", ExpectedCode: http.StatusOK, ExpectedTenant: "team-b"},
+		{Name: "success - header wins over subdomain", Host: "team-b.api.example.com", Header: "team-a", ExpectedCode: http.StatusOK, ExpectedTenant: "team-a"},
+		{Name: "success - default tenant when not required", ExpectedCode: http.StatusOK, ExpectedTenant: DefaultTenant},
+		{Name: "fail - missing tenant when required", Required: true, ExpectedCode: http.StatusBadRequest},
+		{Name: "fail - nested subdomain is not a tenant", Required: true, Host: "x.team-b.api.example.com", ExpectedCode: http.StatusBadRequest},
+		{Name: "fail - invalid tenant", Header: "../admin", ExpectedCode: http.StatusBadRequest},
+	}
+	for _, tc := range tt {
+		t.Run(tc.Name, func(t *testing.T) {
+			var tenant string
+			handler := Middleware(tc.Required, HeaderSource(""), SubdomainSource("api.example.com"))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				tenant, _ = FromContext(r.Context())
+			}))
+			req := httptest.NewRequest(http.MethodGet, "/base/123", nil)
+			if tc.Host != "" {
+				req.Host = tc.Host
+			}
+			if tc.Header != "" {
+				req.Header.Set(DefaultHeader, tc.Header)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			assert.Equal(t, tc.ExpectedCode, rec.Code)
+			assert.Equal(t, tc.ExpectedTenant, tenant)
+		})
+	}
+}
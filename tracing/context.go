@@ -0,0 +1,105 @@
+package tracing
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// TraceparentHeader and TracestateHeader are the W3C trace context headers
+const (
+	TraceparentHeader = "traceparent"
+	TracestateHeader  = "tracestate"
+)
+
+// TraceID identifies a whole trace
+type TraceID [16]byte
+
+// SpanID identifies a span inside a trace
+type SpanID [8]byte
+
+func (t TraceID) String() string { return hex.EncodeToString(t[:]) }
+
+func (s SpanID) String() string { return hex.EncodeToString(s[:]) }
+
+// IsValid checks the id is not all zeros, which the spec forbids
+func (t TraceID) IsValid() bool { return t != TraceID{} }
+
+// IsValid checks the id is not all zeros, which the spec forbids
+func (s SpanID) IsValid() bool { return s != SpanID{} }
+
+// flagSampled is the only trace flag defined by the spec
+const flagSampled byte = 0x01
+
+// SpanContext is the part of a span that is propagated between services
+type SpanContext struct {
+	TraceID    TraceID
+	SpanID     SpanID
+	Flags      byte
+	TraceState string
+}
+
+// Sampled reports if the trace is being recorded
+func (sc SpanContext) Sampled() bool { return sc.Flags&flagSampled != 0 }
+
+// IsValid checks both ids are set
+func (sc SpanContext) IsValid() bool { return sc.TraceID.IsValid() && sc.SpanID.IsValid() }
+
+// Traceparent formats the span context as a traceparent header value
+func (sc SpanContext) Traceparent() string {
+	return fmt.Sprintf("00-%s-%s-%02x", sc.TraceID, sc.SpanID, sc.Flags)
+}
+
+// ParseTraceparent parses a traceparent header value, future versions are accepted as long as they start like version 00
+func ParseTraceparent(value string) (SpanContext, error) {
+	sc := SpanContext{}
+	parts := strings.Split(strings.TrimSpace(value), "-")
+	if len(parts) < 4 {
+		return sc, errors.New("malformed traceparent")
+	}
+	version, traceID, spanID, flags := parts[0], parts[1], parts[2], parts[3]
+	if len(version) != 2 || version == "ff" || !isLowerHex(version) {
+		return sc, errors.New("invalid traceparent version")
+	}
+	if version == "00" && len(parts) != 4 {
+		return sc, errors.New("malformed traceparent")
+	}
+	if len(traceID) != 32 || !isLowerHex(traceID) || len(spanID) != 16 || !isLowerHex(spanID) || len(flags) != 2 || !isLowerHex(flags) {
+		return sc, errors.New("malformed traceparent")
+	}
+	hex.Decode(sc.TraceID[:], []byte(traceID))
+	hex.Decode(sc.SpanID[:], []byte(spanID))
+	flagBytes, _ := hex.DecodeString(flags)
+	sc.Flags = flagBytes[0]
+	if !sc.IsValid() {
+		return SpanContext{}, errors.New("traceparent with zero ids")
+	}
+	return sc, nil
+}
+
+func isLowerHex(s string) bool {
+	for _, c := range s {
+		if !(c >= '0' && c <= '9') && !(c >= 'a' && c <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+func newTraceID() TraceID {
+	id := TraceID{}
+	for !id.IsValid() {
+		_, _ = rand.Read(id[:])
+	}
+	return id
+}
+
+func newSpanID() SpanID {
+	id := SpanID{}
+	for !id.IsValid() {
+		_, _ = rand.Read(id[:])
+	}
+	return id
+}
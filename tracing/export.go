@@ -0,0 +1,259 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Exporter sends finished spans somewhere
+type Exporter interface {
+	Export(ctx context.Context, serviceName string, spans []*Span) error
+	Shutdown(ctx context.Context) error
+}
+
+const (
+	batchQueueSize   = 2048
+	batchMaxSpans    = 512
+	batchFlushPeriod = 5 * time.Second
+	exportTimeout    = 10 * time.Second
+)
+
+// batchProcessor collects finished spans and exports them in batches from a single goroutine
+type batchProcessor struct {
+	serviceName string
+	exporter    Exporter
+	queue       chan *Span
+	stop        chan struct{}
+	done        chan struct{}
+	once        sync.Once
+}
+
+func newBatchProcessor(serviceName string, exporter Exporter) *batchProcessor {
+	p := &batchProcessor{
+		serviceName: serviceName,
+		exporter:    exporter,
+		queue:       make(chan *Span, batchQueueSize),
+		stop:        make(chan struct{}),
+		done:        make(chan struct{}),
+	}
+	go p.run()
+	return p
+}
+
+// enqueue never blocks the traced code, spans are dropped when the exporter can't keep up
+func (p *batchProcessor) enqueue(s *Span) {
+	select {
+	case p.queue <- s:
+	default:
+	}
+}
+
+func (p *batchProcessor) run() {
+	defer close(p.done)
+	ticker := time.NewTicker(batchFlushPeriod)
+	defer ticker.Stop()
+	batch := []*Span{}
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), exportTimeout)
+		if err := p.exporter.Export(ctx, p.serviceName, batch); err != nil {
+			slog.Error("could not export spans", "package", "tracing", "spans", len(batch), "error", err)
+		}
+		cancel()
+		batch = []*Span{}
+	}
+	for {
+		select {
+		case s := <-p.queue:
+			batch = append(batch, s)
+			if len(batch) >= batchMaxSpans {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		case <-p.stop:
+			for {
+				select {
+				case s := <-p.queue:
+					batch = append(batch, s)
+				default:
+					flush()
+					return
+				}
+			}
+		}
+	}
+}
+
+func (p *batchProcessor) shutdown(ctx context.Context) error {
+	p.once.Do(func() { close(p.stop) })
+	select {
+	case <-p.done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	return p.exporter.Shutdown(ctx)
+}
+
+// otlpAnyValue, otlpKeyValue... follow the OTLP/HTTP JSON encoding, ids are hex and timestamps are strings
+type otlpAnyValue struct {
+	StringValue string `json:"stringValue"`
+}
+
+type otlpKeyValue struct {
+	Key   string       `json:"key"`
+	Value otlpAnyValue `json:"value"`
+}
+
+type otlpStatus struct {
+	Code    StatusCode `json:"code,omitempty"`
+	Message string     `json:"message,omitempty"`
+}
+
+type otlpSpan struct {
+	TraceID           string         `json:"traceId"`
+	SpanID            string         `json:"spanId"`
+	TraceState        string         `json:"traceState,omitempty"`
+	ParentSpanID      string         `json:"parentSpanId,omitempty"`
+	Name              string         `json:"name"`
+	Kind              SpanKind       `json:"kind"`
+	StartTimeUnixNano string         `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+	Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+	Status            otlpStatus     `json:"status"`
+}
+
+func toOTLPSpan(s *Span) otlpSpan {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	span := otlpSpan{
+		TraceID:           s.Context.TraceID.String(),
+		SpanID:            s.Context.SpanID.String(),
+		TraceState:        s.Context.TraceState,
+		Name:              s.Name,
+		Kind:              s.Kind,
+		StartTimeUnixNano: strconv.FormatInt(s.StartTime.UnixNano(), 10),
+		EndTimeUnixNano:   strconv.FormatInt(s.EndTime.UnixNano(), 10),
+		Status:            otlpStatus{Code: s.Status, Message: s.StatusMessage},
+	}
+	if s.Parent.IsValid() {
+		span.ParentSpanID = s.Parent.String()
+	}
+	for k, v := range s.Attributes {
+		span.Attributes = append(span.Attributes, otlpKeyValue{Key: k, Value: otlpAnyValue{StringValue: v}})
+	}
+	return span
+}
+
+// OTLPExporter posts the spans to an OTLP/HTTP collector using the JSON encoding
+type OTLPExporter struct {
+	// Endpoint is the full traces url, e.g. http://localhost:4318/v1/traces
+	Endpoint string
+	Headers  map[string]string
+	Client   *http.Client
+}
+
+// NewOTLPExporter creates an exporter posting to endpoint
+func NewOTLPExporter(endpoint string) *OTLPExporter {
+	return &OTLPExporter{Endpoint: endpoint, Client: &http.Client{Timeout: exportTimeout}}
+}
+
+// Export implements Exporter
+func (e *OTLPExporter) Export(ctx context.Context, serviceName string, spans []*Span) error {
+	otlpSpans := make([]otlpSpan, len(spans))
+	for i, s := range spans {
+		otlpSpans[i] = toOTLPSpan(s)
+	}
+	payload := map[string]interface{}{
+		"resourceSpans": []interface{}{map[string]interface{}{
+			"resource": map[string]interface{}{
+				"attributes": []otlpKeyValue{{Key: "service.name", Value: otlpAnyValue{StringValue: serviceName}}},
+			},
+			"scopeSpans": []interface{}{map[string]interface{}{
+				"scope": map[string]string{"name": "github.com/Martin-Jast/go-microservice/tracing"},
+				"spans": otlpSpans,
+			}},
+		}},
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range e.Headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := e.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("collector answered %s", resp.Status)
+	}
+	return nil
+}
+
+// Shutdown implements Exporter
+func (e *OTLPExporter) Shutdown(ctx context.Context) error {
+	return nil
+}
+
+// FileExporter appends the spans to a local file, one JSON span per line
+type FileExporter struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+// NewFileExporter opens ( or creates ) the NDJSON file in path
+func NewFileExporter(path string) (*FileExporter, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &FileExporter{file: file}, nil
+}
+
+// fileSpan is the OTLP span with the service name, since each line stands alone
+type fileSpan struct {
+	ServiceName string `json:"serviceName"`
+	otlpSpan
+}
+
+// Export implements Exporter
+func (e *FileExporter) Export(ctx context.Context, serviceName string, spans []*Span) error {
+	buf := bytes.Buffer{}
+	enc := json.NewEncoder(&buf)
+	for _, s := range spans {
+		if err := enc.Encode(fileSpan{ServiceName: serviceName, otlpSpan: toOTLPSpan(s)}); err != nil {
+			return err
+		}
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	_, err := e.file.Write(buf.Bytes())
+	return err
+}
+
+// Shutdown implements Exporter
+func (e *FileExporter) Shutdown(ctx context.Context) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.file.Close()
+}
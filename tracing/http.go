@@ -0,0 +1,43 @@
+package tracing
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/Martin-Jast/go-microservice/utils"
+)
+
+// Middleware starts a server span for every request, continuing the trace of the caller when it sends a valid traceparent
+// It must be used as a mux middleware so the span can be named after the route template
+func Middleware(tracer *Tracer) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := WithTracer(r.Context(), tracer)
+			if parent, err := ParseTraceparent(r.Header.Get(TraceparentHeader)); err == nil {
+				parent.TraceState = r.Header.Get(TracestateHeader)
+				ctx = WithRemoteParent(ctx, parent)
+			}
+			route := utils.RouteTemplate(r)
+			ctx, span := StartWithKind(ctx, fmt.Sprintf("%s %s", r.Method, route), KindServer)
+			defer span.End()
+			span.SetAttribute("http.method", r.Method)
+			span.SetAttribute("http.route", route)
+			span.SetAttribute("http.target", r.URL.Path)
+
+			sc := span.SpanContext()
+			w.Header().Set(TraceparentHeader, sc.Traceparent())
+			if sc.TraceState != "" {
+				w.Header().Set(TracestateHeader, sc.TraceState)
+			}
+			w.Header().Set(utils.TraceIDHeader, sc.TraceID.String())
+
+			rec := utils.NewResponseRecorder(w)
+			next.ServeHTTP(rec, r.WithContext(ctx))
+			span.SetAttribute("http.status_code", strconv.Itoa(rec.Status))
+			if rec.Status >= 500 {
+				span.SetError(fmt.Errorf("%s", http.StatusText(rec.Status)))
+			}
+		})
+	}
+}
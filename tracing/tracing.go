@@ -0,0 +1,168 @@
+package tracing
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// SpanKind follows the OTLP span kinds
+type SpanKind int
+
+const (
+	KindInternal SpanKind = 1
+	KindServer   SpanKind = 2
+	KindClient   SpanKind = 3
+)
+
+// StatusCode follows the OTLP status codes
+type StatusCode int
+
+const (
+	StatusUnset StatusCode = 0
+	StatusOK    StatusCode = 1
+	StatusError StatusCode = 2
+)
+
+// Span is an operation being traced, every method is safe to call on a nil span so untraced code needs no checks
+type Span struct {
+	tracer *Tracer
+
+	mu            sync.Mutex
+	Name          string
+	Kind          SpanKind
+	Context       SpanContext
+	Parent        SpanID
+	StartTime     time.Time
+	EndTime       time.Time
+	Attributes    map[string]string
+	Status        StatusCode
+	StatusMessage string
+	ended         bool
+}
+
+// SetAttribute adds an attribute to the span
+func (s *Span) SetAttribute(key, value string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Attributes[key] = value
+}
+
+// SetError marks the span as failed when err is not nil
+func (s *Span) SetError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Status = StatusError
+	s.StatusMessage = err.Error()
+}
+
+// End ends the span and hands it to the exporter if the trace is sampled, only the first call counts
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.EndTime = time.Now()
+	s.mu.Unlock()
+	if s.Context.Sampled() {
+		s.tracer.processor.enqueue(s)
+	}
+}
+
+// SpanContext returns the propagated part of the span, the zero value for a nil span
+func (s *Span) SpanContext() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return s.Context
+}
+
+// Tracer creates spans and sends the finished ones to its exporter
+type Tracer struct {
+	ServiceName string
+	processor   *batchProcessor
+}
+
+// NewTracer creates a tracer exporting the spans of serviceName in batches
+func NewTracer(serviceName string, exporter Exporter) *Tracer {
+	return &Tracer{
+		ServiceName: serviceName,
+		processor:   newBatchProcessor(serviceName, exporter),
+	}
+}
+
+// Shutdown exports the pending spans and stops the tracer
+func (t *Tracer) Shutdown(ctx context.Context) error {
+	return t.processor.shutdown(ctx)
+}
+
+type tracerKey struct{}
+type spanKey struct{}
+type remoteKey struct{}
+
+// WithTracer returns a copy of ctx that creates its spans with t
+func WithTracer(ctx context.Context, t *Tracer) context.Context {
+	return context.WithValue(ctx, tracerKey{}, t)
+}
+
+// WithRemoteParent returns a copy of ctx where the next span is a child of a span from another service
+func WithRemoteParent(ctx context.Context, sc SpanContext) context.Context {
+	return context.WithValue(ctx, remoteKey{}, sc)
+}
+
+// SpanFromContext returns the current span of ctx, nil if there is none
+func SpanFromContext(ctx context.Context) *Span {
+	s, _ := ctx.Value(spanKey{}).(*Span)
+	return s
+}
+
+// TraceIDFromContext returns the trace id of the current span, empty if ctx is not traced
+func TraceIDFromContext(ctx context.Context) string {
+	if s := SpanFromContext(ctx); s != nil {
+		return s.Context.TraceID.String()
+	}
+	return ""
+}
+
+// Start starts a span as a child of the current span of ctx using the tracer of ctx
+// When ctx carries no tracer the span is nil, which is valid and records nothing
+func Start(ctx context.Context, name string) (context.Context, *Span) {
+	return StartWithKind(ctx, name, KindInternal)
+}
+
+// StartWithKind works as Start setting the span kind
+func StartWithKind(ctx context.Context, name string, kind SpanKind) (context.Context, *Span) {
+	tracer, _ := ctx.Value(tracerKey{}).(*Tracer)
+	if tracer == nil {
+		return ctx, nil
+	}
+	span := &Span{
+		tracer:     tracer,
+		Name:       name,
+		Kind:       kind,
+		StartTime:  time.Now(),
+		Attributes: map[string]string{},
+	}
+	if parent := SpanFromContext(ctx); parent != nil {
+		span.Context = parent.Context
+		span.Parent = parent.Context.SpanID
+	} else if remote, ok := ctx.Value(remoteKey{}).(SpanContext); ok && remote.IsValid() {
+		span.Context = remote
+		span.Parent = remote.SpanID
+	} else {
+		span.Context = SpanContext{TraceID: newTraceID(), Flags: flagSampled}
+	}
+	span.Context.SpanID = newSpanID()
+	return context.WithValue(ctx, spanKey{}, span), span
+}
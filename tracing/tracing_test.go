@@ -0,0 +1,150 @@
+package tracing
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/Martin-Jast/go-microservice/utils"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+type memoryExporter struct {
+	mu    sync.Mutex
+	spans []*Span
+}
+
+func (e *memoryExporter) Export(ctx context.Context, serviceName string, spans []*Span) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.spans = append(e.spans, spans...)
+	return nil
+}
+
+func (e *memoryExporter) Shutdown(ctx context.Context) error { return nil }
+
+func TestParseTraceparent(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		wantErr bool
+		sampled bool
+	}{
+		{name: "valid sampled", value: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", sampled: true},
+		{name: "valid not sampled", value: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00"},
+		{name: "future version with extra field", value: "01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", sampled: true},
+		{name: "version ff", value: "ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", wantErr: true},
+		{name: "extra field on version 00", value: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", wantErr: true},
+		{name: "uppercase", value: "00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01", wantErr: true},
+		{name: "zero trace id", value: "00-00000000000000000000000000000000-00f067aa0ba902b7-01", wantErr: true},
+		{name: "zero span id", value: "00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01", wantErr: true},
+		{name: "short", value: "00-4bf92f35-00f067aa0ba902b7-01", wantErr: true},
+		{name: "empty", value: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sc, err := ParseTraceparent(tt.value)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", sc.TraceID.String())
+			assert.Equal(t, "00f067aa0ba902b7", sc.SpanID.String())
+			assert.Equal(t, tt.sampled, sc.Sampled())
+		})
+	}
+}
+
+func TestStart(t *testing.T) {
+	// Without a tracer nothing is recorded and the nil span is safe to use
+	ctx, span := Start(context.Background(), "untraced")
+	assert.Nil(t, span)
+	span.SetAttribute("k", "v")
+	span.End()
+	assert.Equal(t, "", TraceIDFromContext(ctx))
+
+	exporter := &memoryExporter{}
+	tracer := NewTracer("test", exporter)
+	remote, _ := ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	ctx = WithRemoteParent(WithTracer(context.Background(), tracer), remote)
+
+	ctx, parent := StartWithKind(ctx, "parent", KindServer)
+	_, child := Start(ctx, "child")
+	child.End()
+	parent.End()
+	assert.NoError(t, tracer.Shutdown(context.Background()))
+
+	assert.Len(t, exporter.spans, 2)
+	assert.Equal(t, remote.TraceID, parent.Context.TraceID)
+	assert.Equal(t, remote.SpanID, parent.Parent)
+	assert.Equal(t, parent.Context.TraceID, child.Context.TraceID)
+	assert.Equal(t, parent.Context.SpanID, child.Parent)
+	assert.NotEqual(t, parent.Context.SpanID, child.Context.SpanID)
+}
+
+func TestMiddleware(t *testing.T) {
+	exporter := &memoryExporter{}
+	tracer := NewTracer("test", exporter)
+	router := mux.NewRouter()
+	router.Use(Middleware(tracer))
+	router.HandleFunc("/base/{id}", func(w http.ResponseWriter, r *http.Request) {
+		utils.WriteError(assert.AnError, w, 500)
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/base/42", nil)
+	req.Header.Set(TraceparentHeader, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	req.Header.Set(TracestateHeader, "vendor=value")
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	assert.NoError(t, tracer.Shutdown(context.Background()))
+
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", rec.Header().Get(utils.TraceIDHeader))
+	assert.Equal(t, "vendor=value", rec.Header().Get(TracestateHeader))
+	sc, err := ParseTraceparent(rec.Header().Get(TraceparentHeader))
+	assert.NoError(t, err)
+	resp := utils.ErrorResponse{}
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(&resp))
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", resp.TraceID)
+
+	assert.Len(t, exporter.spans, 1)
+	span := exporter.spans[0]
+	assert.Equal(t, "GET /base/{id}", span.Name)
+	assert.Equal(t, sc.SpanID, span.Context.SpanID)
+	assert.Equal(t, StatusError, span.Status)
+	assert.Equal(t, "500", span.Attributes["http.status_code"])
+}
+
+func TestFileExporter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "spans.ndjson")
+	exporter, err := NewFileExporter(path)
+	assert.NoError(t, err)
+	tracer := NewTracer("test", exporter)
+	ctx := WithTracer(context.Background(), tracer)
+	for _, name := range []string{"first", "second"} {
+		_, span := Start(ctx, name)
+		span.SetAttribute("name", name)
+		span.End()
+	}
+	assert.NoError(t, tracer.Shutdown(context.Background()))
+
+	file, err := os.Open(path)
+	assert.NoError(t, err)
+	defer file.Close()
+	names := []string{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := fileSpan{}
+		assert.NoError(t, json.Unmarshal(scanner.Bytes(), &line))
+		assert.Equal(t, "test", line.ServiceName)
+		names = append(names, line.Name)
+	}
+	assert.Equal(t, []string{"first", "second"}, names)
+}
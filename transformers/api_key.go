@@ -0,0 +1,45 @@
+package transformers
+
+import (
+	"time"
+
+	"github.com/Martin-Jast/go-microservice/persistence"
+)
+
+type APIKeyResponse struct {
+	ID        string     `json:"id"`
+	TenantID  string     `json:"tenantId"`
+	Name      string     `json:"name"`
+	Prefix    string     `json:"prefix"`
+	Roles     []string   `json:"roles"`
+	CreatedAt time.Time  `json:"createdAt"`
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+	RevokedAt *time.Time `json:"revokedAt,omitempty"`
+}
+
+// CreateAPIKeyResponse is the only response containing the plain key
+type CreateAPIKeyResponse struct {
+	APIKeyResponse
+	Key string `json:"key"`
+}
+
+func ToAPIKeyResponse(k persistence.APIKey) APIKeyResponse {
+	return APIKeyResponse{
+		ID:        *k.ID,
+		TenantID:  k.TenantID,
+		Name:      k.Name,
+		Prefix:    k.Prefix,
+		Roles:     k.Roles,
+		CreatedAt: *k.CreatedAt,
+		ExpiresAt: k.ExpiresAt,
+		RevokedAt: k.RevokedAt,
+	}
+}
+
+func ToAPIKeyResponseArray(ks []persistence.APIKey) []APIKeyResponse {
+	response := make([]APIKeyResponse, len(ks))
+	for i := range ks {
+		response[i] = ToAPIKeyResponse(ks[i])
+	}
+	return response
+}
@@ -0,0 +1,57 @@
+package transformers
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/Martin-Jast/go-microservice/persistence"
+)
+
+type AuditEntryResponse struct {
+	Seq        int64           `json:"seq"`
+	TenantID   string          `json:"tenantId"`
+	Time       time.Time       `json:"time"`
+	Actor      string          `json:"actor"`
+	Action     string          `json:"action"`
+	DocumentID string          `json:"documentId,omitempty"`
+	RequestID  string          `json:"requestId,omitempty"`
+	Before     json.RawMessage `json:"before,omitempty"`
+	After      json.RawMessage `json:"after,omitempty"`
+	PrevHash   string          `json:"prevHash"`
+	Hash       string          `json:"hash"`
+}
+
+type AuditVerifyResponse struct {
+	Valid   bool   `json:"valid"`
+	Entries int    `json:"entries"`
+	Error   string `json:"error,omitempty"`
+}
+
+func ToAuditEntryResponse(e persistence.AuditEntry) AuditEntryResponse {
+	resp := AuditEntryResponse{
+		Seq:        e.Seq,
+		TenantID:   e.TenantID,
+		Time:       e.Time,
+		Actor:      e.Actor,
+		Action:     e.Action,
+		DocumentID: e.DocumentID,
+		RequestID:  e.RequestID,
+		PrevHash:   e.PrevHash,
+		Hash:       e.Hash,
+	}
+	if e.Before != "" {
+		resp.Before = json.RawMessage(e.Before)
+	}
+	if e.After != "" {
+		resp.After = json.RawMessage(e.After)
+	}
+	return resp
+}
+
+func ToAuditEntryResponseArray(es []persistence.AuditEntry) []AuditEntryResponse {
+	response := make([]AuditEntryResponse, len(es))
+	for i := range es {
+		response[i] = ToAuditEntryResponse(es[i])
+	}
+	return response
+}
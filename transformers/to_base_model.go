@@ -9,7 +9,9 @@ import (
 
 type BaseModelResponse struct {
 	ID        *string `json:"id"`
+	TenantID  string `json:"tenantId"`
 	Data      string `json:"data"`
+	CreatedBy string `json:"createdBy,omitempty"`
 	CreatedAt time.Time `json:"createdAt"`
 	DeletedAt *time.Time `json:"deletedAt,omitempty"`
 }
@@ -17,7 +19,9 @@ type BaseModelResponse struct {
 func ToBaseModelResponse(b persistence.BaseModel) BaseModelResponse {
 	return BaseModelResponse{
 		ID: utils.StrPnt(*b.ID),
+		TenantID: b.TenantID,
 		Data: b.Data,
+		CreatedBy: b.CreatedBy,
 		CreatedAt: *b.CreatedAt,
 		DeletedAt: b.DeletedAt,
 	}
@@ -29,4 +33,24 @@ func ToBaseModelResponseArray(bs []persistence.BaseModel) []BaseModelResponse{
 		response[i] = ToBaseModelResponse(bs[i])
 	}
 	return response
-}
\ No newline at end of file
+}
+
+type RevisionResponse struct {
+	Version   int64             `json:"version"`
+	ValidFrom time.Time         `json:"validFrom"`
+	Operation string            `json:"operation"`
+	Document  BaseModelResponse `json:"document"`
+}
+
+func ToRevisionResponseArray(rs []persistence.Revision) []RevisionResponse {
+	response := make([]RevisionResponse, len(rs))
+	for i := range rs {
+		response[i] = RevisionResponse{
+			Version:   rs[i].Version,
+			ValidFrom: rs[i].ValidFrom,
+			Operation: rs[i].Operation,
+			Document:  ToBaseModelResponse(rs[i].Document),
+		}
+	}
+	return response
+}
@@ -7,62 +7,254 @@ import (
 	"strings"
 )
 
-// SetupEnvVars gets a file with the declared env vars and set them in the environment
-// envs should be declared as KEY="VALUE" with the value always inside " symbols
-func SetupEnvVars(path string) error {
-	envs, err := SafeGetFileAsLines(path)
+// EnvParseError is a syntax error in an env file
+type EnvParseError struct {
+	Path string
+	Line int
+	Msg  string
+}
+
+func (e *EnvParseError) Error() string {
+	return fmt.Sprintf("%s:%d: %s", e.Path, e.Line, e.Msg)
+}
+
+// SetupEnvVars sets the vars declared in the env files in the environment, vars already set are kept
+// Files are read in order so a var of .env.local replaces the same var of .env
+//
+// The files follow the dotenv format:
+//
+//	# comment
+//	export KEY=value # comment
+//	SINGLE='no ${EXPANSION} nor \n escapes'
+//	DOUBLE="escapes \n \t \" \\ \$ and ${VAR} or ${VAR:-default} expansion"
+//	MULTILINE="first line
+//	second line"
+func SetupEnvVars(paths ...string) error {
+	envs, err := ReadEnvFiles(paths...)
 	if err != nil {
 		return err
 	}
+	for key, value := range envs {
+		if _, set := os.LookupEnv(key); set {
+			continue
+		}
+		if err := os.Setenv(key, value); err != nil {
+			return fmt.Errorf("could not set env var %s: %v", key, err)
+		}
+	}
+	return nil
+}
 
-	for _, env := range envs {
-		 keyRegex := regexp.MustCompile(`(^[A-Za-z])\w+`)
-		 keyName := keyRegex.FindStringSubmatch(env)
-		 if len(keyName) != 0 {
-			// found a key, now get the value and declare as envVar
-			valueRegex := regexp.MustCompile(`\"(.*)\"`)
-			value := valueRegex.FindStringSubmatch(env)
-			if len(value) == 0 {
-				fmt.Printf("No Value set for %s env var. \n", keyName[0])
-				continue;
-			}
-			err := os.Setenv(keyName[0], trimQuotationMarks(value[0]))
-			if err != nil {
-				fmt.Printf("Error setting %s env var.", keyName[0])
-				panic(err)
+// OverloadEnvVars works as SetupEnvVars but replaces the vars already set
+func OverloadEnvVars(paths ...string) error {
+	envs, err := readEnvFiles(paths, false)
+	if err != nil {
+		return err
+	}
+	for key, value := range envs {
+		if err := os.Setenv(key, value); err != nil {
+			return fmt.Errorf("could not set env var %s: %v", key, err)
+		}
+	}
+	return nil
+}
+
+// ReadEnvFiles returns the vars declared in the env files without setting them, see SetupEnvVars for the format
+// ${VAR} resolves to the value set in the environment, then to the value declared above it
+func ReadEnvFiles(paths ...string) (map[string]string, error) {
+	return readEnvFiles(paths, true)
+}
+
+func readEnvFiles(paths []string, environmentFirst bool) (map[string]string, error) {
+	envs := map[string]string{}
+	lookup := func(name string) (string, bool) {
+		if environmentFirst {
+			if value, ok := os.LookupEnv(name); ok {
+				return value, true
 			}
-		 }
+		}
+		if value, ok := envs[name]; ok {
+			return value, true
+		}
+		return os.LookupEnv(name)
 	}
+	for _, path := range paths {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		if err := parseEnv(path, strings.TrimPrefix(string(b), "\ufeff"), envs, lookup); err != nil {
+			return nil, err
+		}
+	}
+	return envs, nil
+}
 
+var envNameRegex = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// parseEnv adds the vars declared in content to envs
+func parseEnv(path, content string, envs map[string]string, lookup func(string) (string, bool)) error {
+	lines := strings.Split(strings.ReplaceAll(content, "\r\n", "\n"), "\n")
+	for i := 0; i < len(lines); i++ {
+		start := i + 1
+		fail := func(format string, args ...interface{}) error {
+			return &EnvParseError{Path: path, Line: start, Msg: fmt.Sprintf(format, args...)}
+		}
+		line := strings.TrimSpace(lines[i])
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if rest, ok := strings.CutPrefix(line, "export"); ok && rest != "" && (rest[0] == ' ' || rest[0] == '\t') {
+			line = strings.TrimSpace(rest)
+		}
+		key, rest, ok := strings.Cut(line, "=")
+		if !ok {
+			return fail("expected KEY=VALUE, got %q", line)
+		}
+		key = strings.TrimSpace(key)
+		if !envNameRegex.MatchString(key) {
+			return fail("invalid variable name %q", key)
+		}
+		rest = strings.TrimLeft(rest, " \t")
+
+		var value string
+		switch {
+		case strings.HasPrefix(rest, "'"), strings.HasPrefix(rest, `"`):
+			quote := rest[0]
+			text := rest[1:]
+			for {
+				var closed bool
+				var err error
+				if quote == '\'' {
+					value, rest, closed = scanSingleQuoted(text)
+				} else if value, rest, closed, err = scanDoubleQuoted(text, lookup); err != nil {
+					return fail("%v", err)
+				}
+				if closed {
+					break
+				}
+				if i+1 == len(lines) {
+					return fail("unterminated quoted value of %s", key)
+				}
+				i++
+				text += "\n" + lines[i]
+			}
+			if rest = strings.TrimSpace(rest); rest != "" && !strings.HasPrefix(rest, "#") {
+				return fail("unexpected %q after the quoted value of %s", rest, key)
+			}
+		default:
+			// An inline comment starts with a # after a space
+			for j := 1; j < len(rest); j++ {
+				if rest[j] == '#' && (rest[j-1] == ' ' || rest[j-1] == '\t') {
+					rest = rest[:j]
+					break
+				}
+			}
+			if strings.HasPrefix(rest, "#") {
+				rest = ""
+			}
+			var err error
+			if value, err = expand(strings.TrimSpace(rest), lookup); err != nil {
+				return fail("%v", err)
+			}
+		}
+		envs[key] = value
+	}
 	return nil
 }
 
-func trimQuotationMarks(s string) string {
-	t := s
-	quotation:= "\""
-	if strings.HasSuffix(t, "\"") {
-        t = t[:len(t)-len(quotation)]
-    }
-	if strings.HasPrefix(t, "\"") {
-        t = t[len(quotation):]
-    }
-	return t
+// scanSingleQuoted returns the literal value up to the closing quote and what follows it
+func scanSingleQuoted(s string) (value string, rest string, closed bool) {
+	end := strings.IndexByte(s, '\'')
+	if end < 0 {
+		return "", "", false
+	}
+	return s[:end], s[end+1:], true
 }
 
-// CheckIfNeededVarsAreSet returns error in case of missing env var
-func CheckIfNeededVarsAreSet(vars []string, verbose bool) error {
-	unset := []string{}
-	for _, envVar := range vars {
-		val, isSet := os.LookupEnv(envVar)
-		if verbose {
-			fmt.Printf("LookupEnv: %s variable is set: %t, value: %s \n", envVar, isSet, val)
+// scanDoubleQuoted returns the value up to the closing quote with its escapes and vars replaced, and what follows it
+func scanDoubleQuoted(s string, lookup func(string) (string, bool)) (value string, rest string, closed bool, err error) {
+	b := strings.Builder{}
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '\\':
+			if i+1 == len(s) {
+				b.WriteByte(c)
+				continue
+			}
+			i++
+			switch s[i] {
+			case 'n':
+				b.WriteByte('\n')
+			case 'r':
+				b.WriteByte('\r')
+			case 't':
+				b.WriteByte('\t')
+			case '"', '\\', '$':
+				b.WriteByte(s[i])
+			default:
+				b.WriteByte('\\')
+				b.WriteByte(s[i])
+			}
+		case '"':
+			return b.String(), s[i+1:], true, nil
+		case '$':
+			v, next, err := expandRef(s, i, lookup)
+			if err != nil {
+				return "", "", false, err
+			}
+			b.WriteString(v)
+			i = next - 1
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return "", "", false, nil
+}
+
+// expand replaces the vars of an unquoted value
+func expand(s string, lookup func(string) (string, bool)) (string, error) {
+	b := strings.Builder{}
+	for i := 0; i < len(s); i++ {
+		if s[i] != '$' {
+			b.WriteByte(s[i])
+			continue
+		}
+		v, next, err := expandRef(s, i, lookup)
+		if err != nil {
+			return "", err
+		}
+		b.WriteString(v)
+		i = next - 1
+	}
+	return b.String(), nil
+}
+
+// expandRef resolves the $VAR, ${VAR} or ${VAR:-default} starting at s[i], next is the index following it
+// A $ not followed by a name is kept as it is
+func expandRef(s string, i int, lookup func(string) (string, bool)) (value string, next int, err error) {
+	if strings.HasPrefix(s[i+1:], "{") {
+		end := strings.IndexByte(s[i+2:], '}')
+		if end < 0 {
+			return "", 0, fmt.Errorf("unterminated ${ in %q", s[i:])
+		}
+		name, fallback, hasFallback := strings.Cut(s[i+2:i+2+end], ":-")
+		if !envNameRegex.MatchString(name) {
+			return "", 0, fmt.Errorf("invalid variable name %q", name)
 		}
-		if !isSet {
-			unset = append(unset, envVar)
+		value, _ = lookup(name)
+		if value == "" && hasFallback {
+			value = fallback
 		}
+		return value, i + 2 + end + 1, nil
 	}
-	if len(unset) > 0 {
-		return fmt.Errorf("envs not set: %s", strings.Join(unset, ", "))
+	j := i + 1
+	for j < len(s) && (s[j] == '_' || 'A' <= s[j] && s[j] <= 'Z' || 'a' <= s[j] && s[j] <= 'z' || j > i+1 && '0' <= s[j] && s[j] <= '9') {
+		j++
 	}
-	return nil
-}
\ No newline at end of file
+	if j == i+1 {
+		return "$", j, nil
+	}
+	value, _ = lookup(s[i+1 : j])
+	return value, j, nil
+}
@@ -0,0 +1,104 @@
+package utils
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseEnv(t *testing.T) {
+	lookup := func(name string) (string, bool) {
+		value, ok := map[string]string{"HOST": "localhost", "EMPTY": ""}[name]
+		return value, ok
+	}
+	tests := []struct {
+		name    string
+		content string
+		want    map[string]string
+		errLine int
+	}{
+		{
+			name:    "legacy format",
+			content: "MONGO_STRING=\"mongodb://localhost\"\nPORT=\"4000\"\n",
+			want:    map[string]string{"MONGO_STRING": "mongodb://localhost", "PORT": "4000"},
+		},
+		{
+			name:    "unquoted values, comments and export",
+			content: "# comment\n\nexport A=1\nB = two words # comment\nC=url#fragment\nD=\nE=# only a comment\n_F=x\n",
+			want:    map[string]string{"A": "1", "B": "two words", "C": "url#fragment", "D": "", "E": "", "_F": "x"},
+		},
+		{
+			name:    "single quotes are literal",
+			content: `A='${HOST} \n "x"' # comment`,
+			want:    map[string]string{"A": `${HOST} \n "x"`},
+		},
+		{
+			name:    "double quote escapes",
+			content: `A="tab\tnew\nquote\" slash\\ dollar\$HOST other\q"`,
+			want:    map[string]string{"A": "tab\tnew\nquote\" slash\\ dollar$HOST other\\q"},
+		},
+		{
+			name:    "multiline values",
+			content: "KEY=\"-----BEGIN KEY-----\nabc\n-----END KEY-----\"\nNEXT='a\nb'\n",
+			want:    map[string]string{"KEY": "-----BEGIN KEY-----\nabc\n-----END KEY-----", "NEXT": "a\nb"},
+		},
+		{
+			name:    "expansion",
+			content: "URL=\"http://${HOST}:$PORT/\"\nPORT=4000\nFULL=${URL}x\nDEF=${MISSING:-fallback}\nEMPTYDEF=${EMPTY:-fallback}\nPRICE=5$\n",
+			want: map[string]string{
+				"URL": "http://localhost:/", "PORT": "4000", "FULL": "http://localhost:/x",
+				"DEF": "fallback", "EMPTYDEF": "fallback", "PRICE": "5$",
+			},
+		},
+		{name: "missing equal sign", content: "A=1\nB\n", errLine: 2},
+		{name: "invalid name", content: "1A=1\n", errLine: 1},
+		{name: "unterminated quote", content: "A=1\nB=\"abc\nC=2\n", errLine: 2},
+		{name: "text after the quote", content: "A='abc' def\n", errLine: 1},
+		{name: "unterminated expansion", content: "A=1\nB=${HOST\n", errLine: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			envs := map[string]string{}
+			err := parseEnv(".env", tt.content, envs, func(name string) (string, bool) {
+				if value, ok := envs[name]; ok {
+					return value, true
+				}
+				return lookup(name)
+			})
+			if tt.errLine != 0 {
+				parseErr := &EnvParseError{}
+				assert.True(t, errors.As(err, &parseErr), "expected a parse error, got %v", err)
+				assert.Equal(t, tt.errLine, parseErr.Line)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, envs)
+		})
+	}
+}
+
+func TestSetupEnvVars(t *testing.T) {
+	dir := t.TempDir()
+	env := filepath.Join(dir, ".env")
+	local := filepath.Join(dir, ".env.local")
+	assert.NoError(t, os.WriteFile(env, []byte("ENV_TEST_A=env\nENV_TEST_B=env\nENV_TEST_C=$ENV_TEST_B\n"), 0o600))
+	assert.NoError(t, os.WriteFile(local, []byte("ENV_TEST_B=local\n"), 0o600))
+	t.Setenv("ENV_TEST_A", "set")
+	for _, name := range []string{"ENV_TEST_B", "ENV_TEST_C"} {
+		t.Setenv(name, "")
+		os.Unsetenv(name)
+	}
+
+	assert.NoError(t, SetupEnvVars(env, local))
+	assert.Equal(t, "set", os.Getenv("ENV_TEST_A"), "vars already set are kept")
+	assert.Equal(t, "local", os.Getenv("ENV_TEST_B"), "later files replace earlier ones")
+	assert.Equal(t, "env", os.Getenv("ENV_TEST_C"))
+
+	assert.NoError(t, OverloadEnvVars(env))
+	assert.Equal(t, "env", os.Getenv("ENV_TEST_A"))
+
+	assert.ErrorIs(t, SetupEnvVars(filepath.Join(dir, ".env.test")), os.ErrNotExist)
+}
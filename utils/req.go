@@ -2,8 +2,11 @@ package utils
 
 import (
 	"encoding/json"
-	"log"
+	"log/slog"
 	"net/http"
+
+	"github.com/Martin-Jast/go-microservice/requestid"
+	"github.com/gorilla/mux"
 )
 
 func WriteJson(data interface{}, w http.ResponseWriter, code int) {
@@ -12,15 +15,45 @@ func WriteJson(data interface{}, w http.ResponseWriter, code int) {
 		var err error
 		respBody, err = json.Marshal(data)
 		if err != nil {
-			log.Printf("Error parsing response json: %v", err)
+			slog.Error("could not encode response json", "error", err)
 		}
 	}
+	if respBody != nil {
+		w.Header().Set("Content-Type", "application/json")
+	}
 	w.WriteHeader(code)
 	w.Write(respBody)
 
 }
 
+// TraceIDHeader carries the trace id of the request in responses so clients can report it
+const TraceIDHeader = "X-Trace-ID"
+
+// ErrorResponse is the standard envelope of every error returned by the service
+type ErrorResponse struct {
+	Error     string `json:"error"`
+	Status    int    `json:"status"`
+	RequestID string `json:"requestId,omitempty"`
+	TraceID   string `json:"traceId,omitempty"`
+}
+
+// WriteError writes err inside the standard error envelope, with the request and trace ids if the response already carries them
 func WriteError(err error, w http.ResponseWriter, code int) {
-	w.WriteHeader(code)
-	w.Write(([]byte)(err.Error()))
-}
\ No newline at end of file
+	w.Header().Set("Content-Type", "application/json")
+	WriteJson(ErrorResponse{
+		Error:     err.Error(),
+		Status:    code,
+		RequestID: w.Header().Get(requestid.Header),
+		TraceID:   w.Header().Get(TraceIDHeader),
+	}, w, code)
+}
+
+// RouteTemplate returns the template of the route matched by mux ( e.g. /base/{id} ), "unmatched" if there is none
+func RouteTemplate(r *http.Request) string {
+	if route := mux.CurrentRoute(r); route != nil {
+		if tpl, err := route.GetPathTemplate(); err == nil {
+			return tpl
+		}
+	}
+	return "unmatched"
+}
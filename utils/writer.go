@@ -0,0 +1,40 @@
+package utils
+
+import (
+	"net/http"
+)
+
+// ResponseRecorder wraps a ResponseWriter keeping the status code and the number of bytes written
+type ResponseRecorder struct {
+	http.ResponseWriter
+	Status int
+	Bytes  int64
+}
+
+// NewResponseRecorder wraps w, the status is 200 until WriteHeader is called
+func NewResponseRecorder(w http.ResponseWriter) *ResponseRecorder {
+	return &ResponseRecorder{ResponseWriter: w, Status: http.StatusOK}
+}
+
+func (r *ResponseRecorder) WriteHeader(code int) {
+	r.Status = code
+	r.ResponseWriter.WriteHeader(code)
+}
+
+func (r *ResponseRecorder) Write(b []byte) (int, error) {
+	n, err := r.ResponseWriter.Write(b)
+	r.Bytes += int64(n)
+	return n, err
+}
+
+// Flush lets streaming handlers flush through the recorder
+func (r *ResponseRecorder) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap gives http.ResponseController access to the wrapped writer
+func (r *ResponseRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}